@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiBasePath is the prefix of the current API version, used by the links in responses
+const apiBasePath = "/api/v1"
+
+// legacyAPIKey marks requests to the unversioned /api paths in the gin context
+const legacyAPIKey = "legacy_api"
+
+// Error codes of the error envelope. Callers should branch on the code, messages may be reworded.
+const (
+	errCodeInvalidRequest       = "invalid_request"
+	errCodeValidationFailed     = "validation_failed"
+	errCodeUnauthorized         = "unauthorized"
+	errCodeForbidden            = "forbidden"
+	errCodeNotFound             = "not_found"
+	errCodeConflict             = "conflict"
+	errCodeGone                 = "gone"
+	errCodeIdempotencyMismatch  = "idempotency_key_reused"
+	errCodeRateLimited          = "rate_limited"
+	errCodeShuttingDown         = "shutting_down"
+	errCodeInsufficientStorage  = "insufficient_storage"
+	errCodeTenantBusy           = "tenant_busy"
+	errCodeJobFailed            = "job_failed"
+	errCodeCandidateFailed      = "candidate_failed"
+	errCodeInternal             = "internal_error"
+	errCodeArchiveNotReady      = "archive_not_ready"
+	errCodeJobAlreadyFinished   = "job_already_finished"
+	errCodeJobOnAnotherInstance = "job_on_another_instance"
+)
+
+// APIError is the error envelope of /api/v1. FieldErrors point at the invalid fields of a request, Details
+// carry what else the caller needs, such as the job the error is about.
+type APIError struct {
+	Code        string       `json:"code"`
+	Message     string       `json:"message"`
+	FieldErrors []FieldError `json:"field_errors,omitempty"`
+	Details     gin.H        `json:"details,omitempty"`
+
+	status int
+}
+
+// FieldError is a problem with one field of a request, named by its JSON path, e.g. candidates[2].profile_url.
+// Query parameters, form parts and headers are named as they are sent.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func apiError(status int, code, message string) *APIError {
+	return &APIError{Code: code, Message: message, status: status}
+}
+
+// field adds a field error
+func (e *APIError) field(field, message string) *APIError {
+	e.FieldErrors = append(e.FieldErrors, FieldError{Field: field, Message: message})
+	return e
+}
+
+// detail adds a detail, the unversioned API returns them next to the error message
+func (e *APIError) detail(key string, value any) *APIError {
+	if e.Details == nil {
+		e.Details = gin.H{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// abort answers the request with the error, as the envelope or, on the unversioned API, as
+// {"error": message} with the details alongside
+func (e *APIError) abort(c *gin.Context) {
+	if !c.GetBool(legacyAPIKey) {
+		c.AbortWithStatusJSON(e.status, e)
+		return
+	}
+	body := gin.H{"error": e.Message}
+	for key, value := range e.Details {
+		body[key] = value
+	}
+	c.AbortWithStatusJSON(e.status, body)
+}
+
+// abortWithError answers the request with an error that has no field errors or details
+func abortWithError(c *gin.Context, status int, code, message string) {
+	apiError(status, code, message).abort(c)
+}
+
+// legacyAPI serves the unversioned /api paths for callers written before /api/v1. Their errors keep the
+// {"error": message} format and the responses point to the versioned path that replaces them.
+func legacyAPI(c *gin.Context) {
+	c.Set(legacyAPIKey, true)
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+apiBasePath+strings.TrimPrefix(c.Request.URL.Path, "/api")+`>; rel="successor-version"`)
+	c.Next()
+}
+
+// routeNotFound answers unknown paths with the error envelope
+func routeNotFound(c *gin.Context) {
+	abortWithError(c, http.StatusNotFound, errCodeNotFound, "no such endpoint: "+c.Request.Method+" "+c.Request.URL.Path)
+}
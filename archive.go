@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexmullins/zip"
+)
+
+// Formats of the final archive of a job
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormat is how an archive format is named and served
+type archiveFormat struct {
+	extension   string
+	contentType string
+}
+
+var archiveFormats = map[string]archiveFormat{
+	archiveZip:   {".zip", "application/zip"},
+	archiveTar:   {".tar", "application/x-tar"},
+	archiveTarGz: {".tar.gz", "application/gzip"},
+}
+
+// maxArchivePasswordLength keeps archive passwords to something people can type
+const maxArchivePasswordLength = 128
+
+// ArchiveEncryption protects the files of a zip archive with AES-256, for archives that are emailed to
+// clients. Without a password a random one is generated and returned in the response to the request and
+// its retries, to be passed on to the client another way.
+type ArchiveEncryption struct {
+	Password string `json:"password,omitempty"`
+}
+
+func (e *ArchiveEncryption) validate(format string) error {
+	if format != "" && format != archiveZip {
+		return fmt.Errorf("archive_encryption needs the %s archive_format", archiveZip)
+	}
+	if len(e.Password) > maxArchivePasswordLength {
+		return fmt.Errorf("archive_encryption password cannot be longer than %d characters", maxArchivePasswordLength)
+	}
+	return nil
+}
+
+// generatePassword fills in a random password when the request has none, and reports whether it did
+func (e *ArchiveEncryption) generatePassword() bool {
+	if e.Password != "" {
+		return false
+	}
+	e.Password = rand.Text()
+	return true
+}
+
+// validateArchiveFormat checks the archive_format of a job, zip when empty
+func validateArchiveFormat(format string) error {
+	if _, ok := archiveFormats[format]; format != "" && !ok {
+		return fmt.Errorf("archive_format must be %s, %s or %s", archiveZip, archiveTar, archiveTarGz)
+	}
+	return nil
+}
+
+// archiveExtension is the file extension of archives of the format, .zip when empty
+func archiveExtension(format string) string {
+	if f, ok := archiveFormats[format]; ok {
+		return f.extension
+	}
+	return archiveFormats[archiveZip].extension
+}
+
+// archiveContentType is the MIME type of an archive going by its name
+func archiveContentType(name string) string {
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.extension) {
+			return f.contentType
+		}
+	}
+	return archiveFormats[archiveZip].contentType
+}
+
+// trimArchiveExtension is the name of an archive without the extension of its format
+func trimArchiveExtension(name string) string {
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.extension) {
+			return strings.TrimSuffix(name, f.extension)
+		}
+	}
+	return name
+}
+
+// archiveFolder packs sourceDir into an archive of the format at archivePath, encrypted if the job asks
+// for it, and sealed with encryption at rest. With files, by their paths relative to sourceDir, only those
+// are packed.
+func archiveFolder(opts JobOptions, sourceDir, archivePath string, files map[string]bool) error {
+	var err error
+	switch {
+	case opts.ArchiveEncryption != nil:
+		err = encryptedZipFolder(sourceDir, archivePath, opts.ArchiveEncryption.Password, files)
+	case opts.ArchiveFormat == archiveTar:
+		err = tarFolder(sourceDir, archivePath, false, files)
+	case opts.ArchiveFormat == archiveTarGz:
+		err = tarFolder(sourceDir, archivePath, true, files)
+	default:
+		err = zipFolder(sourceDir, archivePath, files)
+	}
+	if err != nil {
+		return err
+	}
+	return sealFile(archivePath)
+}
+
+// tarFolder writes the files of sourceDir to a tar file at tarPath like zipFolder, gzip compressed with
+// compress
+func tarFolder(sourceDir, tarPath string, compress bool, files map[string]bool) error {
+	logger.Debug().Msgf("Creating tar file from directory: %s -> %s", sourceDir, tarPath)
+	tarfile, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer tarfile.Close()
+
+	var out io.Writer = tarfile
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(tarfile)
+		out = gz
+	}
+	archive := tar.NewWriter(out)
+
+	fileCount := 0
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(sourceDir, path)
+		if files != nil && !files[filepath.ToSlash(relPath)] {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(archive, file)
+		if err == nil {
+			fileCount++
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	logger.Debug().Msgf("Tar file created with %d files: %s", fileCount, tarPath)
+	return tarfile.Close()
+}
+
+// encryptedZipFolder writes the files of sourceDir to a zip file at zipPath like zipFolder, each encrypted
+// with AES-256 under password in the WinZip format, which 7-Zip and WinZip open
+func encryptedZipFolder(sourceDir, zipPath, password string, files map[string]bool) error {
+	if password == "" {
+		return errors.New("no password to encrypt the archive with")
+	}
+	logger.Debug().Msgf("Creating encrypted zip file from directory: %s -> %s", sourceDir, zipPath)
+	zipfile, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	fileCount := 0
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(sourceDir, path)
+		if files != nil && !files[filepath.ToSlash(relPath)] {
+			return nil
+		}
+		zipEntry, err := archive.Encrypt(filepath.ToSlash(relPath), password)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(zipEntry, file)
+		if err == nil {
+			fileCount++
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	logger.Debug().Msgf("Encrypted zip file created with %d files: %s", fileCount, zipPath)
+	return zipfile.Close()
+}
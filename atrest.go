@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Files sealed at rest start with atRestMagic, the ID of the key and the prefix of their nonces, and go on
+// with the content in chunks of atRestChunkSize, each encrypted with AES-256-GCM on its own so archives can
+// be read from any offset. The nonce of a chunk is the prefix, the chunk's index and whether it is the last
+// one, so chunks cannot be reordered and a truncated file does not open.
+const (
+	atRestMagic      = "FSMSEAL1"
+	atRestKeyIDSize  = 8
+	atRestPrefixSize = 7
+	atRestHeaderSize = len(atRestMagic) + atRestKeyIDSize + atRestPrefixSize
+	atRestChunkSize  = 64 << 10
+	// atRestKMSTimeout bounds the call to KMS that unwraps the key at startup
+	atRestKMSTimeout = 30 * time.Second
+)
+
+const gcpKMSScope = "https://www.googleapis.com/auth/cloudkms"
+
+// atRestKey seals the files the service keeps on disk: archives, the resume cache, and the packets and shared
+// resumes a job keeps in the scratch directory until it is packaged
+type atRestKey struct {
+	aead cipher.AEAD
+	id   []byte
+}
+
+// atRest is nil unless encryption at rest is enabled
+var atRest *atRestKey
+
+func setupEncryptionAtRest() {
+	cfg := appConfig.Storage.Encryption
+	if cfg.Key == "" && cfg.WrappedKey == "" {
+		return
+	}
+	key, source, err := loadAtRestKey(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load the encryption at rest key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid encryption at rest key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid encryption at rest key")
+	}
+	sum := sha256.Sum256(key)
+	atRest = &atRestKey{aead: aead, id: sum[:atRestKeyIDSize]}
+	logger.Info().Msgf("Encryption at rest enabled with the key from %s (key ID %x)", source, atRest.id)
+}
+
+// loadAtRestKey returns the 32 byte key of the configuration and where it came from
+func loadAtRestKey(cfg AtRestConfig) ([]byte, string, error) {
+	if cfg.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.Key)
+		if err != nil || len(key) != 32 {
+			return nil, "", errors.New("storage.encryption.key must be 32 bytes, base64 encoded")
+		}
+		return key, "storage.encryption.key", nil
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(cfg.WrappedKey)
+	if err != nil {
+		return nil, "", errors.New("storage.encryption.wrapped_key must be base64 encoded")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), atRestKMSTimeout)
+	defer cancel()
+	var key []byte
+	if strings.HasPrefix(cfg.KMSKey, "arn:") {
+		key, err = awsKMSDecrypt(ctx, cfg.KMSKey, wrapped)
+	} else {
+		key, err = gcpKMSDecrypt(ctx, cfg.KMSKey, wrapped)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to unwrap the key with %s: %w", cfg.KMSKey, err)
+	}
+	if len(key) != 32 {
+		return nil, "", fmt.Errorf("the key unwrapped with %s is %d bytes, not 32", cfg.KMSKey, len(key))
+	}
+	return key, cfg.KMSKey, nil
+}
+
+// awsKMSDecrypt decrypts a data key with an AWS KMS key, given by its ARN. Credentials come from the default
+// AWS credential chain (env, shared config, IAM role).
+func awsKMSDecrypt(ctx context.Context, keyARN string, wrapped []byte) ([]byte, error) {
+	// arn:<partition>:kms:<region>:<account>:key/<id>
+	fields := strings.Split(keyARN, ":")
+	if len(fields) < 6 || fields[2] != "kms" {
+		return nil, errors.New("not an AWS KMS key ARN")
+	}
+	region := fields[3]
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+
+	body, _ := json.Marshal(map[string]any{"KeyId": keyARN, "CiphertextBlob": wrapped})
+	host := "kms." + region + ".amazonaws.com"
+	if fields[1] == "aws-cn" {
+		host += ".cn"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	payloadHash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "kms", region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var decrypted struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := doKMSRequest(http.DefaultClient, req, &decrypted); err != nil {
+		return nil, err
+	}
+	return decrypted.Plaintext, nil
+}
+
+// gcpKMSDecrypt decrypts a data key with a Google Cloud KMS key, named
+// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>. Credentials come from
+// Application Default Credentials.
+func gcpKMSDecrypt(ctx context.Context, keyName string, wrapped []byte) ([]byte, error) {
+	creds, err := google.FindDefaultCredentials(ctx, gcpKMSScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google credentials: %w", err)
+	}
+	body, _ := json.Marshal(map[string]any{"ciphertext": wrapped})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://cloudkms.googleapis.com/v1/"+keyName+":decrypt", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var decrypted struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := doKMSRequest(oauth2.NewClient(ctx, creds.TokenSource), req, &decrypted); err != nil {
+		return nil, err
+	}
+	return decrypted.Plaintext, nil
+}
+
+func doKMSRequest(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid KMS response: %w", err)
+	}
+	return nil
+}
+
+// nonce is the nonce of the chunk at index of a file whose nonces start with prefix
+func (k *atRestKey) nonce(prefix []byte, index int64, last bool) []byte {
+	nonce := make([]byte, 0, k.aead.NonceSize())
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, uint32(index))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// seal writes what r holds to w encrypted
+func (k *atRestKey) seal(w io.Writer, r io.Reader) error {
+	prefix := make([]byte, atRestPrefixSize)
+	rand.Read(prefix)
+	header := append(append([]byte(atRestMagic), k.id...), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	in := bufio.NewReaderSize(r, atRestChunkSize)
+	chunk := make([]byte, atRestChunkSize)
+	for index := int64(0); ; index++ {
+		n, err := io.ReadFull(in, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, err = in.Peek(1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		last := err == io.EOF
+		if _, err := w.Write(k.aead.Seal(nil, k.nonce(prefix, index, last), chunk[:n], header)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// sealFile encrypts the file at path in place when encryption at rest is enabled
+func sealFile(path string) error {
+	if atRest == nil {
+		return nil
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".seal-*")
+	if err != nil {
+		return err
+	}
+	err = atRest.seal(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// sealInto seals the file at srcPath and moves it to dstPath, which never holds it in the clear
+func sealInto(srcPath, dstPath string) error {
+	if err := sealFile(srcPath); err != nil {
+		return err
+	}
+	return os.Rename(srcPath, dstPath)
+}
+
+// sealValue encrypts a short value kept in the job store, e.g. a generated archive password, like a sealed
+// file of a single chunk, base64 encoded
+func (k *atRestKey) sealValue(value string) (string, error) {
+	var buf bytes.Buffer
+	if err := k.seal(&buf, strings.NewReader(value)); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// openValue decrypts a value sealed with sealValue
+func (k *atRestKey) openValue(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < atRestHeaderSize || string(data[:len(atRestMagic)]) != atRestMagic {
+		return "", errors.New("not a sealed value")
+	}
+	header := data[:atRestHeaderSize]
+	if !bytes.Equal(header[len(atRestMagic):len(atRestMagic)+atRestKeyIDSize], k.id) {
+		return "", errors.New("value is encrypted with another key")
+	}
+	value, err := k.aead.Open(nil, k.nonce(header[len(atRestMagic)+atRestKeyIDSize:], 0, true), data[atRestHeaderSize:], header)
+	if err != nil {
+		return "", errors.New("value does not decrypt")
+	}
+	return string(value), nil
+}
+
+// atRestFile reads a file kept on disk, decrypting it if it is sealed. Files written before encryption at
+// rest was enabled are read as they are.
+type atRestFile struct {
+	file *os.File
+	size int64
+	// key is nil for files that are not sealed
+	key    *atRestKey
+	header []byte
+	chunks int64
+	pos    int64
+	// chunk is the decrypted chunk at chunkIndex
+	chunk      []byte
+	chunkIndex int64
+}
+
+func openAtRest(path string) (*atRestFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f := &atRestFile{file: file, size: info.Size(), chunkIndex: -1}
+	header := make([]byte, atRestHeaderSize)
+	if n, _ := file.ReadAt(header, 0); n < atRestHeaderSize || string(header[:len(atRestMagic)]) != atRestMagic {
+		return f, nil
+	}
+
+	if atRest == nil || !bytes.Equal(header[len(atRestMagic):len(atRestMagic)+atRestKeyIDSize], atRest.id) {
+		file.Close()
+		return nil, fmt.Errorf("%s is encrypted with another key", filepath.Base(path))
+	}
+	overhead := int64(atRest.aead.Overhead())
+	body := info.Size() - int64(atRestHeaderSize)
+	f.key, f.header = atRest, header
+	f.chunks = (body + atRestChunkSize + overhead - 1) / (atRestChunkSize + overhead)
+	f.size = body - f.chunks*overhead
+	if f.chunks == 0 || f.size < 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is truncated", filepath.Base(path))
+	}
+	return f, nil
+}
+
+// Size is the size of the file's content
+func (f *atRestFile) Size() int64 {
+	return f.size
+}
+
+func (f *atRestFile) Read(p []byte) (int, error) {
+	if f.key == nil {
+		return f.file.Read(p)
+	}
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *atRestFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.key == nil {
+		return f.file.ReadAt(p, off)
+	}
+	n := 0
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		index := off / atRestChunkSize
+		if index != f.chunkIndex {
+			if err := f.load(index); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(p[n:], f.chunk[off-index*atRestChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// load decrypts the chunk at index
+func (f *atRestFile) load(index int64) error {
+	sealedSize := int64(atRestChunkSize + f.key.aead.Overhead())
+	sealed := make([]byte, sealedSize)
+	n, err := f.file.ReadAt(sealed, int64(atRestHeaderSize)+index*sealedSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	prefix := f.header[len(atRestMagic)+atRestKeyIDSize:]
+	chunk, err := f.key.aead.Open(sealed[:0], f.key.nonce(prefix, index, index == f.chunks-1), sealed[:n], f.header)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", filepath.Base(f.file.Name()), err)
+	}
+	f.chunk, f.chunkIndex = chunk, index
+	return nil
+}
+
+func (f *atRestFile) Seek(offset int64, whence int) (int64, error) {
+	if f.key == nil {
+		return f.file.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *atRestFile) Close() error {
+	return f.file.Close()
+}
+
+// atRestSize is the size of the content of a file kept on disk, encrypted or not
+func atRestSize(path string) (int64, error) {
+	f, err := openAtRest(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Size(), nil
+}
+
+// unsealDir decrypts the sealed files under dir in place, for the tools that work on them next
+func unsealDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		f, err := openAtRest(path)
+		if err != nil {
+			return err
+		}
+		sealed := f.key != nil
+		f.Close()
+		if !sealed {
+			return nil
+		}
+		tmp := filepath.Join(filepath.Dir(path), ".unseal-"+entry.Name())
+		if err := unsealFile(path, tmp); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to decrypt %s: %w", entry.Name(), err)
+		}
+		return os.Rename(tmp, path)
+	})
+}
+
+// unsealFile copies the content of a file kept on disk to dstPath, decrypted
+func unsealFile(srcPath, dstPath string) error {
+	src, err := openAtRest(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
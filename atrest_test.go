@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func testAtRestKey(t *testing.T, id byte) *atRestKey {
+	t.Helper()
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &atRestKey{aead: aead, id: []byte{id, 0, 0, 0, 0, 0, 0, 0}}
+}
+
+func TestSealValue(t *testing.T) {
+	key := testAtRestKey(t, 1)
+	sealed, err := key.sealValue("N2S7CCFDE64ASETURVIEDADUNH")
+	if err != nil {
+		t.Fatalf("sealValue: %v", err)
+	}
+	if value, err := key.openValue(sealed); err != nil || value != "N2S7CCFDE64ASETURVIEDADUNH" {
+		t.Errorf("openValue = %q, %v", value, err)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-4] ^= 1
+	for name, value := range map[string]string{"tampered": string(tampered), "plain": "N2S7CCFDE64ASETURVIEDADUNH"} {
+		if _, err := key.openValue(value); err == nil {
+			t.Errorf("openValue opened a %s value", name)
+		}
+	}
+	if _, err := testAtRestKey(t, 2).openValue(sealed); err == nil {
+		t.Error("openValue opened a value sealed with another key")
+	}
+}
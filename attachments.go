@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// maxAttachments keeps a candidate's packet to a reasonable size
+const maxAttachments = 10
+
+// Attachment is a document appended to the candidate's packet after the resume, e.g. a cover letter or a
+// certificate. It is fetched and converted like the resume.
+type Attachment struct {
+	URL string `json:"url"`
+	// Title names the attachment on its separator page, "Attachment N" when empty
+	Title string `json:"title,omitempty"`
+}
+
+// title is what the separator page in front of the attachment says, n counting from 1
+func (a Attachment) title(n int) string {
+	if title := strings.TrimSpace(a.Title); title != "" {
+		return title
+	}
+	return fmt.Sprintf("Attachment %d", n)
+}
+
+// validateAttachments checks the attachments of a candidate
+func (cand Candidate) validateAttachments() error {
+	if len(cand.Attachments) > maxAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", maxAttachments)
+	}
+	for i, attachment := range cand.Attachments {
+		if strings.TrimSpace(attachment.URL) == "" {
+			return fmt.Errorf("attachment %d needs a url", i+1)
+		}
+	}
+	return nil
+}
+
+// prepareAttachments downloads the candidate's attachments and converts them to PDF, each in a directory
+// of its own, and returns the PDFs in order. A failed attachment fails the candidate, its packet would be
+// incomplete without it.
+func prepareAttachments(ctx context.Context, tenant string, opts JobOptions, cand Candidate, candTempDir string) ([]string, error) {
+	var pdfs []string
+	for i, attachment := range cand.Attachments {
+		dir := filepath.Join(candTempDir, fmt.Sprintf("attachment-%d", i+1))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		// Attachments go through the resume's download, detection and conversion, with the candidate's headers
+		source := cand
+		source.ResumeURL, source.ResumeContent, source.ResumeFilename, source.ResumePassword = attachment.URL, nil, "", ""
+		var result candidateResult
+		pdf, err := prepareResume(ctx, tenant, opts, source, dir, &result)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %d: %w", i+1, err)
+		}
+		pdfs = append(pdfs, pdf)
+	}
+	return pdfs, nil
+}
+
+// renderSeparatorPage writes a page announcing the next section of a candidate's packet to path: the
+// section's title in the middle of the page on the tenant's letterhead, the candidate's name below it
+func renderSeparatorPage(title string, cand Candidate, opts JobOptions, branding *Branding, path string) error {
+	if branding == nil {
+		branding = &Branding{}
+	}
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	text := newFactsheetText(pdf, opts.direction())
+	drawLetterhead(pdf, text, branding, opts.direction())
+	pdf.AddPage()
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	width := pageWidth - left - right
+
+	y := 120.0
+	textColor(pdf, branding.PrimaryColor, 0)
+	for _, line := range text.lines("B", 24, title, width) {
+		pdf.SetXY(left, y)
+		pdf.CellFormat(width, 12, line, "", 0, "C", false, 0, "")
+		y += 12
+	}
+	if r, g, b, err := parseColor(branding.PrimaryColor); err == nil {
+		pdf.SetDrawColor(r, g, b)
+	}
+	pdf.SetLineWidth(0.6)
+	pdf.Line(pageWidth/2-30, y+4, pageWidth/2+30, y+4)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.SetXY(left, y+10)
+	pdf.CellFormat(width, 8, text.font("", 14, cand.Name), "", 0, "C", false, 0, "")
+	return pdf.OutputFileAndClose(path)
+}
+
+// packetSections are the PDFs of a candidate's packet in order: the factsheet, then the resume and the
+// attachments in order, or the resume and attachments first and the factsheet last for resume_first jobs.
+// Every section but the first goes behind a separator page when the job asks for them.
+func packetSections(opts JobOptions, cand Candidate, branding *Branding, factsheetPDF, resumePDF string, attachmentPDFs []string, candTempDir string) ([]string, error) {
+	pdfs := append([]string{resumePDF}, attachmentPDFs...)
+	titles := []string{"Resume"}
+	for i, attachment := range cand.Attachments {
+		titles = append(titles, attachment.title(i+1))
+	}
+	if opts.MergeOrder == mergeOrderResumeFirst {
+		pdfs, titles = append(pdfs, factsheetPDF), append(titles, "Factsheet")
+	} else {
+		pdfs, titles = append([]string{factsheetPDF}, pdfs...), append([]string{"Factsheet"}, titles...)
+	}
+	if !opts.SeparatorPages {
+		return pdfs, nil
+	}
+	sections := []string{pdfs[0]}
+	for i, pdf := range pdfs[1:] {
+		separator := filepath.Join(candTempDir, fmt.Sprintf("separator-%d.pdf", i))
+		if err := renderSeparatorPage(titles[i+1], cand, opts, branding, separator); err != nil {
+			return nil, fmt.Errorf("failed to render separator page of %s: %w", titles[i+1], err)
+		}
+		sections = append(sections, separator, pdf)
+	}
+	return sections, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log
+const (
+	auditJobSubmitted          = "job.submitted"
+	auditJobFinished           = "job.finished"
+	auditJobCancelled          = "job.cancelled"
+	auditCandidateCancelled    = "candidate.cancelled"
+	auditArchiveDownloaded     = "archive.downloaded"
+	auditPacketGenerated       = "packet.generated"
+	auditArchiveDeleted        = "archive.deleted"
+	auditJobDeleted            = "job.deleted"
+	auditTenantSettingsUpdated = "tenant_settings.updated"
+	auditTenantSettingsDeleted = "tenant_settings.deleted"
+)
+
+var auditActions = []string{auditJobSubmitted, auditJobFinished, auditJobCancelled, auditCandidateCancelled,
+	auditArchiveDownloaded, auditPacketGenerated, auditArchiveDeleted, auditJobDeleted, auditTenantSettingsUpdated,
+	auditTenantSettingsDeleted}
+
+// maxMemoryAuditEntries bounds the audit log kept without a job store, the oldest entries are dropped
+const maxMemoryAuditEntries = 10000
+
+// AuditEntry records who did what to a tenant's jobs or settings. Entries are only ever appended.
+type AuditEntry struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant"`
+	Action string    `json:"action"`
+	JobID  string    `json:"job_id,omitempty"`
+	// Actor is the token subject of the caller, empty without authentication and for entries written by the
+	// service itself, such as job.finished
+	Actor     string          `json:"actor,omitempty"`
+	ClientCN  string          `json:"client_cn,omitempty"`
+	ClientIP  string          `json:"client_ip,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+// AuditFilter selects audit entries, zero fields match everything
+type AuditFilter struct {
+	Tenant  string
+	Actions []string
+	JobID   string
+	Actor   string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Offset  int
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Tenant == "" || entry.Tenant == f.Tenant) &&
+		(len(f.Actions) == 0 || slices.Contains(f.Actions, entry.Action)) &&
+		(f.JobID == "" || entry.JobID == f.JobID) &&
+		(f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.From.IsZero() || !entry.Time.Before(f.From)) &&
+		(f.To.IsZero() || entry.Time.Before(f.To))
+}
+
+// auditCandidate is a candidate of a submitted job with the URLs fetched for it
+type auditCandidate struct {
+	Index       int      `json:"index"`
+	CandidateID string   `json:"candidate_id,omitempty"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	URLs        []string `json:"urls,omitempty"`
+}
+
+// auditCandidateResult is the outcome of a candidate of a finished job
+type auditCandidateResult struct {
+	Index     int    `json:"index"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// auditLog keeps the audit entries in the job store, or in memory when persistence is disabled
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+var audit = &auditLog{}
+
+// record appends an entry. Every entry is logged as well, so it leaves the host with the logs even when the
+// job store is lost.
+func (a *auditLog) record(entry AuditEntry, details any) {
+	entry.ID = uuid.NewString()
+	entry.Time = time.Now().UTC().Truncate(time.Microsecond)
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Error encoding details of audit entry %s", entry.Action)
+		}
+		entry.Details = data
+	}
+
+	logger.Info().Str("audit_id", entry.ID).Str("audit_action", entry.Action).Str("tenant", entry.Tenant).
+		Str("job_id", entry.JobID).Str("actor", entry.Actor).Str("client_cn", entry.ClientCN).
+		Str("client_ip", entry.ClientIP).Str("request_id", entry.RequestID).RawJSON("details", orNull(entry.Details)).
+		Msg("Audit")
+
+	if jobDB != nil {
+		if err := jobDB.saveAuditEntry(entry); err != nil {
+			logger.Error().Err(err).Msgf("Error saving audit entry %s", entry.ID)
+		}
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > maxMemoryAuditEntries {
+		a.entries = slices.Delete(a.entries, 0, len(a.entries)-maxMemoryAuditEntries)
+	}
+}
+
+// orNull turns empty details into null, RawJSON would leave the field without a value
+func orNull(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+// recordRequest appends an entry for an action the caller of the request took
+func (a *auditLog) recordRequest(c *gin.Context, action, tenant, jobID string, details any) {
+	entry := AuditEntry{
+		Tenant:    tenant,
+		Action:    action,
+		JobID:     jobID,
+		ClientCN:  clientCN(c),
+		ClientIP:  c.ClientIP(),
+		RequestID: requestID(c),
+	}
+	if caller := currentPrincipal(c); caller != nil {
+		entry.Actor = caller.Subject
+	}
+	a.record(entry, details)
+}
+
+// list returns one page of the entries matching the filter, newest first, and the total number of matches
+func (a *auditLog) list(filter AuditFilter) ([]AuditEntry, int, error) {
+	if jobDB != nil {
+		return jobDB.listAuditEntries(filter)
+	}
+
+	a.mu.Lock()
+	matched := []AuditEntry{}
+	for _, entry := range a.entries {
+		if filter.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	a.mu.Unlock()
+
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Time.After(matched[j].Time) })
+	total := len(matched)
+	start := min(filter.Offset, total)
+	end := min(start+filter.Limit, total)
+	return matched[start:end], total, nil
+}
+
+// auditSubmittedJob records who submitted a job, its candidates and the URLs that are fetched for them
+func auditSubmittedJob(c *gin.Context, job *Job, req ProcessRequest) {
+	candidates := make([]auditCandidate, len(req.Candidates))
+	for i, cand := range req.Candidates {
+		candidates[i] = auditCandidate{
+			Index:       i,
+			CandidateID: cand.CandidateID,
+			Name:        cand.Name,
+			Email:       cand.Email,
+			URLs:        cand.fetchedURLs(),
+		}
+	}
+	audit.recordRequest(c, auditJobSubmitted, job.TenantName, job.ID, gin.H{
+		"company":    req.CompanyName,
+		"async":      req.Async,
+		"delivery":   req.Delivery,
+		"candidates": candidates,
+	})
+}
+
+// auditFinishedJob records the outcome of a job's candidates and where its archive was delivered
+func auditFinishedJob(job *Job, req ProcessRequest) {
+	job.mu.Lock()
+	results := make([]auditCandidateResult, len(job.Candidates))
+	for i, cand := range job.Candidates {
+		results[i] = auditCandidateResult{Index: i, Email: cand.Email, Status: cand.Status, ErrorCode: cand.ErrorCode}
+	}
+	details := gin.H{
+		"status":     job.Status,
+		"candidates": results,
+		"delivery":   req.Delivery,
+	}
+	if job.Delivery != nil {
+		details["delivered_to"] = job.Delivery.Location
+	} else if job.ZipFileName != "" {
+		details["archive"] = job.ZipFileName
+	}
+	if len(job.Parts) > 0 {
+		var parts []string
+		for _, part := range job.Parts {
+			if part.Delivery != nil {
+				parts = append(parts, part.Delivery.Location)
+			} else {
+				parts = append(parts, part.FileName)
+			}
+		}
+		details["parts"] = parts
+	}
+	job.mu.Unlock()
+
+	audit.record(AuditEntry{Tenant: job.TenantName, Action: auditJobFinished, JobID: job.ID, RequestID: job.RequestID}, details)
+}
+
+// fetchedURLs lists the URLs downloaded for the candidate, with credentials redacted. Resumes sent inline
+// are listed by file name.
+func (cand Candidate) fetchedURLs() []string {
+	var urls []string
+	if len(cand.ResumeContent) > 0 {
+		urls = append(urls, "inline:"+cand.ResumeFilename)
+	} else if cand.ResumeURL != "" {
+		urls = append(urls, redactURL(cand.ResumeURL))
+	}
+	if cand.PhotoURL != "" {
+		urls = append(urls, redactURL(cand.PhotoURL))
+	}
+	for _, attachment := range cand.Attachments {
+		urls = append(urls, redactURL(attachment.URL))
+	}
+	return urls
+}
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// listAuditEntries returns the audit log of the caller's tenant, or of any tenant for admins, filtered by
+// action, job, actor and time, newest first
+func listAuditEntries(c *gin.Context) {
+	tenant, ok := scopeTenant(c, c.Query("tenant"))
+	if !ok {
+		return
+	}
+	filter := AuditFilter{
+		Tenant: tenant,
+		JobID:  c.Query("job_id"),
+		Actor:  c.Query("actor"),
+		Limit:  defaultAuditPageSize,
+	}
+
+	if actions := c.Query("action"); actions != "" {
+		for _, action := range strings.Split(actions, ",") {
+			if !slices.Contains(auditActions, action) {
+				apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid action: "+action).
+					field("action", "must be one of "+strings.Join(auditActions, ", ")).abort(c)
+				return
+			}
+			filter.Actions = append(filter.Actions, action)
+		}
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(c.Query("from"), false); err != nil {
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid from: "+err.Error()).field("from", err.Error()).abort(c)
+		return
+	}
+	if filter.To, err = parseTimeParam(c.Query("to"), true); err != nil {
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid to: "+err.Error()).field("to", err.Error()).abort(c)
+		return
+	}
+	if !parsePage(c, &filter.Limit, &filter.Offset, maxAuditPageSize) {
+		return
+	}
+
+	entries, total, err := audit.list(filter)
+	if err != nil {
+		requestLog(c).Error().Err(err).Msg("Error listing audit entries")
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to list audit entries")
+		return
+	}
+
+	response := gin.H{
+		"entries": entries,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	}
+	if next := filter.Offset + len(entries); next < total {
+		response["next_offset"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
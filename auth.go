@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksMinRefresh limits how often an unknown key id triggers a fetch of the key set
+const jwksMinRefresh = time.Minute
+
+const principalKey = "principal"
+
+// principal is the authenticated caller of a request
+type principal struct {
+	Subject string
+	// Tenant is the only tenant the caller may act for, unless it is an admin
+	Tenant string
+	Admin  bool
+}
+
+// jwks holds the signing keys of the identity provider, nil when authentication is disabled
+var jwks *jwksCache
+
+// setupAuth enables bearer token authentication when a JWKS URL is configured
+func setupAuth() {
+	cfg := appConfig.Auth
+	if cfg.JWKSURL == "" {
+		logger.Warn().Msg("Authentication disabled, set AUTH_JWKS_URL to require bearer tokens")
+		return
+	}
+
+	jwks = &jwksCache{url: cfg.JWKSURL, client: &http.Client{Timeout: 10 * time.Second}}
+	// The identity provider may still be starting, keys are fetched again on the first request
+	if err := jwks.refresh(); err != nil {
+		logger.Warn().Err(err).Msgf("Failed to fetch JWKS from %s", cfg.JWKSURL)
+	}
+	if cfg.JWKSRefresh > 0 {
+		go func() {
+			for {
+				time.Sleep(cfg.JWKSRefresh)
+				if err := jwks.refresh(); err != nil {
+					logger.Warn().Err(err).Msgf("Failed to refresh JWKS from %s", cfg.JWKSURL)
+				}
+			}
+		}()
+	}
+	logger.Info().Msgf("Authentication enabled with JWKS %s (tenant claim %q)", cfg.JWKSURL, cfg.TenantClaim)
+}
+
+// authenticate validates the bearer token of the request and records the caller
+func authenticate(c *gin.Context) {
+	if jwks == nil {
+		c.Next()
+		return
+	}
+
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		abortUnauthorized(c, "missing bearer token")
+		return
+	}
+
+	cfg := appConfig.Auth
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(strings.TrimSpace(header[7:]), claims, jwks.keyfunc, opts...); err != nil {
+		requestLog(c).Warn().Err(err).Msgf("Rejected bearer token from %s", c.ClientIP())
+		abortUnauthorized(c, "invalid bearer token")
+		return
+	}
+
+	caller := &principal{Admin: cfg.AdminScope != "" && hasScope(claims, cfg.AdminScope)}
+	caller.Subject, _ = claims["sub"].(string)
+	caller.Tenant, _ = claims[cfg.TenantClaim].(string)
+	if caller.Tenant == "" && !caller.Admin {
+		abortWithError(c, http.StatusForbidden, errCodeForbidden, "token has no "+cfg.TenantClaim+" claim")
+		return
+	}
+
+	c.Set(principalKey, caller)
+	c.Next()
+}
+
+func abortUnauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	abortWithError(c, http.StatusUnauthorized, errCodeUnauthorized, message)
+}
+
+// requireAdmin rejects callers without the admin scope
+func requireAdmin(c *gin.Context) {
+	if caller := currentPrincipal(c); caller != nil && !caller.Admin {
+		abortWithError(c, http.StatusForbidden, errCodeForbidden, "admin scope required")
+		return
+	}
+	c.Next()
+}
+
+// currentPrincipal returns the authenticated caller, nil when authentication is disabled
+func currentPrincipal(c *gin.Context) *principal {
+	if value, ok := c.Get(principalKey); ok {
+		return value.(*principal)
+	}
+	return nil
+}
+
+// requestedBy describes the caller for audit logs: the token subject and client certificate when present,
+// otherwise the client address
+func requestedBy(c *gin.Context) string {
+	var parts []string
+	if caller := currentPrincipal(c); caller != nil {
+		parts = append(parts, "sub="+caller.Subject)
+	}
+	if cn := clientCN(c); cn != "" {
+		parts = append(parts, "cn="+cn)
+	}
+	if len(parts) == 0 {
+		return c.ClientIP()
+	}
+	return strings.Join(parts, " ")
+}
+
+// canAccessTenant reports whether the caller may see or act on the tenant's data
+func canAccessTenant(c *gin.Context, tenant string) bool {
+	caller := currentPrincipal(c)
+	return caller == nil || caller.Admin || caller.Tenant == tenant
+}
+
+// scopeTenant returns the tenant a request acts for: the requested one, defaulting to the caller's
+// own tenant. It responds with 403 and returns false when the caller may not act for the requested tenant.
+func scopeTenant(c *gin.Context, requested string) (string, bool) {
+	caller := currentPrincipal(c)
+	if caller == nil || caller.Admin {
+		return requested, true
+	}
+	if requested == "" {
+		return caller.Tenant, true
+	}
+	if requested != caller.Tenant {
+		abortWithError(c, http.StatusForbidden, errCodeForbidden, "token is not allowed to act for tenant "+requested)
+		return "", false
+	}
+	return requested, true
+}
+
+// hasScope checks the space separated "scope" claim and the "scp" list used by some providers
+func hasScope(claims jwt.MapClaims, scope string) bool {
+	if scopes, ok := claims["scope"].(string); ok && containsString(strings.Fields(scopes), scope) {
+		return true
+	}
+	switch scp := claims["scp"].(type) {
+	case string:
+		return containsString(strings.Fields(scp), scope)
+	case []any:
+		for _, s := range scp {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksCache keeps the public keys of a JSON Web Key Set by key id
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]any
+	fetchedAt time.Time
+	// fetchMu lets a single request fetch the key set while the others wait for its result
+	fetchMu sync.Mutex
+}
+
+func (k *jwksCache) keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+
+	// An unknown key id usually means the provider rotated its keys
+	k.fetchMu.Lock()
+	defer k.fetchMu.Unlock()
+	k.mu.RLock()
+	stale := time.Since(k.fetchedAt) >= jwksMinRefresh
+	k.mu.RUnlock()
+	if stale {
+		if err := k.fetchLocked(); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds the key by id; tokens without a key id are accepted when the set has a single key
+func (k *jwksCache) lookup(kid string) (any, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *jwksCache) refresh() error {
+	k.fetchMu.Lock()
+	defer k.fetchMu.Unlock()
+	return k.fetchLocked()
+}
+
+func (k *jwksCache) fetchLocked() error {
+	k.mu.Lock()
+	k.fetchedAt = time.Now()
+	k.mu.Unlock()
+
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Skipping JWKS key %q", jwk.Kid)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS has no usable signing keys")
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// jsonWebKey is a public key of a JWKS (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+// Text directions of factsheet layouts, selected with direction
+const (
+	directionLTR = "ltr"
+	directionRTL = "rtl"
+)
+
+// arabicForm holds the presentation forms of an Arabic letter: isolated, final, initial and medial.
+// Letters that only join the letter before them have no initial and medial forms.
+type arabicForm [4]rune
+
+func (f arabicForm) dualJoining() bool { return f[2] != 0 }
+
+// arabicForms maps the Arabic letters to their presentation forms. PDF viewers draw the code points they
+// are given, so the letters are replaced by the form their position in a word calls for.
+var arabicForms = buildArabicForms()
+
+func buildArabicForms() map[rune]arabicForm {
+	forms := map[rune]arabicForm{0x0621: {0xFE80}}
+	// The letters of U+0622-U+064A have consecutive forms from U+FE81, two for letters that only join
+	// the letter before them and four for the others
+	rightJoining := "آأؤإاةدذرزوى"
+	next := rune(0xFE81)
+	for r := rune(0x0622); r <= 0x064A; r++ {
+		if r >= 0x063B && r <= 0x0640 {
+			continue
+		}
+		if strings.ContainsRune(rightJoining, r) {
+			forms[r] = arabicForm{next, next + 1}
+			next += 2
+		} else {
+			forms[r] = arabicForm{next, next + 1, next + 2, next + 3}
+			next += 4
+		}
+	}
+	// Persian and Urdu letters
+	for r, first := range map[rune]rune{0x067E: 0xFB56, 0x0686: 0xFB7A, 0x06A9: 0xFB8E, 0x06AF: 0xFB92, 0x06CC: 0xFBFC} {
+		forms[r] = arabicForm{first, first + 1, first + 2, first + 3}
+	}
+	forms[0x0698] = arabicForm{0xFB8A, 0xFB8B}
+	return forms
+}
+
+// lamAlef holds the isolated form of the ligature of lam with each kind of alef, the final form follows
+var lamAlef = map[rune]rune{0x0622: 0xFEF5, 0x0623: 0xFEF7, 0x0625: 0xFEF9, 0x0627: 0xFEFB}
+
+const (
+	arabicLam     = 0x0644
+	arabicTatweel = 0x0640
+)
+
+// shapeArabic replaces Arabic letters with the presentation forms that join them into words, text in
+// other scripts is left alone
+func shapeArabic(text string) string {
+	if !strings.ContainsFunc(text, func(r rune) bool { return r >= 0x0600 && r <= 0x06FF }) {
+		return text
+	}
+	runes := []rune(text)
+
+	// joinsNext and joinsPrevious look past the vowel marks, which sit on the letters
+	joinsNext := func(i int) bool {
+		for i--; i >= 0 && unicode.Is(unicode.Mn, runes[i]); i-- {
+		}
+		if i < 0 {
+			return false
+		}
+		form, ok := arabicForms[runes[i]]
+		return runes[i] == arabicTatweel || ok && form.dualJoining()
+	}
+	joinsPrevious := func(i int) bool {
+		for i++; i < len(runes) && unicode.Is(unicode.Mn, runes[i]); i++ {
+		}
+		if i >= len(runes) {
+			return false
+		}
+		form, ok := arabicForms[runes[i]]
+		return runes[i] == arabicTatweel || ok && form[1] != 0
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		form, ok := arabicForms[r]
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+		previous := joinsNext(i)
+		if r == arabicLam && i+1 < len(runes) {
+			if ligature, ok := lamAlef[runes[i+1]]; ok {
+				if previous {
+					ligature++
+				}
+				b.WriteRune(ligature)
+				i++
+				continue
+			}
+		}
+		next := form.dualJoining() && joinsPrevious(i)
+		switch {
+		case previous && next:
+			b.WriteRune(form[3])
+		case previous && form[1] != 0:
+			b.WriteRune(form[1])
+		case next:
+			b.WriteRune(form[2])
+		default:
+			b.WriteRune(form[0])
+		}
+	}
+	return b.String()
+}
+
+// textDirection is the direction of the first letter of text that has one, or fallback
+func textDirection(text, fallback string) string {
+	for _, r := range text {
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.L:
+			return directionLTR
+		case bidi.R, bidi.AL:
+			return directionRTL
+		}
+	}
+	return fallback
+}
+
+// visualOrder arranges a line of text in the order it is drawn from left to right, following the
+// Unicode bidirectional algorithm for text without explicit embeddings: right-to-left words, e.g. in
+// Hebrew or Arabic, are reversed and so is the order of the words on a right-to-left line, while
+// numbers and Latin words inside them keep reading left to right. direction applies to lines that
+// start without a letter.
+func visualOrder(line, direction string) string {
+	if !strings.ContainsFunc(line, isRTLRune) {
+		return line
+	}
+	base := 0
+	if textDirection(line, direction) == directionRTL {
+		base = 1
+	}
+
+	// Vowel marks and other combining characters stay with the letter before them
+	type cluster struct {
+		runes []rune
+		class bidi.Class
+		level int
+	}
+	var clusters []*cluster
+	for _, r := range line {
+		props, _ := bidi.LookupRune(r)
+		if props.Class() == bidi.NSM && len(clusters) > 0 {
+			last := clusters[len(clusters)-1]
+			last.runes = append(last.runes, r)
+			continue
+		}
+		clusters = append(clusters, &cluster{runes: []rune{r}, class: props.Class()})
+	}
+
+	// Resolve every cluster to left-to-right, right-to-left or a number. Separators inside numbers and
+	// signs next to them, as in "+1 555-0100" or "12,500", belong to the number.
+	const (
+		strongL = iota
+		strongR
+		number
+		neutral
+	)
+	kinds := make([]int, len(clusters))
+	for i, c := range clusters {
+		switch c.class {
+		case bidi.L:
+			kinds[i] = strongL
+		case bidi.R, bidi.AL:
+			kinds[i] = strongR
+		case bidi.EN, bidi.AN:
+			kinds[i] = number
+		default:
+			kinds[i] = neutral
+		}
+	}
+	for i, c := range clusters {
+		between := i > 0 && i < len(clusters)-1 && kinds[i-1] == number && kinds[i+1] == number
+		if (c.class == bidi.ES || c.class == bidi.CS) && between {
+			kinds[i] = number
+		}
+	}
+	for pass := 0; pass < 2; pass++ {
+		for i, c := range clusters {
+			next := i+1 < len(clusters) && kinds[i+1] == number
+			if c.class == bidi.ET && (i > 0 && kinds[i-1] == number || next) {
+				kinds[i] = number
+			}
+		}
+	}
+
+	// Numbers take the direction of the letters before them, neutrals that of their surroundings when
+	// both sides agree and the line's otherwise
+	directions := make([]int, len(clusters))
+	previous := base
+	for i, kind := range kinds {
+		switch kind {
+		case strongL:
+			previous, directions[i] = 0, 0
+		case strongR:
+			previous, directions[i] = 1, 1
+		case number:
+			directions[i] = previous
+		default:
+			directions[i] = -1
+		}
+	}
+	for i := 0; i < len(clusters); {
+		if directions[i] != -1 {
+			i++
+			continue
+		}
+		end := i
+		for end < len(clusters) && directions[end] == -1 {
+			end++
+		}
+		before, after := base, base
+		if i > 0 {
+			before = directions[i-1]
+		}
+		if end < len(clusters) {
+			after = directions[end]
+		}
+		direction := base
+		if before == after {
+			direction = before
+		}
+		for ; i < end; i++ {
+			directions[i] = direction
+		}
+	}
+
+	// Embedding levels: right-to-left text is odd, left-to-right text and numbers inside right-to-left
+	// text are even and above it
+	maxLevel := 0
+	for i, c := range clusters {
+		switch {
+		case kinds[i] == number && directions[i] == 1:
+			c.level = 2
+		case directions[i] == 1:
+			c.level = 1
+		default:
+			c.level = base * 2
+		}
+		maxLevel = max(maxLevel, c.level)
+	}
+
+	// Reverse every sequence at or above each level, from the highest level down to 1
+	for level := maxLevel; level >= 1; level-- {
+		for i := 0; i < len(clusters); {
+			if clusters[i].level < level {
+				i++
+				continue
+			}
+			end := i
+			for end < len(clusters) && clusters[end].level >= level {
+				end++
+			}
+			for a, b := i, end-1; a < b; a, b = a+1, b-1 {
+				clusters[a], clusters[b] = clusters[b], clusters[a]
+			}
+			i = end
+		}
+	}
+
+	var b strings.Builder
+	for _, c := range clusters {
+		if c.level%2 == 1 && len(c.runes) == 1 {
+			// Brackets are mirrored on right-to-left runs, "(" reads as ")"
+			b.WriteString(bidi.ReverseString(string(c.runes)))
+			continue
+		}
+		b.WriteString(string(c.runes))
+	}
+	return b.String()
+}
+
+func isRTLRune(r rune) bool {
+	props, _ := bidi.LookupRune(r)
+	return props.Class() == bidi.R || props.Class() == bidi.AL
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// blindTemplateTokens are the filename_template tokens that would give a blind candidate away
+var blindTemplateTokens = regexp.MustCompile(`\{(name|email)\}`)
+
+// assignCandidateCodes gives the candidates of a blind job that came without a candidate_code one by their
+// position in the request, CAND-001, CAND-002 and so on, so every instance derives the same codes
+func assignCandidateCodes(candidates []Candidate) {
+	for i := range candidates {
+		if candidates[i].CandidateCode == "" {
+			candidates[i].CandidateCode = fmt.Sprintf("CAND-%03d", i+1)
+		}
+	}
+}
+
+// blinded is the candidate as the packets of blind jobs show them: known by their code, without their name,
+// email, phone number and photo, nor the profile, links and resume source that would lead to them
+func (cand Candidate) blinded() Candidate {
+	cand.Name = cand.CandidateCode
+	cand.Email, cand.MobileNo, cand.PhotoURL, cand.ProfileURL = "", "", "", ""
+	cand.ResumeURL, cand.ResumeFilename = "", ""
+	cand.Links = nil
+	return cand
+}
+
+// shown is the candidate as the job's packets and archive files show them
+func (o JobOptions) shown(cand Candidate) Candidate {
+	if !o.Blind {
+		return cand
+	}
+	return cand.blinded()
+}
+
+// shownCandidates are the candidates of the request as its archive files show them
+func (req ProcessRequest) shownCandidates() []Candidate {
+	if !req.Blind {
+		return req.Candidates
+	}
+	shown := make([]Candidate, len(req.Candidates))
+	for i, cand := range req.Candidates {
+		shown[i] = cand.blinded()
+	}
+	return shown
+}
+
+// factsheetTitle heads the factsheets of the job, marking those of blind jobs
+func (o JobOptions) factsheetTitle() string {
+	if o.Blind {
+		return "BLIND FACTSHEET"
+	}
+	return "CANDIDATE FACTSHEET"
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// maxLogoSize keeps logos small, they are stored with the tenant settings and embedded in every factsheet
+const maxLogoSize = 512 << 10
+
+// logoHeight is the height of the logo in the letterhead, in mm
+const logoHeight = 15.0
+
+// Branding makes a tenant's factsheets look like its own stationery
+type Branding struct {
+	// Logo is a PNG, JPEG or GIF image, base64 encoded in JSON, drawn at the top of every page
+	Logo []byte `json:"logo,omitempty"`
+	// PrimaryColor fills the title bar and SecondaryColor the label column, both as "#RRGGBB"
+	PrimaryColor   string `json:"primary_color,omitempty"`
+	SecondaryColor string `json:"secondary_color,omitempty"`
+	// HeaderText is printed next to the logo, e.g. the company address, and FooterText at the bottom of
+	// every page. Both may have several lines.
+	HeaderText string `json:"header_text,omitempty"`
+	FooterText string `json:"footer_text,omitempty"`
+}
+
+func (b *Branding) validate() error {
+	if len(b.Logo) > 0 {
+		if len(b.Logo) > maxLogoSize {
+			return fmt.Errorf("logo exceeds the limit of %s", formatBytes(maxLogoSize))
+		}
+		if _, _, _, _, err := prepareImage(b.Logo); err != nil {
+			return fmt.Errorf("logo: %w", err)
+		}
+	}
+	for name, value := range map[string]string{"primary_color": b.PrimaryColor, "secondary_color": b.SecondaryColor} {
+		if _, _, _, err := parseColor(value); value != "" && err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parseColor reads a "#RRGGBB" color
+func parseColor(s string) (r, g, b int, err error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 {
+		return 0, 0, 0, errors.New("color must look like #1f4e79")
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, errors.New("color must look like #1f4e79")
+	}
+	return int(rgb >> 16), int(rgb >> 8 & 0xFF), int(rgb & 0xFF), nil
+}
+
+// fillColor sets the fill color to a branding color, or to the gray given when the tenant has none.
+// It reports whether the branding color was used.
+func fillColor(pdf *gofpdf.Fpdf, color string, gray int) bool {
+	if r, g, b, err := parseColor(color); err == nil {
+		pdf.SetFillColor(r, g, b)
+		return true
+	}
+	pdf.SetFillColor(gray, gray, gray)
+	return false
+}
+
+// textColor sets the text color to a branding color, or to the gray given when the tenant has none
+func textColor(pdf *gofpdf.Fpdf, color string, gray int) {
+	if r, g, b, err := parseColor(color); err == nil {
+		pdf.SetTextColor(r, g, b)
+		return
+	}
+	pdf.SetTextColor(gray, gray, gray)
+}
+
+// LogoURI returns the logo as a data: URI for factsheet templates, which may not fetch anything
+func (b Branding) LogoURI() template.URL {
+	if len(b.Logo) == 0 {
+		return ""
+	}
+	return template.URL("data:" + http.DetectContentType(b.Logo) + ";base64," + base64.StdEncoding.EncodeToString(b.Logo))
+}
+
+// drawLetterhead puts the tenant's logo, header text and footer text on every page of a factsheet. The
+// logo goes in the leading corner, on the right for right-to-left layouts, and the header text opposite.
+func drawLetterhead(pdf *gofpdf.Fpdf, text *factsheetText, branding *Branding, direction string) {
+	var logoOpts gofpdf.ImageOptions
+	var logoWidth float64
+	if len(branding.Logo) > 0 {
+		// The logo was checked when it was saved
+		data, imageType, width, height, err := prepareImage(branding.Logo)
+		if err == nil {
+			logoOpts = gofpdf.ImageOptions{ImageType: imageType}
+			pdf.RegisterImageOptionsReader("logo", logoOpts, bytes.NewReader(data))
+			logoWidth = logoHeight * float64(width) / float64(height)
+		}
+	}
+
+	if logoWidth > 0 || branding.HeaderText != "" {
+		pdf.SetHeaderFunc(func() {
+			left, top, right, _ := pdf.GetMargins()
+			pageWidth, _ := pdf.GetPageSize()
+			logoX, textX, textAlign := left, left+logoWidth+5, "R"
+			if direction == directionRTL {
+				logoX, textX, textAlign = pageWidth-right-logoWidth, left, "L"
+			}
+			if logoWidth > 0 {
+				pdf.ImageOptions("logo", logoX, top, logoWidth, logoHeight, false, logoOpts, 0, "")
+			}
+			pdf.SetTextColor(80, 80, 80)
+			textWidth := pageWidth - left - right - logoWidth - 5
+			for i, line := range strings.Split(branding.HeaderText, "\n") {
+				pdf.SetXY(textX, top+float64(i)*4)
+				pdf.CellFormat(textWidth, 4, text.font("", 9, line), "", 0, textAlign, false, 0, "")
+			}
+			pdf.SetTextColor(0, 0, 0)
+			lines := strings.Count(branding.HeaderText, "\n") + 1
+			pdf.SetXY(left, top+max(logoHeight, float64(lines)*4)+5)
+		})
+	}
+
+	if branding.FooterText != "" {
+		lines := strings.Split(branding.FooterText, "\n")
+		// Content breaks onto the next page before it reaches the footer
+		pdf.SetAutoPageBreak(true, 15+float64(len(lines))*4)
+		pdf.SetFooterFunc(func() {
+			pdf.SetY(-10 - float64(len(lines))*4)
+			pdf.SetTextColor(128, 128, 128)
+			for _, line := range lines {
+				pdf.CellFormat(0, 4, text.font("", 8, line), "", 1, "C", false, 0, "")
+			}
+			pdf.SetTextColor(0, 0, 0)
+		})
+	}
+}
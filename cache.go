@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resumeCache keeps downloaded resumes and their converted PDFs on disk, so candidates submitted again in
+// later jobs skip the download and the conversion. Downloads are indexed by tenant, URL and request
+// headers, so a resume fetched with one tenant's credentials is never handed to another; the files
+// themselves and the converted PDFs are stored by content hash.
+//
+//	urls/<key>.json  cacheEntry of a URL
+//	files/<sha256>   downloaded resume
+//	pdf/<sha256>     PDF converted from the resume with that hash
+type resumeCache struct {
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+
+	// mu guards size and serializes adding and evicting files
+	mu   sync.Mutex
+	size int64
+}
+
+// cacheEntry records what a URL returned the last time it was downloaded
+type cacheEntry struct {
+	Hash         string    `json:"hash"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Filename     string    `json:"filename,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// resumes is nil unless the resume cache is enabled
+var resumes *resumeCache
+
+func setupResumeCache() {
+	cfg := appConfig.Cache
+	if cfg.MaxSize <= 0 {
+		return
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(appConfig.Processing.ScratchDir, "resume-cache")
+	}
+	for _, sub := range []string{"urls", "files", "pdf"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			logger.Warn().Err(err).Msgf("Resume cache disabled, cannot create %s", dir)
+			return
+		}
+	}
+
+	c := &resumeCache{dir: dir, maxSize: int64(cfg.MaxSize), ttl: cfg.TTL}
+	for _, f := range c.cachedFiles() {
+		c.size += f.size
+	}
+	resumes = c
+	logger.Info().Msgf("Resume cache enabled in %s (%s of %s used)", dir, formatBytes(c.size), formatBytes(c.maxSize))
+}
+
+// fetchResume downloads a resume, through the cache when it is enabled
+func fetchResume(ctx context.Context, tenant string, cand Candidate, outputPath string) (downloadInfo, error) {
+	guard := tenants.downloadGuard(tenant)
+	if resumes == nil {
+		return downloadFile(ctx, guard, cand.ResumeURL, cand.ResumeHeaders, outputPath)
+	}
+	return resumes.download(ctx, guard, tenant, cand.ResumeURL, cand.ResumeHeaders, outputPath)
+}
+
+// convertResume converts a resume to PDF, reusing an earlier conversion of the same content
+func convertResume(ctx context.Context, inputPath, outputDir string, format resumeFormat) (string, error) {
+	if resumes == nil {
+		return convertFormat(ctx, inputPath, outputDir, format)
+	}
+
+	hash, err := hashFile(inputPath)
+	if err != nil {
+		return "", err
+	}
+	outputPath := filepath.Join(outputDir, "resume.pdf")
+	if err := resumes.restore(filepath.Join("pdf", hash), outputPath); err == nil {
+		logFrom(ctx).Debug().Msgf("Using cached conversion of %s", inputPath)
+		return outputPath, nil
+	}
+
+	converted, err := convertFormat(ctx, inputPath, outputDir, format)
+	if err != nil {
+		return "", err
+	}
+	resumes.add(converted, filepath.Join("pdf", hash))
+	return converted, nil
+}
+
+// download serves a resume from the cache when it is still current and downloads it otherwise. Entries
+// with an ETag or Last-Modified date are revalidated with a conditional request, others are reused
+// until the TTL passes.
+func (c *resumeCache) download(ctx context.Context, guard *downloadGuard, tenant, rawURL string, headers ResumeHeaders, outputPath string) (downloadInfo, error) {
+	key := cacheKey(tenant, rawURL, headers)
+	entry, cached := c.lookup(key)
+
+	conditional := headers
+	if cached {
+		if entry.ETag == "" && entry.LastModified == "" {
+			if time.Since(entry.FetchedAt) < c.ttl && c.restore(filepath.Join("files", entry.Hash), outputPath) == nil {
+				logFrom(ctx).Debug().Msgf("Using cached resume for %s", redactURL(rawURL))
+				return downloadInfo{ContentType: entry.ContentType, Filename: entry.Filename}, nil
+			}
+		} else {
+			validators := ResumeHeaders{}
+			if entry.ETag != "" {
+				validators["If-None-Match"] = entry.ETag
+			}
+			if entry.LastModified != "" {
+				validators["If-Modified-Since"] = entry.LastModified
+			}
+			conditional = headers.merge(validators)
+		}
+	}
+
+	info, err := downloadFile(ctx, guard, rawURL, conditional, outputPath)
+	if err != nil {
+		return info, err
+	}
+	if info.NotModified {
+		if err := c.restore(filepath.Join("files", entry.Hash), outputPath); err != nil {
+			// Evicted since the lookup, fetch it once more without validators
+			retry, err := downloadFile(ctx, guard, rawURL, headers, outputPath)
+			retry.Attempts += info.Attempts
+			if err != nil {
+				return retry, err
+			}
+			info = retry
+		} else {
+			logFrom(ctx).Debug().Msgf("Cached resume for %s is still current", redactURL(rawURL))
+			entry.FetchedAt = time.Now()
+			c.saveEntry(key, entry)
+			info.ContentType, info.Filename = entry.ContentType, entry.Filename
+			return info, nil
+		}
+	}
+
+	hash, err := hashFile(outputPath)
+	if err != nil {
+		logFrom(ctx).Error().Err(err).Msg("Error caching resume")
+		return info, nil
+	}
+	c.add(outputPath, filepath.Join("files", hash))
+	c.saveEntry(key, cacheEntry{
+		Hash:         hash,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+		Filename:     info.Filename,
+		FetchedAt:    time.Now(),
+	})
+	return info, nil
+}
+
+// cacheKey identifies a download by tenant, URL and headers. The header values are part of the key
+// since they usually hold the credentials the document store checks.
+func cacheKey(tenant, rawURL string, headers ResumeHeaders) string {
+	h := sha256.New()
+	io.WriteString(h, tenant+"\x00"+rawURL)
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		io.WriteString(h, "\x00"+name+":"+headers[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *resumeCache) lookup(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, err := os.ReadFile(filepath.Join(c.dir, "urls", key+".json"))
+	if err != nil || json.Unmarshal(data, &entry) != nil {
+		return entry, false
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "files", entry.Hash)); err != nil {
+		os.Remove(filepath.Join(c.dir, "urls", key+".json"))
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *resumeCache) saveEntry(key string, entry cacheEntry) {
+	data, _ := json.Marshal(entry)
+	path := filepath.Join(c.dir, "urls", key+".json")
+	if err := writeFileAtomic(path, data); err != nil {
+		logger.Error().Err(err).Msg("Error writing resume cache entry")
+	}
+}
+
+// restore copies a cached file to outputPath, decrypted, and marks it as recently used
+func (c *resumeCache) restore(name, outputPath string) error {
+	path := filepath.Join(c.dir, name)
+	if err := unsealFile(path, outputPath); err != nil {
+		return err
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return nil
+}
+
+// add copies a file into the cache and evicts the least recently used files once the cache is full
+func (c *resumeCache) add(srcPath, name string) {
+	path := filepath.Join(c.dir, name)
+	tmp, err := copyToTemp(srcPath, filepath.Dir(path))
+	if err == nil {
+		if err = sealFile(tmp); err != nil {
+			os.Remove(tmp)
+		}
+	}
+	if err != nil {
+		logger.Error().Err(err).Msgf("Error caching %s", srcPath)
+		return
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Error().Err(err).Msgf("Error caching %s", srcPath)
+		os.Remove(tmp)
+		return
+	}
+	c.size += info.Size()
+	if c.size > c.maxSize {
+		c.evictLocked()
+	}
+}
+
+type cachedFile struct {
+	path   string
+	size   int64
+	usedAt time.Time
+}
+
+func (c *resumeCache) cachedFiles() []cachedFile {
+	var files []cachedFile
+	for _, sub := range []string{"files", "pdf"} {
+		entries, _ := os.ReadDir(filepath.Join(c.dir, sub))
+		for _, e := range entries {
+			info, err := e.Info()
+			// Files still being copied in are not counted until they are renamed into place
+			if err != nil || !info.Mode().IsRegular() || strings.HasPrefix(e.Name(), ".tmp-") {
+				continue
+			}
+			files = append(files, cachedFile{path: filepath.Join(c.dir, sub, e.Name()), size: info.Size(), usedAt: info.ModTime()})
+		}
+	}
+	return files
+}
+
+// evictLocked removes the least recently used files until the cache is below 90% of its size, leaving
+// room for a few more resumes before the next eviction
+func (c *resumeCache) evictLocked() {
+	files := c.cachedFiles()
+	sort.Slice(files, func(i, j int) bool { return files[i].usedAt.Before(files[j].usedAt) })
+
+	c.size = 0
+	for _, f := range files {
+		c.size += f.size
+	}
+	target := c.maxSize / 10 * 9
+	removed := 0
+	for _, f := range files {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		c.size -= f.size
+		removed++
+	}
+	logger.Debug().Msgf("Evicted %d files from the resume cache (%s of %s used)", removed, formatBytes(c.size), formatBytes(c.maxSize))
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// copyToTemp copies a file to a new temporary file in dir, to be renamed into place
+func copyToTemp(srcPath, dir string) (string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// candidateIDPattern keeps candidate IDs usable as file names on every file system as they are
+var candidateIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// validateCandidateID checks the candidate's external ID, the ATS's own key for them
+func (cand Candidate) validateCandidateID() error {
+	if cand.CandidateID == "" || candidateIDPattern.MatchString(cand.CandidateID) {
+		return nil
+	}
+	return errors.New("candidate_id must be up to 64 letters, digits, '.', '-' or '_', starting with a letter or digit")
+}
+
+// fileKey names the candidate's files: their candidate_id, or their email for callers that send none
+func (cand Candidate) fileKey() string {
+	if cand.CandidateID != "" {
+		return cand.CandidateID
+	}
+	return strings.ReplaceAll(cand.Email, "@", "_")
+}
+
+// checkDuplicateIDs rejects candidates of a request sharing a candidate_id, whose packets would overwrite each
+// other. IDs differing in case only are duplicates too, they name the same file on some file systems.
+func checkDuplicateIDs(candidates []Candidate, errs *requestErrors) {
+	seen := map[string]int{}
+	for i, cand := range candidates {
+		if cand.CandidateID == "" {
+			continue
+		}
+		id := strings.ToLower(cand.CandidateID)
+		if first, ok := seen[id]; ok {
+			errs.add(fmt.Sprintf("candidates[%d].candidate_id", i), fmt.Sprintf("%q is already used by candidate %d", cand.CandidateID, first))
+			continue
+		}
+		seen[id] = i
+	}
+}
+
+// key identifies the candidate in job errors, like the candidate_id or email of the request
+func (p CandidateProgress) key() string {
+	if p.CandidateID != "" {
+		return p.CandidateID
+	}
+	return p.Email
+}
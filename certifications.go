@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxCertifications keeps the certifications table of a candidate to a page
+const maxCertifications = 30
+
+// Certification is a certificate or license the candidate holds
+type Certification struct {
+	Name   string `json:"name"`
+	Issuer string `json:"issuer,omitempty"`
+	// Expiry is the last day it is valid, e.g. "2026-06-30", or its month, e.g. "2026-06"; empty if it does
+	// not expire
+	Expiry string `json:"expiry,omitempty"`
+}
+
+// certificationsTable is the layout of the certifications section
+var certificationsTable = factsheetTable{
+	titles: []string{"Certification", "Issuer", "Expires"},
+	widths: []float64{0.46, 0.3, 0.24},
+}
+
+// expiryLayouts are the accepted formats of expiry dates, and how each is printed
+var expiryLayouts = []struct{ parse, print string }{
+	{"2006-01-02", "2 Jan 2006"},
+	{"2006-01", "Jan 2006"},
+}
+
+// expiresAt is the first moment the certification is no longer valid, and its expiry as it is printed
+func (c Certification) expiresAt() (time.Time, string, error) {
+	for _, layout := range expiryLayouts {
+		if t, err := time.Parse(layout.parse, c.Expiry); err == nil {
+			if layout.parse == "2006-01" {
+				return t.AddDate(0, 1, 0), t.Format(layout.print), nil
+			}
+			return t.AddDate(0, 0, 1), t.Format(layout.print), nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("%q is not a date such as 2026-06-30 or a month such as 2026-06", c.Expiry)
+}
+
+// Expired reports whether the certification has run out by now
+func (c Certification) Expired() bool {
+	end, _, err := c.expiresAt()
+	return c.Expiry != "" && err == nil && !time.Now().Before(end)
+}
+
+// ExpiryDate is the expiry as it is printed, e.g. "30 Jun 2026", empty if it does not expire
+func (c Certification) ExpiryDate() string {
+	_, printed, err := c.expiresAt()
+	if c.Expiry == "" || err != nil {
+		return ""
+	}
+	return printed
+}
+
+// validateCertifications checks the certifications of a candidate
+func (cand Candidate) validateCertifications() error {
+	if len(cand.Certifications) > maxCertifications {
+		return fmt.Errorf("at most %d certifications are allowed", maxCertifications)
+	}
+	for i, cert := range cand.Certifications {
+		if strings.TrimSpace(cert.Name) == "" {
+			return fmt.Errorf("certification %d needs a name", i)
+		}
+		if _, _, err := cert.expiresAt(); cert.Expiry != "" && err != nil {
+			return fmt.Errorf("certification %d expiry: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// drawCertifications draws the candidate's certifications as a table in a column of the page from y like
+// drawEducation, expired ones in red and marked as such
+func (p factsheetPage) drawCertifications(x, y, width, size, bottom float64) (float64, int) {
+	if len(p.cand.Certifications) == 0 {
+		return y, 0
+	}
+	table := certificationsTable
+	table.size, table.clip, table.bottom = size, 4, bottom
+	if bottom > 0 {
+		table.clip = 1
+	}
+	for _, cert := range p.cand.Certifications {
+		expiry := cert.ExpiryDate()
+		if cert.Expired() {
+			expiry += " (expired)"
+		}
+		table.rows = append(table.rows, []string{cert.Name, cert.Issuer, expiry})
+		table.flagged = append(table.flagged, cert.Expired())
+	}
+	return p.drawTable(x, y, width, table)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// combinedPDFName is the file at the root of the archive holding every candidate's packet
+const combinedPDFName = "all_candidates.pdf"
+
+// tocRowsPerPage is how many candidates the table of contents lists on a page
+const tocRowsPerPage = 28
+
+// combinedEntry is a candidate packet in the combined PDF, followed by its separate documents if any, start
+// is its first page counted from 0
+type combinedEntry struct {
+	index int
+	name  string
+	paths []string
+	pages int
+	start int
+}
+
+// pdfLink is a clickable area on a page that jumps to another page, pages counted from 0 and the area in
+// PDF points from the bottom left corner
+type pdfLink struct {
+	page   int
+	rect   [4]float64
+	target int
+}
+
+// pdfBookmark is an entry of the document outline, the bookmarks panel of PDF viewers
+type pdfBookmark struct {
+	title string
+	page  int
+}
+
+// buildCombinedPDF concatenates the packets of the job's processed candidates, in the order they were
+// submitted, into one PDF in factsheetDir behind a table of contents. Each candidate gets a bookmark
+// and a clickable entry in the table of contents. It returns the page each candidate starts on, by
+// position in the request.
+func buildCombinedPDF(job *Job, req ProcessRequest, factsheetDir string) (map[int]int, error) {
+	ctx := context.Background()
+	tempDir := filepath.Join(filepath.Dir(factsheetDir), "temp")
+	job.mu.Lock()
+	progress := append([]CandidateProgress(nil), job.Candidates...)
+	job.mu.Unlock()
+
+	var entries []combinedEntry
+	fileNames := packetFileNames(job.ID, req)
+	for i, cand := range req.shownCandidates() {
+		if i >= len(progress) || progress[i].Status != candidateStatusCompleted {
+			continue
+		}
+		files := []string{fileNames[i]}
+		if req.OutputMode == outputModeSeparate {
+			files = append(files, existingFiles(factsheetDir, separateFileNames(fileNames[i], cand))...)
+		}
+		entry := combinedEntry{index: i, name: cand.Name}
+		for _, file := range files {
+			path := filepath.Join(factsheetDir, file)
+			pages, err := pdfPageCount(ctx, path, "")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			entry.paths, entry.pages = append(entry.paths, path), entry.pages+pages
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	tocPages := (len(entries) + tocRowsPerPage - 1) / tocRowsPerPage
+	next := tocPages
+	for i := range entries {
+		entries[i].start = next
+		next += entries[i].pages
+	}
+
+	os.MkdirAll(tempDir, 0700)
+	tocPath := filepath.Join(tempDir, "contents.pdf")
+	defer os.Remove(tocPath)
+	links, err := renderContents(entries, req, tocPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render table of contents: %w", err)
+	}
+
+	inputs := []string{tocPath}
+	bookmarks := []pdfBookmark{{title: "Contents", page: 0}}
+	for _, entry := range entries {
+		inputs = append(inputs, entry.paths...)
+		bookmarks = append(bookmarks, pdfBookmark{title: entry.name, page: entry.start})
+	}
+	combinedPath := filepath.Join(tempDir, combinedPDFName)
+	if err := mergePDFs(ctx, combinedPath, inputs...); err != nil {
+		os.Remove(combinedPath)
+		return nil, err
+	}
+
+	// The page numbers in the table of contents still lead the way without bookmarks and links
+	if err := addNavigation(ctx, combinedPath, bookmarks, links); err != nil {
+		job.log().Warn().Err(err).Msgf("Combined PDF of job %s has no bookmarks", job.ID)
+	}
+	info := batchInfo(jobInfo{job.ID, req.TenantName, req.CompanyName}, "All Candidates")
+	if err := setPDFInfo(ctx, combinedPath, info); err != nil {
+		job.log().Warn().Err(err).Msgf("Failed to set document information of the combined PDF of job %s", job.ID)
+	}
+	if err := os.Rename(combinedPath, filepath.Join(factsheetDir, combinedPDFName)); err != nil {
+		return nil, err
+	}
+	starts := make(map[int]int, len(entries))
+	for _, entry := range entries {
+		starts[entry.index] = entry.start + 1
+	}
+	return starts, nil
+}
+
+// renderContents draws the table of contents of the combined PDF: a row per candidate with the page
+// their packet starts on. It returns the area of each row to link to that page.
+func renderContents(entries []combinedEntry, req ProcessRequest, outputPath string) ([]pdfLink, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	text := newFactsheetText(pdf, req.direction())
+	pdf.SetAutoPageBreak(false, 0)
+	_, pageHeight := pdf.GetPageSize()
+	left, _, _, _ := pdf.GetMargins()
+	const rowHeight, nameWidth, pageWidth = 8.0, 160.0, 30.0
+	align := "L"
+	if req.direction() == directionRTL {
+		align = "R"
+	}
+
+	var links []pdfLink
+	for i, entry := range entries {
+		row := i % tocRowsPerPage
+		if row == 0 {
+			pdf.AddPage()
+			pdf.SetTextColor(0, 0, 0)
+			pdf.SetFillColor(240, 240, 240)
+			heading := "CANDIDATES"
+			if company := strings.TrimSpace(req.CompanyName); company != "" {
+				heading += " - " + company
+			}
+			pdf.CellFormat(190, 12, text.font("B", 16, heading), "1", 1, "C", true, 0, "")
+			pdf.Ln(6)
+		}
+
+		y := pdf.GetY()
+		number := fmt.Sprintf("%d", entry.start+1)
+		nameX, numberX, numberAlign := left, left+nameWidth, "R"
+		if align == "R" {
+			nameX, numberX, numberAlign = left+pageWidth, left, "L"
+		}
+		pdf.SetXY(nameX, y)
+		pdf.CellFormat(nameWidth, rowHeight, text.font("", 11, entry.name), "B", 0, align, false, 0, "")
+		pdf.SetXY(numberX, y)
+		pdf.CellFormat(pageWidth, rowHeight, text.font("", 11, number), "B", 0, numberAlign, false, 0, "")
+		pdf.SetXY(left, y+rowHeight)
+
+		// gofpdf lays out from the top left in mm, PDF annotations are placed from the bottom left in points
+		scale := 72 / 25.4
+		links = append(links, pdfLink{
+			page:   i / tocRowsPerPage,
+			rect:   [4]float64{left * scale, (pageHeight - y - rowHeight) * scale, (left + nameWidth + pageWidth) * scale, (pageHeight - y) * scale},
+			target: entry.start,
+		})
+	}
+	return links, pdf.OutputFileAndClose(outputPath)
+}
+
+// addNavigation adds bookmarks and links between pages to a PDF through qpdf's JSON interface
+func addNavigation(ctx context.Context, pdfPath string, bookmarks []pdfBookmark, links []pdfLink) error {
+	doc, err := readQPDFDocument(ctx, pdfPath)
+	if err != nil {
+		return err
+	}
+	dest := func(page int) []any {
+		return []any{doc.pages[page], "/Fit"}
+	}
+	update := map[string]any{}
+
+	// Outline items form a doubly linked list under the outline root
+	outlineRef := doc.newObject()
+	var items []pdfBookmark
+	var itemRefs []string
+	for _, bookmark := range bookmarks {
+		if bookmark.page < len(doc.pages) {
+			items = append(items, bookmark)
+			itemRefs = append(itemRefs, doc.newObject())
+		}
+	}
+	for i, ref := range itemRefs {
+		item := map[string]any{
+			"/Title":  "u:" + items[i].title,
+			"/Parent": outlineRef,
+			"/Dest":   dest(items[i].page),
+		}
+		if i > 0 {
+			item["/Prev"] = itemRefs[i-1]
+		}
+		if i < len(itemRefs)-1 {
+			item["/Next"] = itemRefs[i+1]
+		}
+		update["obj:"+ref] = map[string]any{"value": item}
+	}
+	if len(itemRefs) > 0 {
+		catalog, err := doc.object(doc.root())
+		if err != nil {
+			return err
+		}
+		update["obj:"+outlineRef] = map[string]any{"value": map[string]any{
+			"/Type":  "/Outlines",
+			"/First": itemRefs[0],
+			"/Last":  itemRefs[len(itemRefs)-1],
+			"/Count": len(itemRefs),
+		}}
+		catalog["/Outlines"] = outlineRef
+		catalog["/PageMode"] = "/UseOutlines"
+		update["obj:"+doc.root()] = map[string]any{"value": catalog}
+	}
+
+	annots := map[int][]any{}
+	for _, link := range links {
+		if link.page >= len(doc.pages) || link.target >= len(doc.pages) {
+			continue
+		}
+		ref := doc.newObject()
+		update["obj:"+ref] = map[string]any{"value": map[string]any{
+			"/Type":    "/Annot",
+			"/Subtype": "/Link",
+			"/Rect":    []any{round2(link.rect[0]), round2(link.rect[1]), round2(link.rect[2]), round2(link.rect[3])},
+			"/Border":  []any{0, 0, 0},
+			"/Dest":    dest(link.target),
+		}}
+		annots[link.page] = append(annots[link.page], ref)
+	}
+	for page, refs := range annots {
+		pageObject, err := doc.object(doc.pages[page])
+		if err != nil {
+			return err
+		}
+		pageObject["/Annots"] = refs
+		update["obj:"+doc.pages[page]] = map[string]any{"value": pageObject}
+	}
+	return doc.update(ctx, pdfPath, update)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the service. Values are layered: built-in defaults, then the YAML file
+// given by -config or CONFIG_FILE, then environment variables, then command line flags. Every setting
+// has an environment variable (the env tag) and a flag named after it, e.g. WORKER_CONCURRENCY and
+// -worker-concurrency.
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Processing ProcessingConfig `yaml:"processing"`
+	Limits     RequestLimits    `yaml:"limits"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Tenants    TenantDefaults   `yaml:"tenants"`
+	Queue      QueueConfig      `yaml:"queue"`
+	Delivery   DeliveryConfig   `yaml:"delivery"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Download   DownloadConfig   `yaml:"download"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Conversion ConversionConfig `yaml:"conversion"`
+	Factsheet  FactsheetConfig  `yaml:"factsheet"`
+	// Notifications are the default chat webhooks told when a job finishes, for tenants without their own
+	Notifications NotificationConfig `yaml:"notifications"`
+}
+
+type ServerConfig struct {
+	ListenAddr string `yaml:"listen_addr" env:"LISTEN_ADDR"`
+	// InstanceID identifies this instance in the job store and the shared task queue. It should be stable
+	// across restarts (e.g. a StatefulSet pod name) so interrupted jobs can be resumed.
+	InstanceID string `yaml:"instance_id" env:"INSTANCE_ID"`
+	LogDir     string `yaml:"log_dir" env:"LOG_DIR"`
+	// LogLevel is the least severe level logged: debug, info, warn or error. LogFormat is json, one object per
+	// line, or text for reading logs in a terminal.
+	LogLevel  string `yaml:"log_level" env:"LOG_LEVEL"`
+	LogFormat string `yaml:"log_format" env:"LOG_FORMAT"`
+	// The log file is rotated once it grows beyond LogMaxSize or is older than LogMaxAge, either 0 disables
+	// that trigger. LogMaxFiles rotated files are kept, 0 keeps them all.
+	LogMaxSize      ByteSize      `yaml:"log_max_size" env:"LOG_MAX_SIZE"`
+	LogMaxAge       time.Duration `yaml:"log_max_age" env:"LOG_MAX_AGE"`
+	LogMaxFiles     int           `yaml:"log_max_files" env:"LOG_MAX_FILES"`
+	LogCompress     bool          `yaml:"log_compress" env:"LOG_COMPRESS"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// HTTPS is served when a certificate is set; the files are re-read every TLSReloadInterval
+	// so rotated certificates are picked up without a restart
+	TLSCertFile       string        `yaml:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `yaml:"tls_key_file" env:"TLS_KEY_FILE"`
+	TLSReloadInterval time.Duration `yaml:"tls_reload_interval" env:"TLS_RELOAD_INTERVAL"`
+	// TLSClientCAFile enables mutual TLS: client certificates must be signed by one of these CAs.
+	// TLSClientAuth is "require" or "optional", which only verifies certificates that are presented.
+	TLSClientCAFile string `yaml:"tls_client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth   string `yaml:"tls_client_auth" env:"TLS_CLIENT_AUTH"`
+	DisableHTTP2    bool   `yaml:"disable_http2" env:"DISABLE_HTTP2"`
+	// H2C accepts HTTP/2 without TLS, for proxies that forward cleartext HTTP/2
+	H2C bool `yaml:"h2c" env:"H2C"`
+	// DebugAddr serves pprof and expvar on a listener of its own, e.g. 127.0.0.1:6060, empty disables them
+	DebugAddr string `yaml:"debug_addr" env:"DEBUG_ADDR"`
+	// PublicURL is where people reach this service, the base of the archive links in emails and notifications
+	PublicURL string `yaml:"public_url" env:"PUBLIC_URL"`
+	// TrustedProxies are the addresses or networks of the reverse proxies in front of this service, e.g.
+	// 10.0.0.0/8. Only their X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers are believed.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// CORS lets browser apps on other origins call the API
+	CORS CORSConfig `yaml:"cors"`
+	// RateLimit caps the API requests accepted, from everyone together, from each client address and from
+	// each caller
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// RateLimitConfig sizes token buckets refilled at a number of requests per minute, 0 disables a bucket. A
+// burst of 0 lets as many requests through at once as the bucket takes in a minute.
+type RateLimitConfig struct {
+	GlobalPerMinute int `yaml:"global_per_minute" env:"RATE_LIMIT_GLOBAL_PER_MINUTE"`
+	GlobalBurst     int `yaml:"global_burst" env:"RATE_LIMIT_GLOBAL_BURST"`
+	// ClientIPPerMinute applies to each client address before authentication, CallerPerMinute after it
+	ClientIPPerMinute int `yaml:"client_ip_per_minute" env:"RATE_LIMIT_IP_PER_MINUTE"`
+	ClientIPBurst     int `yaml:"client_ip_burst" env:"RATE_LIMIT_IP_BURST"`
+	// CallerPerMinute applies to each caller: the token subject or client certificate of authenticated
+	// callers, the client address of the others
+	CallerPerMinute int `yaml:"caller_per_minute" env:"RATE_LIMIT_CALLER_PER_MINUTE"`
+	CallerBurst     int `yaml:"caller_burst" env:"RATE_LIMIT_CALLER_BURST"`
+}
+
+// CORSConfig is disabled while AllowedOrigins is empty. Origins are matched exactly, e.g.
+// https://recruit.example.com, or by a wildcard subdomain, e.g. https://*.example.com; * allows any origin.
+// MaxAge is how long browsers may cache the answer to a preflight request.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods []string      `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders []string      `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders []string      `yaml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	MaxAge         time.Duration `yaml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+type ProcessingConfig struct {
+	ScratchDir               string        `yaml:"scratch_dir" env:"TEMP_DIR"`
+	WorkerConcurrency        int           `yaml:"worker_concurrency" env:"WORKER_CONCURRENCY"`
+	MaxConcurrentConversions int           `yaml:"max_concurrent_conversions" env:"MAX_CONCURRENT_CONVERSIONS"`
+	DownloadTimeout          time.Duration `yaml:"download_timeout" env:"DOWNLOAD_TIMEOUT"`
+	ConversionTimeout        time.Duration `yaml:"conversion_timeout" env:"CONVERSION_TIMEOUT"`
+	CandidateTimeout         time.Duration `yaml:"candidate_timeout" env:"CANDIDATE_TIMEOUT"`
+	MinFreeDisk              ByteSize      `yaml:"min_free_disk" env:"MIN_FREE_DISK"`
+	MaxJobDiskUsage          ByteSize      `yaml:"max_job_disk_usage" env:"MAX_JOB_DISK_USAGE"`
+	// ScratchPerTenant gives each tenant a directory of its own under ScratchDir, tenants/<tenant>
+	ScratchPerTenant bool `yaml:"scratch_per_tenant" env:"SCRATCH_PER_TENANT"`
+	// OCRLanguages are the Tesseract languages used for jobs that ask for OCR without naming any
+	OCRLanguages []string `yaml:"ocr_languages" env:"OCR_LANGUAGES"`
+	// HTMLConverter renders HTML and Markdown resumes: chromium, wkhtmltopdf, libreoffice or gotenberg
+	HTMLConverter string `yaml:"html_converter" env:"HTML_CONVERTER"`
+	// FailFast is the policy of jobs that do not bring their own
+	FailFast FailFastPolicy `yaml:"fail_fast"`
+}
+
+// RequestLimits bound the size of job requests, 0 disables a limit. MaxFieldLength is in characters and
+// applies to the text fields of candidates, such as name, skills and custom field values.
+type RequestLimits struct {
+	MaxCandidates  int `yaml:"max_candidates" env:"MAX_CANDIDATES"`
+	MaxSkills      int `yaml:"max_skills" env:"MAX_SKILLS"`
+	MaxFieldLength int `yaml:"max_field_length" env:"MAX_FIELD_LENGTH"`
+}
+
+type StorageConfig struct {
+	JobStoreDSN            string        `yaml:"job_store_dsn" env:"JOB_STORE_DSN"`
+	ArchiveDir             string        `yaml:"archive_dir" env:"ARCHIVE_DIR"`
+	ArchiveRetention       time.Duration `yaml:"archive_retention" env:"ARCHIVE_RETENTION"`
+	ArchiveCleanupInterval time.Duration `yaml:"archive_cleanup_interval" env:"ARCHIVE_CLEANUP_INTERVAL"`
+	// JobRetention is how long the records of finished jobs are kept, 0 keeps them forever
+	JobRetention time.Duration `yaml:"job_retention" env:"JOB_RETENTION"`
+	// Encryption seals the archives and the resume cache on disk
+	Encryption AtRestConfig `yaml:"encryption"`
+}
+
+// AtRestConfig enables encryption at rest with a 32 byte AES-256 key, base64 encoded: Key itself, or
+// WrappedKey, a data key encrypted with KMSKey and decrypted with it at startup. KMSKey is an AWS KMS key
+// ARN or a Google Cloud KMS key name, projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>.
+type AtRestConfig struct {
+	Key        string `yaml:"key" env:"AT_REST_KEY"`
+	WrappedKey string `yaml:"wrapped_key" env:"AT_REST_WRAPPED_KEY"`
+	KMSKey     string `yaml:"kms_key" env:"AT_REST_KMS_KEY"`
+}
+
+// TenantDefaults apply to tenants without settings of their own
+type TenantDefaults struct {
+	MaxConcurrentCandidates int           `yaml:"max_concurrent_candidates" env:"TENANT_MAX_CONCURRENT_CANDIDATES"`
+	CandidatesPerMinute     int           `yaml:"candidates_per_minute" env:"TENANT_CANDIDATES_PER_MINUTE"`
+	JobsPerMinute           int           `yaml:"jobs_per_minute" env:"TENANT_JOBS_PER_MINUTE"`
+	SettingsRefresh         time.Duration `yaml:"settings_refresh" env:"TENANT_SETTINGS_REFRESH"`
+}
+
+type QueueConfig struct {
+	RedisURL string `yaml:"redis_url" env:"REDIS_URL"`
+	Prefix   string `yaml:"prefix" env:"REDIS_PREFIX"`
+	// Workers defaults to the worker concurrency when 0
+	Workers     int `yaml:"workers" env:"QUEUE_WORKERS"`
+	MaxAttempts int `yaml:"max_attempts" env:"QUEUE_MAX_ATTEMPTS"`
+}
+
+type DeliveryConfig struct {
+	Backend  string         `yaml:"backend" env:"DELIVERY_BACKEND"`
+	Timeout  time.Duration  `yaml:"timeout" env:"DELIVERY_TIMEOUT"`
+	S3       S3Config       `yaml:"s3"`
+	GCS      GCSConfig      `yaml:"gcs"`
+	Azure    AzureConfig    `yaml:"azure"`
+	Dropbox  DropboxConfig  `yaml:"dropbox"`
+	OneDrive OneDriveConfig `yaml:"onedrive"`
+	Email    EmailConfig    `yaml:"email"`
+}
+
+type S3Config struct {
+	Bucket          string        `yaml:"bucket" env:"S3_BUCKET"`
+	Region          string        `yaml:"region" env:"S3_REGION"`
+	AccessKeyID     string        `yaml:"access_key_id" env:"S3_ACCESS_KEY_ID"`
+	SecretAccessKey string        `yaml:"secret_access_key" env:"S3_SECRET_ACCESS_KEY"`
+	SessionToken    string        `yaml:"session_token" env:"S3_SESSION_TOKEN"`
+	Endpoint        string        `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Prefix          string        `yaml:"prefix" env:"S3_PREFIX"`
+	PresignExpiry   time.Duration `yaml:"presign_expiry" env:"S3_PRESIGN_EXPIRY"`
+}
+
+type GCSConfig struct {
+	Bucket          string        `yaml:"bucket" env:"GCS_BUCKET"`
+	CredentialsFile string        `yaml:"credentials_file" env:"GCS_CREDENTIALS_FILE"`
+	Prefix          string        `yaml:"prefix" env:"GCS_PREFIX"`
+	SignedURLExpiry time.Duration `yaml:"signed_url_expiry" env:"GCS_SIGNED_URL_EXPIRY"`
+	SignerEmail     string        `yaml:"signer_email" env:"GCS_SIGNER_EMAIL"`
+}
+
+type AzureConfig struct {
+	Container               string        `yaml:"container" env:"AZURE_STORAGE_CONTAINER"`
+	ConnectionString        string        `yaml:"connection_string" env:"AZURE_STORAGE_CONNECTION_STRING"`
+	Account                 string        `yaml:"account" env:"AZURE_STORAGE_ACCOUNT"`
+	ManagedIdentityClientID string        `yaml:"managed_identity_client_id" env:"AZURE_MANAGED_IDENTITY_CLIENT_ID"`
+	Endpoint                string        `yaml:"endpoint" env:"AZURE_STORAGE_ENDPOINT"`
+	Prefix                  string        `yaml:"prefix" env:"AZURE_STORAGE_PREFIX"`
+	SASExpiry               time.Duration `yaml:"sas_expiry" env:"AZURE_SAS_EXPIRY"`
+}
+
+// DropboxConfig uploads archives to a folder of the Dropbox account that authorized the app
+type DropboxConfig struct {
+	AppKey       string `yaml:"app_key" env:"DROPBOX_APP_KEY"`
+	AppSecret    string `yaml:"app_secret" env:"DROPBOX_APP_SECRET"`
+	RefreshToken string `yaml:"refresh_token" env:"DROPBOX_REFRESH_TOKEN"`
+	Folder       string `yaml:"folder" env:"DROPBOX_FOLDER"`
+	Endpoint     string `yaml:"endpoint" env:"DROPBOX_ENDPOINT"`
+}
+
+// OneDriveConfig uploads archives to a folder of a OneDrive or SharePoint drive through Microsoft Graph.
+// LinkScope is who the share links work for, "organization" or "anonymous".
+type OneDriveConfig struct {
+	DriveID      string        `yaml:"drive_id" env:"ONEDRIVE_DRIVE_ID"`
+	Folder       string        `yaml:"folder" env:"ONEDRIVE_FOLDER"`
+	TenantID     string        `yaml:"tenant_id" env:"ONEDRIVE_TENANT_ID"`
+	ClientID     string        `yaml:"client_id" env:"ONEDRIVE_CLIENT_ID"`
+	ClientSecret string        `yaml:"client_secret" env:"ONEDRIVE_CLIENT_SECRET"`
+	LinkScope    string        `yaml:"link_scope" env:"ONEDRIVE_LINK_SCOPE"`
+	LinkExpiry   time.Duration `yaml:"link_expiry" env:"ONEDRIVE_LINK_EXPIRY"`
+	Endpoint     string        `yaml:"endpoint" env:"ONEDRIVE_ENDPOINT"`
+}
+
+// EmailConfig sends archives through an SMTP server to the recipients in each tenant's email settings.
+// Subject and Body are the default templates of the message, tenants can have their own.
+type EmailConfig struct {
+	SMTPHost    string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort    int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	Username    string `yaml:"username" env:"SMTP_USERNAME"`
+	Password    string `yaml:"password" env:"SMTP_PASSWORD"`
+	ImplicitTLS bool   `yaml:"implicit_tls" env:"SMTP_IMPLICIT_TLS"`
+	From        string `yaml:"from" env:"SMTP_FROM"`
+	// MaxAttachmentSize is the largest archive attached to a message, larger ones are sent as a link
+	MaxAttachmentSize ByteSize `yaml:"max_attachment_size" env:"EMAIL_MAX_ATTACHMENT_SIZE"`
+	Subject           string   `yaml:"subject" env:"EMAIL_SUBJECT"`
+	Body              string   `yaml:"body" env:"EMAIL_BODY"`
+}
+
+type NotificationConfig struct {
+	NotificationSettings `yaml:",inline"`
+	Timeout              time.Duration `yaml:"timeout" env:"NOTIFICATION_TIMEOUT"`
+}
+
+// AuthConfig enables bearer JWT authentication. Tokens are verified with the keys published at JWKSURL
+// and may only act for the tenant named in TenantClaim, unless they carry AdminScope.
+type AuthConfig struct {
+	JWKSURL     string        `yaml:"jwks_url" env:"AUTH_JWKS_URL"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh" env:"AUTH_JWKS_REFRESH"`
+	Issuer      string        `yaml:"issuer" env:"AUTH_ISSUER"`
+	Audience    string        `yaml:"audience" env:"AUTH_AUDIENCE"`
+	TenantClaim string        `yaml:"tenant_claim" env:"AUTH_TENANT_CLAIM"`
+	AdminScope  string        `yaml:"admin_scope" env:"AUTH_ADMIN_SCOPE"`
+}
+
+// DownloadConfig controls how resumes are fetched. The policy can be overridden per tenant.
+type DownloadConfig struct {
+	DownloadPolicy `yaml:",inline"`
+	// MaxSize is the largest resume that is downloaded, 0 means unlimited
+	MaxSize ByteSize `yaml:"max_size" env:"DOWNLOAD_MAX_SIZE"`
+	// Retries are made for connection errors and 408, 425, 429 and 5xx responses, waiting RetryDelay
+	// before the first retry and doubling the wait up to RetryMaxDelay
+	Retries       int               `yaml:"retries" env:"DOWNLOAD_RETRIES"`
+	RetryDelay    time.Duration     `yaml:"retry_delay" env:"DOWNLOAD_RETRY_DELAY"`
+	RetryMaxDelay time.Duration     `yaml:"retry_max_delay" env:"DOWNLOAD_RETRY_MAX_DELAY"`
+	S3            S3SourceConfig    `yaml:"s3"`
+	GoogleDrive   GoogleDriveConfig `yaml:"google_drive"`
+}
+
+// S3SourceConfig enables s3://bucket/key resume URLs when "s3" is an allowed scheme. Objects are read
+// with the default AWS credential chain, from the listed buckets only ("*" allows any bucket).
+type S3SourceConfig struct {
+	Buckets  []string `yaml:"buckets" env:"DOWNLOAD_S3_BUCKETS"`
+	Region   string   `yaml:"region" env:"DOWNLOAD_S3_REGION"`
+	Endpoint string   `yaml:"endpoint" env:"DOWNLOAD_S3_ENDPOINT"`
+}
+
+// GoogleDriveConfig fetches drive.google.com and docs.google.com links through the Drive API. With an
+// API key only files shared by link can be read; otherwise the credentials file or Application Default
+// Credentials are used and files must be shared with that account.
+type GoogleDriveConfig struct {
+	Enabled         bool   `yaml:"enabled" env:"DOWNLOAD_GOOGLE_DRIVE"`
+	CredentialsFile string `yaml:"credentials_file" env:"DOWNLOAD_GOOGLE_CREDENTIALS_FILE"`
+	APIKey          string `yaml:"api_key" env:"DOWNLOAD_GOOGLE_API_KEY"`
+	Endpoint        string `yaml:"endpoint" env:"DOWNLOAD_GOOGLE_DRIVE_ENDPOINT"`
+}
+
+// CacheConfig keeps downloaded resumes and converted PDFs across jobs, bounded by MaxSize (0 disables the
+// cache). Cached downloads are revalidated with their ETag or Last-Modified date; downloads without
+// either are reused for TTL. Dir defaults to resume-cache in the scratch directory.
+type CacheConfig struct {
+	Dir     string        `yaml:"dir" env:"RESUME_CACHE_DIR"`
+	MaxSize ByteSize      `yaml:"max_size" env:"RESUME_CACHE_MAX_SIZE"`
+	TTL     time.Duration `yaml:"ttl" env:"RESUME_CACHE_TTL"`
+}
+
+// ConversionConfig selects how office documents are converted to PDF: "libreoffice" starts LibreOffice on
+// this host for every document, "unoserver" keeps a pool of LibreOffice processes running and
+// "gotenberg" sends the documents to a Gotenberg service
+type ConversionConfig struct {
+	Backend   string          `yaml:"backend" env:"CONVERSION_BACKEND"`
+	Unoserver UnoserverConfig `yaml:"unoserver"`
+	Gotenberg GotenbergConfig `yaml:"gotenberg"`
+}
+
+// UnoserverConfig configures the unoserver pool, which runs one process per allowed concurrent conversion.
+// Process i listens on Port+2i and Port+2i+1. Idle processes are checked every HealthInterval (0
+// disables the checks) and restarted after MaxConversions conversions (0 never) to contain leaks.
+type UnoserverConfig struct {
+	Port           int           `yaml:"port" env:"UNOSERVER_PORT"`
+	HealthInterval time.Duration `yaml:"health_interval" env:"UNOSERVER_HEALTH_INTERVAL"`
+	MaxConversions int           `yaml:"max_conversions" env:"UNOSERVER_MAX_CONVERSIONS"`
+}
+
+// GotenbergConfig points at a Gotenberg service, with optional basic auth credentials
+type GotenbergConfig struct {
+	URL      string `yaml:"url" env:"GOTENBERG_URL"`
+	Username string `yaml:"username" env:"GOTENBERG_USERNAME"`
+	Password string `yaml:"password" env:"GOTENBERG_PASSWORD"`
+}
+
+// FactsheetConfig configures how factsheets are rendered. Every *.html file in TemplateDir is an HTML
+// template requests can select with template_id, named after the file. The built-in layout draws text
+// with the TrueType fonts of FontDir, Font first, and falls back to the others for characters it lacks.
+type FactsheetConfig struct {
+	TemplateDir string `yaml:"template_dir" env:"FACTSHEET_TEMPLATE_DIR"`
+	FontDir     string `yaml:"font_dir" env:"FACTSHEET_FONT_DIR"`
+	Font        string `yaml:"font" env:"FACTSHEET_FONT"`
+}
+
+// appConfig is the configuration the service was started with
+var appConfig = defaultConfig()
+
+// instanceID identifies this instance, see ServerConfig.InstanceID
+var instanceID = appConfig.Server.InstanceID
+
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			ListenAddr:        ":8081",
+			InstanceID:        defaultInstanceID(),
+			LogDir:            "/var/log/ats-candidate-processor",
+			LogLevel:          "info",
+			LogFormat:         logFormatJSON,
+			LogMaxSize:        100 << 20,
+			LogMaxAge:         24 * time.Hour,
+			LogMaxFiles:       14,
+			LogCompress:       true,
+			ShutdownTimeout:   2 * time.Minute,
+			TLSReloadInterval: time.Minute,
+			TLSClientAuth:     "require",
+			CORS: CORSConfig{
+				AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete},
+				AllowedHeaders: []string{"Authorization", "Content-Type", "Idempotency-Key", requestIDHeader},
+				ExposedHeaders: []string{"Content-Disposition", "Retry-After", requestIDHeader, "Idempotent-Replayed", "Deprecation", "Link"},
+				MaxAge:         10 * time.Minute,
+			},
+		},
+		Processing: ProcessingConfig{
+			ScratchDir:               "/tmp/candidate-processor",
+			WorkerConcurrency:        4,
+			MaxConcurrentConversions: 2,
+			DownloadTimeout:          60 * time.Second,
+			ConversionTimeout:        5 * time.Minute,
+			CandidateTimeout:         15 * time.Minute,
+			MinFreeDisk:              1 << 30,
+			FailFast:                 FailFastPolicy{MinCandidates: 10},
+			OCRLanguages:             []string{"eng"},
+			HTMLConverter:            htmlConverterChromium,
+		},
+		Limits: RequestLimits{
+			MaxCandidates:  100,
+			MaxSkills:      50,
+			MaxFieldLength: 500,
+		},
+		Storage: StorageConfig{
+			JobStoreDSN:            "./data/jobs.db",
+			ArchiveDir:             "/tmp",
+			ArchiveRetention:       72 * time.Hour,
+			ArchiveCleanupInterval: 10 * time.Minute,
+		},
+		Tenants: TenantDefaults{
+			SettingsRefresh: time.Minute,
+		},
+		Queue: QueueConfig{
+			Prefix:      "factsheet",
+			MaxAttempts: 1,
+		},
+		Delivery: DeliveryConfig{
+			Backend:  deliveryLocal,
+			Timeout:  5 * time.Minute,
+			S3:       S3Config{PresignExpiry: 24 * time.Hour},
+			GCS:      GCSConfig{SignedURLExpiry: 24 * time.Hour},
+			Azure:    AzureConfig{SASExpiry: 24 * time.Hour},
+			OneDrive: OneDriveConfig{LinkScope: "organization"},
+			Email:    EmailConfig{SMTPPort: 587, MaxAttachmentSize: 20 << 20},
+		},
+		Auth: AuthConfig{
+			JWKSRefresh: 15 * time.Minute,
+			TenantClaim: "tenant",
+			AdminScope:  "factsheet:admin",
+		},
+		Download: DownloadConfig{
+			DownloadPolicy: DownloadPolicy{AllowedSchemes: []string{"http", "https"}},
+			MaxSize:        50 << 20,
+			Retries:        2,
+			RetryDelay:     time.Second,
+			RetryMaxDelay:  30 * time.Second,
+			GoogleDrive:    GoogleDriveConfig{Endpoint: "https://www.googleapis.com/drive/v3"},
+		},
+		Cache: CacheConfig{TTL: time.Hour},
+		Conversion: ConversionConfig{
+			Backend: conversionLibreOffice,
+			Unoserver: UnoserverConfig{
+				Port:           2003,
+				HealthInterval: 30 * time.Second,
+				MaxConversions: 200,
+			},
+		},
+		Factsheet: FactsheetConfig{
+			TemplateDir: "./templates",
+		},
+		Notifications: NotificationConfig{Timeout: 10 * time.Second},
+	}
+}
+
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "local"
+	}
+	return hostname
+}
+
+// loadConfig builds the configuration from the config file, the environment and the command line flags
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	// Flags are parsed first to find the config file, but applied last so they take precedence
+	flags := flag.NewFlagSet("factsheet-maker", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flagValues := map[string]string{}
+	for _, field := range configFields(&cfg) {
+		env := field.env
+		flags.Func(flagName(env), "overrides "+env, func(value string) error {
+			flagValues[env] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("invalid config file %s: %w", *configFile, err)
+		}
+	}
+
+	// PORT is kept for deployments that predate LISTEN_ADDR
+	if port := os.Getenv("PORT"); port != "" && os.Getenv("LISTEN_ADDR") == "" {
+		cfg.Server.ListenAddr = ":" + port
+	}
+
+	var errs []error
+	for _, field := range configFields(&cfg) {
+		if value, ok := os.LookupEnv(field.env); ok && value != "" {
+			if err := setConfigValue(field.value, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field.env, err))
+			}
+		}
+		if value, ok := flagValues[field.env]; ok {
+			if err := setConfigValue(field.value, value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", flagName(field.env), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return cfg, errors.Join(errs...)
+	}
+
+	return cfg, cfg.validate()
+}
+
+// validate reports every invalid setting at once so they can be fixed in one go
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.ListenAddr != "", "server.listen_addr is required")
+	check(c.Server.InstanceID != "", "server.instance_id is required")
+	check(c.Processing.ScratchDir != "", "processing.scratch_dir is required")
+	_, err := zerolog.ParseLevel(c.Server.LogLevel)
+	check(err == nil && c.Server.LogLevel != "", "server.log_level must be debug, info, warn or error")
+	check(c.Server.LogFormat == logFormatJSON || c.Server.LogFormat == logFormatText, "server.log_format must be json or text")
+	check(c.Server.LogMaxSize >= 0 && c.Server.LogMaxAge >= 0 && c.Server.LogMaxFiles >= 0,
+		"server.log_max_size, server.log_max_age and server.log_max_files cannot be negative")
+	check(c.Storage.ArchiveDir != "", "storage.archive_dir is required")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""),
+		"server.tls_cert_file and server.tls_key_file must be set together")
+	check(c.Server.TLSClientCAFile == "" || c.Server.TLSCertFile != "", "server.tls_client_ca_file requires server.tls_cert_file")
+	check(c.Server.TLSClientAuth == "require" || c.Server.TLSClientAuth == "optional",
+		"server.tls_client_auth must be require or optional")
+	check(c.Server.PublicURL == "" || strings.HasPrefix(c.Server.PublicURL, "https://") || strings.HasPrefix(c.Server.PublicURL, "http://"),
+		"server.public_url must be an http(s) URL")
+	if _, err := parseTrustedProxies(c.Server.TrustedProxies); err != nil {
+		check(false, "server.trusted_proxies: %v", err)
+	}
+	for _, origin := range c.Server.CORS.AllowedOrigins {
+		check(validCORSOrigin(origin), "server.cors.allowed_origins: %q is not an origin like https://app.example.com", origin)
+	}
+	check(len(c.Server.CORS.AllowedOrigins) == 0 || len(c.Server.CORS.AllowedMethods) > 0, "server.cors.allowed_methods cannot be empty")
+	if rl := c.Server.RateLimit; rl.GlobalPerMinute < 0 || rl.GlobalBurst < 0 || rl.ClientIPPerMinute < 0 || rl.ClientIPBurst < 0 ||
+		rl.CallerPerMinute < 0 || rl.CallerBurst < 0 {
+		check(false, "server.rate_limit values cannot be negative")
+	}
+	check(c.Storage.JobStoreDSN != "", "storage.job_store_dsn is required, use \"none\" to disable persistence")
+	check(c.Processing.WorkerConcurrency >= 1, "processing.worker_concurrency must be at least 1")
+	check(c.Processing.MaxConcurrentConversions >= 1, "processing.max_concurrent_conversions must be at least 1")
+	check(c.Processing.MinFreeDisk >= 0 && c.Processing.MaxJobDiskUsage >= 0, "disk limits cannot be negative")
+	check(c.Limits.MaxCandidates >= 0 && c.Limits.MaxSkills >= 0 && c.Limits.MaxFieldLength >= 0, "request limits cannot be negative")
+	if err := c.Processing.FailFast.validate(); err != nil {
+		check(false, "processing.fail_fast: %v", err)
+	}
+	check(len(c.Processing.OCRLanguages) > 0, "processing.ocr_languages cannot be empty")
+	switch c.Processing.HTMLConverter {
+	case htmlConverterChromium, htmlConverterWkhtmltopdf, htmlConverterLibreOffice:
+	case htmlConverterGotenberg:
+		check(c.Conversion.Gotenberg.URL != "", "processing.html_converter is gotenberg but conversion.gotenberg.url is not set")
+	default:
+		check(false, "processing.html_converter must be one of chromium, wkhtmltopdf, libreoffice or gotenberg")
+	}
+	switch c.Conversion.Backend {
+	case conversionLibreOffice:
+	case conversionUnoserver:
+		check(c.Conversion.Unoserver.Port > 0 && c.Conversion.Unoserver.Port+2*c.Processing.MaxConcurrentConversions <= 65536,
+			"conversion.unoserver.port leaves no room for %d processes", c.Processing.MaxConcurrentConversions)
+		check(c.Conversion.Unoserver.MaxConversions >= 0, "conversion.unoserver.max_conversions cannot be negative")
+	case conversionGotenberg:
+		check(c.Conversion.Gotenberg.URL != "", "conversion.backend is gotenberg but conversion.gotenberg.url is not set")
+	default:
+		check(false, "conversion.backend must be libreoffice, unoserver or gotenberg")
+	}
+	check(c.Queue.Workers >= 0, "queue.workers cannot be negative")
+	check(c.Queue.MaxAttempts >= 1, "queue.max_attempts must be at least 1")
+	check(c.Tenants.MaxConcurrentCandidates >= 0 && c.Tenants.CandidatesPerMinute >= 0 && c.Tenants.JobsPerMinute >= 0,
+		"tenant limits cannot be negative")
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"server.tls_reload_interval", c.Server.TLSReloadInterval},
+		{"server.cors.max_age", c.Server.CORS.MaxAge},
+		{"processing.conversion_timeout", c.Processing.ConversionTimeout},
+		{"processing.candidate_timeout", c.Processing.CandidateTimeout},
+		{"storage.archive_retention", c.Storage.ArchiveRetention},
+		{"storage.archive_cleanup_interval", c.Storage.ArchiveCleanupInterval},
+		{"storage.job_retention", c.Storage.JobRetention},
+		{"tenants.settings_refresh", c.Tenants.SettingsRefresh},
+		{"delivery.s3.presign_expiry", c.Delivery.S3.PresignExpiry},
+		{"delivery.gcs.signed_url_expiry", c.Delivery.GCS.SignedURLExpiry},
+		{"delivery.azure.sas_expiry", c.Delivery.Azure.SASExpiry},
+		{"delivery.onedrive.link_expiry", c.Delivery.OneDrive.LinkExpiry},
+		{"auth.jwks_refresh", c.Auth.JWKSRefresh},
+		{"download.retry_delay", c.Download.RetryDelay},
+		{"conversion.unoserver.health_interval", c.Conversion.Unoserver.HealthInterval},
+	}
+	for _, d := range durations {
+		check(d.value >= 0, "%s cannot be negative", d.name)
+	}
+	check(c.Processing.DownloadTimeout > 0, "processing.download_timeout must be positive")
+	check(c.Delivery.Timeout > 0, "delivery.timeout must be positive")
+	check(c.Notifications.Timeout > 0, "notifications.timeout must be positive")
+	for name, webhook := range map[string]string{"slack_webhook_url": c.Notifications.SlackWebhookURL, "teams_webhook_url": c.Notifications.TeamsWebhookURL} {
+		check(webhook == "" || strings.HasPrefix(webhook, "https://") || strings.HasPrefix(webhook, "http://"),
+			"notifications.%s must be an http(s) URL", name)
+	}
+
+	switch c.Delivery.Backend {
+	case deliveryLocal:
+	case "s3":
+		check(c.Delivery.S3.Bucket != "", "delivery.backend is s3 but delivery.s3.bucket is not set")
+	case "gcs":
+		check(c.Delivery.GCS.Bucket != "", "delivery.backend is gcs but delivery.gcs.bucket is not set")
+	case "azure":
+		check(c.Delivery.Azure.Container != "", "delivery.backend is azure but delivery.azure.container is not set")
+	case "dropbox":
+		check(c.Delivery.Dropbox.RefreshToken != "", "delivery.backend is dropbox but delivery.dropbox.refresh_token is not set")
+	case "onedrive":
+		check(c.Delivery.OneDrive.DriveID != "", "delivery.backend is onedrive but delivery.onedrive.drive_id is not set")
+	case deliveryEmail:
+		check(c.Delivery.Email.SMTPHost != "", "delivery.backend is email but delivery.email.smtp_host is not set")
+	default:
+		check(false, "delivery.backend must be one of local, s3, gcs, azure, dropbox, onedrive or email")
+	}
+	check((c.Delivery.S3.AccessKeyID == "") == (c.Delivery.S3.SecretAccessKey == ""),
+		"delivery.s3.access_key_id and delivery.s3.secret_access_key must be set together")
+	check(c.Delivery.GCS.SignedURLExpiry <= gcsMaxURLExpiry, "delivery.gcs.signed_url_expiry cannot exceed %s", gcsMaxURLExpiry)
+	if c.Delivery.Azure.Container != "" {
+		check(c.Delivery.Azure.ConnectionString != "" || c.Delivery.Azure.Account != "",
+			"delivery.azure needs connection_string or account")
+	}
+	if c.Delivery.Dropbox.RefreshToken != "" {
+		check(c.Delivery.Dropbox.AppKey != "" && c.Delivery.Dropbox.AppSecret != "",
+			"delivery.dropbox needs app_key and app_secret with refresh_token")
+	}
+	if onedrive := c.Delivery.OneDrive; onedrive.DriveID != "" {
+		check(onedrive.LinkScope == "organization" || onedrive.LinkScope == "anonymous",
+			"delivery.onedrive.link_scope must be organization or anonymous")
+		check(onedrive.ClientSecret == "" || (onedrive.TenantID != "" && onedrive.ClientID != ""),
+			"delivery.onedrive.client_secret needs tenant_id and client_id")
+	}
+	if email := c.Delivery.Email; email.SMTPHost != "" {
+		check(email.SMTPPort > 0 && email.SMTPPort < 65536, "delivery.email.smtp_port must be a port number")
+		check(email.From != "", "delivery.email.from is required when delivery.email.smtp_host is set")
+		check(email.MaxAttachmentSize >= 0, "delivery.email.max_attachment_size cannot be negative")
+		defaults := EmailSettings{Recipients: []string{"check@example.com"}, Subject: email.Subject, Body: email.Body}
+		if err := defaults.validate(); err != nil {
+			check(false, "delivery.email: %v", err)
+		}
+	}
+
+	if enc := c.Storage.Encryption; enc.Key != "" || enc.WrappedKey != "" || enc.KMSKey != "" {
+		check(enc.Key == "" || (enc.WrappedKey == "" && enc.KMSKey == ""),
+			"storage.encryption.key cannot be set with wrapped_key and kms_key")
+		check(enc.Key != "" || (enc.WrappedKey != "" && enc.KMSKey != ""),
+			"storage.encryption.wrapped_key and kms_key must be set together")
+		check(enc.KMSKey == "" || strings.HasPrefix(enc.KMSKey, "arn:") || strings.HasPrefix(enc.KMSKey, "projects/"),
+			"storage.encryption.kms_key must be an AWS KMS key ARN or a Google Cloud KMS key name")
+	}
+
+	if _, err := c.Download.DownloadPolicy.compile(); err != nil {
+		check(false, "download: %v", err)
+	}
+	check(len(c.Download.AllowedSchemes) > 0, "download.allowed_schemes cannot be empty")
+	check(c.Download.MaxSize >= 0, "download.max_size cannot be negative")
+	check(c.Download.Retries >= 0, "download.retries cannot be negative")
+	check(!containsString(c.Download.AllowedSchemes, "s3") || len(c.Download.S3.Buckets) > 0,
+		"download.s3.buckets is required when s3 is an allowed scheme")
+	check(c.Download.RetryMaxDelay >= c.Download.RetryDelay, "download.retry_max_delay cannot be less than download.retry_delay")
+
+	if c.Auth.JWKSURL != "" {
+		check(strings.HasPrefix(c.Auth.JWKSURL, "https://") || strings.HasPrefix(c.Auth.JWKSURL, "http://"),
+			"auth.jwks_url must be an http(s) URL")
+		check(c.Auth.TenantClaim != "", "auth.tenant_claim is required when authentication is enabled")
+	}
+
+	return errors.Join(errs...)
+}
+
+// ByteSize is a number of bytes that can be written with a unit, e.g. "512MB" or "2GiB"
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := parseBytes(node.Value)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(parsed)
+	return nil
+}
+
+// parseBytes parses a byte size with an optional KB/MB/GB/TB suffix, which are all treated as powers of 1024
+func parseBytes(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// parseDuration accepts Go durations ("90s", "5m") and plain numbers of seconds
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// configField is a setting that can be overridden from the environment or the command line
+type configField struct {
+	env   string
+	value reflect.Value
+}
+
+// configFields lists every field of cfg that has an env tag, walking nested sections
+func configFields(cfg *Config) []configField {
+	var fields []configField
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if env := field.Tag.Get("env"); env != "" {
+				fields = append(fields, configField{env: env, value: v.Field(i)})
+			} else if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+	return fields
+}
+
+func setConfigValue(field reflect.Value, value string) error {
+	switch field.Type() {
+	case reflect.TypeOf(time.Duration(0)):
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case reflect.TypeOf(ByteSize(0)):
+		n, err := parseBytes(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", field.Type())
+		}
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// flagName turns an environment variable name into its flag, e.g. WORKER_CONCURRENCY -> worker-concurrency
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
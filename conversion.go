@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Conversion backends selectable with conversion.backend
+const (
+	conversionLibreOffice = "libreoffice"
+	conversionUnoserver   = "unoserver"
+	conversionGotenberg   = "gotenberg"
+)
+
+// conversionBackend converts office documents to PDF in outputDir, returning the path of the PDF
+type conversionBackend interface {
+	Convert(ctx context.Context, inputPath, outputDir string) (string, error)
+}
+
+var (
+	// documentConverter converts everything that is not an image, HTML or Markdown
+	documentConverter conversionBackend = libreOfficeConverter{}
+	// gotenberg is set when a Gotenberg service is configured
+	gotenberg *gotenbergConverter
+)
+
+// setupConversion selects the conversion backend
+func setupConversion() {
+	cfg := appConfig.Conversion
+	if cfg.Gotenberg.URL != "" {
+		gotenberg = newGotenbergConverter(cfg.Gotenberg)
+	}
+	switch cfg.Backend {
+	case conversionUnoserver:
+		unoserver = newUnoserverPool(cfg.Unoserver, appConfig.Processing.MaxConcurrentConversions)
+		documentConverter = unoserver
+	case conversionGotenberg:
+		documentConverter = gotenberg
+		logger.Info().Msgf("Documents are converted by Gotenberg at %s", cfg.Gotenberg.URL)
+	}
+}
+
+// libreOfficeConverter runs LibreOffice on this host
+type libreOfficeConverter struct{}
+
+func (libreOfficeConverter) Convert(ctx context.Context, inputPath, outputDir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "libreoffice", "--headless", "--convert-to", "pdf", "--outdir", outputDir, inputPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return pdfOutputPath(inputPath, outputDir), nil
+}
+
+// pdfOutputPath is where LibreOffice writes the PDF of inputPath, the other backends follow it
+func pdfOutputPath(inputPath, outputDir string) string {
+	base := filepath.Base(inputPath)
+	return filepath.Join(outputDir, strings.TrimSuffix(base, filepath.Ext(base))+".pdf")
+}
+
+// gotenbergConverter sends documents to a Gotenberg service (https://gotenberg.dev), so LibreOffice and
+// Chromium run in a dedicated container pool instead of on the API host
+type gotenbergConverter struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newGotenbergConverter(cfg GotenbergConfig) *gotenbergConverter {
+	// Requests are bounded by the conversion timeout in their context
+	return &gotenbergConverter{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{},
+	}
+}
+
+// Convert converts an office document with Gotenberg's LibreOffice route, which picks the import filter
+// by the file extension
+func (g *gotenbergConverter) Convert(ctx context.Context, inputPath, outputDir string) (string, error) {
+	outputPath := pdfOutputPath(inputPath, outputDir)
+	return outputPath, g.post(ctx, "/forms/libreoffice/convert", inputPath, filepath.Base(inputPath), nil, outputPath)
+}
+
+// convertHTML prints an HTML page to an A4 PDF with Gotenberg's Chromium route
+func (g *gotenbergConverter) convertHTML(ctx context.Context, inputPath, outputPath string) error {
+	fields := map[string]string{"paperWidth": "8.27", "paperHeight": "11.7"}
+	return g.post(ctx, "/forms/chromium/convert/html", inputPath, "index.html", fields, outputPath)
+}
+
+// post uploads a file to a Gotenberg route and writes the returned PDF to outputPath
+func (g *gotenbergConverter) post(ctx context.Context, route, inputPath, filename string, fields map[string]string, outputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The document is streamed, resumes can be large and many are converted at once
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		for name, value := range fields {
+			if err := form.WriteField(name, value); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("files", filename)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url+route, body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if g.username != "" {
+		req.SetBasicAuth(g.username, g.password)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		body.Close()
+		return fmt.Errorf("gotenberg request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("gotenberg returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return fmt.Errorf("reading gotenberg response: %w", err)
+	}
+	return out.Close()
+}
+
+// health asks Gotenberg for the state of its LibreOffice and Chromium modules
+func (g *gotenbergConverter) health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.url+"/health", nil)
+	if err != nil {
+		return err
+	}
+	if g.username != "" {
+		req.SetBasicAuth(g.username, g.password)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gotenberg request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("gotenberg returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
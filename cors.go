@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsHandler answers preflight requests from the allowed origins and lets browsers read the responses to
+// their API calls, the uploads and downloads included. Requests from other origins get no CORS headers, so
+// browsers keep the responses from the page that made them.
+func corsHandler(cfg CORSConfig) gin.HandlerFunc {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	anyOrigin := false
+	for _, origin := range cfg.AllowedOrigins {
+		anyOrigin = anyOrigin || origin == "*"
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		allowed := anyOrigin || corsOriginAllowed(cfg.AllowedOrigins, origin)
+		if !anyOrigin {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if allowed {
+			if anyOrigin {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+		}
+		if !preflight {
+			if allowed && exposed != "" {
+				c.Header("Access-Control-Expose-Headers", exposed)
+			}
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		if allowed {
+			c.Header("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				c.Header("Access-Control-Allow-Headers", headers)
+			}
+			if cfg.MaxAge > 0 {
+				c.Header("Access-Control-Max-Age", maxAge)
+			}
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// corsOriginAllowed matches origin against the allowed origins, exactly or by a wildcard subdomain
+func corsOriginAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == origin {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			continue
+		}
+		host, found := strings.CutPrefix(origin, scheme+"://")
+		if found && strings.HasSuffix(host, "."+domain) && !strings.ContainsAny(host, "/@") {
+			return true
+		}
+	}
+	return false
+}
+
+// validCORSOrigin accepts *, and http(s) origins without a path, with a wildcard subdomain or not
+func validCORSOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// submissionDateLayout is how submission_date is given in requests
+const submissionDateLayout = "2006-01-02"
+
+// CoverPage puts a cover in front of every candidate's packet. The company and the submission date
+// default to the job's company_name and the day the job is submitted.
+type CoverPage struct {
+	RoleTitle      string `json:"role_title"`
+	Company        string `json:"company,omitempty"`
+	SubmissionDate string `json:"submission_date,omitempty"`
+	// Logo is a PNG, JPEG or GIF image, base64 encoded in JSON, the tenant's branding logo when empty
+	Logo []byte `json:"logo,omitempty"`
+}
+
+func (c *CoverPage) validate() error {
+	if c.RoleTitle == "" {
+		return errors.New("cover_page needs a role_title")
+	}
+	if c.SubmissionDate != "" {
+		if _, err := time.Parse(submissionDateLayout, c.SubmissionDate); err != nil {
+			return errors.New("cover_page submission_date must look like 2024-05-31")
+		}
+	}
+	if len(c.Logo) > 0 {
+		if len(c.Logo) > maxLogoSize {
+			return fmt.Errorf("cover_page logo exceeds the limit of %s", formatBytes(maxLogoSize))
+		}
+		if _, _, _, _, err := prepareImage(c.Logo); err != nil {
+			return fmt.Errorf("cover_page logo: %w", err)
+		}
+	}
+	return nil
+}
+
+// withDefaults fills in what the cover page takes from the job
+func (c CoverPage) withDefaults(company string, submitted time.Time) *CoverPage {
+	if c.Company == "" {
+		c.Company = company
+	}
+	if c.SubmissionDate == "" {
+		c.SubmissionDate = submitted.Format(submissionDateLayout)
+	}
+	return &c
+}
+
+// renderCoverPage writes the cover of a candidate's packet to candTempDir and returns its path: the logo
+// at the top, the role title and candidate name in the middle of the page, the company and submission
+// date below them
+func renderCoverPage(cover *CoverPage, cand Candidate, opts JobOptions, branding *Branding, candTempDir string) (string, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	text := newFactsheetText(pdf, opts.direction())
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	pageWidth, _ := pdf.GetPageSize()
+	left, top, right, _ := pdf.GetMargins()
+	width := pageWidth - left - right
+
+	logo := cover.Logo
+	if len(logo) == 0 && branding != nil {
+		logo = branding.Logo
+	}
+	if len(logo) > 0 {
+		if data, imageType, w, h, err := prepareImage(logo); err == nil {
+			imageOpts := gofpdf.ImageOptions{ImageType: imageType}
+			pdf.RegisterImageOptionsReader("cover-logo", imageOpts, bytes.NewReader(data))
+			// 30mm high, narrower if a wide logo would not fit the page
+			logoHeight := min(30, width*float64(h)/float64(w))
+			logoWidth := logoHeight * float64(w) / float64(h)
+			pdf.ImageOptions("cover-logo", (pageWidth-logoWidth)/2, top+20, logoWidth, logoHeight, false, imageOpts, 0, "")
+		}
+	}
+
+	submitted := cover.SubmissionDate
+	if date, err := time.Parse(submissionDateLayout, submitted); err == nil {
+		submitted = date.Format("2 January 2006")
+	}
+	lines := []struct {
+		y     float64
+		style string
+		size  float64
+		gray  int
+		value string
+	}{
+		{100, "B", 26, 0, cover.RoleTitle},
+		{125, "", 20, 0, cand.Name},
+		{220, "", 12, 80, cover.Company},
+		{228, "", 11, 128, "Submitted " + submitted},
+	}
+	for _, line := range lines {
+		if line.value == "" {
+			continue
+		}
+		pdf.SetTextColor(line.gray, line.gray, line.gray)
+		wrapped := text.lines(line.style, line.size, line.value, width)
+		for i, part := range wrapped {
+			pdf.SetXY(left, line.y+float64(i)*line.size*0.5)
+			pdf.CellFormat(width, line.size*0.5, part, "", 0, "C", false, 0, "")
+		}
+	}
+
+	outputPath := filepath.Join(candTempDir, "cover.pdf")
+	return outputPath, pdf.OutputFileAndClose(outputPath)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// Time zones are looked up in the embedded database when the host has none, e.g. in alpine images
+	_ "time/tzdata"
+)
+
+// defaultDateLayout is how dates are printed on factsheets when the job does not choose
+const defaultDateLayout = "2006-01-02 15:04:05"
+
+// localeDateLayouts are the customary date and time layouts of locales, by language tag or language
+var localeDateLayouts = map[string]string{
+	"en-us": "01/02/2006 3:04 PM",
+	"en-gb": "02/01/2006 15:04",
+	"en-in": "02/01/2006 3:04 PM",
+	"en-au": "2/01/2006 3:04 PM",
+	"en-ca": "2006-01-02 3:04 PM",
+	"en":    "2 Jan 2006 15:04",
+	"de":    "02.01.2006 15:04",
+	"fr":    "02/01/2006 15:04",
+	"es":    "02/01/2006 15:04",
+	"it":    "02/01/2006 15:04",
+	"pt":    "02/01/2006 15:04",
+	"nl":    "02-01-2006 15:04",
+	"pl":    "02.01.2006 15:04",
+	"sv":    "2006-01-02 15:04",
+	"ja":    "2006/01/02 15:04",
+	"zh":    "2006/01/02 15:04",
+	"ko":    "2006. 01. 02. 15:04",
+	"ar":    "02/01/2006 15:04",
+	"he":    "02.01.2006 15:04",
+}
+
+// dateTokens translate date_format patterns to Go layouts, longest tokens first
+var dateTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"}, {"YY", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"}, {"M", "1"},
+	{"dddd", "Monday"}, {"ddd", "Mon"},
+	{"DD", "02"}, {"D", "2"},
+	{"HH", "15"}, {"hh", "03"}, {"h", "3"},
+	{"mm", "04"}, {"ss", "05"},
+	{"A", "PM"}, {"a", "pm"},
+	{"Z", "MST"},
+}
+
+// dateLayout translates a date_format pattern such as "DD MMM YYYY [at] HH:mm" to a Go layout. Text between
+// the tokens is kept, text in square brackets as it is, except digits, which Go would read as part of the
+// layout.
+func dateLayout(pattern string) (string, error) {
+	var layout strings.Builder
+	tokens := 0
+	for rest := pattern; rest != ""; {
+		if literal, after, ok := strings.Cut(rest, "]"); ok && rest[0] == '[' {
+			if strings.ContainsAny(literal, "0123456789") {
+				return "", errors.New("date_format cannot contain digits")
+			}
+			layout.WriteString(literal[1:])
+			rest = after
+			continue
+		}
+		matched := false
+		for _, t := range dateTokens {
+			if strings.HasPrefix(rest, t.token) {
+				layout.WriteString(t.layout)
+				rest = rest[len(t.token):]
+				matched, tokens = true, tokens+1
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if rest[0] >= '0' && rest[0] <= '9' {
+			return "", errors.New("date_format cannot contain digits")
+		}
+		layout.WriteByte(rest[0])
+		rest = rest[1:]
+	}
+	if tokens == 0 {
+		return "", errors.New("date_format needs at least one of YYYY, MM, DD, HH, mm and the like")
+	}
+	return layout.String(), nil
+}
+
+// localeLayout is the date layout of a locale such as en-GB, falling back to its language
+func localeLayout(locale string) (string, bool) {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if layout, ok := localeDateLayouts[tag]; ok {
+		return layout, true
+	}
+	language, _, _ := strings.Cut(tag, "-")
+	layout, ok := localeDateLayouts[language]
+	return layout, ok
+}
+
+// validateDates checks the timezone, date_format and locale of a job
+func (o JobOptions) validateDates() error {
+	if o.Timezone != "" {
+		if _, err := time.LoadLocation(o.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", o.Timezone)
+		}
+	}
+	if o.DateFormat != "" {
+		if _, err := dateLayout(o.DateFormat); err != nil {
+			return err
+		}
+	}
+	if o.Locale != "" {
+		if _, ok := localeLayout(o.Locale); !ok {
+			return fmt.Errorf("unsupported locale %q", o.Locale)
+		}
+	}
+	return nil
+}
+
+// localTime is t in the job's timezone, the server's without one
+func (o JobOptions) localTime(t time.Time) time.Time {
+	if location, err := time.LoadLocation(o.Timezone); o.Timezone != "" && err == nil {
+		return t.In(location)
+	}
+	return t
+}
+
+// formatTime prints t in the job's timezone, in its date_format, or the customary format of its locale
+func (o JobOptions) formatTime(t time.Time) string {
+	layout := defaultDateLayout
+	if o.DateFormat != "" {
+		if converted, err := dateLayout(o.DateFormat); err == nil {
+			layout = converted
+		}
+	} else if localized, ok := localeLayout(o.Locale); ok && o.Locale != "" {
+		layout = localized
+	}
+	return o.localTime(t).Format(layout)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// startDebugServer serves the Go profiler and runtime variables on DEBUG_ADDR, a listener of its own so
+// they are never exposed with the API. Nothing is served when the address is empty.
+func startDebugServer() {
+	addr := appConfig.Server.DebugAddr
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			logger.Warn().Msgf("Debug endpoints on %s are reachable from the network, they have no authentication", addr)
+		}
+	}
+
+	// CPU profiles and traces take as long as the caller asks for, so there is no write timeout
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msgf("Debug server on %s failed", addr)
+		}
+	}()
+	logger.Info().Msgf("Debug endpoints enabled at http://%s/debug/pprof/ and /debug/vars", addr)
+}
+
+// The runtime variables next to expvar's memstats and cmdline, read when /debug/vars is requested
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("candidate_slots_in_use", expvar.Func(func() any { return len(candidateSlots) }))
+	expvar.Publish("conversion_slots_in_use", expvar.Func(func() any { return len(conversionSlots) }))
+	expvar.Publish("jobs", expvar.Func(func() any { return jobs.countByStatus() }))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// sharedResumes lets candidates of the same job that point at the same resume URL, e.g. a shared
+// document or a template mistake, download and convert it once. The first candidate prepares the
+// resume and the others wait for it and copy its PDF, which is kept sealed in the job's temp directory
+// while encryption at rest is enabled. Only candidates processed by the same instance share a resume.
+type sharedResumes struct {
+	mu      sync.Mutex
+	entries map[string]*sharedResume
+}
+
+type sharedResume struct {
+	done chan struct{}
+	pdf  string
+	err  error
+	// format, converter and the stage a failure happened in are reported for every candidate sharing the resume
+	format    string
+	converter string
+	stage     string
+}
+
+// jobResumes holds the resumes shared within the jobs in progress
+var jobResumes = &sharedResumes{entries: map[string]*sharedResume{}}
+
+// prepare returns the PDF of the candidate's resume, reusing the one of an earlier candidate of the
+// job with the same URL, headers and password. tempDir is the job's temp directory and scopes the
+// sharing.
+func (s *sharedResumes) prepare(ctx context.Context, tenant string, opts JobOptions, cand Candidate, tempDir, candTempDir string, result *candidateResult) (string, error) {
+	if cand.ResumeURL == "" || len(cand.ResumeContent) > 0 {
+		return prepareResume(ctx, tenant, opts, cand, candTempDir, result)
+	}
+
+	key := tempDir + "\x00" + cacheKey(tenant, cand.ResumeURL, cand.ResumeHeaders) + "\x00" + cand.ResumePassword
+	s.mu.Lock()
+	entry, shared := s.entries[key]
+	if !shared {
+		entry = &sharedResume{done: make(chan struct{})}
+		s.entries[key] = entry
+	}
+	s.mu.Unlock()
+
+	if !shared {
+		resumePDF, err := prepareResume(ctx, tenant, opts, cand, candTempDir, result)
+		entry.err = err
+		if err == nil {
+			// The candidate's own directory goes when it finishes, the others copy from a copy of the job's
+			sum := sha256.Sum256([]byte(key))
+			entry.pdf = filepath.Join(tempDir, "shared", hex.EncodeToString(sum[:])+".pdf")
+			entry.err = shareResume(resumePDF, entry.pdf)
+		}
+		entry.format, entry.converter, entry.stage = result.Format, result.Converter, result.Stage
+		close(entry.done)
+		return resumePDF, err
+	}
+
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	result.Format, result.Converter, result.Stage = entry.format, entry.converter, entry.stage
+	if entry.err != nil {
+		return "", entry.err
+	}
+	resumePDF := filepath.Join(candTempDir, "resume.pdf")
+	if err := unsealFile(entry.pdf, resumePDF); err != nil {
+		return "", err
+	}
+	logFrom(ctx).Debug().Msgf("Reusing resume of %s already fetched in this job for %s", redactURL(cand.ResumeURL), cand.Email)
+	return resumePDF, nil
+}
+
+// shareResume keeps a copy of a resume PDF at path for the other candidates, sealed at rest
+func shareResume(resumePDF, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to share resume: %w", err)
+	}
+	if err := copyFile(resumePDF, path); err != nil {
+		return fmt.Errorf("failed to share resume: %w", err)
+	}
+	return sealFile(path)
+}
+
+// forget drops the resumes shared within the job whose directory is baseDir
+func (s *sharedResumes) forget(baseDir string) {
+	prefix := baseDir + string(filepath.Separator)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.entries, key)
+		}
+	}
+}
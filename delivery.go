@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const deliveryLocal = "local"
+
+// DeliveryResult describes where a job's archive was delivered
+type DeliveryResult struct {
+	Backend   string     `json:"backend"`
+	Location  string     `json:"location"`
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// deliveryBackend uploads a finished archive to remote storage
+type deliveryBackend interface {
+	Deliver(ctx context.Context, zipPath, objectName string) (*DeliveryResult, error)
+}
+
+var deliveryBackends = map[string]deliveryBackend{}
+
+// defaultDelivery is used when a request does not specify a delivery backend
+var defaultDelivery = deliveryLocal
+
+// setupDelivery registers every delivery backend that is configured through the environment
+func setupDelivery() {
+	cfg := appConfig.Delivery
+	if cfg.S3.Bucket != "" {
+		backend, err := newS3Delivery(cfg.S3)
+		registerDelivery("s3", cfg.S3.Bucket, backend, err)
+	}
+	if cfg.GCS.Bucket != "" {
+		backend, err := newGCSDelivery(cfg.GCS)
+		registerDelivery("gcs", cfg.GCS.Bucket, backend, err)
+	}
+	if cfg.Azure.Container != "" {
+		backend, err := newAzureDelivery(cfg.Azure)
+		registerDelivery("azure", cfg.Azure.Container, backend, err)
+	}
+	if cfg.Dropbox.RefreshToken != "" {
+		backend, err := newDropboxDelivery(cfg.Dropbox)
+		registerDelivery("dropbox", "/"+strings.Trim(cfg.Dropbox.Folder, "/"), backend, err)
+	}
+	if cfg.OneDrive.DriveID != "" {
+		backend, err := newOneDriveDelivery(cfg.OneDrive)
+		registerDelivery("onedrive", cfg.OneDrive.DriveID, backend, err)
+	}
+	if cfg.Email.SMTPHost != "" {
+		// Email needs the job's tenant for its recipients, it is not a storage backend
+		sender, err := newEmailDelivery(cfg.Email)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to configure email delivery")
+		} else {
+			emailSender = sender
+			logger.Info().Msgf("email delivery enabled for %s", cfg.Email.SMTPHost)
+		}
+	}
+
+	// The backend may still be missing when its credentials could not be loaded
+	defaultDelivery = cfg.Backend
+	if err := validateDelivery(defaultDelivery); err != nil {
+		logger.Warn().Err(err).Msgf("Invalid DELIVERY_BACKEND, falling back to %s", deliveryLocal)
+		defaultDelivery = deliveryLocal
+	}
+}
+
+func registerDelivery(name, target string, backend deliveryBackend, err error) {
+	if err != nil {
+		logger.Warn().Err(err).Msgf("Failed to configure %s delivery", name)
+		return
+	}
+	deliveryBackends[name] = backend
+	logger.Info().Msgf("%s delivery enabled for %s", name, target)
+}
+
+// validateDelivery checks that the named delivery backend can be used
+func validateDelivery(name string) error {
+	if name == deliveryLocal || (name == deliveryEmail && emailSender != nil) {
+		return nil
+	}
+	if _, ok := deliveryBackends[name]; !ok {
+		return fmt.Errorf("delivery backend %q is not configured (available: %v)", name, availableDeliveries())
+	}
+	return nil
+}
+
+func availableDeliveries() []string {
+	names := []string{deliveryLocal}
+	for name := range deliveryBackends {
+		names = append(names, name)
+	}
+	if emailSender != nil {
+		names = append(names, deliveryEmail)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// deliverArchive uploads the archive with the named backend
+func deliverArchive(name, zipPath, zipFileName string) (*DeliveryResult, error) {
+	backend, ok := deliveryBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("delivery backend %q is not configured", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), appConfig.Delivery.Timeout)
+	defer cancel()
+
+	logger.Debug().Msgf("Delivering %s via %s", zipPath, name)
+	result, err := backend.Deliver(ctx, zipPath, zipFileName)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Msgf("Delivered %s to %s", zipPath, result.Location)
+	return result, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// User delegation keys, and therefore SAS URLs signed with them, are valid for at most 7 days
+const azureMaxSASExpiry = 7 * 24 * time.Hour
+
+// azureDelivery uploads archives to an Azure Blob Storage container
+type azureDelivery struct {
+	service   *service.Client
+	container string
+	prefix    string
+	sasExpiry time.Duration
+	// sharedKey is set when a connection string is used, SAS URLs are then signed with the account key
+	// instead of a user delegation key
+	sharedKey bool
+}
+
+// newAzureDelivery configures Azure Blob delivery. A connection string takes precedence, otherwise the
+// storage account is used with managed/workload identity via azidentity.
+func newAzureDelivery(c AzureConfig) (*azureDelivery, error) {
+	d := &azureDelivery{
+		container: c.Container,
+		prefix:    c.Prefix,
+		sasExpiry: c.SASExpiry,
+	}
+
+	if c.ConnectionString != "" {
+		client, err := service.NewClientFromConnectionString(c.ConnectionString, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection string: %w", err)
+		}
+		d.service = client
+		d.sharedKey = true
+		return d, nil
+	}
+
+	if c.Account == "" {
+		return nil, errors.New("a connection string or storage account is required")
+	}
+	if d.sasExpiry > azureMaxSASExpiry {
+		return nil, fmt.Errorf("SAS expiry cannot exceed %s with managed identity", azureMaxSASExpiry)
+	}
+
+	var cred azcore.TokenCredential
+	var err error
+	if c.ManagedIdentityClientID != "" {
+		cred, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(c.ManagedIdentityClientID),
+		})
+	} else {
+		// Covers AKS workload identity, system-assigned managed identity and service principal env vars
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+	}
+
+	serviceURL := c.Endpoint
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", c.Account)
+	}
+	client, err := service.NewClient(serviceURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob service client: %w", err)
+	}
+	d.service = client
+	return d, nil
+}
+
+func (d *azureDelivery) Deliver(ctx context.Context, zipPath, objectName string) (*DeliveryResult, error) {
+	file, err := openAtRest(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blobName := path.Join(d.prefix, objectName)
+	client := d.service.NewContainerClient(d.container).NewBlockBlobClient(blobName)
+	_, err = client.UploadStream(ctx, file, &blockblob.UploadStreamOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(archiveContentType(objectName))},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to %s/%s: %w", d.container, blobName, err)
+	}
+
+	result := &DeliveryResult{
+		Backend:  "azure",
+		Location: client.URL(),
+	}
+
+	// A zero expiry disables SAS generation, callers then only get the blob URL
+	if d.sasExpiry > 0 {
+		expiresAt := time.Now().UTC().Add(d.sasExpiry)
+		sasURL, err := d.sasURL(ctx, client, blobName, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SAS URL for %s/%s: %w", d.container, blobName, err)
+		}
+		result.URL = sasURL
+		result.ExpiresAt = &expiresAt
+	}
+
+	return result, nil
+}
+
+// sasURL returns a read-only SAS URL for the blob
+func (d *azureDelivery) sasURL(ctx context.Context, client *blockblob.Client, blobName string, expiresAt time.Time) (string, error) {
+	permissions := sas.BlobPermissions{Read: true}
+	if d.sharedKey {
+		return client.BlobClient().GetSASURL(permissions, expiresAt, nil)
+	}
+
+	// Managed identities have no account key, sign with a user delegation key instead
+	start := time.Now().UTC().Add(-5 * time.Minute)
+	credential, err := d.service.GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(expiresAt.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user delegation key: %w", err)
+	}
+
+	params, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    expiresAt,
+		Permissions:   permissions.String(),
+		ContainerName: d.container,
+		BlobName:      blobName,
+	}.SignWithUserDelegation(credential)
+	if err != nil {
+		return "", err
+	}
+
+	return client.URL() + "?" + params.Encode(), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com"
+	dropboxContentURL = "https://content.dropboxapi.com"
+	dropboxTokenPath  = "/oauth2/token"
+	// Single uploads are limited to 150 MiB, larger archives go up in chunks of an upload session
+	dropboxMaxUpload = 150 << 20
+	dropboxChunkSize = 64 << 20
+)
+
+// dropboxDelivery uploads archives to a Dropbox folder and shares them by link
+type dropboxDelivery struct {
+	client     *http.Client
+	apiURL     string
+	contentURL string
+	folder     string
+}
+
+// newDropboxDelivery configures Dropbox delivery. The app's refresh token, from an OAuth flow with
+// token_access_type=offline, is exchanged for short-lived access tokens as they expire.
+func newDropboxDelivery(c DropboxConfig) (*dropboxDelivery, error) {
+	if c.AppKey == "" || c.AppSecret == "" {
+		return nil, errors.New("app_key and app_secret are required with a refresh token")
+	}
+	d := &dropboxDelivery{
+		apiURL:     dropboxAPIURL,
+		contentURL: dropboxContentURL,
+		folder:     "/" + strings.Trim(c.Folder, "/"),
+	}
+	if c.Endpoint != "" {
+		d.apiURL = strings.TrimSuffix(c.Endpoint, "/")
+		d.contentURL = d.apiURL
+	}
+	oauthConfig := &oauth2.Config{
+		ClientID:     c.AppKey,
+		ClientSecret: c.AppSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: d.apiURL + dropboxTokenPath},
+	}
+	d.client = oauthConfig.Client(context.Background(), &oauth2.Token{RefreshToken: c.RefreshToken})
+	return d, nil
+}
+
+func (d *dropboxDelivery) Deliver(ctx context.Context, zipPath, objectName string) (*DeliveryResult, error) {
+	file, err := openAtRest(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	target := path.Join(d.folder, objectName)
+	// Archives are never overwritten, a name that is taken gets a number from Dropbox
+	commit := map[string]any{"path": target, "mode": "add", "autorename": true, "mute": true}
+	var uploaded struct {
+		PathDisplay string `json:"path_display"`
+	}
+	if file.Size() <= dropboxMaxUpload {
+		err = d.call(ctx, d.contentURL+"/2/files/upload", commit, file, &uploaded)
+	} else {
+		err = d.uploadSession(ctx, file, file.Size(), commit, &uploaded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to dropbox:%s: %w", target, err)
+	}
+
+	var link struct {
+		URL string `json:"url"`
+	}
+	if err := d.call(ctx, d.apiURL+"/2/sharing/create_shared_link_with_settings", map[string]any{"path": uploaded.PathDisplay}, nil, &link); err != nil {
+		return nil, fmt.Errorf("failed to share dropbox:%s: %w", uploaded.PathDisplay, err)
+	}
+	return &DeliveryResult{
+		Backend:  "dropbox",
+		Location: "dropbox:" + uploaded.PathDisplay,
+		URL:      link.URL,
+	}, nil
+}
+
+// uploadSession uploads a file too large for a single request in chunks, committing it with the last
+func (d *dropboxDelivery) uploadSession(ctx context.Context, file io.ReaderAt, size int64, commit map[string]any, out any) error {
+	var session struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := d.call(ctx, d.contentURL+"/2/files/upload_session/start", map[string]any{},
+		io.NewSectionReader(file, 0, dropboxChunkSize), &session); err != nil {
+		return err
+	}
+	offset := int64(dropboxChunkSize)
+	for ; offset+dropboxChunkSize < size; offset += dropboxChunkSize {
+		cursor := map[string]any{"cursor": map[string]any{"session_id": session.SessionID, "offset": offset}}
+		if err := d.call(ctx, d.contentURL+"/2/files/upload_session/append_v2", cursor,
+			io.NewSectionReader(file, offset, dropboxChunkSize), nil); err != nil {
+			return err
+		}
+	}
+	finish := map[string]any{
+		"cursor": map[string]any{"session_id": session.SessionID, "offset": offset},
+		"commit": commit,
+	}
+	return d.call(ctx, d.contentURL+"/2/files/upload_session/finish", finish, io.NewSectionReader(file, offset, size-offset), out)
+}
+
+// call makes a Dropbox API request. Content endpoints take the arguments in the Dropbox-API-Arg header and
+// the file as the body, RPC endpoints, called without content, take the arguments as a JSON body.
+func (d *dropboxDelivery) call(ctx context.Context, endpoint string, args any, content io.Reader, out any) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	var req *http.Request
+	if content != nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, content)
+		if err != nil {
+			return err
+		}
+		if section, ok := content.(*io.SectionReader); ok {
+			req.ContentLength = section.Size()
+		} else if file, ok := content.(*atRestFile); ok {
+			req.ContentLength = file.Size()
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", asciiJSON(data))
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// asciiJSON escapes the non-ASCII characters of JSON, which HTTP headers such as Dropbox-API-Arg cannot carry
+func asciiJSON(data []byte) string {
+	var out strings.Builder
+	for _, r := range string(data) {
+		switch {
+		case r < utf8.RuneSelf:
+			out.WriteRune(r)
+		case r > 0xFFFF:
+			// Characters outside the basic plane are written as a surrogate pair
+			r -= 0x10000
+			fmt.Fprintf(&out, `\u%04x\u%04x`, 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+		default:
+			fmt.Fprintf(&out, `\u%04x`, r)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const deliveryEmail = "email"
+
+// maxEmailRecipients caps the recipients of a tenant, larger audiences belong on a mailing list
+const maxEmailRecipients = 50
+
+// The message sent for a job unless the tenant's email settings or delivery.email have their own
+const (
+	defaultEmailSubject = `Factsheets for {{.Company}}{{if .Parts}} (part {{.Part}} of {{.Parts}}){{end}}`
+	defaultEmailBody    = `The factsheets of {{.Completed}} of {{.Candidates}} candidates for {{.Company}} are {{if .DownloadURL}}ready to download at
+{{.DownloadURL}}{{else}}attached as {{.FileName}}.{{end}}
+{{if not .ExpiresAt.IsZero}}
+The archive is available until {{.ExpiresAt.Format "2 January 2006 15:04 MST"}}.
+{{end}}
+Job {{.JobID}}
+`
+)
+
+// EmailSettings are the recipients of a tenant's archives delivered by email and the templates of the message.
+// Subject and Body are Go text templates, see emailData for the fields they can use.
+type EmailSettings struct {
+	Recipients []string `json:"recipients"`
+	Subject    string   `json:"subject,omitempty"`
+	Body       string   `json:"body,omitempty"`
+}
+
+// emailData fills in the templates of a message, one per archive or part of a split archive
+type emailData struct {
+	Tenant     string
+	Company    string
+	JobID      string
+	FileName   string
+	Candidates int
+	Completed  int
+	Failed     int
+	// Part and Parts number the parts of a split archive, 0 when it is not split
+	Part  int
+	Parts int
+	// DownloadURL is set instead of attaching archives larger than delivery.email.max_attachment_size
+	DownloadURL string
+	ExpiresAt   time.Time
+	// baseURL is where the archive can be downloaded, empty when no link can be given
+	baseURL string
+}
+
+func (s *EmailSettings) validate() error {
+	if len(s.Recipients) == 0 {
+		return errors.New("recipients cannot be empty")
+	}
+	if len(s.Recipients) > maxEmailRecipients {
+		return fmt.Errorf("cannot have more than %d recipients", maxEmailRecipients)
+	}
+	for _, recipient := range s.Recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("invalid recipient %q", recipient)
+		}
+	}
+	sample := emailData{Tenant: "acme", Company: "globex", JobID: "job", FileName: "factsheets.zip", Candidates: 2,
+		Completed: 1, Failed: 1, Part: 1, Parts: 2, DownloadURL: "https://example.com", ExpiresAt: time.Now()}
+	for name, text := range map[string]string{"subject": s.Subject, "body": s.Body} {
+		if _, err := renderEmailTemplate(name, text, sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderEmailTemplate fills in one of the templates of a message
+func renderEmailTemplate(name, text string, data emailData) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// emailDelivery sends archives through an SMTP server
+type emailDelivery struct {
+	cfg  EmailConfig
+	from *mail.Address
+}
+
+// emailSender is the configured SMTP server, nil when email delivery is disabled
+var emailSender *emailDelivery
+
+func newEmailDelivery(cfg EmailConfig) (*emailDelivery, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %w", cfg.From, err)
+	}
+	return &emailDelivery{cfg: cfg, from: from}, nil
+}
+
+// emailArchive sends the job's archive, or every part of a split one in a message of its own, to the
+// recipients of the tenant. Archives too large to attach are sent as a link to their download.
+func emailArchive(job *Job, req ProcessRequest, zipPath, zipFileName string, parts []ArchivePart) (*DeliveryResult, error) {
+	if emailSender == nil {
+		return nil, fmt.Errorf("delivery backend %q is not configured", deliveryEmail)
+	}
+	settings := tenants.email(req.TenantName)
+	if settings == nil || len(settings.Recipients) == 0 {
+		return nil, fmt.Errorf("tenant %s has no email recipients", req.TenantName)
+	}
+
+	job.mu.Lock()
+	data := emailData{
+		Tenant:     req.TenantName,
+		Company:    req.CompanyName,
+		JobID:      job.ID,
+		Candidates: len(job.Candidates),
+		Completed:  job.SuccessCount,
+		Failed:     len(job.Candidates) - job.SuccessCount,
+		ExpiresAt:  job.ArchiveExpiresAt,
+		baseURL:    job.publicBaseURL(),
+	}
+	job.mu.Unlock()
+
+	if len(parts) == 0 {
+		parts = []ArchivePart{{FileName: zipFileName, Path: zipPath}}
+	} else {
+		data.Parts = len(parts)
+	}
+	for _, part := range parts {
+		data.Part, data.FileName = part.Part, part.FileName
+		if err := emailSender.sendArchive(*settings, data, part.Path); err != nil {
+			if data.Parts > 0 {
+				return nil, fmt.Errorf("part %d: %w", part.Part, err)
+			}
+			return nil, err
+		}
+	}
+	job.log().Info().Msgf("Emailed archive of job %s to %s", job.ID, strings.Join(settings.Recipients, ", "))
+	return &DeliveryResult{Backend: deliveryEmail, Location: strings.Join(settings.Recipients, ", ")}, nil
+}
+
+// sendArchive sends one message with the archive at path, attached or as a link when it is too large
+func (e *emailDelivery) sendArchive(settings EmailSettings, data emailData, path string) error {
+	size, err := atRestSize(path)
+	if err != nil {
+		return err
+	}
+	attach := e.cfg.MaxAttachmentSize == 0 || size <= int64(e.cfg.MaxAttachmentSize)
+	if !attach {
+		if data.baseURL == "" {
+			return errors.New("archive is larger than delivery.email.max_attachment_size and server.public_url is not set")
+		}
+		data.DownloadURL = publicDownloadURL(data.baseURL, data.JobID, data.Part)
+	}
+
+	subject, err := renderEmailTemplate("subject", cmp.Or(settings.Subject, e.cfg.Subject, defaultEmailSubject), data)
+	if err != nil {
+		return err
+	}
+	body, err := renderEmailTemplate("body", cmp.Or(settings.Body, e.cfg.Body, defaultEmailBody), data)
+	if err != nil {
+		return err
+	}
+	var recipients []*mail.Address
+	for _, recipient := range settings.Recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q", recipient)
+		}
+		recipients = append(recipients, address)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), appConfig.Delivery.Timeout)
+	defer cancel()
+	return e.send(ctx, recipients, func(w io.Writer) error {
+		attachment := ""
+		if attach {
+			attachment = path
+		}
+		return e.writeMessage(w, recipients, strings.Join(strings.Fields(subject), " "), body, attachment, data.FileName)
+	})
+}
+
+// send delivers a message written by write to the recipients, upgrading the connection with STARTTLS when
+// the server offers it
+func (e *emailDelivery) send(ctx context.Context, recipients []*mail.Address, write func(io.Writer) error) error {
+	addr := net.JoinHostPort(e.cfg.SMTPHost, strconv.Itoa(e.cfg.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: e.cfg.SMTPHost}
+	var conn net.Conn
+	var err error
+	if e.cfg.ImplicitTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !e.cfg.ImplicitTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.SMTPHost)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(e.from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", recipient.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// writeMessage writes the MIME message with the body and, unless attachment is empty, the file at that path
+func (e *emailDelivery) writeMessage(w io.Writer, recipients []*mail.Address, subject, body, attachment, fileName string) error {
+	out := bufio.NewWriter(w)
+	parts := multipart.NewWriter(out)
+	to := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		to[i] = recipient.String()
+	}
+	fmt.Fprintf(out, "From: %s\r\n", e.from.String())
+	fmt.Fprintf(out, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(out, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(out, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(out, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(out, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", parts.Boundary())
+
+	text, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(text)
+	if _, err := io.WriteString(qp, body); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	if attachment != "" {
+		name := mime.QEncoding.Encode("utf-8", filepath.Base(fileName))
+		file, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("%s; name=%q", archiveContentType(fileName), name)},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", name)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeBase64Lines(file, attachment); err != nil {
+			return err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// writeBase64Lines writes the file base64 encoded in lines of 76 characters, as MIME requires
+func writeBase64Lines(w io.Writer, path string) error {
+	f, err := openAtRest(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// 57 bytes make a line of 76 characters
+	chunk := make([]byte, 57*1024)
+	line := make([]byte, base64.StdEncoding.EncodedLen(57)+2)
+	for {
+		n, err := io.ReadFull(f, chunk)
+		for i := 0; i < n; i += 57 {
+			encoded := line[:base64.StdEncoding.EncodedLen(min(57, n-i))]
+			base64.StdEncoding.Encode(encoded, chunk[i:min(i+57, n)])
+			if _, err := w.Write(append(encoded, '\r', '\n')); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsHost         = "storage.googleapis.com"
+	gcsScope        = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMaxURLExpiry = 7 * 24 * time.Hour
+)
+
+// gcsDelivery uploads archives to a Google Cloud Storage bucket
+type gcsDelivery struct {
+	client      *http.Client
+	bucket      string
+	prefix      string
+	urlExpiry   time.Duration
+	signerEmail string
+	// privateKey is only set for service account keys, otherwise URLs are signed through the IAM API
+	privateKey *rsa.PrivateKey
+}
+
+// newGCSDelivery configures GCS delivery. Credentials come from the configured credentials file when set,
+// otherwise from Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS or workload identity).
+func newGCSDelivery(c GCSConfig) (*gcsDelivery, error) {
+	ctx := context.Background()
+
+	var creds *google.Credentials
+	var err error
+	if c.CredentialsFile != "" {
+		data, readErr := os.ReadFile(c.CredentialsFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read GCS credentials file: %w", readErr)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, gcsScope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, gcsScope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google credentials: %w", err)
+	}
+
+	d := &gcsDelivery{
+		client:      oauth2.NewClient(ctx, creds.TokenSource),
+		bucket:      c.Bucket,
+		prefix:      c.Prefix,
+		urlExpiry:   c.SignedURLExpiry,
+		signerEmail: c.SignerEmail,
+	}
+
+	// Service account keys can sign URLs locally
+	if len(creds.JSON) > 0 {
+		var key struct {
+			Type        string `json:"type"`
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+		}
+		if err := json.Unmarshal(creds.JSON, &key); err == nil && key.Type == "service_account" {
+			privateKey, err := parseRSAPrivateKey(key.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse service account key: %w", err)
+			}
+			d.privateKey = privateKey
+			if d.signerEmail == "" {
+				d.signerEmail = key.ClientEmail
+			}
+		}
+	}
+
+	// With workload identity the signer is the service account attached to the instance
+	if d.signerEmail == "" && d.urlExpiry > 0 && metadata.OnGCE() {
+		email, err := metadata.Email("default")
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up service account email: %w", err)
+		}
+		d.signerEmail = email
+	}
+	if d.signerEmail == "" && d.urlExpiry > 0 {
+		return nil, errors.New("cannot sign URLs without a service account, set GCS_SIGNER_EMAIL or GCS_SIGNED_URL_EXPIRY=0")
+	}
+
+	return d, nil
+}
+
+func parseRSAPrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+func (d *gcsDelivery) Deliver(ctx context.Context, zipPath, objectName string) (*DeliveryResult, error) {
+	file, err := openAtRest(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	object := path.Join(d.prefix, objectName)
+	uploadURL := fmt.Sprintf("https://%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", gcsHost, url.PathEscape(d.bucket), url.QueryEscape(object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, file)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = file.Size()
+	req.Header.Set("Content-Type", archiveContentType(objectName))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to gs://%s/%s: %w", d.bucket, object, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to upload to gs://%s/%s: HTTP %d: %s", d.bucket, object, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	result := &DeliveryResult{
+		Backend:  "gcs",
+		Location: fmt.Sprintf("gs://%s/%s", d.bucket, object),
+	}
+
+	// A zero expiry disables signing, callers then only get the object location
+	if d.urlExpiry > 0 {
+		signedURL, err := d.signedURL(ctx, object, time.Now().UTC())
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign URL for gs://%s/%s: %w", d.bucket, object, err)
+		}
+		expiresAt := time.Now().Add(d.urlExpiry)
+		result.URL = signedURL
+		result.ExpiresAt = &expiresAt
+	}
+
+	return result, nil
+}
+
+// signedURL builds a V4 signed GET URL for the object
+// See https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func (d *gcsDelivery) signedURL(ctx context.Context, object string, now time.Time) (string, error) {
+	datestamp := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	scope := datestamp + "/auto/storage/goog4_request"
+
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", d.signerEmail+"/"+scope)
+	query.Set("X-Goog-Date", timestamp)
+	query.Set("X-Goog-Expires", fmt.Sprintf("%d", int(d.urlExpiry.Seconds())))
+	query.Set("X-Goog-SignedHeaders", "host")
+
+	escapedPath := "/" + url.PathEscape(d.bucket) + "/" + escapeObjectPath(object)
+	canonicalQuery := canonicalQueryString(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		escapedPath,
+		canonicalQuery,
+		"host:" + gcsHost + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		timestamp,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signature, err := d.sign(ctx, []byte(stringToSign))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("https://%s%s?%s&X-Goog-Signature=%s", gcsHost, escapedPath, canonicalQuery, hex.EncodeToString(signature)), nil
+}
+
+// sign signs the payload with the local service account key or via the IAM signBlob API
+func (d *gcsDelivery) sign(ctx context.Context, payload []byte) ([]byte, error) {
+	if d.privateKey != nil {
+		digest := sha256.Sum256(payload)
+		return rsa.SignPKCS1v15(nil, d.privateKey, crypto.SHA256, digest[:])
+	}
+
+	body, _ := json.Marshal(map[string]string{"payload": base64.StdEncoding.EncodeToString(payload)})
+	signURL := fmt.Sprintf("https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:signBlob", url.PathEscape(d.signerEmail))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, signURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("signBlob failed: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var signed struct {
+		SignedBlob string `json:"signedBlob"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return nil, fmt.Errorf("invalid signBlob response: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(signed.SignedBlob)
+}
+
+func escapeObjectPath(object string) string {
+	segments := strings.Split(object, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQueryString encodes the query sorted by key as required by V4 signing
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, gcsQueryEscape(key)+"="+gcsQueryEscape(query.Get(key)))
+	}
+	return strings.Join(parts, "&")
+}
+
+// gcsQueryEscape percent-encodes spaces as %20 rather than "+"
+func gcsQueryEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
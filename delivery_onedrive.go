@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"golang.org/x/oauth2"
+)
+
+const (
+	graphURL   = "https://graph.microsoft.com/v1.0"
+	graphScope = "https://graph.microsoft.com/.default"
+	// Upload session chunks must be multiples of 320 KiB
+	oneDriveChunkSize = 32 * 320 << 10
+)
+
+// oneDriveDelivery uploads archives to a folder of a OneDrive or SharePoint document library and shares
+// them by link
+type oneDriveDelivery struct {
+	client *http.Client
+	// upload sends the chunks of upload sessions, whose URLs carry their own authorization
+	upload     *http.Client
+	endpoint   string
+	driveID    string
+	folder     string
+	linkScope  string
+	linkExpiry time.Duration
+}
+
+// newOneDriveDelivery configures OneDrive delivery through Microsoft Graph. A client secret authenticates
+// an app registration, otherwise the credential comes from azidentity like Azure Blob delivery.
+func newOneDriveDelivery(c OneDriveConfig) (*oneDriveDelivery, error) {
+	var cred azcore.TokenCredential
+	var err error
+	if c.ClientSecret != "" {
+		cred, err = azidentity.NewClientSecretCredential(c.TenantID, c.ClientID, c.ClientSecret, nil)
+	} else {
+		// Covers AKS workload identity, managed identity and service principal env vars
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+	}
+
+	d := &oneDriveDelivery{
+		client:     oauth2.NewClient(context.Background(), oauth2.ReuseTokenSource(nil, graphTokenSource{cred})),
+		upload:     &http.Client{},
+		endpoint:   graphURL,
+		driveID:    c.DriveID,
+		folder:     strings.Trim(c.Folder, "/"),
+		linkScope:  c.LinkScope,
+		linkExpiry: c.LinkExpiry,
+	}
+	if c.Endpoint != "" {
+		d.endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	}
+	return d, nil
+}
+
+// graphTokenSource hands tokens of an Azure credential to oauth2 clients
+type graphTokenSource struct {
+	cred azcore.TokenCredential
+}
+
+func (s graphTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{graphScope}})
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: token.Token, TokenType: "Bearer", Expiry: token.ExpiresOn}, nil
+}
+
+func (d *oneDriveDelivery) Deliver(ctx context.Context, zipPath, objectName string) (*DeliveryResult, error) {
+	file, err := openAtRest(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	target := path.Join(d.folder, objectName)
+	item, err := d.uploadFile(ctx, file, file.Size(), target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to onedrive:%s: %w", target, err)
+	}
+
+	// Anonymous links can expire, organization links last as long as the file
+	linkRequest := map[string]any{"type": "view", "scope": d.linkScope}
+	var expiresAt *time.Time
+	if d.linkScope == "anonymous" && d.linkExpiry > 0 {
+		expiry := time.Now().Add(d.linkExpiry).UTC()
+		linkRequest["expirationDateTime"] = expiry.Format(time.RFC3339)
+		expiresAt = &expiry
+	}
+	var permission struct {
+		Link struct {
+			WebURL string `json:"webUrl"`
+		} `json:"link"`
+	}
+	endpoint := fmt.Sprintf("%s/drives/%s/items/%s/createLink", d.endpoint, url.PathEscape(d.driveID), url.PathEscape(item.ID))
+	if err := d.call(ctx, endpoint, linkRequest, &permission); err != nil {
+		return nil, fmt.Errorf("failed to share onedrive:%s: %w", item.path(), err)
+	}
+	return &DeliveryResult{
+		Backend:   "onedrive",
+		Location:  "onedrive:" + item.path(),
+		URL:       permission.Link.WebURL,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// driveItem is the uploaded file as Graph describes it
+type driveItem struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	ParentReference struct {
+		Path string `json:"path"`
+	} `json:"parentReference"`
+}
+
+// path is the item's path in its drive, e.g. /Factsheets/acme_globex_factsheets_<id>.zip
+func (i driveItem) path() string {
+	parent := i.ParentReference.Path
+	if _, rest, ok := strings.Cut(parent, ":"); ok {
+		parent = rest
+	}
+	return path.Join("/", parent, i.Name)
+}
+
+// uploadFile uploads the file through an upload session, which takes files of any size. Archives are never
+// overwritten, a name that is taken gets a number from OneDrive.
+func (d *oneDriveDelivery) uploadFile(ctx context.Context, file io.ReaderAt, size int64, target string) (*driveItem, error) {
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	endpoint := fmt.Sprintf("%s/drives/%s/root:/%s:/createUploadSession", d.endpoint, url.PathEscape(d.driveID), escapeObjectPath(target))
+	if err := d.call(ctx, endpoint, map[string]any{"item": map[string]any{"@microsoft.graph.conflictBehavior": "rename"}}, &session); err != nil {
+		return nil, err
+	}
+
+	for offset := int64(0); ; offset += oneDriveChunkSize {
+		length := min(oneDriveChunkSize, size-offset)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, io.NewSectionReader(file, offset, length))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = length
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		resp, err := d.upload.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// The last chunk is answered with the new item, the others with 202 Accepted
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			var item driveItem
+			err := json.NewDecoder(resp.Body).Decode(&item)
+			resp.Body.Close()
+			return &item, err
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		if offset+length >= size {
+			return nil, fmt.Errorf("upload session did not complete after %d bytes", size)
+		}
+	}
+}
+
+// call posts a JSON request to Graph and decodes the response into out
+func (d *oneDriveDelivery) call(ctx context.Context, endpoint string, args, out any) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
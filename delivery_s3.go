@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3Delivery uploads archives to an S3 (or S3 compatible) bucket
+type s3Delivery struct {
+	client        *s3.Client
+	presigner     *s3.PresignClient
+	bucket        string
+	prefix        string
+	presignExpiry time.Duration
+}
+
+// newS3Delivery configures S3 delivery. Credentials come from the configured access key when set,
+// otherwise from the default AWS credential chain (env, shared config, IAM role).
+func newS3Delivery(c S3Config) (*s3Delivery, error) {
+	opts := []func(*config.LoadOptions) error{}
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+	if c.AccessKeyID != "" {
+		provider := credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)
+		opts = append(opts, config.WithCredentialsProvider(provider))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// Custom endpoints (MinIO, Ceph, ...) usually need path-style addressing
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &s3Delivery{
+		client:        client,
+		presigner:     s3.NewPresignClient(client),
+		bucket:        c.Bucket,
+		prefix:        c.Prefix,
+		presignExpiry: c.PresignExpiry,
+	}, nil
+}
+
+func (d *s3Delivery) Deliver(ctx context.Context, zipPath, objectName string) (*DeliveryResult, error) {
+	file, err := openAtRest(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	key := path.Join(d.prefix, objectName)
+	_, err = d.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(d.bucket),
+		Key:           aws.String(key),
+		Body:          file,
+		ContentLength: aws.Int64(file.Size()),
+		ContentType:   aws.String(archiveContentType(objectName)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to s3://%s/%s: %w", d.bucket, key, err)
+	}
+
+	result := &DeliveryResult{
+		Backend:  "s3",
+		Location: fmt.Sprintf("s3://%s/%s", d.bucket, key),
+	}
+
+	// A zero expiry disables presigning, callers then only get the object key
+	if d.presignExpiry > 0 {
+		presigned, err := d.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(d.bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(d.presignExpiry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign s3://%s/%s: %w", d.bucket, key, err)
+		}
+		expiresAt := time.Now().Add(d.presignExpiry)
+		result.URL = presigned.URL
+		result.ExpiresAt = &expiresAt
+	}
+
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const diskUsageCheckInterval = 2 * time.Second
+
+// diskFullRetryAfter is suggested to clients turned away because the scratch volume is full
+const diskFullRetryAfter = time.Minute
+
+var (
+	// minFreeDisk is the free space the scratch volume needs before new jobs are accepted, 0 disables the check
+	minFreeDisk int64
+	// maxJobDiskUsage aborts jobs whose working directory grows beyond it, 0 disables the cap
+	maxJobDiskUsage int64
+)
+
+func setupDiskGuard() {
+	minFreeDisk = int64(appConfig.Processing.MinFreeDisk)
+	maxJobDiskUsage = int64(appConfig.Processing.MaxJobDiskUsage)
+	logger.Info().Msgf("Disk guard initialized: %s minimum free space, %s per job", formatBytes(minFreeDisk), formatBytes(maxJobDiskUsage))
+}
+
+// scratchRoot is the directory the tenant's jobs work in: the tenant's scratch_dir setting, its own
+// directory with SCRATCH_PER_TENANT, or the scratch directory itself
+func scratchRoot(tenant string) string {
+	if dir := tenants.scratchDir(tenant); dir != "" {
+		return filepath.Join(appConfig.Processing.ScratchDir, dir)
+	}
+	if appConfig.Processing.ScratchPerTenant {
+		name := sanitizeFilename(tenant)
+		if !filepath.IsLocal(name) {
+			name = "_"
+		}
+		return filepath.Join(appConfig.Processing.ScratchDir, "tenants", name)
+	}
+	return appConfig.Processing.ScratchDir
+}
+
+// checkDiskSpace returns an error when the tenant's scratch volume is below the free space threshold
+func checkDiskSpace(tenant string) error {
+	if minFreeDisk <= 0 {
+		return nil
+	}
+	scratchDir := scratchRoot(tenant)
+	if err := os.MkdirAll(scratchDir, 0700); err != nil {
+		return fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	free, err := freeDiskSpace(scratchDir)
+	if err != nil {
+		// Don't turn requests away because the platform cannot report free space
+		logger.Error().Err(err).Msg("Error checking free disk space")
+		return nil
+	}
+	if free < minFreeDisk {
+		return fmt.Errorf("only %s free on the scratch volume, %s required", formatBytes(free), formatBytes(minFreeDisk))
+	}
+	return nil
+}
+
+// watchDiskUsage records the disk usage of the job's working directory and aborts the job when it
+// exceeds the per-job cap. The returned function stops watching.
+func watchDiskUsage(job *Job, dir string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(diskUsageCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			usage := dirSize(dir)
+			job.recordDiskUsage(usage)
+			if maxJobDiskUsage > 0 && usage > maxJobDiskUsage {
+				job.log().Warn().Msgf("Job %s uses %s of disk, aborting (limit %s)", job.ID, formatBytes(usage), formatBytes(maxJobDiskUsage))
+				abortJob(job, fmt.Errorf("job exceeded the disk usage limit of %s", formatBytes(maxJobDiskUsage)))
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		job.recordDiskUsage(dirSize(dir))
+	}
+}
+
+// abortJob stops a job everywhere it is being processed and fails it with err
+func abortJob(job *Job, err error) {
+	job.abort(err)
+	if taskQueue != nil {
+		if err := taskQueue.cancel(job.ID); err != nil {
+			job.log().Error().Err(err).Msgf("Error broadcasting abort of job %s", job.ID)
+		}
+	}
+}
+
+// dirSize returns the total size of the regular files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		// Files disappear while candidates are processed, skip whatever cannot be read
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResumeHeaders are extra request headers for a resume download. The values usually hold credentials,
+// so printing the headers shows only their names.
+type ResumeHeaders map[string]string
+
+// reservedHeaders are managed by the HTTP client and cannot be supplied by callers
+var reservedHeaders = []string{"Host", "Content-Length", "Transfer-Encoding", "Connection", "Te", "Trailer", "Upgrade",
+	"Keep-Alive", "Proxy-Authorization", "Proxy-Connection"}
+
+func (h ResumeHeaders) validate() error {
+	for name, value := range h {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if slices.Contains(reservedHeaders, http.CanonicalHeaderKey(name)) {
+			return fmt.Errorf("header %s cannot be set", http.CanonicalHeaderKey(name))
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("invalid value for header %s", http.CanonicalHeaderKey(name))
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an HTTP token (RFC 9110)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// merge returns the headers with the override applied, header names compared case-insensitively
+func (h ResumeHeaders) merge(override ResumeHeaders) ResumeHeaders {
+	if len(h) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := ResumeHeaders{}
+	for name, value := range h {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range override {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	return merged
+}
+
+func (h ResumeHeaders) String() string {
+	names := slices.Sorted(maps.Keys(h))
+	for i, name := range names {
+		names[i] = name + ": [REDACTED]"
+	}
+	return "{" + strings.Join(names, ", ") + "}"
+}
+
+func (h ResumeHeaders) GoString() string { return h.String() }
+
+// sensitiveQueryParams matches query parameters of signed URLs that grant access on their own
+var sensitiveQueryParams = []string{"sig", "signature", "token", "key", "secret", "password", "credential", "auth", "code"}
+
+// redactURL hides passwords and signature-like query parameters so URLs can be logged
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[invalid URL]"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			lower := strings.ToLower(name)
+			if slices.ContainsFunc(sensitiveQueryParams, func(s string) bool { return strings.Contains(lower, s) }) {
+				query.Set(name, "REDACTED")
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// redactSecretURL keeps only the scheme and host of a URL that grants access by its path, like a chat webhook
+func redactSecretURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "[invalid URL]"
+	}
+	return u.Scheme + "://" + u.Host + "/REDACTED"
+}
+
+// errResumeTooLarge is returned for resumes larger than the configured maximum size
+var errResumeTooLarge = errors.New("resume is too large")
+
+// retryableError marks a download failure that may succeed when tried again, e.g. a 502 from the
+// document store or a dropped connection
+type retryableError struct {
+	err error
+	// retryAfter is the delay the server asked for, zero when it didn't
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// httpStatusError is a download answered with a status other than 200
+type httpStatusError struct {
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("failed to download file: HTTP %d", e.status)
+}
+
+// downloadInfo describes a finished download
+type downloadInfo struct {
+	Attempts int
+	// ETag and LastModified let a cached copy be revalidated on the next download
+	ETag         string
+	LastModified string
+	// NotModified is set when a conditional request found the cached copy current, nothing was written
+	NotModified bool
+	// ContentType and Filename are what the server said about the file, used to detect its format
+	ContentType string
+	Filename    string
+}
+
+// downloadFile fetches a resume, retrying transient failures with exponential backoff
+func downloadFile(ctx context.Context, guard *downloadGuard, rawURL string, headers ResumeHeaders, outputPath string) (downloadInfo, error) {
+	cfg := appConfig.Download
+	attempts := cfg.Retries + 1
+	for attempt := 1; ; attempt++ {
+		info, err := downloadOnce(ctx, guard, rawURL, headers, outputPath)
+		info.Attempts = attempt
+
+		var retryable *retryableError
+		if err == nil || attempt >= attempts || !errors.As(err, &retryable) || ctx.Err() != nil {
+			return info, err
+		}
+
+		delay := backoffDelay(attempt, cfg.RetryDelay, cfg.RetryMaxDelay)
+		if retryable.retryAfter > delay {
+			delay = min(retryable.retryAfter, cfg.RetryMaxDelay)
+		}
+		logFrom(ctx).Warn().Err(err).Msgf("Download of %s failed (attempt %d of %d), retrying in %s", redactURL(rawURL), attempt, attempts, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return info, err
+		}
+	}
+}
+
+// backoffDelay doubles the base delay for every attempt up to the maximum and picks a random delay in
+// the upper half, so candidates failing together don't retry in lockstep
+func backoffDelay(attempt int, base, maxDelay time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// downloadOnce fetches a resume, refusing URLs and addresses the download policy does not allow
+func downloadOnce(ctx context.Context, guard *downloadGuard, rawURL string, headers ResumeHeaders, outputPath string) (downloadInfo, error) {
+	logFrom(ctx).Debug().Msgf("Downloading file from URL: %s", redactURL(rawURL))
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return downloadInfo{}, errors.New("invalid resume URL")
+	}
+	if err := guard.checkURL(u); err != nil {
+		return downloadInfo{}, err
+	}
+	if u.Scheme == "s3" {
+		if resumeS3 == nil {
+			return downloadInfo{}, errors.New("s3:// resume downloads are not configured")
+		}
+		return resumeS3.fetch(ctx, u, outputPath)
+	}
+	// Drive links lead to an HTML viewer page, the file itself comes from the Drive API
+	if isDriveURL(u) && resumeDrive != nil {
+		return resumeDrive.fetch(ctx, u, headers, outputPath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return downloadInfo{}, errors.New("invalid resume URL")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := resumeClient(guard, headers).Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		if errors.Is(err, errDownloadBlocked) {
+			return downloadInfo{}, err
+		}
+		return downloadInfo{}, &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	info := downloadInfo{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		Filename:     dispositionFilename(resp.Header.Get("Content-Disposition")),
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		info.NotModified = true
+		return info, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := &httpStatusError{resp.StatusCode}
+		switch resp.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return info, &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return info, err
+	}
+
+	return info, saveDownload(resp.Body, resp.ContentLength, outputPath)
+}
+
+// resumeClient is the HTTP client of resume downloads, following redirects the download policy allows
+func resumeClient(guard *downloadGuard, headers ResumeHeaders) *http.Client {
+	client := guard.client(appConfig.Processing.DownloadTimeout)
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(redirect *http.Request, via []*http.Request) error {
+		if err := checkRedirect(redirect, via); err != nil {
+			return err
+		}
+		// Credentials meant for the document store must not leak to another host
+		if redirect.URL.Host != via[0].URL.Host {
+			for name := range headers {
+				redirect.Header.Del(name)
+			}
+		}
+		return nil
+	}
+	return client
+}
+
+// saveDownload writes a downloaded body to disk. Oversized resumes are rejected up front when the size
+// is announced, and reading stops once the limit is passed when it isn't or is misreported.
+func saveDownload(src io.Reader, contentLength int64, outputPath string) error {
+	maxSize := int64(appConfig.Download.MaxSize)
+	body := src
+	if maxSize > 0 {
+		if contentLength > maxSize {
+			return fmt.Errorf("%w: %s exceeds the limit of %s", errResumeTooLarge, formatBytes(contentLength), formatBytes(maxSize))
+		}
+		body = io.LimitReader(src, maxSize+1)
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	written, err := io.Copy(out, body)
+	if err != nil {
+		return &retryableError{err: fmt.Errorf("failed to read response: %w", err)}
+	}
+	if maxSize > 0 && written > maxSize {
+		out.Close()
+		os.Remove(outputPath)
+		return fmt.Errorf("%w: more than %s", errResumeTooLarge, formatBytes(maxSize))
+	}
+	logger.Debug().Msgf("File downloaded successfully: %s (%s)", outputPath, formatBytes(written))
+	return nil
+}
+
+// dispositionFilename returns the file name of a Content-Disposition header, including RFC 5987 encoded ones
+func dispositionFilename(value string) string {
+	if value == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunConcurrency bounds the resume URLs a dry run checks at once
+const dryRunConcurrency = 8
+
+// resourceProbe is what a dry run learns about a resume without downloading it
+type resourceProbe struct {
+	HTTPStatus  int
+	ContentType string
+	Filename    string
+	// Size is 0 when the server does not say
+	Size int64
+	// Head is the start of the file, fetched when the headers do not give its format away
+	Head []byte
+}
+
+// DryRunFile is the report of a dry run on a resume or attachment
+type DryRunFile struct {
+	// Source is url for files that are downloaded, inline for resume_content
+	Source      string `json:"source"`
+	URL         string `json:"url,omitempty"`
+	HTTPStatus  int    `json:"http_status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	// Format is the detected type and Converter the tool that would turn it into a PDF
+	Format    string `json:"format,omitempty"`
+	Converter string `json:"converter,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DryRunCandidate is the report of a dry run on a candidate, ready when all of its files could be processed
+type DryRunCandidate struct {
+	Index       int          `json:"index"`
+	CandidateID string       `json:"candidate_id,omitempty"`
+	Name        string       `json:"name"`
+	Email       string       `json:"email"`
+	Status      string       `json:"status"`
+	File        string       `json:"file"`
+	Resume      *DryRunFile  `json:"resume,omitempty"`
+	Attachments []DryRunFile `json:"attachments,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+const (
+	dryRunReady  = "ready"
+	dryRunFailed = "failed"
+)
+
+// dryRun answers a validated job request with what processing it would find, without generating anything:
+// every resume and attachment URL is checked with a HEAD request and the format of every file is detected.
+// Jobs making factsheets only have nothing to check.
+func dryRun(c *gin.Context, req ProcessRequest, warnings []string) {
+	ctx := c.Request.Context()
+	guard := tenants.downloadGuard(req.TenantName)
+	names, nameWarnings := namePackets("dry-run", req)
+	warnings = append(warnings, nameWarnings...)
+
+	report := make([]DryRunCandidate, len(req.Candidates))
+	slots := make(chan struct{}, dryRunConcurrency)
+	var wg sync.WaitGroup
+	for i, cand := range req.Candidates {
+		report[i] = DryRunCandidate{Index: i, CandidateID: cand.CandidateID, Name: cand.Name, Email: cand.Email, File: names[i]}
+		if req.OutputMode == outputModeFactsheetOnly {
+			report[i].Status = dryRunReady
+			continue
+		}
+		wg.Add(1)
+		go func(result *DryRunCandidate, cand Candidate) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			result.check(ctx, guard, cand)
+		}(&report[i], cand)
+	}
+	wg.Wait()
+
+	ready := 0
+	for _, result := range report {
+		if result.Status == dryRunReady {
+			ready++
+		}
+	}
+	requestLog(c).Info().Msgf("Dry run for tenant %s: %d of %d candidates ready", req.TenantName, ready, len(report))
+	response := gin.H{
+		"dry_run":          true,
+		"valid":            ready == len(report),
+		"total_candidates": len(report),
+		"ready":            ready,
+		"failed":           len(report) - ready,
+		"candidates":       report,
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// check fills in the report of a candidate
+func (r *DryRunCandidate) check(ctx context.Context, guard *downloadGuard, cand Candidate) {
+	r.Status = dryRunReady
+	switch {
+	case len(cand.ResumeContent) > 0:
+		r.Resume = checkInline(cand.ResumeContent, cand.ResumeFilename)
+	case cand.ResumeURL != "":
+		r.Resume = checkURL(ctx, guard, cand.ResumeURL, cand.ResumeHeaders)
+	default:
+		r.Status, r.Error = dryRunFailed, "no resume_url or resume_content"
+		return
+	}
+	if r.Resume.Error != "" {
+		r.Status, r.Error = dryRunFailed, "resume: "+r.Resume.Error
+	}
+	for i, attachment := range cand.Attachments {
+		file := checkURL(ctx, guard, attachment.URL, cand.ResumeHeaders)
+		if file.Error != "" && r.Status == dryRunReady {
+			r.Status, r.Error = dryRunFailed, fmt.Sprintf("attachment %d: %s", i+1, file.Error)
+		}
+		r.Attachments = append(r.Attachments, *file)
+	}
+}
+
+// checkInline detects the format of a resume sent in the request
+func checkInline(content []byte, filename string) *DryRunFile {
+	file := &DryRunFile{Source: "inline", Filename: filename, Size: int64(len(content))}
+	format, err := sniffResumeFormat(content[:min(len(content), sniffLength)], bytes.NewReader(content), int64(len(content)), "", filename, "")
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	file.Format, file.Converter = format.MIME, converterName(format)
+	return file
+}
+
+// checkURL looks a file up where processing would download it from and detects its format
+func checkURL(ctx context.Context, guard *downloadGuard, rawURL string, headers ResumeHeaders) *DryRunFile {
+	file := &DryRunFile{Source: "url", URL: redactURL(rawURL)}
+	probe, err := probeURL(ctx, guard, rawURL, headers)
+	file.HTTPStatus, file.ContentType, file.Filename, file.Size = probe.HTTPStatus, probe.ContentType, probe.Filename, probe.Size
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	if maxSize := int64(appConfig.Download.MaxSize); maxSize > 0 && probe.Size > maxSize {
+		file.Error = fmt.Sprintf("%v: %s exceeds the limit of %s", errResumeTooLarge, formatBytes(probe.Size), formatBytes(maxSize))
+		return file
+	}
+
+	var format resumeFormat
+	if probe.Head != nil {
+		format, err = sniffResumeFormat(probe.Head, nil, probe.Size, probe.ContentType, probe.Filename, rawURL)
+	} else if format = hintedFormat(probe.ContentType, probe.Filename, rawURL); format.MIME == "" {
+		err = errors.New("the format cannot be told without downloading the file")
+	}
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	file.Format, file.Converter = format.MIME, converterName(format)
+	return file
+}
+
+// probeURL checks a URL as downloadOnce would fetch it, with a HEAD request. Servers that refuse HEAD, such as
+// presigned URLs signed for GET only, and files whose headers do not give their format away are asked for
+// their first bytes instead.
+func probeURL(ctx context.Context, guard *downloadGuard, rawURL string, headers ResumeHeaders) (resourceProbe, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return resourceProbe{}, errors.New("invalid resume URL")
+	}
+	if err := guard.checkURL(u); err != nil {
+		return resourceProbe{}, err
+	}
+	if u.Scheme == "s3" {
+		if resumeS3 == nil {
+			return resourceProbe{}, errors.New("s3:// resume downloads are not configured")
+		}
+		return resumeS3.probe(ctx, u)
+	}
+	if isDriveURL(u) && resumeDrive != nil {
+		return resumeDrive.probe(ctx, u, headers)
+	}
+
+	client := resumeClient(guard, headers)
+	probe, err := probeRequest(ctx, client, http.MethodHead, rawURL, headers)
+	if err != nil {
+		return probe, err
+	}
+	switch probe.HTTPStatus {
+	case http.StatusOK:
+		if hintedFormat(probe.ContentType, probe.Filename, rawURL).MIME != "" {
+			return probe, nil
+		}
+	case http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+	default:
+		return probe, fmt.Errorf("HTTP %d", probe.HTTPStatus)
+	}
+
+	probe, err = probeRequest(ctx, client, http.MethodGet, rawURL, headers)
+	if err == nil && probe.HTTPStatus != http.StatusOK && probe.HTTPStatus != http.StatusPartialContent {
+		err = fmt.Errorf("HTTP %d", probe.HTTPStatus)
+	}
+	return probe, err
+}
+
+// probeRequest sends a HEAD request, or a GET for the first sniffLength bytes
+func probeRequest(ctx context.Context, client *http.Client, method, rawURL string, headers ResumeHeaders) (resourceProbe, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return resourceProbe{}, errors.New("invalid resume URL")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sniffLength-1))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return resourceProbe{}, err
+	}
+	defer resp.Body.Close()
+
+	probe := resourceProbe{
+		HTTPStatus:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Filename:    dispositionFilename(resp.Header.Get("Content-Disposition")),
+		Size:        max(resp.ContentLength, 0),
+	}
+	if method == http.MethodGet && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) {
+		probe.Head, _ = io.ReadAll(io.LimitReader(resp.Body, sniffLength))
+		if resp.StatusCode == http.StatusPartialContent {
+			probe.Size = rangeTotal(resp.Header.Get("Content-Range"))
+		}
+	}
+	return probe, nil
+}
+
+// rangeTotal is the size of the whole file in a Content-Range header such as "bytes 0-1023/48213", 0 when unknown
+func rangeTotal(contentRange string) int64 {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return 0
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
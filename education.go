@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxEducation keeps the education table of a candidate to a page
+const maxEducation = 20
+
+// Education is a degree or other qualification the candidate earned
+type Education struct {
+	Degree      string `json:"degree"`
+	Institution string `json:"institution"`
+	// Year is when the qualification was completed, e.g. "2019"
+	Year  string `json:"year,omitempty"`
+	Grade string `json:"grade,omitempty"`
+}
+
+// educationTable is the layout of the education section: titles and the share of the width of each column
+var educationTable = factsheetTable{
+	titles: []string{"Degree", "Institution", "Year", "Grade"},
+	widths: []float64{0.36, 0.38, 0.1, 0.16},
+}
+
+// validateEducation checks the qualifications of a candidate
+func (cand Candidate) validateEducation() error {
+	if len(cand.Education) > maxEducation {
+		return fmt.Errorf("at most %d education entries are allowed", maxEducation)
+	}
+	for i, entry := range cand.Education {
+		if strings.TrimSpace(entry.Degree) == "" && strings.TrimSpace(entry.Institution) == "" {
+			return fmt.Errorf("education %d needs a degree or an institution", i)
+		}
+		if _, err := time.Parse("2006", entry.Year); entry.Year != "" && err != nil {
+			return fmt.Errorf("education %d year must be a year such as 2019", i)
+		}
+	}
+	return nil
+}
+
+// drawEducation draws the candidate's qualifications as a table in a column of the page from y, in the
+// order given and in the font size given. With a bottom, as in the compact theme, every cell is kept to a
+// line and the table stops there. It returns the y below the table and how many qualifications fit.
+func (p factsheetPage) drawEducation(x, y, width, size, bottom float64) (float64, int) {
+	if len(p.cand.Education) == 0 {
+		return y, 0
+	}
+	table := educationTable
+	table.size, table.clip, table.bottom = size, 4, bottom
+	if bottom > 0 {
+		table.clip = 1
+	}
+	for _, entry := range p.cand.Education {
+		table.rows = append(table.rows, []string{entry.Degree, entry.Institution, entry.Year, entry.Grade})
+	}
+	return p.drawTable(x, y, width, table)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxPDFPasswordLength is the longest password AES-256 PDF encryption takes, in bytes
+const maxPDFPasswordLength = 127
+
+// OutputEncryption protects the PDFs of a job with AES-256. Readers need the user password to open them,
+// the owner password also lifts the restrictions on printing and copying. Without an owner password a
+// random one is used, so nobody can lift them.
+type OutputEncryption struct {
+	UserPassword  string `json:"user_password"`
+	OwnerPassword string `json:"owner_password,omitempty"`
+}
+
+func (e *OutputEncryption) validate() error {
+	if e.UserPassword == "" && e.OwnerPassword == "" {
+		return errors.New("encryption needs a user_password or an owner_password")
+	}
+	for _, password := range []string{e.UserPassword, e.OwnerPassword} {
+		if len(password) > maxPDFPasswordLength {
+			return fmt.Errorf("encryption passwords cannot be longer than %d bytes", maxPDFPasswordLength)
+		}
+		// Passwords are passed to qpdf a line each
+		if strings.ContainsAny(password, "\r\n") {
+			return errors.New("encryption passwords cannot contain line breaks")
+		}
+	}
+	return nil
+}
+
+// encryptOutputs encrypts every PDF of the archive in factsheetDir: the candidates' packets, the combined
+// PDF and the index. With linearize they are linearized in the same pass, which encrypting would undo.
+func encryptOutputs(ctx context.Context, factsheetDir string, enc *OutputEncryption, linearize bool) error {
+	ownerPassword := enc.OwnerPassword
+	if ownerPassword == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		ownerPassword = hex.EncodeToString(random)
+	}
+	args := []string{"--encrypt", enc.UserPassword, ownerPassword, "256", "--"}
+	if linearize {
+		args = append(args, "--linearize")
+	}
+
+	paths, err := outputPDFs(factsheetDir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := rewritePDF(ctx, path, args); err != nil {
+			rel, _ := filepath.Rel(factsheetDir, path)
+			return fmt.Errorf("%s: %w", filepath.ToSlash(rel), err)
+		}
+	}
+	logFrom(ctx).Debug().Msgf("Encrypted %d PDFs in %s", len(paths), factsheetDir)
+	return nil
+}
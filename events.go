@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// jobEventsPollInterval is how often streams reload jobs run by other instances from the job store
+	jobEventsPollInterval = 2 * time.Second
+	// jobEventsKeepAlive keeps proxies from closing streams of jobs waiting on a slow candidate
+	jobEventsKeepAlive = 15 * time.Second
+)
+
+// jobProgress is the data of progress events, sent when the job's status or counts change
+type jobProgress struct {
+	Status    string `json:"status"`
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	// DownloadURL is only set in the done event
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// candidateEventData is the data of candidate events, sent as each candidate finishes
+type candidateEventData struct {
+	Index       int    `json:"index"`
+	CandidateID string `json:"candidate_id,omitempty"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	ErrorCode   string `json:"error_code,omitempty"`
+	// File and Failure are those of CandidateProgress
+	File    string            `json:"file,omitempty"`
+	Failure *CandidateFailure `json:"failure,omitempty"`
+}
+
+// streamJobEvents sends the progress of a job as Server-Sent Events: a candidate event as each candidate
+// finishes, a progress event whenever the counts change and a done event once the job has finished, after
+// which the stream ends. Candidates that finished before the client connected are sent first, so clients
+// that reconnect catch up without Last-Event-ID.
+func streamJobEvents(c *gin.Context) {
+	job, ok := jobs.get(c.Param("id"))
+	if !ok || !canAccessTenant(c, job.TenantName) {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "job not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// nginx buffers responses unless told otherwise, holding events back
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	followJob(c.Request.Context(), job, func(event string, data any) error {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+		return nil
+	}, func() error {
+		_, err := c.Writer.WriteString(": keep-alive\n\n")
+		c.Writer.Flush()
+		return err
+	})
+}
+
+// followJob passes the events of a job to send until the job has finished, ctx is done, the service shuts
+// down or sending fails. keepAlive is called while nothing happens.
+func followJob(ctx context.Context, job *Job, send func(event string, data any) error, keepAlive func() error) {
+	poll := time.NewTicker(jobEventsPollInterval)
+	defer poll.Stop()
+	idle := time.NewTicker(jobEventsKeepAlive)
+	defer idle.Stop()
+
+	reported := make([]bool, len(job.Candidates))
+	var last jobProgress
+	for {
+		// Taken before reading the job so no change between reading and waiting is missed
+		updated := job.updates()
+		progress, candidates := jobEvents(job, reported)
+		for _, cand := range candidates {
+			if err := send("candidate", cand); err != nil {
+				return
+			}
+		}
+		if isFinalJobStatus(progress.Status) {
+			if url, ok := job.summary()["download_url"].(string); ok {
+				progress.DownloadURL = url
+			}
+			send("done", progress)
+			return
+		}
+		if progress != last {
+			if err := send("progress", progress); err != nil {
+				return
+			}
+			last = progress
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-updated:
+		case <-poll.C:
+			// Streams must not hold up the shutdown, clients reconnect to another instance
+			if shuttingDown.Load() {
+				return
+			}
+			if fresh, ok := jobs.get(job.ID); ok {
+				job = fresh
+			}
+		case <-idle.C:
+			if err := keepAlive(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// jobEvents returns the job's progress and the candidates that finished since the last call, marking them
+// as reported
+func jobEvents(job *Job, reported []bool) (jobProgress, []candidateEventData) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	progress := jobProgress{Status: job.Status, Total: len(job.Candidates)}
+	var finished []candidateEventData
+	for i, cand := range job.Candidates {
+		if !isFinalCandidateStatus(cand.Status) {
+			continue
+		}
+		progress.Processed++
+		switch cand.Status {
+		case candidateStatusCompleted:
+			progress.Succeeded++
+		case candidateStatusFailed, candidateStatusTimedOut:
+			progress.Failed++
+		}
+		if i < len(reported) && !reported[i] {
+			reported[i] = true
+			finished = append(finished, candidateEventData{
+				Index:       i,
+				CandidateID: cand.CandidateID,
+				Name:        cand.Name,
+				Status:      cand.Status,
+				Error:       cand.Error,
+				ErrorCode:   cand.ErrorCode,
+				File:        cand.File,
+				Failure:     cand.Failure,
+			})
+		}
+	}
+	return progress, finished
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxWorkHistory keeps the work history of a candidate to a few pages
+const maxWorkHistory = 30
+
+// WorkExperience is a position in the candidate's work history
+type WorkExperience struct {
+	Company string `json:"company"`
+	Title   string `json:"title"`
+	// Start and End are months such as "2021-04" or years such as "2021". Without End the position is current.
+	Start       string `json:"start"`
+	End         string `json:"end,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// historyDateLayouts are the accepted formats of work history dates, months before years
+var historyDateLayouts = []string{"2006-01", "2006"}
+
+// parseHistoryDate reads a work history date and returns it with how it is printed, "Apr 2021" or "2021"
+func parseHistoryDate(value string) (time.Time, string, error) {
+	for _, layout := range historyDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "2006" {
+				return t, t.Format("2006"), nil
+			}
+			return t, t.Format("Jan 2006"), nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("%q is not a month such as 2021-04 or a year", value)
+}
+
+// Period is when the candidate held the position, e.g. "Apr 2021 – Present"
+func (e WorkExperience) Period() string {
+	_, start, err := parseHistoryDate(e.Start)
+	if err != nil {
+		return ""
+	}
+	end := "Present"
+	if e.End != "" {
+		if _, end, err = parseHistoryDate(e.End); err != nil {
+			return start
+		}
+	}
+	return start + " – " + end
+}
+
+// validateWorkHistory checks the positions of a candidate's work history
+func (cand Candidate) validateWorkHistory() error {
+	if len(cand.WorkHistory) > maxWorkHistory {
+		return fmt.Errorf("at most %d work_history entries are allowed", maxWorkHistory)
+	}
+	for i, position := range cand.WorkHistory {
+		if strings.TrimSpace(position.Company) == "" && strings.TrimSpace(position.Title) == "" {
+			return fmt.Errorf("work_history %d needs a company or a title", i)
+		}
+		if position.Start == "" {
+			return fmt.Errorf("work_history %d needs a start", i)
+		}
+		start, _, err := parseHistoryDate(position.Start)
+		if err != nil {
+			return fmt.Errorf("work_history %d start: %w", i, err)
+		}
+		if position.End == "" {
+			continue
+		}
+		end, _, err := parseHistoryDate(position.End)
+		if err != nil {
+			return fmt.Errorf("work_history %d end: %w", i, err)
+		}
+		if end.Before(start) {
+			return errors.New("work_history entries cannot end before they start")
+		}
+	}
+	return nil
+}
+
+// sortedWorkHistory is the work history most recent first: current positions, then by start date
+func sortedWorkHistory(history []WorkExperience) []WorkExperience {
+	sorted := slices.Clone(history)
+	slices.SortStableFunc(sorted, func(a, b WorkExperience) int {
+		if (a.End == "") != (b.End == "") {
+			if a.End == "" {
+				return -1
+			}
+			return 1
+		}
+		aStart, _, _ := parseHistoryDate(a.Start)
+		bStart, _, _ := parseHistoryDate(b.Start)
+		return bStart.Compare(aStart)
+	})
+	return sorted
+}
+
+// positionHeading is the first line of a position: the title at the company
+func positionHeading(position WorkExperience) string {
+	switch {
+	case position.Title == "":
+		return position.Company
+	case position.Company == "":
+		return position.Title
+	}
+	return position.Title + ", " + position.Company
+}
+
+// drawWorkHistory draws the candidate's positions, sorted most recent first, in a column of the page from y,
+// continuing on new pages as needed, and returns the y below them
+func (p factsheetPage) drawWorkHistory(x, y, width float64) float64 {
+	if len(p.cand.WorkHistory) == 0 {
+		return y
+	}
+	pdf, text := p.pdf, p.text
+	y = p.sectionHeading(x, y, width, "WORK EXPERIENCE")
+	trailing := map[string]string{"L": "R", "R": "L"}
+	for _, position := range p.cand.WorkHistory {
+		heading, period := positionHeading(position), position.Period()
+		periodWidth := 45.0
+		headingLines := text.lines("B", 11, heading, width-periodWidth-2)
+		y = p.ensureRoom(y, float64(len(headingLines))*5.5+5)
+
+		// The period goes on the first line, at the far end from the heading
+		align := text.align(heading)
+		headingX := x
+		if align == "R" {
+			headingX = x + periodWidth + 2
+		}
+		text.font("B", 11, heading)
+		top := y
+		y = p.writeLines(headingX, y, width-periodWidth-2, 5.5, headingLines, align)
+		pdf.SetTextColor(100, 100, 100)
+		periodX := x + width - periodWidth
+		if align == "R" {
+			periodX = x
+		}
+		p.writeLines(periodX, top, periodWidth, 5.5, []string{text.font("I", 9, period)}, trailing[align])
+		pdf.SetTextColor(0, 0, 0)
+
+		if position.Description != "" {
+			for _, line := range text.lines("", 10, position.Description, width) {
+				// A new page draws its letterhead in another font
+				y = p.ensureRoom(y, 5)
+				text.font("", 10, position.Description)
+				y = p.writeLines(x, y, width, 5, []string{line}, text.align(position.Description))
+			}
+		}
+		y += 3
+	}
+	return y + 2
+}
+
+// sectionHeading draws the heading of a factsheet section in the tenant's color, underlined, keeping it
+// on the page with room for a line of the section below it. It returns the y below the heading.
+func (p factsheetPage) sectionHeading(x, y, width float64, heading string) float64 {
+	pdf, text := p.pdf, p.text
+	y = p.ensureRoom(y, 20)
+	textColor(pdf, p.branding.PrimaryColor, 60)
+	y = p.writeLines(x, y, width, 7, []string{text.font("B", 12, heading)}, text.align(heading))
+	pdf.SetTextColor(0, 0, 0)
+	if r, g, b, err := parseColor(p.branding.PrimaryColor); err == nil {
+		pdf.SetDrawColor(r, g, b)
+	}
+	pdf.SetLineWidth(0.4)
+	pdf.Line(x, y+0.5, x+width, y+0.5)
+	pdf.SetLineWidth(0.2)
+	pdf.SetDrawColor(0, 0, 0)
+	return y + 3
+}
+
+// ensureRoom returns y, or the top of a new page when height does not fit below it
+func (p factsheetPage) ensureRoom(y, height float64) float64 {
+	if _, _, limit, _ := p.frame(); y+height > limit {
+		p.pdf.AddPage()
+		return p.pdf.GetY()
+	}
+	return y
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Stages of a candidate's pipeline, reported with its failure
+const (
+	stageFactsheet   = "factsheet"
+	stageDownload    = "download"
+	stageFormat      = "format"
+	stageConversion  = "conversion"
+	stageAttachments = "attachments"
+	stageRedaction   = "redaction"
+	// stageAssembly puts the packet together: cover and separator pages, merging, stamps and optimization
+	stageAssembly = "assembly"
+)
+
+// CandidateFailure tells callers where a candidate failed and whether submitting it again may help
+type CandidateFailure struct {
+	Stage string `json:"stage"`
+	// Reason is a stable code for the failure, e.g. not_found for a resume URL answering 404
+	Reason string `json:"reason"`
+	// Retryable is set for failures that may pass on another try, e.g. a timeout or a 503 from the document store
+	Retryable bool `json:"retryable"`
+}
+
+// stageReasons are the reasons of failures no more is known about than the stage they happened in
+var stageReasons = map[string]string{
+	stageFactsheet:   "factsheet_failed",
+	stageDownload:    "download_failed",
+	stageFormat:      "unsupported_format",
+	stageConversion:  "conversion_failed",
+	stageAttachments: "attachment_failed",
+	stageRedaction:   "redaction_failed",
+	stageAssembly:    "assembly_failed",
+}
+
+// describeFailure classifies the error a candidate failed with at stage
+func describeFailure(stage string, err error) *CandidateFailure {
+	failure := &CandidateFailure{Stage: stage, Reason: stageReasons[stage]}
+	var status *httpStatusError
+	var retryable *retryableError
+	switch code := errorCode(err); {
+	case code != "":
+		failure.Reason = code
+		failure.Retryable = code == errorCodeConversionTimeout || code == errorCodeCandidateTimeout
+	case errors.Is(err, errDownloadBlocked):
+		failure.Reason = "url_not_allowed"
+	case errors.Is(err, errResumeTooLarge):
+		failure.Reason = "too_large"
+	case errors.As(err, &status):
+		switch status.status {
+		case http.StatusNotFound, http.StatusGone:
+			failure.Reason = "not_found"
+		case http.StatusUnauthorized, http.StatusForbidden:
+			failure.Reason = "access_denied"
+		default:
+			failure.Reason = "http_error"
+		}
+		if errors.As(err, &retryable) {
+			failure.Reason, failure.Retryable = "source_unavailable", true
+		}
+	case errors.As(err, &retryable):
+		failure.Reason, failure.Retryable = "network_error", true
+	}
+	return failure
+}
+
+// errFailFast is the cancel cause of jobs stopped by their fail-fast policy, they end as aborted
+var errFailFast = errors.New("job aborted")
+
+// FailFastPolicy stops a job whose candidates keep failing, e.g. because every resume link has expired, instead
+// of working through the whole batch. The candidates that finished are packaged as usual. 0 disables a trigger.
+type FailFastPolicy struct {
+	// MaxFailurePercent aborts the job once more than this share of its finished candidates failed,
+	// judged once MinCandidates have finished
+	MaxFailurePercent int `yaml:"max_failure_percent" env:"FAIL_FAST_MAX_FAILURE_PERCENT" json:"max_failure_percent,omitempty"`
+	MinCandidates     int `yaml:"min_candidates" env:"FAIL_FAST_MIN_CANDIDATES" json:"min_candidates,omitempty"`
+	// MaxConsecutiveFailures aborts the job once this many candidates failed one after another
+	MaxConsecutiveFailures int `yaml:"max_consecutive_failures" env:"FAIL_FAST_MAX_CONSECUTIVE_FAILURES" json:"max_consecutive_failures,omitempty"`
+}
+
+func (p FailFastPolicy) validate() error {
+	if p.MaxFailurePercent < 0 || p.MaxFailurePercent > 100 {
+		return errors.New("max_failure_percent must be between 0 and 100")
+	}
+	if p.MinCandidates < 0 || p.MaxConsecutiveFailures < 0 {
+		return errors.New("min_candidates and max_consecutive_failures cannot be negative")
+	}
+	return nil
+}
+
+// failFastPolicy is the policy of a job: the request's, with the configured min_candidates when it has none,
+// or the configured one
+func (req ProcessRequest) failFastPolicy() FailFastPolicy {
+	policy := appConfig.Processing.FailFast
+	if req.FailFast != nil {
+		minCandidates := policy.MinCandidates
+		policy = *req.FailFast
+		if policy.MinCandidates == 0 {
+			policy.MinCandidates = minCandidates
+		}
+	}
+	return policy
+}
+
+// failFastCause returns why the job has to stop as a candidate finishes, nil while it may go on. Callers must
+// hold j.mu.
+func (j *Job) failFastCause() error {
+	policy := j.failFast
+	if policy.MaxConsecutiveFailures > 0 && j.consecutiveFailures >= policy.MaxConsecutiveFailures {
+		return fmt.Errorf("%w after %d consecutive candidate failures", errFailFast, j.consecutiveFailures)
+	}
+	if policy.MaxFailurePercent == 0 {
+		return nil
+	}
+	finished, failed := 0, 0
+	for _, cand := range j.Candidates {
+		switch cand.Status {
+		case candidateStatusFailed, candidateStatusTimedOut:
+			failed++
+			finished++
+		case candidateStatusCompleted:
+			finished++
+		}
+	}
+	if finished >= max(policy.MinCandidates, 1) && failed*100 > policy.MaxFailurePercent*finished {
+		return fmt.Errorf("%w: %d of %d finished candidates failed, more than %d%%", errFailFast, failed, finished, policy.MaxFailurePercent)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxFilenameTemplateLength keeps the packets' names well below the limits of file systems
+const maxFilenameTemplateLength = 100
+
+// filenameToken matches the placeholders of a filename_template, e.g. {name}
+var filenameToken = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// filenameTokens are the placeholders a filename_template may use
+var filenameTokens = []string{"index", "candidate_id", "candidate_code", "name", "email", "group", "company", "tenant", "job_id"}
+
+// validateFilenameTemplate checks a filename_template, e.g. "{index}_{name}_{company}.pdf"
+func validateFilenameTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	if len(template) > maxFilenameTemplateLength {
+		return fmt.Errorf("filename_template cannot be longer than %d characters", maxFilenameTemplateLength)
+	}
+	for _, match := range filenameToken.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(filenameTokens, match[1]) {
+			return fmt.Errorf("unknown filename_template token {%s}, use one of {%s}", match[1], strings.Join(filenameTokens, "}, {"))
+		}
+	}
+	literal := filenameToken.ReplaceAllString(template, "")
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("filename_template has an unmatched brace")
+	}
+	if strings.ContainsAny(literal, `/\:*?"<>|`) {
+		return fmt.Errorf(`filename_template cannot contain any of / \ : * ? " < > |`)
+	}
+	if literal == template && strings.Trim(strings.TrimSuffix(strings.ToLower(literal), ".pdf"), "._- ") == "" {
+		return fmt.Errorf("filename_template needs a token or a name")
+	}
+	return nil
+}
+
+// packetFileNames are the names of the candidates' packets in the archive, in the order of the request.
+// Every instance of the service derives the same names from the same request.
+func packetFileNames(jobID string, req ProcessRequest) []string {
+	names, _ := namePackets(jobID, req)
+	return names
+}
+
+// namePackets names the candidates' packets and explains the names that had to change. Without a
+// filename_template packets are named after the candidate's key, otherwise the template's tokens are filled
+// in with the candidate's details made safe for file names. A name given twice, e.g. to candidates with the
+// same email, gets a suffix, _2, _3 and so on, in order, and so does a name of the archive's own files.
+// Packets of grouped candidates go in their group's folder, e.g. Engineering/jdoe_example.com_factsheet.pdf.
+func namePackets(jobID string, req ProcessRequest) ([]string, []string) {
+	names := make([]string, len(req.Candidates))
+	warnings := duplicateEmails(req.Candidates)
+	folders := groupFolders(req.Candidates)
+	// owners are the candidates the names taken so far belong to, -1 for the archive's own files
+	owners := map[string]int{indexPDFName: -1, combinedPDFName: -1}
+	width := len(strconv.Itoa(len(req.Candidates)))
+	for i, cand := range req.Candidates {
+		base := strings.TrimSuffix(factsheetFileName(cand), ".pdf")
+		if req.Blind {
+			base = sanitizeFilename(cand.CandidateCode) + "_factsheet"
+		}
+		if req.FilenameTemplate != "" {
+			base = fillFilenameTemplate(req.FilenameTemplate, jobID, req, i, width)
+		}
+		if folders[i] != "" {
+			base = folders[i] + "/" + base
+		}
+		name := base + ".pdf"
+		owner, clash := owners[strings.ToLower(name)]
+		for n := 2; clash; n++ {
+			name = fmt.Sprintf("%s_%d.pdf", base, n)
+			if _, taken := owners[strings.ToLower(name)]; !taken {
+				if owner < 0 {
+					warnings = append(warnings, fmt.Sprintf("candidate %d: %s.pdf is a file of the archive, the packet is named %s", i, base, name))
+				} else {
+					warnings = append(warnings, fmt.Sprintf("candidate %d: %s.pdf is the packet of candidate %d, the packet is named %s", i, base, owner, name))
+				}
+				break
+			}
+		}
+		owners[strings.ToLower(name)] = i
+		names[i] = name
+	}
+	return names, warnings
+}
+
+// fillFilenameTemplate is the name the filename_template gives the i-th candidate, without .pdf
+func fillFilenameTemplate(template, jobID string, req ProcessRequest, i, width int) string {
+	cand := req.Candidates[i]
+	values := map[string]string{
+		"index":          fmt.Sprintf("%0*d", width, i+1),
+		"candidate_id":   cand.CandidateID,
+		"candidate_code": cand.CandidateCode,
+		"name":           cand.Name,
+		"email":          cand.Email,
+		"group":          cand.Group,
+		"company":        req.CompanyName,
+		"tenant":         req.TenantName,
+		"job_id":         jobID,
+	}
+	if strings.HasSuffix(strings.ToLower(template), ".pdf") {
+		template = template[:len(template)-len(".pdf")]
+	}
+	base := filenameToken.ReplaceAllStringFunc(template, func(token string) string {
+		return sanitizeFilename(values[strings.Trim(token, "{}")])
+	})
+	if base = strings.TrimSpace(base); base == "" {
+		return values["index"]
+	}
+	return base
+}
+
+// duplicateEmails warns of candidates sharing an email, most likely the same person submitted twice
+func duplicateEmails(candidates []Candidate) []string {
+	var emails []string
+	indexes := map[string][]string{}
+	for i, cand := range candidates {
+		email := strings.ToLower(strings.TrimSpace(cand.Email))
+		if email == "" {
+			continue
+		}
+		if _, seen := indexes[email]; !seen {
+			emails = append(emails, email)
+		}
+		indexes[email] = append(indexes[email], strconv.Itoa(i))
+	}
+	var warnings []string
+	for _, email := range emails {
+		if same := indexes[email]; len(same) > 1 {
+			last := len(same) - 1
+			warnings = append(warnings, fmt.Sprintf("candidates %s and %s have the same email %s", strings.Join(same[:last], ", "), same[last], email))
+		}
+	}
+	return warnings
+}
+
+// maxGroupLength keeps group folders short enough for the packets' names to fit in a path
+const maxGroupLength = 100
+
+// validateGroup checks the group of a candidate, which becomes a folder of the archive
+func (cand Candidate) validateGroup() error {
+	if len(cand.Group) > maxGroupLength {
+		return fmt.Errorf("group cannot be longer than %d characters", maxGroupLength)
+	}
+	if cand.Group != "" && groupFolder(cand.Group) == "" {
+		return fmt.Errorf("group %q needs a letter or digit", cand.Group)
+	}
+	return nil
+}
+
+// groupFolder is the folder of the archive a group's packets go in. Dots become underscores too, so no
+// folder is named like a file at the root of the archive or refers to its parent.
+func groupFolder(group string) string {
+	return strings.Trim(sanitizeFilename(strings.ReplaceAll(strings.TrimSpace(group), ".", "_")), "_")
+}
+
+// groupFolders are the folders of the candidates' packets, empty for candidates without a group. Groups
+// differing in case only share the folder of the first of them, they are one folder on some file systems.
+func groupFolders(candidates []Candidate) []string {
+	folders := make([]string, len(candidates))
+	first := map[string]string{}
+	for i, cand := range candidates {
+		folder := groupFolder(cand.Group)
+		if folder == "" {
+			continue
+		}
+		if _, seen := first[strings.ToLower(folder)]; !seen {
+			first[strings.ToLower(folder)] = folder
+		}
+		folders[i] = first[strings.ToLower(folder)]
+	}
+	return folders
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/image/font/sfnt"
+)
+
+// coreFont is the font factsheets are drawn with when no font directory is configured. It only knows
+// the characters of Windows-1252.
+const coreFont = "Arial"
+
+// fontStyles are the variants looked up next to a font, e.g. DejaVuSans-Bold.ttf for DejaVuSans.ttf
+var fontStyles = map[string][]string{
+	"B": {"-Bold"},
+	"I": {"-Italic", "-Oblique"},
+}
+
+// factsheetFont is a TrueType family of the font directory
+type factsheetFont struct {
+	family string
+	// styles holds the font files by gofpdf style, "" being the regular one. Missing styles fall back to
+	// the regular file.
+	styles map[string][]byte
+	face   *sfnt.Font
+}
+
+// factsheetFonts are the fonts of the font directory, the configured font first. Text is drawn in the
+// first of them that has all of its characters.
+var factsheetFonts []*factsheetFont
+
+// setupFonts loads the TrueType fonts of the font directory, so factsheets can show names in any script
+func setupFonts() {
+	cfg := appConfig.Factsheet
+	if cfg.FontDir == "" {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(cfg.FontDir, "*.ttf"))
+	if err != nil || len(paths) == 0 {
+		logger.Warn().Msgf("No fonts found in %s, factsheets use %s", cfg.FontDir, coreFont)
+		return
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		family := strings.TrimSuffix(filepath.Base(path), ".ttf")
+		if isStyleVariant(family) {
+			continue
+		}
+		font, err := loadFont(cfg.FontDir, family)
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Skipping font %s", family)
+			continue
+		}
+		if family == cfg.Font {
+			factsheetFonts = slices.Insert(factsheetFonts, 0, font)
+		} else {
+			factsheetFonts = append(factsheetFonts, font)
+		}
+	}
+	if len(factsheetFonts) == 0 {
+		logger.Warn().Msgf("No usable fonts in %s, factsheets use %s", cfg.FontDir, coreFont)
+		return
+	}
+	if cfg.Font != "" && factsheetFonts[0].family != cfg.Font {
+		logger.Warn().Msgf("Font %s not found in %s, using %s", cfg.Font, cfg.FontDir, factsheetFonts[0].family)
+	}
+	families := make([]string, len(factsheetFonts))
+	for i, font := range factsheetFonts {
+		families[i] = font.family
+	}
+	logger.Info().Msgf("Factsheet fonts: %s", strings.Join(families, ", "))
+}
+
+func loadFont(dir, family string) (*factsheetFont, error) {
+	regular, err := os.ReadFile(filepath.Join(dir, family+".ttf"))
+	if err != nil {
+		return nil, err
+	}
+	face, err := sfnt.Parse(regular)
+	if err != nil {
+		return nil, fmt.Errorf("not a TrueType font: %w", err)
+	}
+	font := &factsheetFont{family: family, styles: map[string][]byte{"": regular}, face: face}
+	for style, suffixes := range fontStyles {
+		font.styles[style] = regular
+		for _, suffix := range suffixes {
+			if data, err := os.ReadFile(filepath.Join(dir, family+suffix+".ttf")); err == nil {
+				font.styles[style] = data
+				break
+			}
+		}
+	}
+	return font, nil
+}
+
+func isStyleVariant(name string) bool {
+	for _, suffixes := range fontStyles {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coverage counts the characters of text the font has a glyph for, spaces and controls aside
+func (f *factsheetFont) coverage(text string) (covered, total int) {
+	var buf sfnt.Buffer
+	for _, r := range text {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			continue
+		}
+		total++
+		if index, err := f.face.GlyphIndex(&buf, r); err == nil && index != 0 {
+			covered++
+		}
+	}
+	return covered, total
+}
+
+// pickFont returns the first font that has every character of text, or else the one missing the fewest
+func pickFont(text string) *factsheetFont {
+	best, bestCovered := factsheetFonts[0], -1
+	for _, font := range factsheetFonts {
+		covered, total := font.coverage(text)
+		if covered == total {
+			return font
+		}
+		if covered > bestCovered {
+			best, bestCovered = font, covered
+		}
+	}
+	return best
+}
+
+// factsheetText sets the font of each piece of text drawn on a factsheet and prepares the text for
+// drawing: Arabic is shaped and right-to-left text put in visual order. Fonts are embedded when they are
+// first used, so fallback fonts only add to the size of factsheets that need them.
+type factsheetText struct {
+	pdf        *gofpdf.Fpdf
+	direction  string
+	registered map[string]bool
+	// translate converts UTF-8 to the encoding of the core font when there are no TrueType fonts
+	translate func(string) string
+}
+
+func newFactsheetText(pdf *gofpdf.Fpdf, direction string) *factsheetText {
+	t := &factsheetText{pdf: pdf, direction: direction, registered: map[string]bool{}}
+	if len(factsheetFonts) == 0 {
+		t.translate = pdf.UnicodeTranslatorFromDescriptor("")
+	}
+	return t
+}
+
+// font selects a font for text in the given style and size and returns text as it is to be drawn
+func (t *factsheetText) font(style string, size float64, text string) string {
+	return t.lines(style, size, text, 0)[0]
+}
+
+// lines selects a font like font and breaks text into lines that fit width, all on one line for 0
+func (t *factsheetText) lines(style string, size float64, text string, width float64) []string {
+	if t.translate != nil {
+		t.pdf.SetFont(coreFont, style, size)
+		text = t.translate(text)
+		if width == 0 {
+			return []string{text}
+		}
+		return splitLines(t.pdf.SplitLines([]byte(text), width))
+	}
+
+	// gofpdf only measures characters of the Basic Multilingual Plane
+	text = strings.Map(func(r rune) rune {
+		if r > 0xFFFF {
+			return unicode.ReplacementChar
+		}
+		return r
+	}, shapeArabic(text))
+	font := pickFont(text)
+	if key := font.family + "\x00" + style; !t.registered[key] {
+		t.pdf.AddUTF8FontFromBytes(font.family, style, font.styles[style])
+		t.registered[key] = true
+	}
+	t.pdf.SetFont(font.family, style, size)
+
+	lines := []string{text}
+	if width > 0 {
+		lines = t.pdf.SplitText(text, width)
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	// Lines are broken in reading order and each is then arranged for drawing
+	for i, line := range lines {
+		lines[i] = visualOrder(line, t.direction)
+	}
+	return lines
+}
+
+// align is the alignment of text in a cell: right in mirrored layouts and for right-to-left text
+func (t *factsheetText) align(text string) string {
+	if t.direction == directionRTL || textDirection(text, directionLTR) == directionRTL {
+		return "R"
+	}
+	return "L"
+}
+
+func splitLines(raw [][]byte) []string {
+	lines := make([]string, len(raw))
+	for i, line := range raw {
+		lines[i] = string(line)
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	return lines
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// resumeFormat is the detected type of a resume file
+type resumeFormat struct {
+	MIME string
+	// Ext is the extension the file is converted under, LibreOffice picks its import filter by it
+	Ext string
+}
+
+func (f resumeFormat) isPDF() bool   { return f.MIME == "application/pdf" }
+func (f resumeFormat) isImage() bool { return strings.HasPrefix(f.MIME, "image/") }
+
+// imageArchive is a zip archive of images only, e.g. photos of each page of a CV, which becomes a
+// page per image
+var imageArchive = resumeFormat{"application/zip", ".zip"}
+
+// resumeFormats are the formats recognized from content types and file extensions
+var resumeFormats = []resumeFormat{
+	{"application/pdf", ".pdf"},
+	{"application/msword", ".doc"},
+	{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	{"application/vnd.oasis.opendocument.text", ".odt"},
+	{"application/rtf", ".rtf"},
+	{"text/rtf", ".rtf"},
+	{"text/plain", ".txt"},
+	{"text/html", ".html"},
+	{"text/markdown", ".md"},
+	{"application/vnd.ms-excel", ".xls"},
+	{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+	{"application/vnd.oasis.opendocument.spreadsheet", ".ods"},
+	{"application/vnd.ms-powerpoint", ".ppt"},
+	{"application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx"},
+	{"application/vnd.oasis.opendocument.presentation", ".odp"},
+	{"image/jpeg", ".jpg"},
+	{"image/png", ".png"},
+	{"image/tiff", ".tif"},
+	{"image/gif", ".gif"},
+	{"image/bmp", ".bmp"},
+	{"image/webp", ".webp"},
+}
+
+// formatAliases map further extensions to the formats above
+var formatAliases = map[string]string{".jpeg": ".jpg", ".tiff": ".tif", ".htm": ".html", ".text": ".txt", ".markdown": ".md"}
+
+func formatByMIME(mimeType string) (resumeFormat, bool) {
+	for _, f := range resumeFormats {
+		if f.MIME == mimeType {
+			return f, true
+		}
+	}
+	return resumeFormat{}, false
+}
+
+func formatByExt(ext string) (resumeFormat, bool) {
+	ext = strings.ToLower(ext)
+	if alias, ok := formatAliases[ext]; ok {
+		ext = alias
+	}
+	for _, f := range resumeFormats {
+		if f.Ext == ext {
+			return f, true
+		}
+	}
+	return resumeFormat{}, false
+}
+
+// detectResumeFormat identifies a resume by its content, then by the Content-Type and file name the
+// server sent, then by the extension of the URL path. Signed URLs with query strings and download
+// endpoints without extensions are recognized as long as the content or the server gives the type away.
+// A file claiming to be a PDF without being one, typically an HTML login or error page, is rejected.
+func detectResumeFormat(filePath, contentType, filename, rawURL string) (resumeFormat, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return resumeFormat{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return resumeFormat{}, err
+	}
+	head := make([]byte, sniffLength)
+	n, _ := io.ReadFull(f, head)
+	return sniffResumeFormat(head[:n], f, info.Size(), contentType, filename, rawURL)
+}
+
+// sniffLength is how much of a resume its format is detected from
+const sniffLength = 1024
+
+// sniffResumeFormat identifies a resume by its first bytes like detectResumeFormat, with the whole file in
+// content to look into zip archives. Without it, as in a dry run that only fetches the start of a resume, zip
+// based documents are told apart by the type they claim.
+func sniffResumeFormat(head []byte, content io.ReaderAt, size int64, contentType, filename, rawURL string) (resumeFormat, error) {
+	hinted := hintedFormat(contentType, filename, rawURL)
+	if len(head) == 0 {
+		return resumeFormat{}, errors.New("resume is empty")
+	}
+
+	switch {
+	// PDF readers accept the header anywhere in the first 1024 bytes
+	case bytes.Contains(head, []byte("%PDF-")):
+		return resumeFormat{"application/pdf", ".pdf"}, nil
+	case bytes.HasPrefix(head, []byte("{\\rtf")):
+		return resumeFormat{"application/rtf", ".rtf"}, nil
+	// Compound file: doc, xls and ppt share the container
+	case bytes.HasPrefix(head, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")):
+		if hinted.Ext == ".doc" || hinted.Ext == ".xls" || hinted.Ext == ".ppt" {
+			return hinted, nil
+		}
+		return resumeFormat{"application/msword", ".doc"}, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		if content == nil && zipBased(hinted) {
+			return hinted, nil
+		}
+		if content != nil {
+			if format, ok := zipFormat(content, size); ok {
+				return format, nil
+			}
+		}
+		return resumeFormat{}, errors.New("unsupported resume format: zip archive")
+	}
+
+	if format, ok := sniffImage(head); ok {
+		return format, nil
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if hinted.isPDF() || claimsPDF(filename, rawURL) {
+		return resumeFormat{}, fmt.Errorf("resume is not a valid PDF, received %s", sniffed)
+	}
+	if sniffed == "text/html" {
+		return resumeFormat{"text/html", ".html"}, nil
+	}
+	if hinted.MIME != "" {
+		return hinted, nil
+	}
+	if sniffed == "text/plain" {
+		return resumeFormat{"text/plain", ".txt"}, nil
+	}
+	return resumeFormat{}, fmt.Errorf("unsupported resume format: %s", sniffed)
+}
+
+// convertFormat converts a resume that is not a PDF: images are laid out on pages directly, HTML and
+// Markdown go through the HTML converter, everything else through LibreOffice
+func convertFormat(ctx context.Context, inputPath, outputDir string, format resumeFormat) (string, error) {
+	outputPath := filepath.Join(outputDir, "resume.pdf")
+	switch {
+	case format.isImage():
+		return outputPath, imagesToPDF([]string{inputPath}, outputPath)
+	case format == imageArchive:
+		pages, err := extractImages(inputPath, filepath.Join(outputDir, "pages"))
+		if err != nil {
+			return "", err
+		}
+		return outputPath, imagesToPDF(pages, outputPath)
+	case format.MIME == "text/html" || format.MIME == "text/markdown":
+		return webToPDF(ctx, inputPath, outputDir, format)
+	}
+	return convertToPDF(ctx, inputPath, outputDir)
+}
+
+// converterName is the tool convertFormat uses for a format, "none" for PDFs which need no conversion
+func converterName(format resumeFormat) string {
+	switch {
+	case format.isPDF():
+		return "none"
+	case format.isImage() || format == imageArchive:
+		return "images"
+	case format.MIME == "text/html" || format.MIME == "text/markdown":
+		return appConfig.Processing.HTMLConverter
+	}
+	return appConfig.Conversion.Backend
+}
+
+// sniffImage recognizes image content, including TIFF which http.DetectContentType does not know
+func sniffImage(head []byte) (resumeFormat, bool) {
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return formatByExt(".tif")
+	}
+	format, ok := formatByMIME(http.DetectContentType(head))
+	return format, ok && format.isImage()
+}
+
+// hintedFormat is the format the Content-Type, file name or URL claim, generic binary types are ignored
+func hintedFormat(contentType, filename, rawURL string) resumeFormat {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if format, ok := formatByMIME(strings.ToLower(mediaType)); ok {
+			return format
+		}
+	}
+	if format, ok := formatByExt(path.Ext(filename)); ok {
+		return format
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if format, ok := formatByExt(path.Ext(u.Path)); ok {
+			return format
+		}
+	}
+	return resumeFormat{}
+}
+
+// claimsPDF reports whether the file name or URL promise a PDF, even if the Content-Type says otherwise
+func claimsPDF(filename, rawURL string) bool {
+	if strings.EqualFold(path.Ext(filename), ".pdf") {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.EqualFold(path.Ext(u.Path), ".pdf")
+}
+
+// zipBased reports whether files of the format are zip archives
+func zipBased(format resumeFormat) bool {
+	switch format.Ext {
+	case ".docx", ".xlsx", ".pptx", ".odt", ".ods", ".odp":
+		return true
+	}
+	return false
+}
+
+// zipFormat tells Office Open XML and OpenDocument files apart from other zip archives
+func zipFormat(content io.ReaderAt, size int64) (resumeFormat, bool) {
+	r, err := zip.NewReader(content, size)
+	if err != nil {
+		return resumeFormat{}, false
+	}
+
+	if _, ok := imageArchiveEntries(r); ok {
+		return imageArchive, true
+	}
+	for _, file := range r.File {
+		switch {
+		case file.Name == "word/document.xml":
+			return formatByExt(".docx")
+		case strings.HasPrefix(file.Name, "xl/"):
+			return formatByExt(".xlsx")
+		case strings.HasPrefix(file.Name, "ppt/"):
+			return formatByExt(".pptx")
+		case file.Name == "mimetype":
+			// OpenDocument stores its type uncompressed in the first entry
+			rc, err := file.Open()
+			if err != nil {
+				return resumeFormat{}, false
+			}
+			data, _ := io.ReadAll(io.LimitReader(rc, 100))
+			rc.Close()
+			return formatByMIME(strings.TrimSpace(string(data)))
+		}
+	}
+	return resumeFormat{}, false
+}
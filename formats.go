@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Validation modes of a job request, deciding what happens to malformed emails, phone numbers and resume URLs
+const (
+	// validationStrict rejects the request
+	validationStrict = "strict"
+	// validationWarn accepts the request and lists the problems in the job's warnings
+	validationWarn = "warn"
+	// validationNormalize rewrites the values to their canonical form and warns about what cannot be fixed
+	validationNormalize = "normalize"
+)
+
+var (
+	countryCodePattern = regexp.MustCompile(`^\+?[1-9][0-9]{0,2}$`)
+	// phoneSeparators are written between the digits of phone numbers and dropped by normalization
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "", "\u00a0", "")
+)
+
+const (
+	// minPhoneDigits and maxPhoneDigits bound the digits of a phone number, E.164 allows at most 15
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// checkFormats checks the email, mobile_no and resume_url of the request's candidates in its validation mode.
+// Strict adds the problems to errs, the other modes return them as warnings; normalize rewrites the values.
+func checkFormats(req *ProcessRequest, errs *requestErrors) []string {
+	mode := req.ValidationMode
+	if mode == "" {
+		mode = validationWarn
+	}
+	if mode != validationStrict && mode != validationWarn && mode != validationNormalize {
+		errs.add("validation_mode", fmt.Sprintf("must be %s, %s or %s", validationStrict, validationWarn, validationNormalize))
+		return nil
+	}
+	if req.PhoneCountryCode != "" && !countryCodePattern.MatchString(req.PhoneCountryCode) {
+		errs.add("phone_country_code", "must be a calling code of 1 to 3 digits, e.g. 91")
+		return nil
+	}
+
+	fix := mode == validationNormalize
+	var warnings []string
+	for i := range req.Candidates {
+		cand := &req.Candidates[i]
+		fields := []struct {
+			name      string
+			value     *string
+			normalize func(value string, fix bool) (string, error)
+		}{
+			{"email", &cand.Email, normalizeEmail},
+			{"mobile_no", &cand.MobileNo, func(phone string, _ bool) (string, error) { return normalizePhone(phone, req.PhoneCountryCode) }},
+			{"resume_url", &cand.ResumeURL, normalizeResumeURL},
+		}
+		for _, field := range fields {
+			if *field.value == "" {
+				continue
+			}
+			normalized, err := field.normalize(*field.value, fix)
+			switch {
+			case err == nil && fix:
+				*field.value = normalized
+			case err == nil:
+			case mode == validationStrict:
+				errs.add(fmt.Sprintf("candidates[%d].%s", i, field.name), err.Error())
+			default:
+				warnings = append(warnings, fmt.Sprintf("candidate %d: %s %v", i, field.name, err))
+			}
+		}
+	}
+	return warnings
+}
+
+// normalizeEmail returns the address of an email with the domain in lower case. With fix, surrounding
+// whitespace, a mailto: prefix and a display name are removed, otherwise they make the email invalid.
+func normalizeEmail(email string, fix bool) (string, error) {
+	raw := email
+	if fix {
+		email = strings.TrimPrefix(strings.TrimSpace(email), "mailto:")
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", errors.New("is not a valid email address")
+	}
+	if !fix && address.Address != raw {
+		return "", errors.New("must be a bare address such as jane@example.com")
+	}
+	local, domain, _ := strings.Cut(address.Address, "@")
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") || strings.HasPrefix(domain, "[") {
+		return "", errors.New("needs a domain name such as example.com")
+	}
+	return local + "@" + strings.ToLower(domain), nil
+}
+
+// normalizePhone returns a phone number in E.164 format, e.g. +919876543210. Numbers written without a
+// country code, nor a "+" or "00" prefix, get countryCode, and lose a leading trunk 0.
+func normalizePhone(phone, countryCode string) (string, error) {
+	number := phoneSeparators.Replace(strings.TrimSpace(phone))
+	international := false
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number, international = number[1:], true
+	case strings.HasPrefix(number, "00"):
+		number, international = number[2:], true
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return "", errors.New("may only contain digits, spaces, a leading + and the separators - . ( ) /")
+		}
+	}
+
+	if !international {
+		if countryCode == "" {
+			return "", errors.New("has no country code, write it as +<country code><number> or set phone_country_code")
+		}
+		number = strings.TrimPrefix(countryCode, "+") + strings.TrimPrefix(number, "0")
+	}
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits || number[0] == '0' {
+		return "", fmt.Errorf("must have %d to %d digits including the country code", minPhoneDigits, maxPhoneDigits)
+	}
+	return "+" + number, nil
+}
+
+// normalizeResumeURL returns a resume URL with its scheme and host in lower case. With fix, surrounding
+// whitespace is removed and URLs written without a scheme, e.g. example.com/cv.pdf, get https.
+func normalizeResumeURL(raw string, fix bool) (string, error) {
+	if fix {
+		raw = strings.TrimSpace(raw)
+	}
+	if !strings.Contains(raw, "://") {
+		if !fix {
+			return "", errors.New("needs a scheme such as https://")
+		}
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("is not a valid URL")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "s3" {
+		return "", errors.New("must be an http, https or s3 URL")
+	}
+	if u.Host == "" {
+		return "", errors.New("needs a host")
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone       string
+		countryCode string
+		want        string
+	}{
+		{"+91 98765 43210", "", "+919876543210"},
+		{"0091 98765 43210", "", "+919876543210"},
+		{"0091-98765-43210", "44", "+919876543210"},
+		{"+1 (415) 555-0100", "", "+14155550100"},
+		{"098765 43210", "91", "+919876543210"},
+		{"98765.43210", "+91", "+919876543210"},
+		{" +49 30/1234567 ", "", "+49301234567"},
+		// Failures
+		{"+0 98765 43210", "", ""},
+		{"+0091 98765 43210", "", ""},
+		{"98765 43210", "", ""},
+		{"098765 43210", "", ""},
+		{"+91 98765 4321x", "", ""},
+		{"+91 123", "", ""},
+		{"+91 98765 43210 12345", "", ""},
+	}
+	for _, tt := range tests {
+		got, err := normalizePhone(tt.phone, tt.countryCode)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizePhone(%q, %q) = %q, want an error", tt.phone, tt.countryCode, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizePhone(%q, %q) = %q, %v, want %q", tt.phone, tt.countryCode, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		fix   bool
+		want  string
+	}{
+		{"jane@example.com", false, "jane@example.com"},
+		{"Jane.Doe@EXAMPLE.com", false, "Jane.Doe@example.com"},
+		{"Jane <jane@EXAMPLE.com>", true, "jane@example.com"},
+		{" mailto:jane@Example.COM ", true, "jane@example.com"},
+		{"jane@example.com", true, "jane@example.com"},
+		// Failures
+		{"Jane <jane@EXAMPLE.com>", false, ""},
+		{" jane@example.com", false, ""},
+		{"mailto:jane@example.com", false, ""},
+		{"jane", true, ""},
+		{"jane@localhost", true, ""},
+		{"jane@example.", true, ""},
+		{"jane@[192.0.2.1]", true, ""},
+	}
+	for _, tt := range tests {
+		got, err := normalizeEmail(tt.email, tt.fix)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizeEmail(%q, %v) = %q, want an error", tt.email, tt.fix, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeEmail(%q, %v) = %q, %v, want %q", tt.email, tt.fix, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeResumeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		fix  bool
+		want string
+	}{
+		{"https://example.com/cv.pdf", false, "https://example.com/cv.pdf"},
+		{"HTTPS://Example.COM/CV.pdf", false, "https://example.com/CV.pdf"},
+		{"s3://resumes/jane.pdf", false, "s3://resumes/jane.pdf"},
+		{" example.com/cv.pdf ", true, "https://example.com/cv.pdf"},
+		{"http://example.com/cv.pdf", true, "http://example.com/cv.pdf"},
+		// Failures
+		{"example.com/cv.pdf", false, ""},
+		{" https://example.com/cv.pdf", false, ""},
+		{"ftp://example.com/cv.pdf", true, ""},
+		{"file:///etc/passwd", true, ""},
+		{"https:///cv.pdf", true, ""},
+		{"https://exa mple.com/cv.pdf", true, ""},
+	}
+	for _, tt := range tests {
+		got, err := normalizeResumeURL(tt.url, tt.fix)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizeResumeURL(%q, %v) = %q, want an error", tt.url, tt.fix, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeResumeURL(%q, %v) = %q, %v, want %q", tt.url, tt.fix, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckFormats(t *testing.T) {
+	type values struct{ email, mobile, resumeURL string }
+	fixable := values{"Jane <jane@EXAMPLE.com>", "0091 98765 43210", "example.com/cv.pdf"}
+	clean := values{"jane@example.com", "+919876543210", "https://example.com/cv.pdf"}
+	tests := []struct {
+		name        string
+		mode        string
+		countryCode string
+		in          values
+		want        values
+		// errors are the fields strict mode rejects, warnings the number of warnings of the other modes
+		errors   []string
+		warnings int
+	}{
+		{
+			name:   "strict rejects what normalize would fix",
+			mode:   validationStrict,
+			in:     fixable,
+			want:   fixable,
+			errors: []string{"candidates[0].email", "candidates[0].resume_url"},
+		},
+		{
+			name:   "strict rejects a national number without a country code",
+			mode:   validationStrict,
+			in:     values{clean.email, "098765 43210", clean.resumeURL},
+			want:   values{clean.email, "098765 43210", clean.resumeURL},
+			errors: []string{"candidates[0].mobile_no"},
+		},
+		{
+			name: "strict accepts valid values as they are",
+			mode: validationStrict,
+			in:   values{clean.email, "0091 98765 43210", clean.resumeURL},
+			want: values{clean.email, "0091 98765 43210", clean.resumeURL},
+		},
+		{
+			name:     "warn keeps the values",
+			mode:     validationWarn,
+			in:       fixable,
+			want:     fixable,
+			warnings: 2,
+		},
+		{
+			name:     "warn is the default",
+			in:       values{"jane", "+0 98765 43210", "ftp://example.com/cv.pdf"},
+			want:     values{"jane", "+0 98765 43210", "ftp://example.com/cv.pdf"},
+			warnings: 3,
+		},
+		{
+			name: "normalize fixes every field",
+			mode: validationNormalize,
+			in:   fixable,
+			want: clean,
+		},
+		{
+			name:        "normalize adds the country code",
+			mode:        validationNormalize,
+			countryCode: "91",
+			in:          values{clean.email, "098765 43210", clean.resumeURL},
+			want:        clean,
+		},
+		{
+			name:     "normalize warns about what it cannot fix",
+			mode:     validationNormalize,
+			in:       values{"jane", "+0 98765 43210", "ftp://example.com/cv.pdf"},
+			want:     values{"jane", "+0 98765 43210", "ftp://example.com/cv.pdf"},
+			warnings: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ProcessRequest{
+				ValidationMode:   tt.mode,
+				PhoneCountryCode: tt.countryCode,
+				Candidates:       []Candidate{{Name: "Jane", Email: tt.in.email, MobileNo: tt.in.mobile, ResumeURL: tt.in.resumeURL}},
+			}
+			var errs requestErrors
+			warnings := checkFormats(req, &errs)
+
+			cand := req.Candidates[0]
+			if got := (values{cand.Email, cand.MobileNo, cand.ResumeURL}); got != tt.want {
+				t.Errorf("values = %+v, want %+v", got, tt.want)
+			}
+			var fields []string
+			for _, field := range errs.fields {
+				fields = append(fields, field.Field)
+			}
+			if !slices.Equal(fields, tt.errors) {
+				t.Errorf("errors on %v, want %v", fields, tt.errors)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestCheckFormatsInvalidSettings(t *testing.T) {
+	for _, req := range []ProcessRequest{
+		{ValidationMode: "lenient"},
+		{ValidationMode: validationNormalize, PhoneCountryCode: "0091"},
+		{ValidationMode: validationNormalize, PhoneCountryCode: "+1234"},
+	} {
+		var errs requestErrors
+		checkFormats(&req, &errs)
+		if errs.total != 1 {
+			t.Errorf("checkFormats(mode %q, country code %q) reported %d errors, want 1", req.ValidationMode, req.PhoneCountryCode, errs.total)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	healthHealthy  = "healthy"
+	healthDegraded = "degraded"
+
+	componentOK          = "ok"
+	componentFailed      = "failed"
+	componentUnavailable = "unavailable"
+
+	// healthCheckTimeout bounds each check, a hung tool must not hang the probe
+	healthCheckTimeout = 5 * time.Second
+	// toolCheckTTL is how long the tool checks are reused, starting LibreOffice for every probe is expensive
+	toolCheckTTL = time.Minute
+)
+
+// componentHealth is the result of one check of the deep health check. Failed required components degrade the
+// service, optional ones only turn off the features that need them.
+type componentHealth struct {
+	Status   string `json:"status"`
+	Required bool   `json:"required"`
+	Version  string `json:"version,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// toolCheck is an external program the service runs
+type toolCheck struct {
+	name string
+	// binary is found on the PATH, or by find when it has several names
+	binary string
+	find   func() (string, error)
+	// versionArgs print the version, the program is only looked up without them
+	versionArgs []string
+	required    bool
+}
+
+var toolHealth struct {
+	mu        sync.Mutex
+	results   map[string]componentHealth
+	checkedAt time.Time
+}
+
+func healthCheck(c *gin.Context) {
+	response := gin.H{
+		"status":    healthHealthy,
+		"service":   "ats-candidate-processor",
+		"version":   "1.0.0",
+		"timestamp": time.Now().Unix(),
+	}
+	deep, _ := strconv.ParseBool(c.Query("deep"))
+	if !deep {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	checks := deepHealthChecks(c.Request.Context())
+	status := http.StatusOK
+	for _, check := range checks {
+		if check.Required && check.Status != componentOK {
+			response["status"] = healthDegraded
+			status = http.StatusServiceUnavailable
+		}
+	}
+	response["checks"] = checks
+	c.JSON(status, response)
+}
+
+// deepHealthChecks checks the external tools of the configured converters, the scratch directory, free disk
+// space and the services the instance depends on
+func deepHealthChecks(ctx context.Context) map[string]componentHealth {
+	checks := cachedToolChecks(ctx)
+	checks["scratch_dir"] = checkScratchDir()
+	checks["disk_space"] = checkFreeDisk()
+	if gotenberg != nil {
+		checks["gotenberg"] = checkGotenberg(ctx)
+	}
+	if jobDB != nil {
+		checks["job_store"] = checkPing(ctx, jobDB.db.PingContext)
+	}
+	if taskQueue != nil {
+		checks["redis"] = checkPing(ctx, func(ctx context.Context) error { return taskQueue.client.Ping(ctx).Err() })
+	}
+	return checks
+}
+
+// healthTools lists the programs the configuration needs, and the optional ones
+func healthTools() []toolCheck {
+	backend := appConfig.Conversion.Backend
+	html := appConfig.Processing.HTMLConverter
+	return []toolCheck{
+		{name: "libreoffice", binary: "libreoffice", versionArgs: []string{"--version"},
+			required: backend == conversionLibreOffice || html == htmlConverterLibreOffice},
+		{name: "unoserver", binary: "unoserver", required: backend == conversionUnoserver},
+		{name: "unoconvert", binary: "unoconvert", required: backend == conversionUnoserver},
+		{name: "chromium", find: chromiumBinary, versionArgs: []string{"--version"}, required: html == htmlConverterChromium},
+		{name: "wkhtmltopdf", binary: "wkhtmltopdf", versionArgs: []string{"--version"}, required: html == htmlConverterWkhtmltopdf},
+		// Damaged and encrypted resumes, page numbers and compression need qpdf; without it resumes are merged as they are
+		{name: "qpdf", binary: "qpdf", versionArgs: []string{"--version"}},
+		{name: "ghostscript", binary: "gs", versionArgs: []string{"--version"}},
+		{name: "ocrmypdf", binary: "ocrmypdf", versionArgs: []string{"--version"}},
+		// Redaction renders pages with Ghostscript and finds what to black out with Tesseract
+		{name: "tesseract", binary: "tesseract", versionArgs: []string{"--version"}},
+	}
+}
+
+// cachedToolChecks returns the tool checks, running them again once they are older than toolCheckTTL
+func cachedToolChecks(ctx context.Context) map[string]componentHealth {
+	toolHealth.mu.Lock()
+	defer toolHealth.mu.Unlock()
+	if toolHealth.results == nil || time.Since(toolHealth.checkedAt) > toolCheckTTL {
+		results := map[string]componentHealth{}
+		for _, tool := range healthTools() {
+			results[tool.name] = checkTool(ctx, tool)
+		}
+		toolHealth.results, toolHealth.checkedAt = results, time.Now()
+	}
+
+	checks := make(map[string]componentHealth, len(toolHealth.results))
+	for name, result := range toolHealth.results {
+		checks[name] = result
+	}
+	return checks
+}
+
+// checkTool looks the program up and runs it to print its version
+func checkTool(ctx context.Context, tool toolCheck) componentHealth {
+	result := componentHealth{Required: tool.required}
+	find := tool.find
+	if find == nil {
+		find = func() (string, error) { return exec.LookPath(tool.binary) }
+	}
+	path, err := find()
+	if err != nil {
+		result.Status = componentUnavailable
+		if tool.required {
+			result.Status = componentFailed
+		}
+		result.Error = "not installed"
+		return result
+	}
+	result.Path = path
+	if tool.versionArgs == nil {
+		result.Status = componentOK
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, tool.versionArgs...)
+	killProcessGroupOnCancel(cmd)
+	var output bytes.Buffer
+	// Some programs print their version to stderr
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		result.Status = componentFailed
+		result.Error = fmt.Sprintf("%s does not run: %v", tool.name, err)
+		return result
+	}
+	result.Status = componentOK
+	result.Version, _, _ = strings.Cut(strings.TrimSpace(output.String()), "\n")
+	return result
+}
+
+// checkScratchDir makes sure files can be written where jobs keep their work
+func checkScratchDir() componentHealth {
+	dir := appConfig.Processing.ScratchDir
+	result := componentHealth{Required: true, Path: dir}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		result.Status, result.Error = componentFailed, fmt.Sprintf("failed to create scratch directory: %v", err)
+		return result
+	}
+	file, err := os.CreateTemp(dir, ".health-")
+	if err != nil {
+		result.Status, result.Error = componentFailed, err.Error()
+		return result
+	}
+	_, err = file.WriteString("ok")
+	file.Close()
+	os.Remove(file.Name())
+	if err != nil {
+		result.Status, result.Error = componentFailed, err.Error()
+		return result
+	}
+	result.Status = componentOK
+	return result
+}
+
+// checkFreeDisk compares the free space of the scratch volume with the minimum new jobs need
+func checkFreeDisk() componentHealth {
+	dir := appConfig.Processing.ScratchDir
+	result := componentHealth{Required: true, Path: dir}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		// Jobs are accepted when the platform cannot report free space, see checkDiskSpace
+		result.Status, result.Required, result.Error = componentUnavailable, false, err.Error()
+		return result
+	}
+	result.Detail = formatBytes(free) + " free"
+	if minFreeDisk > 0 {
+		result.Detail += ", " + formatBytes(minFreeDisk) + " required"
+	}
+	result.Status = componentOK
+	if minFreeDisk > 0 && free < minFreeDisk {
+		result.Status = componentFailed
+		result.Error = "new jobs are turned away until space is freed"
+	}
+	return result
+}
+
+// checkGotenberg asks Gotenberg for its own health
+func checkGotenberg(ctx context.Context) componentHealth {
+	backend := appConfig.Conversion.Backend
+	result := componentHealth{
+		Required: backend == conversionGotenberg || appConfig.Processing.HTMLConverter == htmlConverterGotenberg,
+		Path:     gotenberg.url,
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := gotenberg.health(ctx); err != nil {
+		result.Status, result.Error = componentFailed, err.Error()
+		return result
+	}
+	result.Status = componentOK
+	return result
+}
+
+// checkPing checks a connection the instance needs
+func checkPing(ctx context.Context, ping func(ctx context.Context) error) componentHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := ping(ctx); err != nil {
+		return componentHealth{Status: componentFailed, Required: true, Error: err.Error()}
+	}
+	return componentHealth{Status: componentOK, Required: true}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HTML converters selectable with processing.html_converter
+const (
+	htmlConverterChromium    = "chromium"
+	htmlConverterWkhtmltopdf = "wkhtmltopdf"
+	htmlConverterLibreOffice = "libreoffice"
+	htmlConverterGotenberg   = "gotenberg"
+)
+
+// chromiumBinaries are the names headless Chromium is installed under, tried in order
+var chromiumBinaries = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable"}
+
+// markdownStyle gives rendered Markdown resumes a plain document look
+const markdownStyle = `@page { size: A4; margin: 18mm; }
+body { font-family: "Helvetica Neue", Arial, sans-serif; font-size: 10.5pt; line-height: 1.45; color: #222; }
+h1, h2, h3 { line-height: 1.2; margin: 1.1em 0 0.4em; }
+h1 { font-size: 20pt; } h2 { font-size: 14pt; border-bottom: 1px solid #ccc; } h3 { font-size: 12pt; }
+pre, code { font-family: Menlo, Consolas, monospace; font-size: 9pt; }
+pre { background: #f5f5f5; padding: 6px; white-space: pre-wrap; }
+blockquote { margin-left: 0; padding-left: 10px; border-left: 3px solid #ccc; color: #555; }
+a { color: #1a5fb4; }`
+
+// webToPDF converts HTML and Markdown resumes with the configured HTML converter. Markdown is rendered
+// to HTML first.
+func webToPDF(ctx context.Context, inputPath, outputDir string, format resumeFormat) (string, error) {
+	if format.MIME == "text/markdown" {
+		source, err := os.ReadFile(inputPath)
+		if err != nil {
+			return "", err
+		}
+		page := fmt.Sprintf("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><style>%s</style></head><body>\n%s</body></html>\n",
+			markdownStyle, markdownToHTML(string(source)))
+		inputPath = strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".html"
+		if err := os.WriteFile(inputPath, []byte(page), 0600); err != nil {
+			return "", err
+		}
+	}
+	return htmlToPDF(ctx, inputPath, outputDir)
+}
+
+// htmlToPDF prints an HTML page to PDF in outputDir with the configured HTML converter
+func htmlToPDF(ctx context.Context, inputPath, outputDir string) (string, error) {
+	converter := appConfig.Processing.HTMLConverter
+	if converter == htmlConverterLibreOffice {
+		return convertToPDF(ctx, inputPath, outputDir)
+	}
+
+	ctx, done, err := startConversion(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	outputPath := pdfOutputPath(inputPath, outputDir)
+	if converter == htmlConverterGotenberg {
+		logFrom(ctx).Debug().Msgf("Converting file to PDF with gotenberg: %s", inputPath)
+		if err := gotenberg.convertHTML(ctx, inputPath, outputPath); err != nil {
+			return "", timeoutError(ctx, "conversion", err)
+		}
+		logFrom(ctx).Debug().Msgf("File converted to PDF: %s", outputPath)
+		return outputPath, nil
+	}
+
+	var cmd *exec.Cmd
+	switch converter {
+	case htmlConverterWkhtmltopdf:
+		// The proxy points nowhere, resumes must not make the server fetch anything
+		cmd = exec.CommandContext(ctx, "wkhtmltopdf", "--quiet", "--disable-javascript", "--disable-local-file-access",
+			"--proxy", "127.0.0.1:9", "--page-size", "A4", inputPath, outputPath)
+	default:
+		binary, err := chromiumBinary()
+		if err != nil {
+			return "", err
+		}
+		absInput, err := filepath.Abs(inputPath)
+		if err != nil {
+			return "", err
+		}
+		profileDir, err := os.MkdirTemp(outputDir, "chromium-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(profileDir)
+
+		// Scripts are off and every request goes to a proxy that refuses it, so a resume cannot make
+		// the server fetch internal URLs
+		args := []string{"--headless", "--disable-gpu", "--no-first-run", "--disable-extensions",
+			"--blink-settings=scriptEnabled=false", "--proxy-server=127.0.0.1:9", "--proxy-bypass-list=<-loopback>",
+			"--user-data-dir=" + profileDir, "--no-pdf-header-footer", "--print-to-pdf=" + outputPath}
+		// Chromium refuses its sandbox as root, as in most containers
+		if os.Geteuid() == 0 {
+			args = append(args, "--no-sandbox")
+		}
+		fileURL := url.URL{Scheme: "file", Path: filepath.ToSlash(absInput)}
+		cmd = exec.CommandContext(ctx, binary, append(args, fileURL.String())...)
+	}
+
+	logFrom(ctx).Debug().Msgf("Converting file to PDF with %s: %s", converter, inputPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", timeoutError(ctx, "conversion", fmt.Errorf("%s: %v: %s", converter, err, strings.TrimSpace(stderr.String())))
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		return "", fmt.Errorf("%s produced no PDF: %s", converter, strings.TrimSpace(stderr.String()))
+	}
+	logFrom(ctx).Debug().Msgf("File converted to PDF: %s", outputPath)
+	return outputPath, nil
+}
+
+func chromiumBinary() (string, error) {
+	for _, name := range chromiumBinaries {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("chromium is not installed, install it or set processing.html_converter")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// requestHash fingerprints a request so a reused idempotency key with a different body can be rejected
+func requestHash(req ProcessRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayJob answers a retried request with the job created by the original one
+func replayJob(c *gin.Context, original *Job, hash string) {
+	if original.RequestHash != "" && original.RequestHash != hash {
+		apiError(http.StatusUnprocessableEntity, errCodeIdempotencyMismatch, "Idempotency-Key was already used with a different request").
+			detail("job_id", original.ID).abort(c)
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	summary := original.summary()
+	code, response := http.StatusOK, summary
+	if status := summary["status"]; status == jobStatusQueued || status == jobStatusProcessing {
+		code, response = http.StatusAccepted, gin.H{
+			"job_id":     original.ID,
+			"status":     status,
+			"status_url": apiBasePath + "/jobs/" + original.ID,
+		}
+	}
+	// The retry may be the first answer the caller gets, so it carries the generated password too
+	if original.ArchivePassword != "" {
+		response["archive_password"] = original.ArchivePassword
+	}
+	c.JSON(code, response)
+}
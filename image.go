@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// imagePageMargin is the margin around an image on its page, in mm
+	imagePageMargin = 10
+	// maxImagePixels guards against images that decompress to more memory than a scan could need
+	maxImagePixels = 60_000_000
+	// maxImagePages limits the images taken from an archive
+	maxImagePages = 50
+)
+
+// imagesToPDF lays out images one per A4 page, scaled to fit within the margins and centred. Each page
+// is portrait or landscape to match its image, and JPEG photos are turned upright by their EXIF
+// orientation, so phone photos of a CV come out readable.
+func imagesToPDF(imagePaths []string, outputPath string) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	for i, imagePath := range imagePaths {
+		data, imageType, width, height, err := loadPageImage(imagePath)
+		if err != nil {
+			return fmt.Errorf("image %s: %w", filepath.Base(imagePath), err)
+		}
+
+		orientation := "P"
+		if width > height {
+			orientation = "L"
+		}
+		pdf.AddPageFormat(orientation, pdf.GetPageSizeStr("A4"))
+		pageWidth, pageHeight := pdf.GetPageSize()
+
+		scale := min((pageWidth-2*imagePageMargin)/float64(width), (pageHeight-2*imagePageMargin)/float64(height))
+		w, h := float64(width)*scale, float64(height)*scale
+
+		name := fmt.Sprintf("page%d", i)
+		opts := gofpdf.ImageOptions{ImageType: imageType}
+		pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(data))
+		pdf.ImageOptions(name, (pageWidth-w)/2, (pageHeight-h)/2, w, h, false, opts, 0, "")
+	}
+	return pdf.OutputFileAndClose(outputPath)
+}
+
+// loadPageImage reads an image file and prepares it with prepareImage
+func loadPageImage(imagePath string) (data []byte, imageType string, width, height int, err error) {
+	data, err = os.ReadFile(imagePath)
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+	return prepareImage(data)
+}
+
+// prepareImage returns an image in a form gofpdf embeds: upright JPEGs are passed through untouched,
+// everything else is decoded, flattened onto white and encoded as PNG
+func prepareImage(data []byte) (_ []byte, imageType string, width, height int, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", 0, 0, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, "", 0, 0, fmt.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+		if orientation == 1 {
+			return data, "JPG", cfg.Width, cfg.Height, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", 0, 0, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	upright := orientImage(img, orientation)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, upright); err != nil {
+		return nil, "", 0, 0, err
+	}
+	bounds := upright.Bounds()
+	return buf.Bytes(), "PNG", bounds.Dx(), bounds.Dy(), nil
+}
+
+// orientImage applies an EXIF orientation (1-8) and flattens transparency onto white
+func orientImage(src image.Image, orientation int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 && orientation <= 8 {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	if orientation < 2 || orientation > 8 {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+		return dst
+	}
+
+	srcW, srcH := b.Dx(), b.Dy()
+	oriented := image.NewRGBA(dst.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = srcW-1-x, y
+			case 3:
+				sx, sy = srcW-1-x, srcH-1-y
+			case 4:
+				sx, sy = x, srcH-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, srcH-1-x
+			case 7:
+				sx, sy = srcW-1-y, srcH-1-x
+			case 8:
+				sx, sy = srcW-1-y, x
+			}
+			oriented.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	draw.Draw(dst, dst.Bounds(), oriented, image.Point{}, draw.Over)
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag of a JPEG, 1 (upright) when there is none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		// Start of scan: the metadata segments are all before it
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation finds tag 0x0112 in IFD0 of a TIFF structured EXIF block
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// isImageName reports whether a file name has the extension of a supported image format
+func isImageName(name string) bool {
+	format, ok := formatByExt(path.Ext(name))
+	return ok && format.isImage()
+}
+
+// imageArchiveEntries returns the images of a zip archive in name order, or false when the archive
+// holds anything else. Folders and macOS metadata are ignored.
+func imageArchiveEntries(r *zip.Reader) ([]*zip.File, bool) {
+	var images []*zip.File
+	for _, file := range r.File {
+		base := path.Base(file.Name)
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+		if !isImageName(base) {
+			return nil, false
+		}
+		images = append(images, file)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+	return images, len(images) > 0
+}
+
+// extractImages unpacks the images of an archive into dir, in page order
+func extractImages(archivePath, dir string) ([]string, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries, ok := imageArchiveEntries(&r.Reader)
+	if !ok {
+		return nil, errors.New("archive does not contain only images")
+	}
+	if len(entries) > maxImagePages {
+		return nil, fmt.Errorf("archive has %d images, at most %d pages are supported", len(entries), maxImagePages)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	maxSize := int64(appConfig.Download.MaxSize)
+	var paths []string
+	for i, entry := range entries {
+		if maxSize > 0 && entry.UncompressedSize64 > uint64(maxSize) {
+			return nil, fmt.Errorf("%w: image %s", errResumeTooLarge, entry.Name)
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		pagePath := filepath.Join(dir, fmt.Sprintf("page%03d%s", i+1, strings.ToLower(path.Ext(entry.Name))))
+		out, err := os.Create(pagePath)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		var src io.Reader = rc
+		if maxSize > 0 {
+			src = io.LimitReader(rc, maxSize)
+		}
+		_, err = io.Copy(out, src)
+		rc.Close()
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, pagePath)
+	}
+	return paths, nil
+}
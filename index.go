@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// indexPDFName is the file at the root of the archive listing what it holds
+const indexPDFName = "index.pdf"
+
+// indexColumn is a column of the index table, its width in mm
+type indexColumn struct {
+	title string
+	width float64
+}
+
+var indexColumns = []indexColumn{
+	{"#", 10},
+	{"Name", 45},
+	{"Email", 55},
+	{"Skills", 70},
+	{"File", 55},
+	{"Status", 42},
+}
+
+// buildIndexPDF writes the index of the archive to factsheetDir: a row for every candidate of the job, in
+// the order they were submitted, with their skills, the file of their packet and how processing went.
+// combinedPages has the first page of each candidate in the combined PDF, by position in the request.
+func buildIndexPDF(job *Job, req ProcessRequest, factsheetDir string, combinedPages map[int]int) error {
+	job.mu.Lock()
+	progress, successCount := append([]CandidateProgress(nil), job.Candidates...), job.SuccessCount
+	job.mu.Unlock()
+
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	batchInfo(jobInfo{job.ID, req.TenantName, req.CompanyName}, "Candidate Index").apply(pdf)
+	text := newFactsheetText(pdf, req.direction())
+	pdf.SetAutoPageBreak(false, 0)
+	pageWidth, pageHeight := pdf.GetPageSize()
+	left, _, right, bottom := pdf.GetMargins()
+	const lineHeight, headerFill = 5.0, 220
+
+	// Right-to-left layouts mirror the table, the numbers go on the right
+	columns := indexColumns
+	if req.direction() == directionRTL {
+		columns = make([]indexColumn, len(indexColumns))
+		for i, column := range indexColumns {
+			columns[len(columns)-1-i] = column
+		}
+	}
+	header := map[string]string{}
+	for _, column := range columns {
+		header[column.title] = column.title
+	}
+
+	// drawRow writes the cells of a row side by side, every cell as high as the longest one. A row that
+	// does not fit starts a new page under a repeated header row.
+	var drawRow func(cells map[string]string, fill int)
+	drawRow = func(cells map[string]string, fill int) {
+		style := ""
+		if fill == headerFill {
+			style = "B"
+		}
+		height := lineHeight
+		for _, column := range columns {
+			lines := text.lines(style, 9, cells[column.title], column.width-2)
+			height = max(height, float64(len(lines))*lineHeight)
+		}
+		height += 2
+		if pdf.GetY()+height > pageHeight-bottom && fill != headerFill {
+			pdf.AddPage()
+			drawRow(header, headerFill)
+		}
+		top := pdf.GetY()
+		x := left
+		pdf.SetFillColor(fill, fill, fill)
+		for _, column := range columns {
+			pdf.SetXY(x, top)
+			pdf.CellFormat(column.width, height, "", "1", 0, "", true, 0, "")
+			// Every cell is written in a font that has its script
+			for n, line := range text.lines(style, 9, cells[column.title], column.width-2) {
+				pdf.SetXY(x+1, top+1+float64(n)*lineHeight)
+				pdf.CellFormat(column.width-2, lineHeight, line, "", 0, text.align(cells[column.title]), false, 0, "")
+			}
+			x += column.width
+		}
+		pdf.SetXY(left, top+height)
+	}
+
+	pdf.AddPage()
+	heading := "CANDIDATE INDEX"
+	if company := strings.TrimSpace(req.CompanyName); company != "" {
+		heading += " - " + company
+	}
+	pdf.CellFormat(pageWidth-left-right, 12, text.font("B", 16, heading), "", 1, "C", false, 0, "")
+	summary := fmt.Sprintf("%d candidates, %d processed. Generated on: %s", len(req.Candidates), successCount, req.formatTime(time.Now()))
+	pdf.SetTextColor(128, 128, 128)
+	pdf.CellFormat(pageWidth-left-right, 6, text.font("I", 9, summary), "", 1, "C", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(4)
+	drawRow(header, headerFill)
+
+	fileNames := packetFileNames(job.ID, req)
+	for i, cand := range req.shownCandidates() {
+		status, file := candidateStatusPending, ""
+		if i < len(progress) {
+			status = progress[i].Status
+			if progress[i].Error != "" {
+				status += ": " + progress[i].Error
+			}
+		}
+		// Candidates whose resume failed can still have left a factsheet of their own
+		if _, err := os.Stat(filepath.Join(factsheetDir, fileNames[i])); err == nil {
+			file = fileNames[i]
+			if page, ok := combinedPages[i]; ok {
+				file += fmt.Sprintf(", page %d of %s", page, combinedPDFName)
+			}
+		}
+		drawRow(map[string]string{
+			"#":      fmt.Sprintf("%d", i+1),
+			"Name":   cand.Name,
+			"Email":  cand.Email,
+			"Skills": strings.Join(cand.Skills, ", "),
+			"File":   file,
+			"Status": status,
+		}, 255-i%2*10)
+	}
+
+	return pdf.OutputFileAndClose(filepath.Join(factsheetDir, indexPDFName))
+}
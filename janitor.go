@@ -0,0 +1,228 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archiveRetention is how long local archives are kept when the tenant has no override, 0 keeps them forever
+var archiveRetention time.Duration
+
+// expiredJobsBatch is how many expired job records are deleted from the job store per tenant and sweep
+const expiredJobsBatch = 500
+
+// setupJanitor starts the background cleanup of expired archives and job records
+func setupJanitor() {
+	archiveRetention = appConfig.Storage.ArchiveRetention
+	interval := appConfig.Storage.ArchiveCleanupInterval
+	if interval <= 0 {
+		logger.Info().Msg("Archive cleanup disabled")
+		return
+	}
+
+	go func() {
+		for {
+			cleanupExpiredArchives()
+			cleanupExpiredJobs()
+			time.Sleep(interval)
+		}
+	}()
+	logger.Info().Msgf("Archive cleanup enabled (default retention %s, job retention %s, every %s)",
+		archiveRetention, appConfig.Storage.JobRetention, interval)
+}
+
+// cleanupExpiredArchives deletes the local archives whose retention has passed. Every instance only
+// sweeps its own disk; archives of unknown jobs, e.g. from before a restart without a job store,
+// expire after the default retention counted from the file's modification time.
+func cleanupExpiredArchives() {
+	paths, err := filepath.Glob(filepath.Join(appConfig.Storage.ArchiveDir, "*_factsheets_*"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Error listing archives")
+		return
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, path := range paths {
+		if trimArchiveExtension(path) == path {
+			continue
+		}
+		job, known := jobs.get(archiveJobID(path))
+		if known && !job.hasArchive(path) {
+			known = false
+		}
+
+		var expiresAt time.Time
+		if known {
+			expiresAt = job.archiveExpiry()
+		} else if archiveRetention > 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			expiresAt = info.ModTime().Add(archiveRetention)
+		}
+		if expiresAt.IsZero() || now.Before(expiresAt) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			logger.Error().Err(err).Msgf("Error removing expired archive %s", path)
+			continue
+		}
+		if known {
+			job.archiveDeleted()
+			audit.record(AuditEntry{Tenant: job.TenantName, Action: auditArchiveDeleted, JobID: job.ID}, gin.H{
+				"file":       filepath.Base(path),
+				"expired_at": expiresAt,
+			})
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		logger.Info().Msgf("Removed %d expired archives", removed)
+	}
+}
+
+// archiveJobID extracts the job ID from an archive named <tenant>_<company>_factsheets_<id>.zip, or .tar
+// or .tar.gz, and from parts of split archives named <tenant>_<company>_factsheets_<id>.part<n>.zip
+func archiveJobID(path string) string {
+	name := trimArchiveExtension(filepath.Base(path))
+	if i := strings.LastIndex(name, ".part"); i >= 0 {
+		name = name[:i]
+	}
+	return name[strings.LastIndex(name, "_factsheets_")+len("_factsheets_"):]
+}
+
+// retentionFor returns how long archives of the tenant are kept, 0 means forever
+func retentionFor(tenant string) time.Duration {
+	if retention, ok := tenants.archiveRetention(tenant); ok {
+		return retention
+	}
+	return archiveRetention
+}
+
+// jobRetentionFor returns how long the records of the tenant's jobs are kept once finished, 0 means forever
+func jobRetentionFor(tenant string) time.Duration {
+	if retention, ok := tenants.jobRetention(tenant); ok {
+		return retention
+	}
+	return appConfig.Storage.JobRetention
+}
+
+// cleanupExpiredJobs deletes the jobs that finished longer ago than their tenant's job retention, with their
+// local archives. Jobs in the job store are deleted from it by whichever instance gets to them first.
+func cleanupExpiredJobs() {
+	now := time.Now()
+	removed := 0
+	if jobDB != nil {
+		removed = cleanupStoredJobs(now)
+	}
+
+	jobs.mu.RLock()
+	var expired []*Job
+	for _, job := range jobs.jobs {
+		retention := jobRetentionFor(job.TenantName)
+		job.mu.Lock()
+		finished := !job.CompletedAt.IsZero() && job.Status != jobStatusQueued && job.Status != jobStatusProcessing
+		if retention > 0 && finished && now.Sub(job.CompletedAt) > retention {
+			expired = append(expired, job)
+		}
+		job.mu.Unlock()
+	}
+	jobs.mu.RUnlock()
+	for _, job := range expired {
+		jobs.untrack(job)
+		// Stored jobs were deleted above, or are left to the instance that deletes them
+		if jobDB == nil {
+			deleteExpiredJob(job, jobRetentionFor(job.TenantName))
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		logger.Info().Msgf("Removed %d expired jobs", removed)
+	}
+}
+
+// cleanupStoredJobs deletes the expired jobs of the job store, each tenant with a retention override of its
+// own on its own and every other tenant with the default retention
+func cleanupStoredJobs(now time.Time) int {
+	retentions := tenants.jobRetentions()
+	overridden := make([]string, 0, len(retentions))
+	for tenant := range retentions {
+		overridden = append(overridden, tenant)
+	}
+	slices.Sort(overridden)
+
+	removed := 0
+	sweep := func(tenant string, retention time.Duration) {
+		if retention <= 0 {
+			return
+		}
+		var exclude []string
+		if tenant == "" {
+			exclude = overridden
+		}
+		expired, err := jobDB.expiredJobs(tenant, exclude, now.Add(-retention), expiredJobsBatch)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error listing expired jobs")
+			return
+		}
+		for _, finished := range expired {
+			job, ok := jobs.get(finished.id)
+			if !ok {
+				continue
+			}
+			if err := jobDB.deleteJob(finished.id); err != nil {
+				logger.Error().Err(err).Msgf("Error deleting expired job %s", finished.id)
+				continue
+			}
+			jobs.untrack(job)
+			deleteExpiredJob(job, retention)
+			removed++
+		}
+	}
+	for _, tenant := range overridden {
+		sweep(tenant, retentions[tenant])
+	}
+	sweep("", appConfig.Storage.JobRetention)
+	return removed
+}
+
+// deleteExpiredJob removes the local archive of a job whose record was deleted and audits the deletion
+func deleteExpiredJob(job *Job, retention time.Duration) {
+	job.mu.Lock()
+	paths := []string{job.ZipPath}
+	for _, part := range job.Parts {
+		paths = append(paths, part.Path)
+	}
+	completedAt := job.CompletedAt
+	job.mu.Unlock()
+
+	var files []string
+	for _, path := range paths {
+		if path == "" || slices.Contains(files, filepath.Base(path)) {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			files = append(files, filepath.Base(path))
+		} else if !os.IsNotExist(err) {
+			logger.Error().Err(err).Msgf("Error removing archive %s of expired job %s", path, job.ID)
+		}
+	}
+	details := gin.H{
+		"completed_at": completedAt,
+		"retention":    retention.String(),
+	}
+	if len(files) > 0 {
+		details["archives"] = files
+	}
+	audit.record(AuditEntry{Tenant: job.TenantName, Action: auditJobDeleted, JobID: job.ID}, details)
+}
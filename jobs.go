@@ -0,0 +1,768 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Job statuses reported by the job status endpoint
+const (
+	jobStatusQueued                = "queued"
+	jobStatusProcessing            = "processing"
+	jobStatusCompletedSuccessfully = "completed_successfully"
+	jobStatusCompletedWithErrors   = "completed_with_errors"
+	jobStatusFailed                = "failed"
+	jobStatusCancelled             = "cancelled"
+	// jobStatusAborted is the status of jobs stopped by their fail-fast policy
+	jobStatusAborted = "aborted"
+)
+
+// errJobCancelled is the cancel cause of jobs cancelled through the API
+var errJobCancelled = errors.New("job cancelled")
+
+// Candidate statuses reported as per-candidate progress
+const (
+	candidateStatusPending    = "pending"
+	candidateStatusProcessing = "processing"
+	candidateStatusCompleted  = "completed"
+	candidateStatusFailed     = "failed"
+	candidateStatusCancelled  = "cancelled"
+	candidateStatusTimedOut   = "timed_out"
+)
+
+// CandidateProgress tracks the processing state of a single candidate within a job
+type CandidateProgress struct {
+	CandidateID string `json:"candidate_id,omitempty"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	// ErrorCode classifies the error when it is a known kind of failure, e.g. resume_corrupt
+	ErrorCode string `json:"error_code,omitempty"`
+	// DownloadAttempts counts the requests made for the resume, including retries
+	DownloadAttempts int `json:"download_attempts,omitempty"`
+	// Format is the detected MIME type of the resume and Converter the tool that made it a PDF
+	Format    string `json:"format,omitempty"`
+	Converter string `json:"converter,omitempty"`
+	// File is the packet of a completed candidate in the archive, e.g. Engineering/jdoe_example.com_factsheet.pdf
+	File string `json:"file,omitempty"`
+	// Failure is where and why a failed candidate failed, and whether submitting it again may help
+	Failure     *CandidateFailure `json:"failure,omitempty"`
+	StartedAt   *time.Time        `json:"started_at,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+// Job holds the state of a batch of candidates being processed
+type Job struct {
+	mu sync.Mutex
+	// saveMu serializes writes to the job store so an older snapshot never overwrites a newer one
+	saveMu sync.Mutex
+
+	ID           string
+	TenantName   string
+	CompanyName  string
+	Status       string
+	Candidates   []CandidateProgress
+	SuccessCount int
+	Errors       []string
+	ZipPath      string
+	ZipFileName  string
+	Delivery     *DeliveryResult
+	CreatedAt    time.Time
+	StartedAt    time.Time
+	CompletedAt  time.Time
+	// Warnings are problems with the request that did not stop the job, e.g. candidates sharing an email
+	Warnings []string
+	// Parts are the archives the job's files are split into with split_archive_mb, ZipPath is the first
+	Parts []ArchivePart
+	// IdempotencyKey and RequestHash identify retries of the request that created the job
+	IdempotencyKey string
+	RequestHash    string
+	// ArchivePassword is the archive password the service generated, returned again to retries of the
+	// request. It is only persisted sealed with the encryption at rest key.
+	ArchivePassword string
+	// RequestID is the id of the request that created the job, its logs carry it. It is not persisted.
+	RequestID string
+	// BaseURL is where the caller reached this service through a trusted proxy, the base of the archive
+	// links in emails and notifications when server.public_url is not set. It is not persisted.
+	BaseURL string
+	// ArchiveExpiresAt is when the janitor deletes the local archive, zero keeps it forever
+	ArchiveExpiresAt time.Time
+	ArchiveDeletedAt time.Time
+	// DiskUsage is the largest size of the job's working directory seen while it ran
+	DiskUsage int64
+
+	// ctx is cancelled to stop the job, it is only set on the instance running the job
+	ctx        context.Context
+	cancelFunc context.CancelCauseFunc
+	// stopped marks candidates cancelled on their own, stops interrupts those being processed
+	stopped map[int]bool
+	stops   map[int]context.CancelFunc
+	// updated is closed at the job's next change, waking up its event streams
+	updated chan struct{}
+	// failFast stops the job when its candidates keep failing, consecutiveFailures counts the latest ones
+	failFast            FailFastPolicy
+	consecutiveFailures int
+}
+
+// jobStore keeps track of all jobs known to this process
+type jobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	// keys maps tenant and idempotency key to the job created for them
+	keys map[string]string
+	// keyMu serializes idempotent submissions so concurrent retries create a single job
+	keyMu sync.Mutex
+}
+
+var jobs = &jobStore{jobs: make(map[string]*Job), keys: make(map[string]string)}
+
+func (s *jobStore) add(job *Job) {
+	s.track(job)
+
+	if jobDB != nil {
+		if err := jobDB.createJob(job); err != nil {
+			job.log().Error().Err(err).Msgf("Error persisting job %s", job.ID)
+		}
+	}
+}
+
+// addIdempotent adds the job unless its idempotency key was already used by the tenant,
+// in which case the original job is returned instead
+func (s *jobStore) addIdempotent(job *Job) *Job {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if original, ok := s.findByIdempotencyKey(job.TenantName, job.IdempotencyKey); ok {
+		return original
+	}
+
+	s.track(job)
+	if jobDB != nil {
+		if err := jobDB.createJob(job); err != nil {
+			// Another instance may have created a job for the same key in the meantime
+			if isUniqueViolation(err) {
+				if original, ok := s.findByIdempotencyKey(job.TenantName, job.IdempotencyKey); ok {
+					s.untrack(job)
+					return original
+				}
+			}
+			job.log().Error().Err(err).Msgf("Error persisting job %s", job.ID)
+		}
+	}
+	return nil
+}
+
+// findByIdempotencyKey returns the job the tenant created with the idempotency key
+func (s *jobStore) findByIdempotencyKey(tenant, key string) (*Job, bool) {
+	s.mu.RLock()
+	id, ok := s.keys[idempotencyScope(tenant, key)]
+	s.mu.RUnlock()
+	if ok {
+		return s.get(id)
+	}
+	if jobDB == nil {
+		return nil, false
+	}
+
+	id, err := jobDB.findJobByIdempotencyKey(tenant, key)
+	if err != nil {
+		if !errors.Is(err, errJobNotFound) {
+			logger.Error().Err(err).Msgf("Error looking up idempotency key for tenant %s", tenant)
+		}
+		return nil, false
+	}
+	return s.get(id)
+}
+
+// track registers a job in memory without persisting it
+func (s *jobStore) track(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	if job.IdempotencyKey != "" {
+		s.keys[idempotencyScope(job.TenantName, job.IdempotencyKey)] = job.ID
+	}
+}
+
+func (s *jobStore) untrack(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, job.ID)
+	if job.IdempotencyKey != "" {
+		delete(s.keys, idempotencyScope(job.TenantName, job.IdempotencyKey))
+	}
+}
+
+// idempotencyScope keys are per tenant, so tenants cannot see each other's jobs by guessing keys
+func idempotencyScope(tenant, key string) string {
+	return tenant + "\x00" + key
+}
+
+// get returns a job from memory, falling back to the job store for jobs from earlier runs
+func (s *jobStore) get(id string) (*Job, bool) {
+	s.mu.RLock()
+	job, ok := s.jobs[id]
+	s.mu.RUnlock()
+	if ok || jobDB == nil {
+		return job, ok
+	}
+
+	job, err := jobDB.loadJob(id)
+	if err != nil {
+		if !errors.Is(err, errJobNotFound) {
+			logger.Error().Err(err).Msgf("Error loading job %s", id)
+		}
+		return nil, false
+	}
+	return job, true
+}
+
+// JobFilter selects jobs for the job listing, zero values match everything
+type JobFilter struct {
+	Tenant   string
+	Statuses []string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+func (f JobFilter) matches(job *Job) bool {
+	if f.Tenant != "" && job.TenantName != f.Tenant {
+		return false
+	}
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, job.Status) {
+		return false
+	}
+	if !f.From.IsZero() && job.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !job.CreatedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// JobListItem is the condensed view of a job returned by the job listing
+type JobListItem struct {
+	JobID                 string          `json:"job_id"`
+	TenantName            string          `json:"tenant_name"`
+	CompanyName           string          `json:"company_name"`
+	Status                string          `json:"status"`
+	TotalCandidates       int             `json:"total_candidates"`
+	ProcessedSuccessfully int             `json:"processed_successfully"`
+	ErrorsCount           int             `json:"errors_count"`
+	CreatedAt             time.Time       `json:"created_at"`
+	StartedAt             *time.Time      `json:"started_at,omitempty"`
+	CompletedAt           *time.Time      `json:"completed_at,omitempty"`
+	DurationSeconds       *float64        `json:"duration_seconds,omitempty"`
+	ZipFilePath           string          `json:"zip_file_path,omitempty"`
+	ZipFileName           string          `json:"zip_file_name,omitempty"`
+	ArchiveExpiresAt      *time.Time      `json:"archive_expires_at,omitempty"`
+	ArchiveDeletedAt      *time.Time      `json:"archive_deleted_at,omitempty"`
+	DownloadURL           string          `json:"download_url,omitempty"`
+	Delivery              *DeliveryResult `json:"delivery,omitempty"`
+}
+
+// list returns one page of the jobs matching the filter, newest first, and the total number of matches
+func (s *jobStore) list(filter JobFilter) ([]JobListItem, int, error) {
+	if jobDB != nil {
+		return jobDB.listJobs(filter)
+	}
+
+	s.mu.RLock()
+	matched := []*Job{}
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		ok := filter.matches(job)
+		job.mu.Unlock()
+		if ok {
+			matched = append(matched, job)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].CreatedAt.After(matched[j].CreatedAt) })
+	total := len(matched)
+	start := min(filter.Offset, total)
+	end := min(start+filter.Limit, total)
+
+	items := make([]JobListItem, 0, end-start)
+	for _, job := range matched[start:end] {
+		items = append(items, job.listItem())
+	}
+	return items, total, nil
+}
+
+// countByStatus counts the jobs this process knows about by status, for /debug/vars
+func (s *jobStore) countByStatus() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := map[string]int{}
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		counts[job.Status]++
+		job.mu.Unlock()
+	}
+	return counts
+}
+
+// listItem returns the condensed view of the job
+func (j *Job) listItem() JobListItem {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return newJobListItem(j, len(j.Candidates))
+}
+
+// newJobListItem builds a list item from a job whose candidates may not be loaded, callers must hold j.mu
+// when the job is shared
+func newJobListItem(j *Job, totalCandidates int) JobListItem {
+	item := JobListItem{
+		JobID:                 j.ID,
+		TenantName:            j.TenantName,
+		CompanyName:           j.CompanyName,
+		Status:                j.Status,
+		TotalCandidates:       totalCandidates,
+		ProcessedSuccessfully: j.SuccessCount,
+		ErrorsCount:           len(j.Errors),
+		CreatedAt:             j.CreatedAt,
+		ZipFilePath:           j.ZipPath,
+		ZipFileName:           j.ZipFileName,
+		Delivery:              j.Delivery,
+	}
+	if !j.StartedAt.IsZero() {
+		startedAt := j.StartedAt
+		item.StartedAt = &startedAt
+		if !j.CompletedAt.IsZero() {
+			completedAt := j.CompletedAt
+			duration := completedAt.Sub(startedAt).Seconds()
+			item.CompletedAt = &completedAt
+			item.DurationSeconds = &duration
+		}
+	}
+	if !j.ArchiveExpiresAt.IsZero() {
+		expiresAt := j.ArchiveExpiresAt
+		item.ArchiveExpiresAt = &expiresAt
+	}
+	if !j.ArchiveDeletedAt.IsZero() {
+		deletedAt := j.ArchiveDeletedAt
+		item.ArchiveDeletedAt = &deletedAt
+		item.ZipFilePath = ""
+	} else if j.ZipPath != "" || (j.Delivery != nil && j.Delivery.URL != "") {
+		item.DownloadURL = apiBasePath + "/jobs/" + j.ID + "/download"
+	}
+	return item
+}
+
+func newJob(req ProcessRequest) *Job {
+	job := &Job{
+		ID:          uuid.New().String(),
+		TenantName:  req.TenantName,
+		CompanyName: req.CompanyName,
+		Status:      jobStatusQueued,
+		Candidates:  make([]CandidateProgress, len(req.Candidates)),
+		Errors:      []string{},
+		CreatedAt:   time.Now(),
+	}
+	_, job.Warnings = namePackets(job.ID, req)
+	for i, cand := range req.Candidates {
+		job.Candidates[i] = CandidateProgress{
+			CandidateID: cand.CandidateID,
+			Name:        cand.Name,
+			Email:       cand.Email,
+			Status:      candidateStatusPending,
+		}
+	}
+	job.failFast = req.failFastPolicy()
+	job.initCancel()
+	return job
+}
+
+// initCancel makes the job cancellable, for jobs created or resumed by this instance
+func (j *Job) initCancel() {
+	j.ctx, j.cancelFunc = context.WithCancelCause(context.Background())
+}
+
+// context returns the context that is cancelled when the job is cancelled
+func (j *Job) context() context.Context {
+	if j.ctx == nil {
+		return context.Background()
+	}
+	return j.ctx
+}
+
+func (j *Job) isCancelled() bool {
+	return j.ctx != nil && j.ctx.Err() != nil
+}
+
+// cancel stops the job's remaining candidates. It returns false when the job is not running on this instance.
+func (j *Job) cancel() bool {
+	return j.abort(errJobCancelled)
+}
+
+// abort stops the job's remaining candidates because of err, the job then fails with that error
+// unless it was cancelled through the API
+func (j *Job) abort(err error) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancelFunc == nil || isFinalJobStatus(j.Status) {
+		return false
+	}
+	j.cancelFunc(err)
+	return true
+}
+
+// stopCandidate cancels a single candidate of a job running on this instance: a pending candidate is skipped,
+// one being processed is interrupted. It returns false when the job is not running on this instance.
+func (j *Job) stopCandidate(index int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancelFunc == nil || isFinalJobStatus(j.Status) {
+		return false
+	}
+	if j.stopped == nil {
+		j.stopped = make(map[int]bool)
+	}
+	j.stopped[index] = true
+	if stop := j.stops[index]; stop != nil {
+		stop()
+	}
+	return true
+}
+
+func (j *Job) candidateStopped(index int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stopped[index]
+}
+
+// candidateContext returns the context a candidate is processed with, cancelled with the job or by
+// stopCandidate, and the function to call once the candidate is done
+func (j *Job) candidateContext(index int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(j.log().WithContext(j.context()))
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stopped[index] {
+		cancel()
+		return ctx, cancel
+	}
+	if j.stops == nil {
+		j.stops = make(map[int]context.CancelFunc)
+	}
+	j.stops[index] = cancel
+	return ctx, func() {
+		j.mu.Lock()
+		delete(j.stops, index)
+		j.mu.Unlock()
+		cancel()
+	}
+}
+
+func (j *Job) recordDiskUsage(usage int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.DiskUsage = max(j.DiskUsage, usage)
+}
+
+func isFinalJobStatus(status string) bool {
+	return status != jobStatusQueued && status != jobStatusProcessing
+}
+
+func isFinalCandidateStatus(status string) bool {
+	return status == candidateStatusCompleted || status == candidateStatusFailed || status == candidateStatusCancelled ||
+		status == candidateStatusTimedOut
+}
+
+// persist writes the job row to the job store, if one is configured, and wakes up the job's event streams
+func (j *Job) persist() {
+	j.notify()
+	if jobDB == nil {
+		return
+	}
+	j.saveMu.Lock()
+	defer j.saveMu.Unlock()
+	if err := jobDB.saveJob(jobDB.db, j); err != nil {
+		j.log().Error().Err(err).Msgf("Error persisting job %s", j.ID)
+	}
+}
+
+// persistCandidate writes a single candidate's state to the job store, if one is configured, and wakes up
+// the job's event streams
+func (j *Job) persistCandidate(index int) {
+	j.notify()
+	if jobDB == nil {
+		return
+	}
+	j.saveMu.Lock()
+	defer j.saveMu.Unlock()
+	j.mu.Lock()
+	cand := j.Candidates[index]
+	j.mu.Unlock()
+	if err := jobDB.saveCandidate(jobDB.db, j.ID, index, cand); err != nil {
+		j.log().Error().Err(err).Msgf("Error persisting candidate %d of job %s", index, j.ID)
+	}
+}
+
+// updates returns a channel that is closed when the job changes next
+func (j *Job) updates() <-chan struct{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.updated == nil {
+		j.updated = make(chan struct{})
+	}
+	return j.updated
+}
+
+func (j *Job) notify() {
+	j.mu.Lock()
+	if j.updated != nil {
+		close(j.updated)
+		j.updated = nil
+	}
+	j.mu.Unlock()
+}
+
+func (j *Job) start() {
+	j.mu.Lock()
+	j.Status = jobStatusProcessing
+	j.StartedAt = time.Now()
+	j.mu.Unlock()
+	j.persist()
+}
+
+func (j *Job) startCandidate(index int) {
+	j.mu.Lock()
+	now := time.Now()
+	j.Candidates[index].Status = candidateStatusProcessing
+	j.Candidates[index].StartedAt = &now
+	j.mu.Unlock()
+	j.persistCandidate(index)
+}
+
+func (j *Job) finishCandidate(index int, result candidateResult, err error) {
+	j.mu.Lock()
+	now := time.Now()
+	j.Candidates[index].CompletedAt = &now
+	j.Candidates[index].DownloadAttempts = result.DownloadAttempts
+	j.Candidates[index].Format, j.Candidates[index].Converter = result.Format, result.Converter
+	if err != nil && (j.isCancelled() || j.stopped[index]) {
+		// Errors caused by killing the download or conversion are not candidate failures
+		j.Candidates[index].Status = candidateStatusCancelled
+	} else if err != nil {
+		j.consecutiveFailures++
+		j.Candidates[index].Status = candidateStatusFailed
+		if errorCode(err) == errorCodeCandidateTimeout {
+			j.Candidates[index].Status = candidateStatusTimedOut
+		}
+		j.Candidates[index].Error = err.Error()
+		j.Candidates[index].ErrorCode = errorCode(err)
+		j.Candidates[index].Failure = result.Failure
+		j.Errors = append(j.Errors, fmt.Sprintf("%s: %v", j.Candidates[index].key(), err))
+	} else {
+		j.Candidates[index].Status = candidateStatusCompleted
+		j.Candidates[index].File = result.File
+		j.SuccessCount++
+		j.consecutiveFailures = 0
+	}
+	var abort error
+	if j.Candidates[index].Status != candidateStatusCancelled && j.ctx != nil && j.ctx.Err() == nil {
+		abort = j.failFastCause()
+	}
+	j.mu.Unlock()
+	j.persistCandidate(index)
+	j.persist()
+	if abort != nil {
+		j.log().Warn().Msgf("Aborting job %s: %v", j.ID, abort)
+		abortJob(j, abort)
+	}
+}
+
+// cancelCandidate marks a candidate that was skipped because the job was cancelled
+func (j *Job) cancelCandidate(index int) {
+	j.mu.Lock()
+	now := time.Now()
+	j.Candidates[index].Status = candidateStatusCancelled
+	j.Candidates[index].CompletedAt = &now
+	j.mu.Unlock()
+	j.persistCandidate(index)
+}
+
+// setArchive records the location of the job's zip file, or the parts it was split into, and when it expires
+func (j *Job) setArchive(zipPath, zipFileName string, parts []ArchivePart) {
+	retention := retentionFor(j.TenantName)
+	j.mu.Lock()
+	j.ZipPath = zipPath
+	j.ZipFileName = zipFileName
+	j.Parts = parts
+	if retention > 0 {
+		j.ArchiveExpiresAt = time.Now().Add(retention)
+	}
+	j.mu.Unlock()
+	j.persist()
+}
+
+// hasArchive reports whether the file at path is the job's archive or one of its parts
+func (j *Job) hasArchive(path string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.ZipPath == path {
+		return true
+	}
+	return slices.ContainsFunc(j.Parts, func(part ArchivePart) bool { return part.Path == path })
+}
+
+func (j *Job) archiveExpiry() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.ArchiveExpiresAt
+}
+
+// archiveDeleted records that the janitor removed the job's archive
+func (j *Job) archiveDeleted() {
+	j.mu.Lock()
+	j.ArchiveDeletedAt = time.Now()
+	j.mu.Unlock()
+	j.persist()
+}
+
+func (j *Job) complete() {
+	j.mu.Lock()
+	j.finish()
+	j.mu.Unlock()
+	j.persist()
+}
+
+// completeDelivered marks the job as done once its archive lives in remote storage
+func (j *Job) completeDelivered(delivery *DeliveryResult, parts []ArchivePart) {
+	j.mu.Lock()
+	j.Delivery = delivery
+	j.Parts = parts
+	j.ZipPath = ""
+	j.ArchiveExpiresAt = time.Time{}
+	j.finish()
+	j.mu.Unlock()
+	j.persist()
+}
+
+// completeEmailed marks the job as done once its archive is emailed, the archive stays available for download
+func (j *Job) completeEmailed(delivery *DeliveryResult) {
+	j.mu.Lock()
+	j.Delivery = delivery
+	j.finish()
+	j.mu.Unlock()
+	j.persist()
+}
+
+// finish sets the final job status, callers must hold j.mu
+func (j *Job) finish() {
+	j.CompletedAt = time.Now()
+	if cause := context.Cause(j.context()); cause != nil && !errors.Is(cause, errJobCancelled) {
+		j.Errors = append(j.Errors, cause.Error())
+		j.Status = jobStatusFailed
+		if errors.Is(cause, errFailFast) {
+			j.Status = jobStatusAborted
+		}
+	} else if j.isCancelled() {
+		j.Status = jobStatusCancelled
+	} else if len(j.Errors) > 0 {
+		j.Status = jobStatusCompletedWithErrors
+	} else {
+		j.Status = jobStatusCompletedSuccessfully
+	}
+}
+
+// warn adds a problem that does not stop the job to its warnings
+func (j *Job) warn(warning string) {
+	j.mu.Lock()
+	j.Warnings = append(j.Warnings, warning)
+	j.mu.Unlock()
+}
+
+func (j *Job) fail(err error) {
+	j.mu.Lock()
+	j.Errors = append(j.Errors, err.Error())
+	j.CompletedAt = time.Now()
+	j.Status = jobStatusFailed
+	j.mu.Unlock()
+	j.persist()
+}
+
+// summary returns a point-in-time view of the job suitable for API responses
+func (j *Job) summary() gin.H {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	candidates := make([]CandidateProgress, len(j.Candidates))
+	copy(candidates, j.Candidates)
+
+	response := gin.H{
+		"job_id":                 j.ID,
+		"tenant_name":            j.TenantName,
+		"company_name":           j.CompanyName,
+		"status":                 j.Status,
+		"total_candidates":       len(j.Candidates),
+		"processed_successfully": j.SuccessCount,
+		"errors_count":           len(j.Errors),
+		"candidates":             candidates,
+		"created_at":             j.CreatedAt,
+	}
+	if !j.StartedAt.IsZero() {
+		response["started_at"] = j.StartedAt
+	}
+	if !j.CompletedAt.IsZero() {
+		response["completed_at"] = j.CompletedAt
+	}
+	if j.ZipPath != "" && j.ArchiveDeletedAt.IsZero() {
+		response["zip_file_path"] = j.ZipPath
+		response["download_url"] = apiBasePath + "/jobs/" + j.ID + "/download"
+	}
+	if !j.ArchiveExpiresAt.IsZero() {
+		response["archive_expires_at"] = j.ArchiveExpiresAt
+	}
+	if !j.ArchiveDeletedAt.IsZero() {
+		response["archive_deleted_at"] = j.ArchiveDeletedAt
+	}
+	if j.ZipFileName != "" {
+		response["zip_file_name"] = j.ZipFileName
+	}
+	if j.Delivery != nil {
+		delivery := *j.Delivery
+		response["delivery"] = delivery
+		if delivery.URL != "" {
+			response["download_url"] = apiBasePath + "/jobs/" + j.ID + "/download"
+		}
+	}
+	if j.DiskUsage > 0 {
+		response["disk_usage_bytes"] = j.DiskUsage
+	}
+	if len(j.Errors) > 0 {
+		response["errors"] = append([]string(nil), j.Errors...)
+	}
+	if len(j.Warnings) > 0 {
+		response["warnings"] = append([]string(nil), j.Warnings...)
+	}
+	if len(j.Parts) > 0 && j.ArchiveDeletedAt.IsZero() {
+		parts := []gin.H{}
+		for _, part := range j.Parts {
+			parts = append(parts, gin.H{
+				"part":         part.Part,
+				"file_name":    part.FileName,
+				"size":         part.Size,
+				"files":        part.Files,
+				"download_url": fmt.Sprintf("%s/jobs/%s/download?part=%d", apiBasePath, j.ID, part.Part),
+			})
+		}
+		response["archive_parts"] = parts
+	}
+	return response
+}
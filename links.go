@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxLinks keeps the links of a candidate to a few lines
+	maxLinks = 10
+	// maxLinkLength is the longest link accepted, in bytes
+	maxLinkLength = 2000
+)
+
+// candidateLink is a labelled link of a candidate, e.g. LinkedIn
+type candidateLink struct {
+	label string
+	url   string
+}
+
+// sortedLinks are the candidate's links in the order of their labels, which is how they are printed
+func (cand Candidate) sortedLinks() []candidateLink {
+	var links []candidateLink
+	for _, label := range slices.Sorted(maps.Keys(cand.Links)) {
+		links = append(links, candidateLink{label, cand.Links[label]})
+	}
+	return links
+}
+
+// validateLinks checks the candidate's links, which become clickable in the PDF
+func (cand Candidate) validateLinks() error {
+	if len(cand.Links) > maxLinks {
+		return fmt.Errorf("at most %d links are allowed", maxLinks)
+	}
+	for label, link := range cand.Links {
+		if strings.TrimSpace(label) == "" {
+			return errors.New("links need a label")
+		}
+		if len(link) > maxLinkLength {
+			return fmt.Errorf("link %q is too long", label)
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("link %q must be an http or https URL", label)
+		}
+	}
+	return nil
+}
+
+// displayURL is a link as it is printed, without the scheme and trailing slash, e.g. github.com/jdoe
+func displayURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	u.Scheme = ""
+	return strings.TrimSuffix(strings.TrimPrefix(u.String(), "//"), "/")
+}
+
+// linkBlue is the color of clickable links on a light background
+var linkBlue = [3]int{5, 99, 193}
+
+// drawLink writes text on a line at x, y in the color given, underlined and linking to target. The line is
+// cut short to fit width. It returns the width of the text.
+func (p factsheetPage) drawLink(x, y, width, lineHeight, size float64, text, target string, color [3]int) float64 {
+	pdf := p.pdf
+	line := clipLines(p.text.lines("", size, text, width), 1)[0]
+	textWidth := min(pdf.GetStringWidth(line), width)
+	align := p.text.align(text)
+	textX := x
+	if align == "R" {
+		textX = x + width - textWidth
+	}
+	pdf.SetTextColor(color[0], color[1], color[2])
+	pdf.SetXY(x, y)
+	pdf.CellFormat(width, lineHeight, line, "", 0, align, false, 0, "")
+	pdf.SetDrawColor(color[0], color[1], color[2])
+	// Cells keep a margin on either side of their text
+	margin := pdf.GetCellMargin()
+	if align == "R" {
+		margin = -margin
+	}
+	pdf.Line(textX+margin, y+lineHeight/2+size*0.18, textX+margin+textWidth, y+lineHeight/2+size*0.18)
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.LinkString(textX+margin, y, textWidth, lineHeight, target)
+	return textWidth
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+const (
+	// requestIDHeader carries the id of a request, set by the caller or a proxy, or by this service otherwise
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	// maxRequestIDLength bounds ids taken from callers, longer ones are replaced
+	maxRequestIDLength = 128
+)
+
+// logger is the service's log. Work done for a request, job or candidate logs through loggers derived from
+// it that carry their ids: requestLog, (*Job).log and logFrom.
+var logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+func setupLogging() {
+	cfg := appConfig.Server
+	// Create logs directory if it doesn't exist
+	logDir := cfg.LogDir
+	if _, err := os.Stat(logDir); os.IsNotExist(err) {
+		// Try to create in /var/log, if permission denied, use local logs directory
+		if err := os.MkdirAll(logDir, 0700); err != nil {
+			logger.Warn().Err(err).Msgf("Cannot create log directory %s, using ./logs instead", logDir)
+			logDir = "./logs"
+			os.MkdirAll(logDir, 0700)
+		}
+	}
+
+	// Write to both the file and stdout, or stdout only when the file cannot be opened
+	var out io.Writer = os.Stdout
+	logFile, err := openRotatingFile(logDir, cfg)
+	logPath := ""
+	if err != nil {
+		logger.Warn().Err(err).Msgf("Failed to open log file in %s, logging to stdout only", logDir)
+	} else {
+		out = io.MultiWriter(os.Stdout, logFile)
+		logPath = logFile.path()
+	}
+	if cfg.LogFormat == logFormatText {
+		out = zerolog.ConsoleWriter{Out: out, NoColor: true, TimeFormat: time.DateTime}
+	}
+
+	zerolog.CallerMarshalFunc = func(_ uintptr, file string, line int) string {
+		return filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	level, _ := zerolog.ParseLevel(cfg.LogLevel)
+	base := zerolog.New(out).Level(level).With().Timestamp().Str("instance", instanceID)
+	logger = base.Caller().Logger()
+	zerolog.DefaultContextLogger = &logger
+
+	// Libraries and net/http log through the standard logger
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{base.Logger()})
+
+	logger.Info().Str("log_file", logPath).Msg("Logging initialized")
+}
+
+// stdLogWriter turns lines of the standard logger into log events
+type stdLogWriter struct {
+	logger zerolog.Logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.Info().Msg(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// logFrom returns the logger carried by ctx, or the service's logger when it carries none
+func logFrom(ctx context.Context) *zerolog.Logger {
+	return zerolog.Ctx(ctx)
+}
+
+// requestLog returns the logger of a request, carrying its request id
+func requestLog(c *gin.Context) *zerolog.Logger {
+	return logFrom(c.Request.Context())
+}
+
+// requestID returns the id of the request, see logRequests
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// jobLogger starts the logger of work done for a job
+func jobLogger(jobID, tenant, requestID string) zerolog.Context {
+	l := logger.With().Str("job_id", jobID).Str("tenant", tenant)
+	if requestID != "" {
+		l = l.Str("request_id", requestID)
+	}
+	return l
+}
+
+// log returns the logger of the job, carrying its id, its tenant and the id of the request that created it
+func (j *Job) log() *zerolog.Logger {
+	l := jobLogger(j.ID, j.TenantName, j.RequestID).Logger()
+	return &l
+}
+
+// logRequests gives every request an id, the one in X-Request-ID when the caller or a proxy sent one, and logs
+// the request once it is answered
+func logRequests(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = uuid.NewString()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+	l := logger.With().Str("request_id", id).Logger()
+	c.Request = c.Request.WithContext(l.WithContext(c.Request.Context()))
+
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	var event *zerolog.Event
+	switch {
+	case status >= 500:
+		event = l.Error()
+	case status >= 400:
+		event = l.Warn()
+	case c.FullPath() == "/health":
+		// Probes every few seconds would drown everything else
+		event = l.Debug()
+	default:
+		event = l.Info()
+	}
+	if len(c.Errors) > 0 {
+		event = event.Str("error", c.Errors.String())
+	}
+	event.Str("method", c.Request.Method).
+		Str("path", c.Request.URL.Path).
+		Int("status", status).
+		Int("size", c.Writer.Size()).
+		Dur("duration", time.Since(start)).
+		Str("client_ip", c.ClientIP()).
+		Str("scheme", requestScheme(c)).
+		Msg("Request")
+}
+
+// validRequestID accepts ids of printable ASCII, so they cannot break log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
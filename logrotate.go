@@ -0,0 +1,179 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFileBase = "ats-processor"
+	// rotatedLogLayout names rotated files after the time they were rotated, which also sorts them
+	rotatedLogLayout = "2006-01-02T15-04-05.000"
+)
+
+// rotatingFile is the log file, moved aside and replaced by a new one once it grows too large or too old.
+// Rotated files are compressed and the oldest removed in the background.
+type rotatingFile struct {
+	dir      string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	compress bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	// cleanupMu keeps cleanups of quick successive rotations from racing each other
+	cleanupMu sync.Mutex
+}
+
+// openRotatingFile opens the log file in dir, rotating a file a previous run left behind when it is due
+func openRotatingFile(dir string, cfg ServerConfig) (*rotatingFile, error) {
+	r := &rotatingFile{
+		dir:      dir,
+		maxSize:  int64(cfg.LogMaxSize),
+		maxAge:   cfg.LogMaxAge,
+		maxFiles: cfg.LogMaxFiles,
+		compress: cfg.LogCompress,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if r.due(0) {
+		if err := r.rotate(); err != nil {
+			r.file.Close()
+			return nil, err
+		}
+	}
+	// Files rotated by a previous run may wait to be compressed or removed
+	go r.cleanup()
+	return r, nil
+}
+
+// path is the path of the current log file
+func (r *rotatingFile) path() string {
+	return filepath.Join(r.dir, logFileBase+".log")
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size, r.opened = file, info.Size(), time.Now()
+	if r.size > 0 {
+		// The age of a file a previous run wrote counts from when it was last written, which it is at least
+		r.opened = info.ModTime()
+	}
+	return nil
+}
+
+// due reports whether the file must be rotated before n more bytes are written to it
+func (r *rotatingFile) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	return (r.maxSize > 0 && r.size+int64(n) > r.maxSize) || (r.maxAge > 0 && time.Since(r.opened) > r.maxAge)
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			// The logger cannot log its own failures, keep writing to the current file
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", r.path(), err)
+		} else {
+			go r.cleanup()
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and opens a new one
+func (r *rotatingFile) rotate() error {
+	rotated := filepath.Join(r.dir, logFileBase+"-"+time.Now().Format(rotatedLogLayout)+".log")
+	if err := os.Rename(r.path(), rotated); err != nil {
+		return err
+	}
+	previous := r.file
+	if err := r.open(); err != nil {
+		return err
+	}
+	previous.Close()
+	return nil
+}
+
+// cleanup compresses rotated files and removes the oldest beyond maxFiles
+func (r *rotatingFile) cleanup() {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+
+	rotated, err := filepath.Glob(filepath.Join(r.dir, logFileBase+"-*.log*"))
+	if err != nil {
+		return
+	}
+	slices.Sort(rotated)
+	if r.maxFiles > 0 && len(rotated) > r.maxFiles {
+		for _, path := range rotated[:len(rotated)-r.maxFiles] {
+			if err := os.Remove(path); err != nil {
+				logger.Error().Err(err).Msgf("Error removing old log file %s", path)
+			}
+		}
+		rotated = rotated[len(rotated)-r.maxFiles:]
+	}
+	if !r.compress {
+		return
+	}
+	for _, path := range rotated {
+		if strings.HasSuffix(path, ".log") {
+			if err := compressFile(path); err != nil {
+				logger.Error().Err(err).Msgf("Error compressing log file %s", path)
+			}
+		}
+	}
+}
+
+// compressFile replaces a file with its gzipped copy
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
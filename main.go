@@ -2,24 +2,29 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
 type Candidate struct {
+	// CandidateID is the ATS's own key for the candidate, naming their files instead of the email
+	CandidateID string `json:"candidate_id,omitempty"`
+	// CandidateCode stands in for the candidate in the packets of blind jobs, e.g. CAND-001
+	CandidateCode string   `json:"candidate_code,omitempty"`
 	Name          string   `json:"name"`
 	Email         string   `json:"email"`
 	MobileNo      string   `json:"mobile_no"`
@@ -27,171 +32,901 @@ type Candidate struct {
 	Experience    string   `json:"experience"`
 	Qualification string   `json:"qualification"`
 	ResumeURL     string   `json:"resume_url"`
+	// ResumeContent holds the resume itself, base64 encoded in JSON, for callers that cannot host it on a URL
+	ResumeContent  []byte `json:"resume_content,omitempty"`
+	ResumeFilename string `json:"resume_filename,omitempty"`
+	// ResumeHeaders are sent with the resume download, on top of the job's headers
+	ResumeHeaders ResumeHeaders `json:"resume_headers,omitempty"`
+	// ResumePassword opens a password protected PDF resume
+	ResumePassword string `json:"resume_password,omitempty"`
+	// ProfileURL links to the candidate's live ATS profile, printed as a QR code
+	ProfileURL string `json:"profile_url,omitempty"`
+	// PhotoURL is the candidate's photo, shown next to the title of the factsheet
+	PhotoURL string `json:"photo_url,omitempty"`
+	// CustomFields are extra rows of the factsheet, in order, e.g. notice period or visa status
+	CustomFields []CustomField `json:"custom_fields,omitempty"`
+	// Links are the candidate's profiles by label, e.g. LinkedIn or GitHub, printed as clickable links
+	Links map[string]string `json:"links,omitempty"`
+	// WorkHistory lists the candidate's positions, shown most recent first below the fields
+	WorkHistory []WorkExperience `json:"work_history,omitempty"`
+	// Education lists the candidate's degrees, shown as a table below the work history
+	Education []Education `json:"education,omitempty"`
+	// Attachments are appended to the packet after the resume, e.g. a cover letter
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Group files the candidate's packet in a folder of the archive, e.g. the role, department or recruiter
+	Group string `json:"group,omitempty"`
+	// Certifications are the candidate's certificates and licenses, expired ones are flagged
+	Certifications []Certification `json:"certifications,omitempty"`
 }
 
+// CustomField is a factsheet row a tenant defines without code changes
+type CustomField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// maxCustomFields keeps the factsheet table of a candidate on a page or two
+const maxCustomFields = 30
+
 func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	appConfig = cfg
+	instanceID = cfg.Server.InstanceID
+	restrictFileModes()
+
 	// Setup logging
 	setupLogging()
-
-	router := gin.Default()
-	router.POST("/api/process-candidates", processCandidates)
+	setupEncryptionAtRest()
+	setupAuth()
+	setupJobStore()
+	setupDelivery()
+	setupResumeSources()
+	setupResumeCache()
+	setupConversion()
+	setupTemplates()
+	setupFonts()
+	setupWatermarkFonts()
+	setupWorkerPool()
+	setupDiskGuard()
+	setupTenants()
+	setupJanitor()
+	resumed := setupTaskQueue()
+	failInterruptedJobs(resumed)
+
+	setupRateLimits()
+	router := gin.New()
+	if err := setupTrustedProxies(router); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to configure trusted proxies")
+	}
+	router.Use(logRequests, gin.Recovery())
+	if cors := appConfig.Server.CORS; len(cors.AllowedOrigins) > 0 {
+		router.Use(corsHandler(cors))
+	}
+	router.NoRoute(routeNotFound)
+	registerAPI(router.Group(apiBasePath, recordClientCertificate, limitClients, authenticate, limitRequests))
+	// The unversioned paths stay for existing callers
+	registerAPI(router.Group("/api", legacyAPI, recordClientCertificate, limitClients, authenticate, limitRequests))
 	router.GET("/health", healthCheck)
 
-	log.Println("Server started at :8081")
-	router.Run(":8081")
+	startDebugServer()
+	srv, err := newServer(router)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to configure server")
+	}
+	go func() {
+		if err := serve(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal().Err(err).Msg("Server failed")
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	shutdown(srv)
+}
+
+// registerAPI adds the endpoints of the API to a version's route group
+func registerAPI(api *gin.RouterGroup) {
+	api.POST("/process-candidates", processCandidates)
+	api.POST("/process-candidates/upload", processCandidatesUpload)
+	api.POST("/candidate", processSingleCandidate)
+	api.POST("/factsheet/preview", previewFactsheet)
+	api.GET("/jobs", listJobs)
+	api.GET("/jobs/:id", getJob)
+	api.GET("/jobs/:id/download", downloadJobArchive)
+	api.GET("/jobs/:id/events", streamJobEvents)
+	api.GET("/jobs/:id/monitor", monitorJob)
+	api.DELETE("/jobs/:id", cancelJob)
+	api.GET("/tenants", requireAdmin, listTenantSettings)
+	api.GET("/tenants/:tenant/settings", getTenantSettings)
+	api.PUT("/tenants/:tenant/settings", requireAdmin, updateTenantSettings)
+	api.DELETE("/tenants/:tenant/settings", requireAdmin, deleteTenantSettings)
+	api.GET("/audit", listAuditEntries)
+}
+
+// prepareRequest scopes a job request to the caller's tenant, validates it and fills in the defaults of its
+// options, returning the format warnings. It answers the request itself when it is invalid.
+func prepareRequest(c *gin.Context, req *ProcessRequest) ([]string, bool) {
+	// Authenticated callers can only create jobs for the tenant in their token
+	var ok bool
+	if req.TenantName, ok = scopeTenant(c, req.TenantName); !ok {
+		return nil, false
+	}
+
+	formatWarnings, e := validateRequest(req)
+	if e != nil {
+		e.abort(c)
+		return nil, false
+	}
+	if err := req.JobOptions.validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeValidationFailed, err.Error())
+		return nil, false
+	}
+	req.Watermark = watermarkText(req.Watermark, req.TenantName, req.CompanyName)
+	if req.CoverPage != nil {
+		req.CoverPage = req.CoverPage.withDefaults(req.CompanyName, time.Now())
+	}
+	// Candidates carry the combined headers so queue workers on other instances get them too
+	for i := range req.Candidates {
+		req.Candidates[i].ResumeHeaders = req.ResumeHeaders.merge(req.Candidates[i].ResumeHeaders)
+	}
+	if req.Blind {
+		assignCandidateCodes(req.Candidates)
+	}
+	return formatWarnings, true
+}
+
+// ProcessRequest is the payload accepted by the process candidates endpoint
+type ProcessRequest struct {
+	TenantName  string      `json:"tenant_name"`
+	CompanyName string      `json:"company_name"`
+	Candidates  []Candidate `json:"candidates"`
+	// Async returns the job ID immediately instead of waiting for processing to finish
+	Async bool `json:"async"`
+	// Delivery selects where the final archive is uploaded ("local", "s3", "gcs", "azure", "dropbox",
+	// "onedrive") or "email"
+	Delivery string `json:"delivery"`
+	// Concurrency limits how many of this job's candidates are processed at once (0 uses the pool size)
+	Concurrency int `json:"concurrency"`
+	// ResumeHeaders are sent with every resume download of the job, e.g. an Authorization header
+	ResumeHeaders ResumeHeaders `json:"resume_headers,omitempty"`
+	// ValidationMode decides what happens to candidates with a malformed email, mobile_no or resume_url:
+	// strict rejects the request, warn (the default) runs the job with warnings, normalize fixes what it can
+	ValidationMode string `json:"validation_mode,omitempty"`
+	// PhoneCountryCode is the calling code of mobile numbers written without one, e.g. 91
+	PhoneCountryCode string `json:"phone_country_code,omitempty"`
+	// DryRun validates the request and checks its resumes without generating anything, see dryRun
+	DryRun bool `json:"dry_run,omitempty"`
+	// FailFast replaces the configured fail-fast policy of the job
+	FailFast *FailFastPolicy `json:"fail_fast,omitempty"`
+	JobOptions
+}
+
+// JobOptions change how each candidate of a job is processed. They travel with every queued task.
+type JobOptions struct {
+	// OCR adds a searchable text layer to scanned resumes
+	OCR bool `json:"ocr,omitempty"`
+	// OCRLanguages are the Tesseract languages of the resumes, the configured default when empty
+	OCRLanguages []string `json:"ocr_languages,omitempty"`
+	// TemplateID selects an HTML factsheet template, the built-in table layout when empty
+	TemplateID string `json:"template_id,omitempty"`
+	// Theme selects a built-in layout: classic, modern or compact, the tenant's default when empty
+	Theme string `json:"theme,omitempty"`
+	// Direction is rtl for factsheets laid out right to left, e.g. for Arabic or Hebrew readers
+	Direction string `json:"direction,omitempty"`
+	// CoverPage puts a cover with the role title in front of every candidate's packet
+	CoverPage *CoverPage `json:"cover_page,omitempty"`
+	// PageNumbers stamps "Page X of Y" and the candidate's name at the foot of every page of a packet
+	PageNumbers bool `json:"page_numbers,omitempty"`
+	// Watermark is stamped diagonally across every page, e.g. "CONFIDENTIAL - {CompanyName}"
+	Watermark string `json:"watermark,omitempty"`
+	// CombinedPDF adds all_candidates.pdf to the archive, every packet in one file behind a table of contents
+	CombinedPDF bool `json:"combined_pdf,omitempty"`
+	// FilenameTemplate names the packets, e.g. "{index}_{name}_{company}.pdf", instead of after the candidate
+	FilenameTemplate string `json:"filename_template,omitempty"`
+	// ArchiveFormat packs the job's files as a zip, tar or tar.gz file, zip when empty
+	ArchiveFormat string `json:"archive_format,omitempty"`
+	// ArchiveEncryption encrypts the zip archive with a password
+	ArchiveEncryption *ArchiveEncryption `json:"archive_encryption,omitempty"`
+	// SplitArchiveMB splits the archive into numbered parts of at most this many megabytes each
+	SplitArchiveMB int `json:"split_archive_mb,omitempty"`
+	// ManifestCSV adds manifest.csv, the archive's manifest.json as a spreadsheet
+	ManifestCSV bool `json:"manifest_csv,omitempty"`
+	// Optimize downsamples the images of each packet to low, medium or high quality to keep files small
+	Optimize string `json:"optimize,omitempty"`
+	// Linearize optimizes the PDFs of the archive for fast web view, so browsers show the first page early
+	Linearize bool `json:"linearize,omitempty"`
+	// SeparatorPages put a page with the section's title in front of the resume and every attachment
+	SeparatorPages bool `json:"separator_pages,omitempty"`
+	// Encryption protects every PDF of the archive with a password
+	Encryption *OutputEncryption `json:"encryption,omitempty"`
+	// Timezone is the IANA zone generation dates are shown in, e.g. Asia/Kolkata, the server's when empty
+	Timezone string `json:"timezone,omitempty"`
+	// DateFormat prints generation dates, e.g. "DD/MM/YYYY HH:mm", instead of the locale's format
+	DateFormat string `json:"date_format,omitempty"`
+	// Locale picks the customary date format of a region, e.g. en-GB or de
+	Locale string `json:"locale,omitempty"`
+	// OutputMode is merged for one packet per candidate, factsheet_only to skip the resumes or separate to
+	// deliver the resume and attachments unmerged next to the factsheet, merged when empty
+	OutputMode string `json:"output_mode,omitempty"`
+	// MergeOrder is resume_first to put the resume and attachments in front of the factsheet, factsheet_first
+	// when empty
+	MergeOrder string `json:"merge_order,omitempty"`
+	// Blind leaves the candidates' name, email, phone number and photo out of the packets for unbiased
+	// screening, showing their candidate_code instead
+	Blind bool `json:"blind,omitempty"`
+	// Redact blacks out email addresses, phone numbers and photos on the pages of resumes and attachments,
+	// and the candidate's name in blind jobs
+	Redact bool `json:"redact,omitempty"`
+}
+
+// validate checks the options a job was submitted with
+func (o JobOptions) validate() error {
+	if len(o.OCRLanguages) > 0 && !o.OCR && !o.Redact {
+		return fmt.Errorf("ocr_languages requires ocr or redact")
+	}
+	for _, lang := range o.OCRLanguages {
+		if !ocrLanguagePattern.MatchString(lang) {
+			return fmt.Errorf("invalid ocr language %q", lang)
+		}
+	}
+	if _, ok := factsheetTemplates[o.TemplateID]; o.TemplateID != "" && !ok {
+		return fmt.Errorf("unknown template_id %q", o.TemplateID)
+	}
+	if err := validateTheme(o.Theme); err != nil {
+		return err
+	}
+	if o.Theme != "" && o.TemplateID != "" {
+		return fmt.Errorf("theme and template_id cannot be combined")
+	}
+	if o.Direction != "" && o.Direction != directionLTR && o.Direction != directionRTL {
+		return fmt.Errorf("direction must be %s or %s", directionLTR, directionRTL)
+	}
+	if o.CoverPage != nil {
+		if err := o.CoverPage.validate(); err != nil {
+			return err
+		}
+	}
+	if len(o.Watermark) > maxWatermarkLength {
+		return fmt.Errorf("watermark cannot be longer than %d characters", maxWatermarkLength)
+	}
+	if _, ok := ghostscriptSettings[o.Optimize]; o.Optimize != "" && !ok {
+		return fmt.Errorf("optimize must be %s, %s or %s", optimizeLow, optimizeMedium, optimizeHigh)
+	}
+	if o.Encryption != nil {
+		if err := o.Encryption.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateArchiveFormat(o.ArchiveFormat); err != nil {
+		return err
+	}
+	if o.ArchiveEncryption != nil {
+		if err := o.ArchiveEncryption.validate(o.ArchiveFormat); err != nil {
+			return err
+		}
+	}
+	if o.SplitArchiveMB < 0 || o.SplitArchiveMB > maxSplitArchiveMB {
+		return fmt.Errorf("split_archive_mb must be between 1 and %d", maxSplitArchiveMB)
+	}
+	if err := validateFilenameTemplate(o.FilenameTemplate); err != nil {
+		return err
+	}
+	if o.Blind && blindTemplateTokens.MatchString(o.FilenameTemplate) {
+		return fmt.Errorf("filename_template cannot use {name} or {email} in a blind job")
+	}
+	if err := validateOutputMode(o.OutputMode); err != nil {
+		return err
+	}
+	if err := validateMergeOrder(o.MergeOrder); err != nil {
+		return err
+	}
+	if o.OutputMode != "" && o.OutputMode != outputModeMerged {
+		if o.SeparatorPages {
+			return fmt.Errorf("separator_pages requires output_mode %s", outputModeMerged)
+		}
+		if o.MergeOrder != "" && o.MergeOrder != mergeOrderFactsheetFirst {
+			return fmt.Errorf("merge_order requires output_mode %s", outputModeMerged)
+		}
+	}
+	return o.validateDates()
+}
+
+// userPassword opens the PDFs of the job, empty when they are not encrypted or need no password to open
+func (o JobOptions) userPassword() string {
+	if o.Encryption == nil {
+		return ""
+	}
+	return o.Encryption.UserPassword
+}
+
+// direction is the text direction of the job's factsheets
+func (o JobOptions) direction() string {
+	if o.Direction == "" {
+		return directionLTR
+	}
+	return o.Direction
+}
+
+func processCandidates(c *gin.Context) {
+	var req ProcessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c).Warn().Err(err).Msg("Error binding JSON")
+		invalidBody("Invalid input", err).abort(c)
+		return
+	}
+	submitJob(c, req)
+}
+
+// submitJob validates a job request and runs it, answering with the summary or, for async jobs, the job id
+func submitJob(c *gin.Context, req ProcessRequest) {
+	// Retries are recognized by the request as sent, before defaults such as the date of the cover page,
+	// which changes at midnight, are filled in
+	hash := requestHash(req)
+	formatWarnings, ok := prepareRequest(c, &req)
+	if !ok {
+		return
+	}
+
+	if req.Delivery == "" {
+		req.Delivery = defaultDelivery
+	}
+	if err := validateDelivery(req.Delivery); err != nil {
+		apiError(http.StatusBadRequest, errCodeValidationFailed, err.Error()).field("delivery", err.Error()).abort(c)
+		return
+	}
+	if email := tenants.email(req.TenantName); req.Delivery == deliveryEmail && (email == nil || len(email.Recipients) == 0) {
+		message := "email delivery needs recipients in the email settings of tenant " + req.TenantName
+		apiError(http.StatusBadRequest, errCodeValidationFailed, message).field("delivery", message).abort(c)
+		return
+	}
+
+	if dry, _ := strconv.ParseBool(c.Query("dry_run")); dry || req.DryRun {
+		dryRun(c, req, formatWarnings)
+		return
+	}
+
+	// Retries carrying the same Idempotency-Key get the original job instead of a duplicate
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Idempotency-Key is too long")
+		return
+	}
+	// Retries are answered from the job store, which keeps a generated archive password only sealed
+	if idempotencyKey != "" && req.ArchiveEncryption != nil && req.ArchiveEncryption.Password == "" && jobDB != nil && atRest == nil {
+		abortWithError(c, http.StatusBadRequest, errCodeValidationFailed,
+			"archive_encryption needs a password with Idempotency-Key unless encryption at rest is enabled")
+		return
+	}
+	if idempotencyKey != "" {
+		if original, ok := jobs.findByIdempotencyKey(req.TenantName, idempotencyKey); ok {
+			replayJob(c, original, hash)
+			return
+		}
+	}
+
+	if shuttingDown.Load() {
+		abortWithError(c, http.StatusServiceUnavailable, errCodeShuttingDown, "service is shutting down")
+		return
+	}
+
+	// Turn jobs away while the scratch volume is nearly full instead of failing them halfway
+	if err := checkDiskSpace(req.TenantName); err != nil {
+		requestLog(c).Warn().Err(err).Msgf("Rejecting job for tenant %s", req.TenantName)
+		c.Header("Retry-After", retryAfterSeconds(diskFullRetryAfter))
+		abortWithError(c, http.StatusServiceUnavailable, errCodeInsufficientStorage, "insufficient disk space, try again later")
+		return
+	}
+
+	if ok, wait := tenants.allowJob(req.TenantName); !ok {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		abortWithError(c, http.StatusTooManyRequests, errCodeRateLimited, "job rate limit exceeded for tenant "+req.TenantName)
+		return
+	}
+
+	// A generated archive password is only ever returned to this request and its retries, not with the
+	// job's status
+	var archivePassword string
+	if req.ArchiveEncryption != nil && req.ArchiveEncryption.generatePassword() {
+		archivePassword = req.ArchiveEncryption.Password
+	}
+
+	if !admitJob() {
+		abortWithError(c, http.StatusServiceUnavailable, errCodeShuttingDown, "service is shutting down")
+		return
+	}
+	job := newJob(req)
+	job.RequestHash = hash
+	job.ArchivePassword = archivePassword
+	job.RequestID = requestID(c)
+	job.BaseURL = requestBaseURL(c)
+	job.Warnings = append(formatWarnings, job.Warnings...)
+	if idempotencyKey != "" {
+		job.IdempotencyKey = idempotencyKey
+		if original := jobs.addIdempotent(job); original != nil {
+			activeJobs.Done()
+			replayJob(c, original, hash)
+			return
+		}
+	} else {
+		jobs.add(job)
+	}
+	job.log().Info().Msgf("Accepted job %s for tenant %s from %s", job.ID, req.TenantName, requestedBy(c))
+	auditSubmittedJob(c, job, req)
+
+	if req.Async {
+		job.log().Info().Msgf("Queued job %s for tenant: %s, company: %s with %d candidates", job.ID, req.TenantName, req.CompanyName, len(req.Candidates))
+		go func() {
+			defer activeJobs.Done()
+			runJob(job, req)
+		}()
+		response := gin.H{
+			"job_id":     job.ID,
+			"status":     jobStatusQueued,
+			"status_url": apiBasePath + "/jobs/" + job.ID,
+		}
+		if len(job.Warnings) > 0 {
+			response["warnings"] = job.Warnings
+		}
+		if archivePassword != "" {
+			response["archive_password"] = archivePassword
+		}
+		c.JSON(http.StatusAccepted, response)
+		return
+	}
+
+	err := runJob(job, req)
+	activeJobs.Done()
+	if err != nil {
+		apiError(http.StatusInternalServerError, errCodeJobFailed, err.Error()).detail("job_id", job.ID).abort(c)
+		return
+	}
+
+	response := job.summary()
+	if archivePassword != "" {
+		response["archive_password"] = archivePassword
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func setupLogging() {
-	// Create logs directory if it doesn't exist
-	logDir := "/var/log/ats-candidate-processor"
-	if _, err := os.Stat(logDir); os.IsNotExist(err) {
-		// Try to create in /var/log, if permission denied, use local logs directory
-		if err := os.MkdirAll(logDir, 0755); err != nil {
-			log.Printf("Cannot create log directory %s: %v, using ./logs instead", logDir, err)
-			logDir = "./logs"
-			os.MkdirAll(logDir, 0755)
+const (
+	defaultJobPageSize = 50
+	maxJobPageSize     = 200
+)
+
+// listJobs returns job summaries filtered by tenant, status and creation time, newest first
+func listJobs(c *gin.Context) {
+	tenant, ok := scopeTenant(c, c.Query("tenant"))
+	if !ok {
+		return
+	}
+	filter := JobFilter{
+		Tenant: tenant,
+		Limit:  defaultJobPageSize,
+	}
+
+	if statuses := c.Query("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			switch status {
+			case jobStatusQueued, jobStatusProcessing, jobStatusCompletedSuccessfully, jobStatusCompletedWithErrors,
+				jobStatusFailed, jobStatusCancelled, jobStatusAborted:
+				filter.Statuses = append(filter.Statuses, status)
+			default:
+				apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid status: "+status).field("status", "unknown status "+status).abort(c)
+				return
+			}
 		}
 	}
 
-	// Create log file with timestamp
-	timestamp := time.Now().Format("2006-01-02")
-	logFileName := fmt.Sprintf("ats-processor-%s.log", timestamp)
-	logPath := filepath.Join(logDir, logFileName)
+	var err error
+	if filter.From, err = parseTimeParam(c.Query("from"), false); err != nil {
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid from: "+err.Error()).field("from", err.Error()).abort(c)
+		return
+	}
+	if filter.To, err = parseTimeParam(c.Query("to"), true); err != nil {
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid to: "+err.Error()).field("to", err.Error()).abort(c)
+		return
+	}
 
-	// Open log file
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if !parsePage(c, &filter.Limit, &filter.Offset, maxJobPageSize) {
+		return
+	}
+
+	items, total, err := jobs.list(filter)
 	if err != nil {
-		log.Printf("Failed to open log file %s: %v, logging to stdout only", logPath, err)
+		requestLog(c).Error().Err(err).Msg("Error listing jobs")
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to list jobs")
 		return
 	}
 
-	// Create multi-writer to write to both file and stdout
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(multiWriter)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	response := gin.H{
+		"jobs":   items,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}
+	if next := filter.Offset + len(items); next < total {
+		response["next_offset"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	log.Printf("Logging initialized. Log file: %s", logPath)
+// parsePage reads the limit and offset query parameters into limit and offset, which keep their values when
+// the parameters are absent. It responds with 400 and returns false when they are invalid.
+func parsePage(c *gin.Context, limit, offset *int, maxLimit int) bool {
+	var err error
+	if value := c.Query("limit"); value != "" {
+		if *limit, err = strconv.Atoi(value); err != nil || *limit < 1 || *limit > maxLimit {
+			message := fmt.Sprintf("limit must be between 1 and %d", maxLimit)
+			apiError(http.StatusBadRequest, errCodeValidationFailed, message).field("limit", message).abort(c)
+			return false
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if *offset, err = strconv.Atoi(value); err != nil || *offset < 0 {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, "offset must be a non-negative integer").
+				field("offset", "must be a non-negative integer").abort(c)
+			return false
+		}
+	}
+	return true
 }
 
-func healthCheck(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"status":    "healthy",
-		"service":   "ats-candidate-processor",
-		"version":   "1.0.0",
-		"timestamp": time.Now().Unix(),
-	})
+// parseTimeParam accepts RFC 3339 timestamps or plain dates. A plain date used as an upper bound
+// includes the whole day.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
 }
 
-func processCandidates(c *gin.Context) {
-	var req struct {
-		TenantName  string      `json:"tenant_name"`
-		CompanyName string      `json:"company_name"`
-		Candidates  []Candidate `json:"candidates"`
-	}
-	if err := c.BindJSON(&req); err != nil {
-		log.Printf("Error binding JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+func getJob(c *gin.Context) {
+	job, ok := jobs.get(c.Param("id"))
+	if !ok || !canAccessTenant(c, job.TenantName) {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "job not found")
 		return
 	}
 
-	// Validate required fields
-	if req.TenantName == "" || req.CompanyName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_name and company_name are required"})
+	c.JSON(http.StatusOK, job.summary())
+}
+
+func downloadJobArchive(c *gin.Context) {
+	job, ok := jobs.get(c.Param("id"))
+	if !ok || !canAccessTenant(c, job.TenantName) {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "job not found")
 		return
 	}
 
-	if len(req.Candidates) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "candidates list cannot be empty"})
+	job.mu.Lock()
+	status, zipPath, zipFileName, delivery := job.Status, job.ZipPath, job.ZipFileName, job.Delivery
+	deletedAt := job.ArchiveDeletedAt
+	job.mu.Unlock()
+
+	if !deletedAt.IsZero() {
+		apiError(http.StatusGone, errCodeGone, "archive expired and was deleted").detail("archive_deleted_at", deletedAt).abort(c)
+		return
+	}
+
+	// Parts of a split archive are downloaded one at a time, without a part the first is served
+	if partNumber := c.Query("part"); partNumber != "" {
+		part, ok := job.archivePart(partNumber)
+		if !ok {
+			apiError(http.StatusNotFound, errCodeNotFound, "archive part not found").field("part", "no such part").abort(c)
+			return
+		}
+		zipPath, zipFileName, delivery = part.Path, part.FileName, part.Delivery
+	}
+
+	// Archives delivered to remote storage are served from there
+	if zipPath == "" && delivery != nil && delivery.URL != "" {
+		audit.recordRequest(c, auditArchiveDownloaded, job.TenantName, job.ID, gin.H{"archive": delivery.Location})
+		c.Redirect(http.StatusFound, delivery.URL)
 		return
 	}
 
-	jobID := uuid.New().String()
-	log.Printf("Starting job %s for tenant: %s, company: %s with %d candidates", jobID, req.TenantName, req.CompanyName, len(req.Candidates))
+	if zipPath == "" {
+		apiError(http.StatusConflict, errCodeArchiveNotReady, "archive is not available yet").detail("status", status).abort(c)
+		return
+	}
+
+	file, err := openAtRest(zipPath)
+	if errors.Is(err, os.ErrNotExist) {
+		requestLog(c).Error().Err(err).Msgf("Error opening zip file %s for job %s", zipPath, job.ID)
+		abortWithError(c, http.StatusGone, errCodeGone, "archive is no longer available")
+		return
+	} else if err != nil {
+		requestLog(c).Error().Err(err).Msgf("Error reading zip file %s for job %s", zipPath, job.ID)
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to read archive")
+		return
+	}
+	defer file.Close()
+
+	requestLog(c).Debug().Msgf("Serving zip file %s for job %s", zipPath, job.ID)
+	audit.recordRequest(c, auditArchiveDownloaded, job.TenantName, job.ID, gin.H{"archive": zipFileName})
+	c.DataFromReader(http.StatusOK, file.Size(), archiveContentType(zipFileName), file, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", zipFileName),
+	})
+}
+
+// runJob processes every candidate of the job and packages the factsheets into a zip file
+func runJob(job *Job, req ProcessRequest) error {
+	jobID := job.ID
+	job.start()
+	defer notifyJobDone(job, req)
+	defer auditFinishedJob(job, req)
+	job.log().Info().Msgf("Starting job %s for tenant: %s, company: %s with %d candidates", jobID, req.TenantName, req.CompanyName, len(req.Candidates))
 
-	baseDir := filepath.Join("/tmp/candidate-processor", jobID)
-	factsheetDir := filepath.Join(baseDir, "factsheets")
-	tempDir := filepath.Join(baseDir, "temp")
+	baseDir, factsheetDir, tempDir := jobDirs(job.TenantName, jobID)
 
 	// Create directories
-	os.MkdirAll(factsheetDir, 0755)
-	os.MkdirAll(tempDir, 0755)
+	os.MkdirAll(factsheetDir, 0700)
+	os.MkdirAll(tempDir, 0700)
 
 	// Ensure cleanup happens (but not the zip file since we're returning its path)
-	defer func() {
-		log.Printf("Cleaning up temporary files for job %s", jobID)
-		if err := os.RemoveAll(baseDir); err != nil {
-			log.Printf("Error cleaning up directory %s: %v", baseDir, err)
-		} else {
-			log.Printf("Successfully cleaned up temporary files for job %s", jobID)
+	defer cleanupJobDir(jobID, baseDir)
+
+	stopWatching := watchDiskUsage(job, baseDir)
+	if taskQueue != nil {
+		defer taskQueue.release(jobID)
+
+		// Candidates are spread over every instance sharing the queue
+		if err := taskQueue.run(job, req, factsheetDir, tempDir); err != nil {
+			stopWatching()
+			job.log().Error().Err(err).Msgf("Error queueing job %s", jobID)
+			job.fail(fmt.Errorf("failed to queue candidates: %w", err))
+			return fmt.Errorf("failed to queue candidates")
 		}
-	}()
+	} else {
+		processCandidatesLocally(job, req, factsheetDir, tempDir)
+	}
+	stopWatching()
+
+	return packageJob(job, req, factsheetDir)
+}
+
+// cancelJob stops a running job. Candidates that already finished are kept and packaged as usual.
+func cancelJob(c *gin.Context) {
+	jobID := c.Param("id")
+	job, ok := jobs.get(jobID)
+	if !ok || !canAccessTenant(c, job.TenantName) {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "job not found")
+		return
+	}
+
+	job.mu.Lock()
+	status := job.Status
+	job.mu.Unlock()
+	if isFinalJobStatus(status) {
+		apiError(http.StatusConflict, errCodeJobAlreadyFinished, "job has already finished").
+			detail("job_id", jobID).detail("status", status).abort(c)
+		return
+	}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errors := []string{}
-	successCount := 0
-
-	for _, candidate := range req.Candidates {
-		wg.Add(1)
-		go func(cand Candidate) {
-			defer wg.Done()
-			log.Printf("Processing candidate: %s (%s)", cand.Name, cand.Email)
-
-			if err := handleCandidate(cand, factsheetDir, tempDir); err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Sprintf("%s: %v", cand.Email, err))
-				mu.Unlock()
-				log.Printf("Error processing candidate %s: %v", cand.Email, err)
-			} else {
-				mu.Lock()
-				successCount++
-				mu.Unlock()
-				log.Printf("Successfully processed candidate: %s", cand.Email)
+	cancelled := job.cancel()
+	if taskQueue != nil {
+		// Workers on other instances may be processing candidates of the job, or own it
+		if err := taskQueue.cancel(jobID); err != nil {
+			requestLog(c).Error().Err(err).Msgf("Error broadcasting cancellation of job %s", jobID)
+			if !cancelled {
+				abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to cancel job")
+				return
 			}
-		}(candidate)
+		}
+	} else if !cancelled {
+		apiError(http.StatusConflict, errCodeJobOnAnotherInstance, "job is running on another instance").detail("job_id", jobID).abort(c)
+		return
 	}
 
-	wg.Wait()
+	requestLog(c).Info().Msgf("Cancelling job %s at the request of %s", jobID, requestedBy(c))
+	audit.recordRequest(c, auditJobCancelled, job.TenantName, jobID, nil)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     jobID,
+		"status":     "cancelling",
+		"status_url": apiBasePath + "/jobs/" + jobID,
+	})
+}
 
-	log.Printf("Processing completed. Success: %d, Errors: %d", successCount, len(errors))
+// jobDirs returns the scratch directories used by a job of the tenant
+func jobDirs(tenant, jobID string) (baseDir, factsheetDir, tempDir string) {
+	baseDir = filepath.Join(scratchRoot(tenant), jobID)
+	return baseDir, filepath.Join(baseDir, "factsheets"), filepath.Join(baseDir, "temp")
+}
+
+func cleanupJobDir(jobID, baseDir string) {
+	logger.Debug().Msgf("Cleaning up temporary files for job %s", jobID)
+	jobResumes.forget(baseDir)
+	if err := os.RemoveAll(baseDir); err != nil {
+		logger.Error().Err(err).Msgf("Error cleaning up directory %s", baseDir)
+	} else {
+		logger.Debug().Msgf("Successfully cleaned up temporary files for job %s", jobID)
+	}
+}
 
-	// Create zip file with only factsheets
+// candidateResult holds details of how a candidate was processed, whether or not it succeeded
+type candidateResult struct {
+	DownloadAttempts int
+	// Format is the detected MIME type of the resume and Converter what turned it into a PDF
+	Format    string
+	Converter string
+	// Stage is the step of the pipeline the candidate got to
+	Stage string
+	// File is the packet of a candidate that succeeded, Failure explains why one failed
+	File    string
+	Failure *CandidateFailure
+}
+
+// errCandidateDeadline is the cancel cause of candidates that exceed the candidate timeout
+var errCandidateDeadline = errors.New("candidate deadline exceeded")
+
+// processCandidate runs the full pipeline for a single candidate with logging, within the candidate
+// timeout so a pathological resume cannot hold up the rest of its job
+func processCandidate(ctx context.Context, job jobInfo, opts JobOptions, cand Candidate, fileName, factsheetDir, tempDir string) (candidateResult, error) {
+	// Every step logs through the context, carrying the candidate's email next to the ids of its job
+	l := logFrom(ctx).With().Str("candidate_email", cand.Email).Logger()
+	ctx = l.WithContext(ctx)
+	l.Info().Msgf("Processing candidate: %s (%s)", cand.Name, cand.Email)
+	timeout := appConfig.Processing.CandidateTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errCandidateDeadline)
+		defer cancel()
+	}
+	result, err := handleCandidate(ctx, job, opts, cand, fileName, factsheetDir, tempDir)
+	if err != nil && errors.Is(context.Cause(ctx), errCandidateDeadline) {
+		// Whatever step was running reports the deadline as its own failure
+		err = &codedError{errorCodeCandidateTimeout, fmt.Errorf("processing took longer than %s", timeout)}
+	}
+	if err != nil {
+		result.Failure = describeFailure(result.Stage, err)
+		logFrom(ctx).Error().Err(err).Msgf("Error processing candidate %s", cand.Email)
+	} else {
+		result.File = fileName
+		logFrom(ctx).Info().Msgf("Successfully processed candidate: %s", cand.Email)
+	}
+	return result, err
+}
+
+// packageJob zips the generated factsheets and delivers the archive once all candidates are done
+func packageJob(job *Job, req ProcessRequest, factsheetDir string) error {
+	jobID := job.ID
+
+	job.mu.Lock()
+	successCount, errors := job.SuccessCount, append([]string(nil), job.Errors...)
+	job.mu.Unlock()
+	job.log().Info().Msgf("Processing completed. Success: %d, Errors: %d", successCount, len(errors))
+
+	// The packets waited sealed at rest, the tools that package them need them in the clear until the
+	// archive is written and sealed in turn
+	if err := unsealDir(factsheetDir); err != nil {
+		job.log().Error().Err(err).Msgf("Error decrypting packets for job %s", jobID)
+		job.fail(fmt.Errorf("failed to decrypt packets: %w", err))
+		return fmt.Errorf("failed to decrypt packets")
+	}
+
+	var combinedPages map[int]int
+	if req.CombinedPDF && successCount > 0 {
+		// The zip still holds every packet on its own, the combined PDF is only a convenience
+		pages, err := buildCombinedPDF(job, req, factsheetDir)
+		if err != nil {
+			job.log().Error().Err(err).Msgf("Error building combined PDF for job %s", jobID)
+		}
+		combinedPages = pages
+	}
+	if err := buildIndexPDF(job, req, factsheetDir, combinedPages); err != nil {
+		job.log().Error().Err(err).Msgf("Error building index for job %s", jobID)
+	}
+	if req.Encryption != nil {
+		if err := encryptOutputs(context.Background(), factsheetDir, req.Encryption, req.Linearize); err != nil {
+			// Nothing goes out unless all of it is encrypted
+			job.log().Error().Err(err).Msgf("Error encrypting PDFs for job %s", jobID)
+			job.fail(fmt.Errorf("failed to encrypt PDFs: %w", err))
+			return fmt.Errorf("failed to encrypt PDFs")
+		}
+	} else if req.Linearize {
+		linearizeOutputs(context.Background(), factsheetDir)
+	}
+	if err := buildManifest(job, req, factsheetDir); err != nil {
+		job.log().Error().Err(err).Msgf("Error writing manifest for job %s", jobID)
+	}
+	if err := buildSummaryWorkbook(job, req, factsheetDir); err != nil {
+		job.log().Error().Err(err).Msgf("Error writing summary workbook for job %s", jobID)
+	}
+
+	// Create the archive, a zip file unless the job asks for a tar file, with only factsheets
 	// Sanitize tenant and company names for filename
 	sanitizedTenant := sanitizeFilename(req.TenantName)
 	sanitizedCompany := sanitizeFilename(req.CompanyName)
-	zipFileName := fmt.Sprintf("%s_%s_factsheets_%s.zip", sanitizedTenant, sanitizedCompany, jobID)
-	zipPath := filepath.Join("/tmp", zipFileName)
+	baseName := fmt.Sprintf("%s_%s_factsheets_%s", sanitizedTenant, sanitizedCompany, jobID)
+	zipFileName := baseName + archiveExtension(req.ArchiveFormat)
+	zipPath := filepath.Join(appConfig.Storage.ArchiveDir, zipFileName)
+
+	var parts []ArchivePart
+	if req.SplitArchiveMB > 0 {
+		var err error
+		if parts, err = splitArchive(job, req, factsheetDir, baseName); err != nil {
+			job.log().Error().Err(err).Msgf("Error splitting archive of job %s", jobID)
+			job.fail(fmt.Errorf("failed to split archive: %w", err))
+			return fmt.Errorf("failed to split archive")
+		}
+	}
+	if len(parts) > 0 {
+		zipPath, zipFileName = parts[0].Path, parts[0].FileName
+	} else if err := archiveFolder(req.JobOptions, factsheetDir, zipPath, nil); err != nil {
+		job.log().Error().Err(err).Msg("Error creating zip file")
+		job.fail(fmt.Errorf("failed to zip files: %w", err))
+		return fmt.Errorf("failed to zip files")
+	} else {
+		job.log().Info().Msgf("Created zip file: %s", zipPath)
+	}
+	job.setArchive(zipPath, zipFileName, parts)
 
-	if err := zipFolder(factsheetDir, zipPath); err != nil {
-		log.Printf("Error creating zip file: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to zip files"})
-		return
+	if req.Delivery == deliveryEmail {
+		delivery, err := emailArchive(job, req, zipPath, zipFileName, parts)
+		if err != nil {
+			// The archive can still be downloaded from this instance
+			job.log().Error().Err(err).Msgf("Error emailing archive of job %s", jobID)
+			job.fail(fmt.Errorf("failed to email archive: %w", err))
+			return fmt.Errorf("failed to email archive")
+		}
+		job.completeEmailed(delivery)
+	} else if req.Delivery != deliveryLocal {
+		var delivery *DeliveryResult
+		var err error
+		if len(parts) > 0 {
+			err = deliverArchiveParts(req.Delivery, parts)
+		} else {
+			delivery, err = deliverArchive(req.Delivery, zipPath, zipFileName)
+		}
+		if err != nil {
+			// The local archive is kept so it can still be downloaded from this instance
+			job.log().Error().Err(err).Msgf("Error delivering zip file for job %s", jobID)
+			job.fail(fmt.Errorf("failed to deliver archive: %w", err))
+			return fmt.Errorf("failed to deliver archive via %s", req.Delivery)
+		}
+
+		if len(parts) > 0 {
+			delivery = parts[0].Delivery
+		} else if err := os.Remove(zipPath); err != nil {
+			// Remote storage is the source of truth, don't leave a copy in /tmp
+			job.log().Error().Err(err).Msgf("Error removing delivered zip file %s", zipPath)
+		}
+		job.completeDelivered(delivery, parts)
+	} else {
+		job.complete()
 	}
 
-	log.Printf("Created zip file: %s", zipPath)
+	job.mu.Lock()
+	status := job.Status
+	job.mu.Unlock()
 
-	// Prepare response
-	response := gin.H{
-		"job_id":                 jobID,
-		"tenant_name":            req.TenantName,
-		"company_name":           req.CompanyName,
-		"zip_file_path":          zipPath,
-		"zip_file_name":          zipFileName,
-		"total_candidates":       len(req.Candidates),
-		"processed_successfully": successCount,
-		"errors_count":           len(errors),
-	}
-
-	if len(errors) > 0 {
-		log.Printf("Job %s completed with errors for %s - %s: %v", jobID, req.TenantName, req.CompanyName, errors)
-		response["errors"] = errors
-		response["status"] = "completed_with_errors"
+	if status == jobStatusFailed || status == jobStatusCancelled || status == jobStatusAborted {
+		job.log().Warn().Msgf("Job %s %s for %s - %s after %d candidates", jobID, status, req.TenantName, req.CompanyName, successCount)
+	} else if len(errors) > 0 {
+		job.log().Warn().Msgf("Job %s completed with errors for %s - %s: %v", jobID, req.TenantName, req.CompanyName, errors)
 	} else {
-		log.Printf("Job %s completed successfully for %s - %s", jobID, req.TenantName, req.CompanyName)
-		response["status"] = "completed_successfully"
+		job.log().Info().Msgf("Job %s completed successfully for %s - %s", jobID, req.TenantName, req.CompanyName)
 	}
 
-	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// factsheetFileName is the name of a candidate's packet in the archive when the job has no filename_template
+func factsheetFileName(cand Candidate) string {
+	return fmt.Sprintf("%s_factsheet.pdf", cand.fileKey())
 }
 
 // sanitizeFilename removes or replaces characters that are not safe for filenames
@@ -231,69 +966,256 @@ func sanitizeFilename(filename string) string {
 	return filename
 }
 
-func handleCandidate(cand Candidate, factsheetDir, tempDir string) error {
-	// Create candidate-specific temp directory
-	candTempDir := filepath.Join(tempDir, strings.ReplaceAll(cand.Email, "@", "_"))
-	os.MkdirAll(candTempDir, 0755)
+func handleCandidate(ctx context.Context, job jobInfo, opts JobOptions, cand Candidate, fileName, factsheetDir, tempDir string) (result candidateResult, err error) {
+	// Create candidate-specific temp directory, named like the packet so candidates sharing an email keep apart
+	candTempDir := filepath.Join(tempDir, strings.TrimSuffix(fileName, ".pdf"))
+	os.MkdirAll(candTempDir, 0700)
 
-	// Generate factsheet directly in factsheet directory
-	factsheetPath := filepath.Join(factsheetDir, fmt.Sprintf("%s_factsheet.pdf", strings.ReplaceAll(cand.Email, "@", "_")))
-	if err := generateFactsheetPDF(cand, factsheetPath); err != nil {
-		return fmt.Errorf("failed to generate factsheet: %w", err)
+	// The packet goes to the factsheet directory, in the folder of the candidate's group if any, once it is
+	// finished and sealed at rest. Until then the candidate's files stay in its temp directory.
+	packetPath := filepath.Join(factsheetDir, fileName)
+	os.MkdirAll(filepath.Dir(packetPath), 0700)
+	factsheetPath := filepath.Join(candTempDir, "factsheet.pdf")
+	mergedPath := filepath.Join(candTempDir, "merged.pdf")
+	// Candidates that ran out of time deliver nothing, not even a factsheet without its resume, and neither
+	// do failed candidates of watermarked jobs, whose factsheet must not go out unmarked. Other failed
+	// candidates deliver their factsheet alone. The temp directory goes as the candidate finishes.
+	stored := false
+	defer func() {
+		if err != nil && (errors.Is(context.Cause(ctx), errCandidateDeadline) || opts.Watermark != "") {
+			os.Remove(packetPath)
+			if opts.OutputMode == outputModeSeparate {
+				for _, name := range separateFileNames(fileName, cand) {
+					os.Remove(filepath.Join(factsheetDir, name))
+				}
+			}
+		} else if err != nil && !stored {
+			if _, statErr := os.Stat(factsheetPath); statErr == nil {
+				if sealErr := sealInto(factsheetPath, packetPath); sealErr != nil {
+					logFrom(ctx).Error().Err(sealErr).Msgf("Dropping factsheet of %s", cand.Email)
+				}
+			}
+		}
+		os.RemoveAll(candTempDir)
+	}()
+	// Everything drawn shows the candidate as the job does, downloads need the candidate as sent
+	shown := opts.shown(cand)
+	result.Stage = stageFactsheet
+	if err := renderFactsheet(ctx, job, opts, tenants.branding(job.Tenant), shown, factsheetPath, candTempDir); err != nil {
+		return result, fmt.Errorf("failed to generate factsheet: %w", err)
 	}
 
-	// Download resume to temp directory
-	resumeFile := filepath.Join(candTempDir, "resume")
-	if err := downloadFile(cand.ResumeURL, resumeFile); err != nil {
-		return fmt.Errorf("failed to download resume: %w", err)
+	pdfs := []string{factsheetPath}
+	var documents []string
+	if opts.OutputMode != outputModeFactsheetOnly {
+		// Candidates of the same job sharing a resume URL download and convert it only once
+		result.Stage = stageDownload
+		resumePDF, err := jobResumes.prepare(ctx, job.Tenant, opts, cand, tempDir, candTempDir, &result)
+		if err != nil {
+			return result, err
+		}
+
+		result.Stage = stageAttachments
+		attachmentPDFs, err := prepareAttachments(ctx, job.Tenant, opts, cand, candTempDir)
+		if err != nil {
+			return result, err
+		}
+		result.Stage = stageAssembly
+		if opts.OutputMode == outputModeSeparate {
+			documents = append([]string{resumePDF}, attachmentPDFs...)
+		} else if pdfs, err = packetSections(opts, shown, tenants.branding(job.Tenant), factsheetPath, resumePDF, attachmentPDFs, candTempDir); err != nil {
+			return result, err
+		}
 	}
+	result.Stage = stageAssembly
 
-	// Convert resume to PDF in temp directory
-	resumePDF := resumeFile + ".pdf"
-	if strings.HasSuffix(strings.ToLower(cand.ResumeURL), ".pdf") {
-		os.Rename(resumeFile, resumePDF)
-	} else {
-		if _, err := convertToPDF(resumeFile, candTempDir); err != nil {
-			return fmt.Errorf("conversion failed: %w", err)
+	// Merge PDFs and save final result as factsheet, behind the cover page if the job has one
+	if opts.CoverPage != nil {
+		coverPath, err := renderCoverPage(opts.CoverPage, shown, opts, tenants.branding(job.Tenant), candTempDir)
+		if err != nil {
+			return result, fmt.Errorf("failed to render cover page: %w", err)
 		}
+		pdfs = append([]string{coverPath}, pdfs...)
+	}
+	if err := mergePDFs(ctx, mergedPath, pdfs...); err != nil {
+		return result, fmt.Errorf("failed to merge pdfs: %w", err)
+	}
+	if err := finishPDF(ctx, job, opts, shown, mergedPath, candTempDir); err != nil {
+		return result, err
 	}
 
-	// Merge PDFs and save final result as factsheet
-	mergedPath := filepath.Join(candTempDir, "merged.pdf")
-	if err := mergePDFs(factsheetPath, resumePDF, mergedPath); err != nil {
-		return fmt.Errorf("failed to merge pdfs: %w", err)
+	if err := sealInto(mergedPath, packetPath); err != nil {
+		return result, fmt.Errorf("failed to store packet: %w", err)
+	}
+	stored = true
+
+	if documents != nil {
+		if err := writeSeparateDocuments(ctx, job, opts, shown, fileName, factsheetDir, candTempDir, documents); err != nil {
+			return result, err
+		}
 	}
+	return result, nil
+}
 
-	// Replace the original factsheet with merged version
-	if err := os.Rename(mergedPath, factsheetPath); err != nil {
-		return fmt.Errorf("failed to move merged file: %w", err)
+// finishPDF stamps page numbers and the watermark on a PDF of a candidate, optimizes it and sets its
+// document information, as the job asks
+func finishPDF(ctx context.Context, job jobInfo, opts JobOptions, cand Candidate, path, candTempDir string) error {
+	if opts.PageNumbers {
+		if err := stampPageNumbers(ctx, path, cand.Name, opts.direction(), candTempDir); err != nil {
+			return fmt.Errorf("failed to number pages: %w", err)
+		}
+	}
+	if opts.Watermark != "" {
+		watermark := strings.ReplaceAll(opts.Watermark, "{CandidateName}", cand.Name)
+		if err := stampWatermark(ctx, path, watermark, opts.direction()); err != nil {
+			return fmt.Errorf("failed to stamp watermark: %w", err)
+		}
 	}
 
+	if opts.Optimize != "" {
+		optimizePDF(ctx, path, opts.Optimize)
+	}
+
+	// The merge keeps the document information of the first PDF, which need not be the factsheet
+	if err := setPDFInfo(ctx, path, candidateInfo(job, cand)); err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("Failed to set document information of %s", cand.Email)
+	}
 	return nil
 }
 
-func generateFactsheetPDF(cand Candidate, outputPath string) error {
+// prepareResume downloads or writes out the candidate's resume and converts it to PDF, returning the
+// path of the PDF. Scanned resumes get a text layer when the job asks for OCR.
+func prepareResume(ctx context.Context, tenant string, opts JobOptions, cand Candidate, candTempDir string, result *candidateResult) (string, error) {
+	// Download resume to temp directory, inline resumes are written out as they are
+	resumeFile := filepath.Join(candTempDir, "resume")
+	var info downloadInfo
+	if len(cand.ResumeContent) > 0 {
+		info.Filename = cand.ResumeFilename
+		if err := os.WriteFile(resumeFile, cand.ResumeContent, 0600); err != nil {
+			return "", fmt.Errorf("failed to write resume: %w", err)
+		}
+	} else {
+		var err error
+		info, err = fetchResume(ctx, tenant, cand, resumeFile)
+		result.DownloadAttempts = info.Attempts
+		if err != nil {
+			return "", fmt.Errorf("failed to download resume: %w", err)
+		}
+	}
+
+	result.Stage = stageFormat
+	format, err := detectResumeFormat(resumeFile, info.ContentType, info.Filename, cand.ResumeURL)
+	if err != nil {
+		return "", err
+	}
+	logFrom(ctx).Debug().Msgf("Resume of %s detected as %s", cand.Email, format.MIME)
+	result.Format, result.Converter = format.MIME, converterName(format)
+
+	// Convert resume to PDF in temp directory, under the extension of its format
+	resumePDF := filepath.Join(candTempDir, "resume.pdf")
+	if format.isPDF() {
+		if err := os.Rename(resumeFile, resumePDF); err != nil {
+			return "", err
+		}
+		if resumePDF, err = unlockPDF(ctx, resumePDF, cand.ResumePassword); err != nil {
+			return "", err
+		}
+		if resumePDF, err = repairPDF(ctx, resumePDF); err != nil {
+			return "", err
+		}
+	} else {
+		result.Stage = stageConversion
+		typedFile := resumeFile + format.Ext
+		if err := os.Rename(resumeFile, typedFile); err != nil {
+			return "", err
+		}
+		if resumePDF, err = convertResume(ctx, typedFile, candTempDir, format); err != nil {
+			return "", fmt.Errorf("conversion failed: %w", err)
+		}
+	}
+
+	// A resume that cannot be redacted must not go out as it is
+	if opts.Redact {
+		result.Stage = stageRedaction
+		if resumePDF, err = redactResume(ctx, resumePDF, cand, opts); err != nil {
+			return "", fmt.Errorf("redaction failed: %w", err)
+		}
+	}
+	if opts.OCR {
+		resumePDF = ocrResume(ctx, resumePDF, opts.ocrLanguages())
+	}
+	return resumePDF, nil
+}
+
+func generateFactsheetPDF(cand Candidate, opts JobOptions, info pdfInfo, branding *Branding, photo *candidatePhoto, outputPath string) error {
 	pdf := gofpdf.New("P", "mm", "A4", "")
+	info.apply(pdf)
+	text := newFactsheetText(pdf, opts.direction())
+	if branding == nil {
+		branding = &Branding{}
+	}
+	drawLetterhead(pdf, text, branding, opts.direction())
 	pdf.AddPage()
+	draw, ok := factsheetThemes[opts.Theme]
+	if !ok {
+		draw = drawClassicFactsheet
+	}
+	draw(factsheetPage{pdf: pdf, text: text, cand: cand, opts: opts, branding: branding, photo: photo})
 
-	// Title
-	pdf.SetFont("Arial", "B", 18)
-	pdf.SetFillColor(240, 240, 240)
-	pdf.CellFormat(190, 12, "CANDIDATE FACTSHEET", "1", 1, "C", true, 0, "")
+	return pdf.OutputFileAndClose(outputPath)
+}
+
+// drawClassicFactsheet draws the candidate's fields as a table under a title bar
+func drawClassicFactsheet(p factsheetPage) {
+	pdf, text, cand, opts, branding, photo := p.pdf, p.text, p.cand, p.opts, p.branding, p.photo
+
+	// Title, white on the tenant's primary color. The photo goes beside it, on the right unless the
+	// layout is mirrored.
+	titleWidth := 190.0
+	titleX, titleY := pdf.GetX(), pdf.GetY()
+	if photo != nil {
+		titleWidth -= photoWidth + 5
+		photoX := titleX + titleWidth + 5
+		if opts.direction() == directionRTL {
+			photoX, titleX = titleX, titleX+photoWidth+5
+		}
+		photo.draw(pdf, text, photoX, titleY)
+		pdf.SetXY(titleX, titleY)
+	}
+	title := text.font("B", 18, opts.factsheetTitle())
+	if fillColor(pdf, branding.PrimaryColor, 240) {
+		pdf.SetTextColor(255, 255, 255)
+	}
+	pdf.CellFormat(titleWidth, 12, title, "1", 1, "C", true, 0, "")
+	pdf.SetTextColor(0, 0, 0)
 	pdf.Ln(8)
+	if photo != nil {
+		pdf.SetY(max(pdf.GetY(), titleY+photoHeight+5))
+	}
 
 	// Table setup
-	pdf.SetFont("Arial", "B", 12)
 	pdf.SetFillColor(220, 220, 220)
 
-	// Table rows
+	// Table rows, blind factsheets have the candidate's code in place of their contact details
 	tableData := [][]string{
 		{"Name", cand.Name},
 		{"Email", cand.Email},
 		{"Mobile Number", cand.MobileNo},
+	}
+	if opts.Blind {
+		tableData = [][]string{{"Candidate Code", cand.CandidateCode}}
+	}
+	tableData = append(tableData, [][]string{
 		{"Qualification", cand.Qualification},
 		{"Experience", cand.Experience},
 		{"Skills", strings.Join(cand.Skills, ", ")},
+	}...)
+	for _, field := range cand.CustomFields {
+		tableData = append(tableData, []string{field.Label, field.Value})
+	}
+	// Links come last, their URL as a third column of the row
+	for _, link := range cand.sortedLinks() {
+		tableData = append(tableData, []string{link.label, displayURL(link.url), link.url})
 	}
 
 	// Column widths
@@ -301,114 +1223,200 @@ func generateFactsheetPDF(cand Candidate, outputPath string) error {
 	col2Width := 140.0
 	rowHeight := 10.0
 
+	// Right-to-left layouts mirror the table, labels go on the right
+	_, pageHeight := pdf.GetPageSize()
+	left := pdf.GetX()
+	labelX, valueX := left, left+col1Width
+	if opts.direction() == directionRTL {
+		labelX, valueX = left+col2Width, left
+	}
+
 	for i, row := range tableData {
-		// Alternate row colors
-		if i%2 == 0 {
-			pdf.SetFillColor(250, 250, 250)
-		} else {
-			pdf.SetFillColor(240, 240, 240)
+		// Long labels and values wrap onto several lines
+		lines := text.lines("", 11, row[1], col2Width-4)
+		if len(row) > 2 {
+			// Links keep to a line
+			lines = lines[:1]
+		}
+		labelLines := text.lines("B", 11, row[0], col1Width-4)
+		cellHeight := max(rowHeight, float64(max(len(lines), len(labelLines)))*5.0)
+		top := pdf.GetY()
+		// A row that does not fit starts a new page, its cells stay side by side
+		if _, margin := pdf.GetAutoPageBreak(); top+cellHeight > pageHeight-margin {
+			pdf.AddPage()
+			top = pdf.GetY()
 		}
 
-		// Field name (bold)
-		pdf.SetFont("Arial", "B", 11)
-		pdf.CellFormat(col1Width, rowHeight, row[0], "1", 0, "L", true, 0, "")
-
-		// Field value (normal)
-		pdf.SetFont("Arial", "", 11)
-
-		// Handle long text (especially skills) with MultiCell
-		if row[0] == "Skills" && len(row[1]) > 50 {
-			// Calculate required height for skills
-			lines := pdf.SplitLines([]byte(row[1]), col2Width-4)
-			cellHeight := float64(len(lines)) * 5.0
-			if cellHeight < rowHeight {
-				cellHeight = rowHeight
+		// drawCell fills a cell of the row and writes the lines into it in the current font
+		drawCell := func(x, width float64, lines []string, align string) {
+			pdf.SetXY(x, top)
+			if len(lines) == 1 {
+				pdf.CellFormat(width, cellHeight, lines[0], "1", 0, align, true, 0, "")
+				return
 			}
+			// Draw the cell border first, then the lines inside it
+			pdf.CellFormat(width, cellHeight, "", "1", 0, "", true, 0, "")
+			for n, line := range lines {
+				pdf.SetXY(x+1, top+1+float64(n)*5)
+				pdf.CellFormat(width-2, 5, line, "", 0, align, false, 0, "")
+			}
+		}
 
-			// Draw the cell border first
-			pdf.CellFormat(col2Width, cellHeight, "", "1", 1, "L", true, 0, "")
-
-			// Go back to write the text
-			currentY := pdf.GetY() - cellHeight
-			pdf.SetY(currentY + 1)
-			pdf.SetX(pdf.GetX() + col1Width + 1)
-
-			// Write multi-line text
-			pdf.MultiCell(col2Width-2, 5, row[1], "", "L", false)
-
-			// Move to next row position
-			pdf.SetY(currentY + cellHeight)
+		// Field name (bold), on the tenant's secondary color
+		rowFill := 250 - i%2*10
+		fillColor(pdf, branding.SecondaryColor, rowFill)
+		drawCell(labelX, col1Width, labelLines, text.align(row[0]))
+
+		// Field value (normal), in a font that has its script
+		pdf.SetFillColor(rowFill, rowFill, rowFill)
+		text.font("", 11, row[1])
+		if len(row) > 2 {
+			drawCell(valueX, col2Width, []string{""}, "")
+			p.drawLink(valueX, top, col2Width, cellHeight, 11, row[1], row[2], linkBlue)
 		} else {
-			pdf.CellFormat(col2Width, rowHeight, row[1], "1", 1, "L", true, 0, "")
+			drawCell(valueX, col2Width, lines, text.align(row[1]))
 		}
+
+		// Move to next row position
+		pdf.SetXY(left, top+cellHeight)
 	}
 
-	// Add footer
+	if len(cand.WorkHistory) > 0 {
+		pdf.SetXY(left, p.drawWorkHistory(left, pdf.GetY()+8, 190))
+	}
+	if len(cand.Education) > 0 {
+		y := p.sectionHeading(left, pdf.GetY()+8, 190, "EDUCATION")
+		y, _ = p.drawEducation(left, y, 190, 10, 0)
+		pdf.SetXY(left, y)
+	}
+	if len(cand.Certifications) > 0 {
+		y := p.sectionHeading(left, pdf.GetY()+8, 190, "CERTIFICATIONS")
+		y, _ = p.drawCertifications(left, y, 190, 10, 0)
+		pdf.SetXY(left, y)
+	}
+
+	// Add footer, with the QR code of the live profile at its far end
 	pdf.Ln(10)
-	pdf.SetFont("Arial", "I", 9)
+	if cand.ProfileURL != "" {
+		if _, margin := pdf.GetAutoPageBreak(); pdf.GetY()+qrSize+5 > pageHeight-margin {
+			pdf.AddPage()
+		}
+		qrX := left + 190 - qrSize
+		if opts.direction() == directionRTL {
+			qrX = left
+		}
+		top := pdf.GetY()
+		drawProfileQR(pdf, text, cand.ProfileURL, qrX, top)
+		pdf.SetXY(left, top)
+	}
+	generated := p.generatedLine()
+	footer := text.font("I", 9, generated)
 	pdf.SetTextColor(128, 128, 128)
-	pdf.Cell(190, 5, fmt.Sprintf("Generated on: %s", time.Now().Format("2006-01-02 15:04:05")))
+	pdf.CellFormat(190, 5, footer, "", 0, text.align(generated), false, 0, "")
+}
 
-	return pdf.OutputFileAndClose(outputPath)
+// validateResumeContent checks an inline resume, which replaces resume_url
+func (cand Candidate) validateResumeContent() error {
+	if len(cand.ResumeContent) == 0 {
+		if cand.ResumeFilename != "" {
+			return errors.New("resume_filename requires resume_content")
+		}
+		return nil
+	}
+	if cand.ResumeURL != "" {
+		return errors.New("resume_url and resume_content cannot both be set")
+	}
+	if maxSize := int64(appConfig.Download.MaxSize); maxSize > 0 && int64(len(cand.ResumeContent)) > maxSize {
+		return fmt.Errorf("%w: %s exceeds the limit of %s", errResumeTooLarge, formatBytes(int64(len(cand.ResumeContent))), formatBytes(maxSize))
+	}
+	return nil
 }
 
-func downloadFile(url, outputPath string) error {
-	log.Printf("Downloading file from URL: %s", url)
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return err
+// validateCustomFields checks the extra factsheet rows of a candidate
+func (cand Candidate) validateCustomFields() error {
+	if len(cand.CustomFields) > maxCustomFields {
+		return fmt.Errorf("at most %d custom_fields are allowed", maxCustomFields)
+	}
+	for _, field := range cand.CustomFields {
+		if strings.TrimSpace(field.Label) == "" {
+			return errors.New("custom_fields need a label")
+		}
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: HTTP %d", resp.StatusCode)
+// startConversion waits for a conversion slot and applies the conversion timeout. LibreOffice and the other
+// converters are memory hungry, only a few conversions may run at the same time. done releases the slot.
+func startConversion(ctx context.Context) (context.Context, func(), error) {
+	select {
+	case conversionSlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx, nil, ctx.Err()
 	}
+	ctx, cancel := withConversionTimeout(ctx)
+	return ctx, func() {
+		cancel()
+		<-conversionSlots
+	}, nil
+}
 
-	out, err := os.Create(outputPath)
-	if err != nil {
-		return err
+// processWaitDelay is how long an external tool that was killed or has exited may keep its output open
+const processWaitDelay = 10 * time.Second
+
+// withConversionTimeout bounds a run of an external tool by the conversion timeout, so a tool stuck on a
+// malformed document cannot hold up its candidate forever
+func withConversionTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := appConfig.Processing.ConversionTimeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
 	}
-	defer out.Close()
+	return ctx, func() {}
+}
 
-	_, err = io.Copy(out, resp.Body)
-	log.Printf("File downloaded successfully: %s", outputPath)
+// timeoutError reports a tool killed by the conversion timeout as such, its own error only says
+// "signal: killed"
+func timeoutError(ctx context.Context, tool string, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &codedError{errorCodeConversionTimeout,
+			fmt.Errorf("%s timed out after %s", tool, appConfig.Processing.ConversionTimeout)}
+	}
 	return err
 }
 
-func convertToPDF(inputPath, outputDir string) (string, error) {
-	log.Printf("Converting file to PDF: %s", inputPath)
-	cmd := exec.Command("libreoffice", "--headless", "--convert-to", "pdf", "--outdir", outputDir, inputPath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Stdout = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("%v: %s", err, stderr.String())
+// convertToPDF converts an office document with the configured conversion backend
+func convertToPDF(ctx context.Context, inputPath, outputDir string) (string, error) {
+	ctx, done, err := startConversion(ctx)
+	if err != nil {
+		return "", err
 	}
+	defer done()
 
-	outputFile := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)) + ".pdf"
-	outputPath := filepath.Join(outputDir, outputFile)
-	log.Printf("File converted to PDF: %s", outputPath)
+	logFrom(ctx).Debug().Msgf("Converting file to PDF: %s", inputPath)
+	outputPath, err := documentConverter.Convert(ctx, inputPath, outputDir)
+	if err != nil {
+		return "", timeoutError(ctx, "conversion", err)
+	}
+	logFrom(ctx).Debug().Msgf("File converted to PDF: %s", outputPath)
 	return outputPath, nil
 }
 
-func mergePDFs(pdf1, pdf2, outputPath string) error {
-	log.Printf("Merging PDFs: %s + %s -> %s", pdf1, pdf2, outputPath)
-	cmd := exec.Command("pdfunite", pdf1, pdf2, outputPath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("pdfunite failed: %v - %s", err, stderr.String())
+// mergePDFs puts the pages of inputs together, in order, into a PDF at outputPath. The merge runs in
+// process with pdfcpu and is bound by the conversion timeout like the external tools.
+func mergePDFs(ctx context.Context, outputPath string, inputs ...string) error {
+	logFrom(ctx).Debug().Msgf("Merging PDFs: %s -> %s", strings.Join(inputs, " + "), outputPath)
+	err := writePDFWithPDFCPU(ctx, "pdf merge", outputPath, func(tmp string) error {
+		return api.MergeCreateFile(inputs, tmp, false, pdfcpuConfig())
+	})
+	if err != nil {
+		return err
 	}
 
-	log.Printf("PDFs merged successfully: %s", outputPath)
+	logFrom(ctx).Debug().Msgf("PDFs merged successfully: %s", outputPath)
 	return nil
 }
 
-func zipFolder(sourceDir, zipPath string) error {
-	log.Printf("Creating zip file from directory: %s -> %s", sourceDir, zipPath)
+// zipFolder writes the files of sourceDir to a zip file at zipPath, only those of files unless it is nil
+func zipFolder(sourceDir, zipPath string, files map[string]bool) error {
+	logger.Debug().Msgf("Creating zip file from directory: %s -> %s", sourceDir, zipPath)
 	zipfile, err := os.Create(zipPath)
 	if err != nil {
 		return err
@@ -428,7 +1436,10 @@ func zipFolder(sourceDir, zipPath string) error {
 		}
 
 		relPath, _ := filepath.Rel(sourceDir, path)
-		zipEntry, err := archive.Create(relPath)
+		if files != nil && !files[filepath.ToSlash(relPath)] {
+			return nil
+		}
+		zipEntry, err := archive.Create(filepath.ToSlash(relPath))
 		if err != nil {
 			return err
 		}
@@ -446,6 +1457,6 @@ func zipFolder(sourceDir, zipPath string) error {
 		return err
 	})
 
-	log.Printf("Zip file created with %d files: %s", fileCount, zipPath)
+	logger.Debug().Msgf("Zip file created with %d files: %s", fileCount, zipPath)
 	return err
 }
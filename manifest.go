@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the manifest files at the root of the archive
+const (
+	manifestJSONName = "manifest.json"
+	manifestCSVName  = "manifest.csv"
+)
+
+// jobManifest describes the archive of a job for systems that import it
+type jobManifest struct {
+	JobID       string              `json:"job_id"`
+	TenantName  string              `json:"tenant_name"`
+	CompanyName string              `json:"company_name"`
+	CreatedAt   time.Time           `json:"created_at"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Candidates  []candidateManifest `json:"candidates"`
+	// Groups are the folders of the archive, in the order their first candidate was submitted
+	Groups []groupManifest `json:"groups,omitempty"`
+	// Parts are the files of an archive split with split_archive_mb, in order
+	Parts []string `json:"parts,omitempty"`
+}
+
+// groupManifest describes a folder of the archive and the candidates whose packets it holds
+type groupManifest struct {
+	Group  string `json:"group"`
+	Folder string `json:"folder"`
+	// Candidates are the positions of the group's candidates in the request and in candidates
+	Candidates []int `json:"candidates"`
+}
+
+// candidateManifest describes a candidate of the job and the packet made for them, if any
+type candidateManifest struct {
+	CandidateID string `json:"candidate_id,omitempty"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Group       string `json:"group,omitempty"`
+	Status      string `json:"status"`
+	// Source is the resume URL with its credentials redacted, or the filename of an inline resume
+	Source           string `json:"source"`
+	Format           string `json:"format,omitempty"`
+	Converter        string `json:"converter,omitempty"`
+	DownloadAttempts int    `json:"download_attempts,omitempty"`
+	File             string `json:"file,omitempty"`
+	Part             int    `json:"part,omitempty"`
+	Pages            int    `json:"pages,omitempty"`
+	Size             int64  `json:"size,omitempty"`
+	SHA256           string `json:"sha256,omitempty"`
+	// Documents are the resume and attachment files of jobs with output_mode separate, next to the packet
+	Documents []string `json:"documents,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	ErrorCode string   `json:"error_code,omitempty"`
+	// Failure is where and why the candidate failed, see CandidateProgress
+	Failure *CandidateFailure `json:"failure,omitempty"`
+}
+
+// buildManifest writes manifest.json to factsheetDir, and manifest.csv with a row per candidate when
+// the job asks for it
+func buildManifest(job *Job, req ProcessRequest, factsheetDir string) error {
+	job.mu.Lock()
+	progress, createdAt := append([]CandidateProgress(nil), job.Candidates...), job.CreatedAt
+	job.mu.Unlock()
+
+	manifest := jobManifest{
+		JobID:       job.ID,
+		TenantName:  req.TenantName,
+		CompanyName: req.CompanyName,
+		CreatedAt:   createdAt,
+		GeneratedAt: time.Now(),
+	}
+	fileNames := packetFileNames(job.ID, req)
+	for i, cand := range req.shownCandidates() {
+		entry := candidateManifest{CandidateID: cand.CandidateID, Name: cand.Name, Email: cand.Email, Group: cand.Group,
+			Status: candidateStatusPending}
+		if len(cand.ResumeContent) > 0 {
+			entry.Source = cand.ResumeFilename
+		} else {
+			entry.Source = redactURL(cand.ResumeURL)
+		}
+		if i < len(progress) {
+			p := progress[i]
+			entry.Status, entry.Error, entry.ErrorCode, entry.Failure = p.Status, p.Error, p.ErrorCode, p.Failure
+			entry.Format, entry.Converter, entry.DownloadAttempts = p.Format, p.Converter, p.DownloadAttempts
+		}
+
+		path := filepath.Join(factsheetDir, fileNames[i])
+		if info, err := os.Stat(path); err == nil {
+			entry.File, entry.Size = fileNames[i], info.Size()
+			if entry.SHA256, err = hashFile(path); err != nil {
+				return err
+			}
+			// Counting pages needs qpdf, the manifest goes out without them otherwise
+			if pages, err := pdfPageCount(context.Background(), path, req.userPassword()); err == nil {
+				entry.Pages = pages
+			} else {
+				job.log().Warn().Err(err).Msgf("Failed to count pages of %s for the manifest", entry.File)
+			}
+		}
+		if req.OutputMode == outputModeSeparate {
+			entry.Documents = existingFiles(factsheetDir, separateFileNames(fileNames[i], cand))
+		}
+		manifest.Candidates = append(manifest.Candidates, entry)
+	}
+	groups := map[string]int{}
+	for i, folder := range groupFolders(req.Candidates) {
+		if folder == "" {
+			continue
+		}
+		if _, ok := groups[folder]; !ok {
+			groups[folder] = len(manifest.Groups)
+			manifest.Groups = append(manifest.Groups, groupManifest{Group: req.Candidates[i].Group, Folder: folder})
+		}
+		group := &manifest.Groups[groups[folder]]
+		group.Candidates = append(group.Candidates, i)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(factsheetDir, manifestJSONName), data, 0600); err != nil {
+		return err
+	}
+	if req.ManifestCSV {
+		return writeManifestCSV(manifest.Candidates, filepath.Join(factsheetDir, manifestCSVName))
+	}
+	return nil
+}
+
+func writeManifestCSV(candidates []candidateManifest, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"candidate_id", "name", "email", "group", "status", "source", "format", "converter", "download_attempts", "file", "part",
+		"pages", "size", "sha256", "error", "error_code", "failure_stage", "failure_reason", "retryable"})
+	for _, c := range candidates {
+		var stage, reason, retryable string
+		if c.Failure != nil {
+			stage, reason, retryable = c.Failure.Stage, c.Failure.Reason, strconv.FormatBool(c.Failure.Retryable)
+		}
+		w.Write([]string{c.CandidateID, csvText(c.Name), csvText(c.Email), csvText(c.Group), c.Status, csvText(c.Source), c.Format, c.Converter,
+			strconv.Itoa(c.DownloadAttempts), c.File, csvPart(c.Part), strconv.Itoa(c.Pages), strconv.FormatInt(c.Size, 10), c.SHA256,
+			csvText(c.Error), c.ErrorCode, stage, reason, retryable})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// csvPart is the part column of manifest.csv, empty unless the archive is split
+func csvPart(part int) string {
+	if part == 0 {
+		return ""
+	}
+	return strconv.Itoa(part)
+}
+
+// csvText keeps spreadsheets from running text given by callers as a formula
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// markdownToHTML renders the Markdown found in resumes exported by ATS systems: headings, paragraphs,
+// nested lists, block quotes, code blocks, rules, emphasis and links. Raw HTML is shown as text rather than
+// interpreted, and links keep only http, https and mailto targets.
+func markdownToHTML(source string) string {
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+	var b strings.Builder
+	renderMarkdownBlocks(&b, lines)
+	return b.String()
+}
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdSetext      = regexp.MustCompile(`^(=+|-+)\s*$`)
+	mdRule        = regexp.MustCompile(`^(\*\s*){3,}$|^(-\s*){3,}$|^(_\s*){3,}$`)
+	mdFence       = regexp.MustCompile("^(```|~~~)")
+	mdListItem    = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	mdQuote       = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	mdImage       = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+&#34;[^)]*&#34;)?\)`)
+	mdAutolink    = regexp.MustCompile(`&lt;((?:https?://|mailto:)[^\s&]+)&gt;`)
+	mdStrong      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	mdEmphasis    = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*|\b_(\S(?:[^_]*?\S)?)_\b`)
+	mdCodeSpan    = regexp.MustCompile("`([^`]+)`")
+	mdPlaceholder = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+func renderMarkdownBlocks(b *strings.Builder, lines []string) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			fmt.Fprintf(b, "<p>%s</p>\n", renderInline(strings.Join(paragraph, "\n")))
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case mdFence.MatchString(trimmed):
+			flush()
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			fmt.Fprintf(b, "<pre><code>%s</code></pre>\n", html.EscapeString(strings.Join(code, "\n")))
+		case len(paragraph) > 0 && mdSetext.MatchString(trimmed):
+			level := 1
+			if trimmed[0] == '-' {
+				level = 2
+			}
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", level, renderInline(strings.Join(paragraph, " ")), level)
+			paragraph = nil
+		case mdRule.MatchString(trimmed):
+			flush()
+			b.WriteString("<hr>\n")
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", len(m[1]), renderInline(m[2]), len(m[1]))
+		case mdQuote.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, mdQuote.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+		case mdListItem.MatchString(line):
+			flush()
+			ordered := listOrdered(line)
+			end := i + 1
+			for end < len(lines) {
+				next := lines[end]
+				if strings.TrimSpace(next) == "" {
+					// A blank line ends the list unless another item or an indented line follows
+					if end+1 < len(lines) && (mdListItem.MatchString(lines[end+1]) || indentation(lines[end+1]) > 0) {
+						end++
+						continue
+					}
+					break
+				}
+				if indentation(next) == 0 && (!mdListItem.MatchString(next) || listOrdered(next) != ordered) {
+					break
+				}
+				end++
+			}
+			renderMarkdownList(b, lines[i:end])
+			i = end - 1
+		default:
+			paragraph = append(paragraph, trimmed)
+			if strings.HasSuffix(lines[i], "  ") {
+				paragraph[len(paragraph)-1] += "\x01"
+			}
+		}
+	}
+	flush()
+}
+
+// renderMarkdownList renders list items, more deeply indented items become a nested list of the item
+// before them
+func renderMarkdownList(b *strings.Builder, lines []string) {
+	first := mdListItem.FindStringSubmatch(lines[0])
+	indent := len(first[1])
+	tag := "ul"
+	if listOrdered(lines[0]) {
+		tag = "ol"
+	}
+
+	fmt.Fprintf(b, "<%s>\n", tag)
+	for i := 0; i < len(lines); {
+		m := mdListItem.FindStringSubmatch(lines[i])
+		if m == nil {
+			i++
+			continue
+		}
+		text := []string{strings.TrimSpace(m[3])}
+		i++
+		var nested []string
+		for ; i < len(lines); i++ {
+			next := mdListItem.FindStringSubmatch(lines[i])
+			if next != nil && len(next[1]) <= indent {
+				break
+			}
+			if next != nil || len(nested) > 0 {
+				nested = append(nested, lines[i])
+			} else if trimmed := strings.TrimSpace(lines[i]); trimmed != "" {
+				text = append(text, trimmed)
+			}
+		}
+		fmt.Fprintf(b, "<li>%s", renderInline(strings.Join(text, "\n")))
+		if len(nested) > 0 {
+			b.WriteString("\n")
+			renderMarkdownList(b, nested)
+		}
+		b.WriteString("</li>\n")
+	}
+	fmt.Fprintf(b, "</%s>\n", tag)
+}
+
+// listOrdered reports whether a list item line is numbered
+func listOrdered(line string) bool {
+	m := mdListItem.FindStringSubmatch(line)
+	return m != nil && !strings.ContainsAny(m[2], "-*+")
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// renderInline renders emphasis, code spans and links of a line. Code spans are set aside first so their
+// content is not formatted.
+func renderInline(text string) string {
+	var spans []string
+	text = mdCodeSpan.ReplaceAllStringFunc(text, func(span string) string {
+		spans = append(spans, "<code>"+html.EscapeString(span[1:len(span)-1])+"</code>")
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+
+	text = html.EscapeString(text)
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllStringFunc(text, func(link string) string {
+		m := mdLink.FindStringSubmatch(link)
+		if !safeLinkTarget(m[2]) {
+			return m[1]
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, m[2], m[1])
+	})
+	text = mdAutolink.ReplaceAllString(text, `<a href="$1">$1</a>`)
+	text = mdStrong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = mdEmphasis.ReplaceAllString(text, "<em>$1$2</em>")
+	text = strings.ReplaceAll(text, "\x01\n", "<br>\n")
+	text = strings.ReplaceAll(text, "\x01", "")
+
+	return mdPlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		var index int
+		fmt.Sscanf(strings.Trim(placeholder, "\x00"), "%d", &index)
+		return spans[index]
+	})
+}
+
+func safeLinkTarget(target string) bool {
+	lower := strings.ToLower(html.UnescapeString(target))
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:")
+}
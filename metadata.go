@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// pdfCreator names the service in the metadata of the PDFs it generates
+const pdfCreator = "factsheet-maker"
+
+// jobInfo identifies the job a candidate belongs to
+type jobInfo struct {
+	ID      string
+	Tenant  string
+	Company string
+}
+
+// pdfInfo is the document information of a PDF, which document management systems index
+type pdfInfo struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+}
+
+// candidateInfo is the document information of a candidate's factsheet and packet
+func candidateInfo(job jobInfo, cand Candidate) pdfInfo {
+	subject := "Candidate factsheet"
+	if job.Company != "" {
+		subject += " for " + job.Company
+	}
+	return pdfInfo{
+		Title:    "Candidate Factsheet - " + cand.Name,
+		Author:   job.Tenant,
+		Subject:  subject,
+		Keywords: pdfKeywords(job.Tenant, job.Company, cand.Name, job.ID),
+	}
+}
+
+// batchInfo is the document information of the files covering the whole job, e.g. the index
+func batchInfo(job jobInfo, title string) pdfInfo {
+	if job.Company != "" {
+		title += " - " + job.Company
+	}
+	return pdfInfo{
+		Title:    title,
+		Author:   job.Tenant,
+		Subject:  "Candidates of job " + job.ID,
+		Keywords: pdfKeywords(job.Tenant, job.Company, job.ID),
+	}
+}
+
+// pdfKeywords joins the non-empty keywords
+func pdfKeywords(keywords ...string) string {
+	var kept []string
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			kept = append(kept, keyword)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+// apply sets the document information of a PDF being drawn
+func (i pdfInfo) apply(pdf *gofpdf.Fpdf) {
+	pdf.SetTitle(i.Title, true)
+	pdf.SetAuthor(i.Author, true)
+	pdf.SetSubject(i.Subject, true)
+	pdf.SetKeywords(i.Keywords, true)
+	pdf.SetCreator(pdfCreator, true)
+}
+
+// setPDFInfo replaces the document information of an existing PDF, e.g. a packet merged from
+// several, keeping entries like the producer that it does not set
+func setPDFInfo(ctx context.Context, pdfPath string, info pdfInfo) error {
+	doc, err := readQPDFDocument(ctx, pdfPath)
+	if err != nil {
+		return err
+	}
+	dict := map[string]any{}
+	trailer := doc.objects["trailer"]
+	infoRef, _ := trailer["/Info"].(string)
+	if existing, err := doc.object(infoRef); infoRef != "" && err == nil {
+		dict = existing
+	} else {
+		infoRef = doc.newObject()
+	}
+	for key, value := range map[string]string{
+		"/Title":    info.Title,
+		"/Author":   info.Author,
+		"/Subject":  info.Subject,
+		"/Keywords": info.Keywords,
+		"/Creator":  pdfCreator,
+	} {
+		if value != "" {
+			dict[key] = "u:" + value
+		}
+	}
+	trailer["/Info"] = infoRef
+	return doc.update(ctx, pdfPath, map[string]any{
+		"obj:" + infoRef: map[string]any{"value": dict},
+		"trailer":        map[string]any{"value": trailer},
+	})
+}
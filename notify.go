@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxNotifiedErrors keeps notifications of large failed jobs readable, the job status has all errors
+const maxNotifiedErrors = 5
+
+// NotificationSettings are the chat channels told when a job finishes. Slack takes an incoming webhook URL,
+// Teams the URL of a channel's incoming webhook or of a Workflows "post to a channel" webhook.
+type NotificationSettings struct {
+	SlackWebhookURL string `yaml:"slack_webhook_url" json:"slack_webhook_url,omitempty" env:"SLACK_WEBHOOK_URL"`
+	TeamsWebhookURL string `yaml:"teams_webhook_url" json:"teams_webhook_url,omitempty" env:"TEAMS_WEBHOOK_URL"`
+}
+
+func (n *NotificationSettings) validate() error {
+	for name, value := range map[string]string{"slack_webhook_url": n.SlackWebhookURL, "teams_webhook_url": n.TeamsWebhookURL} {
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%s must be an https URL", name)
+		}
+	}
+	return nil
+}
+
+// redacted returns the settings with the webhook URLs cut down to scheme and host, for logs and the audit log
+func (n *NotificationSettings) redacted() *NotificationSettings {
+	r := *n
+	if r.SlackWebhookURL != "" {
+		r.SlackWebhookURL = redactSecretURL(r.SlackWebhookURL)
+	}
+	if r.TeamsWebhookURL != "" {
+		r.TeamsWebhookURL = redactSecretURL(r.TeamsWebhookURL)
+	}
+	return &r
+}
+
+// webhookGuard keeps webhooks set through the tenant API off the private network, like resume downloads
+var webhookGuard, _ = DownloadPolicy{AllowedSchemes: []string{"https"}}.compile()
+
+// jobNotification is what a notification says about a finished job
+type jobNotification struct {
+	title       string
+	status      string
+	summary     string
+	errors      []string
+	downloadURL string
+}
+
+// notifyJobDone posts the outcome of a finished job to the Slack and Teams webhooks of its tenant, or the
+// configured ones when the tenant has none. Failures are logged, they do not change the job.
+func notifyJobDone(job *Job, req ProcessRequest) {
+	settings, custom := tenants.notifications(req.TenantName)
+	if settings.SlackWebhookURL == "" && settings.TeamsWebhookURL == "" {
+		return
+	}
+	client := &http.Client{Timeout: appConfig.Notifications.Timeout}
+	if custom {
+		client = webhookGuard.client(appConfig.Notifications.Timeout)
+	}
+
+	n := newJobNotification(job, req)
+	if settings.SlackWebhookURL != "" {
+		if err := postWebhook(client, settings.SlackWebhookURL, n.slack()); err != nil {
+			job.log().Error().Err(err).Msgf("Error notifying Slack of job %s", job.ID)
+		}
+	}
+	if settings.TeamsWebhookURL != "" {
+		if err := postWebhook(client, settings.TeamsWebhookURL, n.teams()); err != nil {
+			job.log().Error().Err(err).Msgf("Error notifying Teams of job %s", job.ID)
+		}
+	}
+}
+
+func newJobNotification(job *Job, req ProcessRequest) jobNotification {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	failed := 0
+	for _, cand := range job.Candidates {
+		if cand.Status == candidateStatusFailed || cand.Status == candidateStatusTimedOut {
+			failed++
+		}
+	}
+	n := jobNotification{
+		title:   fmt.Sprintf("Factsheets for %s (%s)", req.CompanyName, req.TenantName),
+		status:  strings.ReplaceAll(job.Status, "_", " "),
+		summary: fmt.Sprintf("%d of %d candidates processed, %d failed", job.SuccessCount, len(job.Candidates), failed),
+	}
+	n.errors = job.Errors[:min(len(job.Errors), maxNotifiedErrors)]
+	if more := len(job.Errors) - len(n.errors); more > 0 {
+		n.errors = append(n.errors[:len(n.errors):len(n.errors)], fmt.Sprintf("and %d more", more))
+	}
+
+	switch {
+	case job.Delivery != nil && job.Delivery.URL != "":
+		n.downloadURL = job.Delivery.URL
+	case job.ZipPath != "" && job.publicBaseURL() != "":
+		n.downloadURL = publicDownloadURL(job.publicBaseURL(), job.ID, 0)
+	}
+	return n
+}
+
+// slack is the payload of a Slack incoming webhook
+func (n jobNotification) slack() any {
+	var text strings.Builder
+	fmt.Fprintf(&text, "*%s* %s\n%s", slackEscape(n.title), n.status, n.summary)
+	for _, err := range n.errors {
+		fmt.Fprintf(&text, "\n• %s", slackEscape(err))
+	}
+	if n.downloadURL != "" {
+		fmt.Fprintf(&text, "\n<%s|Download the archive>", n.downloadURL)
+	}
+	return map[string]any{"text": text.String()}
+}
+
+// slackEscape keeps Slack from reading text given by callers as links or mentions
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// teams is the payload of a Teams webhook, an adaptive card
+func (n jobNotification) teams() any {
+	body := []map[string]any{
+		{"type": "TextBlock", "text": n.title, "weight": "Bolder", "size": "Medium", "wrap": true},
+		{"type": "TextBlock", "text": n.status + ": " + n.summary, "wrap": true},
+	}
+	for _, err := range n.errors {
+		body = append(body, map[string]any{"type": "TextBlock", "text": "- " + err, "wrap": true, "spacing": "None"})
+	}
+	card := map[string]any{
+		"type":    "AdaptiveCard",
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"version": "1.4",
+		"body":    body,
+	}
+	if n.downloadURL != "" {
+		card["actions"] = []map[string]any{{"type": "Action.OpenUrl", "title": "Download the archive", "url": n.downloadURL}}
+	}
+	return map[string]any{
+		"type":        "message",
+		"attachments": []map[string]any{{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	}
+}
+
+// postWebhook sends the payload as JSON and expects a 2xx response
+func postWebhook(client *http.Client, webhookURL string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error names the URL, which must not end up in the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactSecretURL(webhookURL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// publicBaseURL is where people reach this service to download the job's archive: server.public_url, or
+// where the job was submitted through a trusted proxy
+func (j *Job) publicBaseURL() string {
+	return cmp.Or(appConfig.Server.PublicURL, j.BaseURL)
+}
+
+// publicDownloadURL is the link to the job's archive, or a part of it, at baseURL
+func publicDownloadURL(baseURL, jobID string, part int) string {
+	link := strings.TrimSuffix(baseURL, "/") + apiBasePath + "/jobs/" + jobID + "/download"
+	if part > 0 {
+		link += "?part=" + strconv.Itoa(part)
+	}
+	return link
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ocrLanguagePattern matches Tesseract language names such as eng, deu or chi_sim
+var ocrLanguagePattern = regexp.MustCompile(`^[A-Za-z_]{3,20}$`)
+
+// ocrLanguages are the languages the job's resumes are recognized in
+func (o JobOptions) ocrLanguages() []string {
+	if len(o.OCRLanguages) > 0 {
+		return o.OCRLanguages
+	}
+	return appConfig.Processing.OCRLanguages
+}
+
+// ocrResume adds an invisible text layer to the pages of a PDF that have no text, so scanned and
+// photographed resumes become searchable in the final factsheet. Pages with text are left as they are.
+// OCR only improves the resume, when it fails the PDF is used without it.
+func ocrResume(ctx context.Context, pdfPath string, languages []string) string {
+	outputPath := filepath.Join(filepath.Dir(pdfPath), "resume-ocr.pdf")
+	if err := runOCR(ctx, pdfPath, outputPath, languages); err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("OCR of %s failed, using it without a text layer", pdfPath)
+		return pdfPath
+	}
+	logFrom(ctx).Debug().Msgf("Added text layer to %s", pdfPath)
+	return outputPath
+}
+
+func runOCR(ctx context.Context, inputPath, outputPath string, languages []string) error {
+	// OCR is as heavy as a LibreOffice conversion and shares its limit
+	ctx, done, err := startConversion(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// The output stays a plain PDF, PDF/A would need Ghostscript and rewrites the whole file
+	cmd := exec.CommandContext(ctx, "ocrmypdf", "--skip-text", "--output-type", "pdf", "--jobs", "1",
+		"-l", strings.Join(languages, "+"), inputPath, outputPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return timeoutError(ctx, "ocr", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Qualities of the optimization pass, by the resolution images are downsampled to
+const (
+	optimizeLow    = "low"
+	optimizeMedium = "medium"
+	optimizeHigh   = "high"
+)
+
+// ghostscriptSettings are the pdfwrite presets of each quality: 72, 150 and 300 dpi images
+var ghostscriptSettings = map[string]string{
+	optimizeLow:    "/screen",
+	optimizeMedium: "/ebook",
+	optimizeHigh:   "/printer",
+}
+
+// optimizePDF shrinks a packet in place: Ghostscript downsamples and recompresses its images to the
+// quality, then qpdf packs its objects into compressed object streams. Optimizing only saves space, when
+// it fails or makes the file larger the packet is kept as it was.
+func optimizePDF(ctx context.Context, pdfPath, quality string) {
+	before, err := os.Stat(pdfPath)
+	if err != nil {
+		return
+	}
+	optimizedPath := pdfPath + ".optimized"
+	defer os.Remove(optimizedPath)
+	if err := runGhostscript(ctx, pdfPath, optimizedPath, quality); err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("Optimizing %s failed, keeping it as it is", pdfPath)
+		return
+	}
+	if err := compressObjects(ctx, optimizedPath); err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("Compressing the objects of %s failed", pdfPath)
+	}
+
+	after, err := os.Stat(optimizedPath)
+	if err != nil || after.Size() >= before.Size() {
+		logFrom(ctx).Debug().Msgf("Optimizing %s saved nothing, keeping it as it is", pdfPath)
+		return
+	}
+	if err := os.Rename(optimizedPath, pdfPath); err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("Failed to replace %s with its optimized version", pdfPath)
+		return
+	}
+	logFrom(ctx).Debug().Msgf("Optimized %s from %s to %s", pdfPath, formatBytes(before.Size()), formatBytes(after.Size()))
+}
+
+func runGhostscript(ctx context.Context, inputPath, outputPath, quality string) error {
+	// Rewriting every page is as heavy as a LibreOffice conversion and shares its limit
+	ctx, done, err := startConversion(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	cmd := exec.CommandContext(ctx, "gs", "-q", "-dSAFER", "-dBATCH", "-dNOPAUSE", "-sDEVICE=pdfwrite",
+		"-dCompatibilityLevel=1.7", "-dPDFSETTINGS="+ghostscriptSettings[quality], "-dDetectDuplicateImages=true",
+		"-sOutputFile="+outputPath, inputPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("ghostscript is not installed")
+		}
+		return timeoutError(ctx, "ghostscript", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return nil
+}
+
+// compressObjects rewrites a PDF in place with compressed object streams and every stream recompressed
+func compressObjects(ctx context.Context, pdfPath string) error {
+	outputPath := pdfPath + ".compressed"
+	if err := runQPDF(ctx, "", "--object-streams=generate", "--compress-streams=y", "--recompress-flate",
+		"--compression-level=9", pdfPath, outputPath); err != nil {
+		os.Remove(outputPath)
+		return qpdfFailed(err, pdfPath)
+	}
+	return os.Rename(outputPath, pdfPath)
+}
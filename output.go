@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Output modes of a job, deciding what goes into the archive for each candidate
+const (
+	// outputModeMerged puts the factsheet, resume and attachments of a candidate into one packet
+	outputModeMerged = "merged"
+	// outputModeFactsheetOnly makes the factsheet alone, without downloading or converting anything
+	outputModeFactsheetOnly = "factsheet_only"
+	// outputModeSeparate delivers the resume and every attachment as PDFs of their own next to the factsheet
+	outputModeSeparate = "separate"
+)
+
+// Merge orders of a packet, the factsheet first unless the job asks otherwise
+const (
+	mergeOrderFactsheetFirst = "factsheet_first"
+	mergeOrderResumeFirst    = "resume_first"
+)
+
+func validateMergeOrder(order string) error {
+	if order != "" && order != mergeOrderFactsheetFirst && order != mergeOrderResumeFirst {
+		return fmt.Errorf("merge_order must be %s or %s", mergeOrderFactsheetFirst, mergeOrderResumeFirst)
+	}
+	return nil
+}
+
+func validateOutputMode(mode string) error {
+	if mode != "" && mode != outputModeMerged && mode != outputModeFactsheetOnly && mode != outputModeSeparate {
+		return fmt.Errorf("output_mode must be %s, %s or %s", outputModeMerged, outputModeFactsheetOnly, outputModeSeparate)
+	}
+	return nil
+}
+
+// separateFileNames are the files the resume and then each attachment of a candidate get in separate output
+// mode, named after the candidate's packet: <packet>_resume.pdf, <packet>_attachment_1.pdf and so on
+func separateFileNames(fileName string, cand Candidate) []string {
+	base := strings.TrimSuffix(fileName, ".pdf")
+	names := []string{base + "_resume.pdf"}
+	for i := range cand.Attachments {
+		names = append(names, fmt.Sprintf("%s_attachment_%d.pdf", base, i+1))
+	}
+	return names
+}
+
+// writeSeparateDocuments copies the resume and attachment PDFs of a candidate into factsheetDir under their
+// separate file names, stamped like the packet
+func writeSeparateDocuments(ctx context.Context, job jobInfo, opts JobOptions, cand Candidate, fileName, factsheetDir, candTempDir string, pdfs []string) error {
+	for i, name := range separateFileNames(fileName, cand) {
+		work := filepath.Join(candTempDir, fmt.Sprintf("separate-%d.pdf", i))
+		if err := copyFile(pdfs[i], work); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+		if err := finishPDF(ctx, job, opts, cand, work, candTempDir); err != nil {
+			return err
+		}
+		if err := sealInto(work, filepath.Join(factsheetDir, name)); err != nil {
+			return fmt.Errorf("failed to move %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// existingFiles are those of names, paths relative to dir, that were written
+func existingFiles(dir string, names []string) []string {
+	var files []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// processSingleCandidate runs the full pipeline for the one candidate of a job request and sends its packet
+// back as the response, for download buttons that cannot wait on a job. Archive and delivery options do not
+// apply, the packet's own ones, such as the watermark, cover page and encryption, do.
+func processSingleCandidate(c *gin.Context) {
+	var req ProcessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestLog(c).Warn().Err(err).Msg("Error binding JSON")
+		invalidBody("Invalid input", err).abort(c)
+		return
+	}
+	if len(req.Candidates) > 1 {
+		message := fmt.Sprintf("exactly one candidate is processed here, got %d; submit a job for more", len(req.Candidates))
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "candidates: "+message).field("candidates", message).abort(c)
+		return
+	}
+	if req.OutputMode == outputModeSeparate {
+		message := "separate output makes more than one file; submit a job for it"
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "output_mode: "+message).field("output_mode", message).abort(c)
+		return
+	}
+	warnings, ok := prepareRequest(c, &req)
+	if !ok {
+		return
+	}
+	for _, warning := range warnings {
+		requestLog(c).Warn().Msgf("Single candidate request for tenant %s: %s", req.TenantName, warning)
+	}
+
+	if shuttingDown.Load() {
+		abortWithError(c, http.StatusServiceUnavailable, errCodeShuttingDown, "service is shutting down")
+		return
+	}
+	if err := checkDiskSpace(req.TenantName); err != nil {
+		requestLog(c).Warn().Err(err).Msgf("Rejecting single candidate request for tenant %s", req.TenantName)
+		c.Header("Retry-After", retryAfterSeconds(diskFullRetryAfter))
+		abortWithError(c, http.StatusServiceUnavailable, errCodeInsufficientStorage, "insufficient disk space, try again later")
+		return
+	}
+	// A packet counts against the tenant's job rate like a job of one candidate
+	if ok, wait := tenants.allowJob(req.TenantName); !ok {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		abortWithError(c, http.StatusTooManyRequests, errCodeRateLimited, "job rate limit exceeded for tenant "+req.TenantName)
+		return
+	}
+	if !admitJob() {
+		abortWithError(c, http.StatusServiceUnavailable, errCodeShuttingDown, "service is shutting down")
+		return
+	}
+	defer activeJobs.Done()
+
+	// The candidate waits for the tenant and the worker pool like those of jobs, until the caller gives up
+	ctx := c.Request.Context()
+	release, err := tenants.acquire(ctx, req.TenantName)
+	if err != nil {
+		if ctx.Err() != nil {
+			// The caller is gone, there is nobody to answer
+			return
+		}
+		// The tenant's candidate rate cannot take the candidate, e.g. not before the caller's deadline
+		requestLog(c).Warn().Err(err).Msgf("Rejecting single candidate request for tenant %s", req.TenantName)
+		abortWithError(c, http.StatusServiceUnavailable, errCodeTenantBusy, "tenant "+req.TenantName+" is at its candidate limit, try again later")
+		return
+	}
+	defer release()
+	select {
+	case candidateSlots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-candidateSlots }()
+
+	id := "packet-" + uuid.New().String()
+	cand := req.Candidates[0]
+	audit.recordRequest(c, auditPacketGenerated, req.TenantName, "", gin.H{
+		"company":      req.CompanyName,
+		"candidate_id": cand.CandidateID,
+		"name":         cand.Name,
+		"email":        cand.Email,
+		"urls":         cand.fetchedURLs(),
+	})
+
+	baseDir, factsheetDir, tempDir := jobDirs(req.TenantName, id)
+	os.MkdirAll(factsheetDir, 0700)
+	os.MkdirAll(tempDir, 0700)
+	defer cleanupJobDir(id, baseDir)
+
+	fileName := packetFileNames(id, req)[0]
+	l := jobLogger(id, req.TenantName, requestID(c)).Logger()
+	ctx = l.WithContext(ctx)
+	result, err := processCandidate(ctx, jobInfo{id, req.TenantName, req.CompanyName}, req.JobOptions, cand, fileName, factsheetDir, tempDir)
+	if err == nil {
+		err = finishPacket(ctx, req.JobOptions, factsheetDir)
+	}
+	if err != nil {
+		if ctx.Err() != nil && errorCode(err) == "" {
+			// The caller is gone, there is nobody to answer
+			return
+		}
+		e := apiError(http.StatusUnprocessableEntity, errCodeCandidateFailed, err.Error())
+		if code := errorCode(err); code != "" {
+			e.detail("error_code", code)
+		}
+		if result.Failure == nil {
+			result.Failure = describeFailure(stageAssembly, err)
+		}
+		e.detail("failure", result.Failure)
+		e.abort(c)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(factsheetDir, fileName))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to read packet")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to read packet")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, info.Size(), "application/pdf", file, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", filepath.Base(fileName)),
+	})
+}
+
+// finishPacket decrypts a single packet sealed at rest, and encrypts or linearizes it as packageJob does the
+// PDFs of an archive
+func finishPacket(ctx context.Context, opts JobOptions, factsheetDir string) error {
+	if err := unsealDir(factsheetDir); err != nil {
+		return fmt.Errorf("failed to decrypt the packet: %w", err)
+	}
+	if opts.Encryption != nil {
+		if err := encryptOutputs(ctx, factsheetDir, opts.Encryption, opts.Linearize); err != nil {
+			return fmt.Errorf("failed to encrypt the packet: %w", err)
+		}
+	} else if opts.Linearize {
+		linearizeOutputs(ctx, factsheetDir)
+	}
+	return nil
+}
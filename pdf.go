@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Error codes reported with failed candidates, for failures the recruiter has to act on
+const (
+	errorCodeResumeCorrupt     = "resume_corrupt"
+	errorCodeResumeEncrypted   = "resume_encrypted"
+	errorCodeResumePassword    = "resume_password_invalid"
+	errorCodeConversionTimeout = "conversion_timeout"
+	errorCodeCandidateTimeout  = "candidate_timeout"
+)
+
+// pdfcpuConfig is the configuration of the PDF operations run in process with pdfcpu. pdfcpu reads no
+// configuration directory of its own and adds no bookmarks to merged files, qpdf writes the service's.
+func pdfcpuConfig() *model.Configuration {
+	api.DisableConfigDir()
+	conf := model.NewDefaultConfiguration()
+	conf.CreateBookmarks = false
+	return conf
+}
+
+// runPDFCPU runs a pdfcpu operation, turning a panic on a malformed PDF into an error of the candidate
+// instead of the end of the service
+func runPDFCPU(op func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("pdfcpu: %v", r)
+		}
+	}()
+	return op()
+}
+
+// writePDFWithPDFCPU runs a pdfcpu operation that writes a PDF to the path it is given, and moves the
+// PDF to outputPath once it is complete. The operation is bound by the conversion timeout like the
+// external tools, but pdfcpu cannot be interrupted: one that runs out of time finishes into a file nobody
+// reads.
+func writePDFWithPDFCPU(ctx context.Context, what, outputPath string, op func(tmp string) error) error {
+	ctx, cancel := withConversionTimeout(ctx)
+	defer cancel()
+
+	tmp := filepath.Join(filepath.Dir(outputPath), ".pdfcpu-"+filepath.Base(outputPath))
+	done := make(chan error, 1)
+	go func() {
+		err := runPDFCPU(func() error { return op(tmp) })
+		if err != nil || ctx.Err() != nil {
+			os.Remove(tmp)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", what, err)
+		}
+	case <-ctx.Done():
+		return timeoutError(ctx, what, ctx.Err())
+	}
+	if err := os.Rename(tmp, outputPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// codedError classifies a candidate failure with a stable code, reported next to the message
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// errorCode returns the code of a candidate failure, empty for unclassified errors
+func errorCode(err error) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return ""
+}
+
+var (
+	errResumeEncrypted = &codedError{errorCodeResumeEncrypted, errors.New("resume is password protected, set resume_password to open it")}
+	errResumePassword  = &codedError{errorCodeResumePassword, errors.New("resume_password does not open the resume")}
+)
+
+// pdfEncrypted reports whether a PDF has an /Encrypt entry in its trailer. The trailer dictionary is
+// never compressed, even in files with cross-reference streams, so a plain scan finds it.
+func pdfEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	marker := []byte("/Encrypt")
+	buf := make([]byte, 64<<10)
+	var carry []byte
+	for {
+		n, err := f.Read(buf)
+		chunk := append(carry, buf[:n]...)
+		if bytes.Contains(chunk, marker) {
+			return true, nil
+		}
+		// Keep the end of the chunk in case the marker straddles two reads
+		carry = append(carry[:0], chunk[max(0, len(chunk)-len(marker)):]...)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// unlockPDF removes the encryption of a PDF, so it can be merged and searched. PDFs restricted only by an
+// owner password open without one; anything else needs the candidate's resume_password.
+func unlockPDF(ctx context.Context, pdfPath, password string) (string, error) {
+	encrypted, err := pdfEncrypted(pdfPath)
+	if err != nil {
+		return "", err
+	}
+	if !encrypted {
+		if password != "" {
+			logFrom(ctx).Warn().Msgf("Ignoring resume_password, %s is not encrypted", pdfPath)
+		}
+		return pdfPath, nil
+	}
+
+	outputPath := filepath.Join(filepath.Dir(pdfPath), "resume-decrypted.pdf")
+	// The password goes through stdin, command lines are visible to every user of the host
+	if err := runQPDF(ctx, password+"\n", "--password-file=-", "--decrypt", pdfPath, outputPath); err != nil {
+		var failure *qpdfFailure
+		switch {
+		case !errors.As(err, &failure):
+			return "", err
+		case strings.Contains(failure.stderr, "invalid password"):
+			if password == "" {
+				return "", errResumeEncrypted
+			}
+			return "", errResumePassword
+		default:
+			return "", fmt.Errorf("failed to decrypt resume: %v: %s", failure.err, strings.TrimSpace(failure.stderr))
+		}
+	}
+	logFrom(ctx).Debug().Msgf("Decrypted resume %s", pdfPath)
+	return outputPath, nil
+}
+
+// repairPDF checks the structure of a PDF with qpdf and rewrites damaged files, which qpdf reconstructs
+// where it can, e.g. a broken cross-reference table or a truncated trailer. The merge gives up on such
+// files. PDFs beyond repair fail with errorCodeResumeCorrupt.
+func repairPDF(ctx context.Context, pdfPath string) (string, error) {
+	ctx, cancel := withConversionTimeout(ctx)
+	defer cancel()
+	check := exec.CommandContext(ctx, "qpdf", "--check", pdfPath)
+	killProcessGroupOnCancel(check)
+	var checkOutput bytes.Buffer
+	check.Stdout = &checkOutput
+	check.Stderr = &checkOutput
+	err := check.Run()
+	if err == nil {
+		return pdfPath, nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		qpdfMissing.Do(func() { logFrom(ctx).Warn().Msg("qpdf is not installed, resume PDFs are merged without validation") })
+		return pdfPath, nil
+	}
+	if ctx.Err() != nil {
+		return "", timeoutError(ctx, "qpdf", ctx.Err())
+	}
+
+	logFrom(ctx).Warn().Msgf("Resume %s is damaged, repairing: %s", pdfPath, qpdfMessage(checkOutput.String(), pdfPath))
+	outputPath := filepath.Join(filepath.Dir(pdfPath), "resume-repaired.pdf")
+	// qpdf recovers what it can and warns about the rest
+	if err := runQPDF(ctx, "", pdfPath, outputPath); err != nil {
+		var failure *qpdfFailure
+		if !errors.As(err, &failure) {
+			return "", err
+		}
+		return "", &codedError{errorCodeResumeCorrupt,
+			fmt.Errorf("resume is a damaged PDF that cannot be repaired: %s", failure.message(pdfPath))}
+	}
+	logFrom(ctx).Debug().Msgf("Repaired resume %s", pdfPath)
+	return outputPath, nil
+}
+
+// qpdfMissing logs the missing validation only once
+var qpdfMissing sync.Once
+
+// qpdfMessage is the first line qpdf printed, without the path of the file it prefixes messages with
+func qpdfMessage(output, pdfPath string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	return strings.TrimPrefix(strings.TrimPrefix(line, "qpdf: "), pdfPath+": ")
+}
+
+// qpdfFailure is a run of qpdf that did not write its output, with what qpdf printed
+type qpdfFailure struct {
+	err    error
+	stderr string
+}
+
+func (f *qpdfFailure) Error() string {
+	return fmt.Sprintf("qpdf failed: %v: %s", f.err, strings.TrimSpace(f.stderr))
+}
+
+func (f *qpdfFailure) Unwrap() error {
+	return f.err
+}
+
+// message is the first line qpdf printed about pdfPath
+func (f *qpdfFailure) message(pdfPath string) string {
+	return qpdfMessage(f.stderr, pdfPath)
+}
+
+// runQPDF runs qpdf within the conversion timeout, with stdin as its input unless empty. Exit status 3 means
+// qpdf wrote its output with warnings, which is not a failure. Runs that time out return a
+// conversion_timeout error, other failures a *qpdfFailure.
+func runQPDF(ctx context.Context, stdin string, args ...string) error {
+	ctx, cancel := withConversionTimeout(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "qpdf", args...)
+	killProcessGroupOnCancel(cmd)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil, errors.As(err, &exitErr) && exitErr.ExitCode() == 3:
+		return nil
+	case ctx.Err() != nil:
+		return timeoutError(ctx, "qpdf", ctx.Err())
+	}
+	return &qpdfFailure{err, stderr.String()}
+}
+
+// qpdfFailed describes a failed run of qpdf on pdfPath by the first line qpdf printed
+func qpdfFailed(err error, pdfPath string) error {
+	var failure *qpdfFailure
+	if errors.As(err, &failure) {
+		return fmt.Errorf("qpdf failed: %v: %s", failure.err, failure.message(pdfPath))
+	}
+	return err
+}
+
+// rewritePDF runs a PDF through qpdf in place with options such as --linearize. The options go through
+// stdin as an argument file, command lines are visible to every user of the host and may hold passwords.
+func rewritePDF(ctx context.Context, pdfPath string, options []string) error {
+	outputPath := pdfPath + ".rewritten"
+	if err := runQPDF(ctx, strings.Join(options, "\n")+"\n", "@-", pdfPath, outputPath); err != nil {
+		os.Remove(outputPath)
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("qpdf is not installed")
+		}
+		return qpdfFailed(err, pdfPath)
+	}
+	return os.Rename(outputPath, pdfPath)
+}
+
+// outputPDFs are the PDFs of the archive in factsheetDir, at its root and in the folders of groups
+func outputPDFs(factsheetDir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(factsheetDir, "*.pdf"))
+	if err != nil {
+		return nil, err
+	}
+	grouped, err := filepath.Glob(filepath.Join(factsheetDir, "*", "*.pdf"))
+	return append(paths, grouped...), err
+}
+
+// linearizeOutputs linearizes every PDF of factsheetDir for fast web view, so browsers show
+// the first page before the whole file is downloaded. PDFs that cannot be linearized are kept as they are.
+func linearizeOutputs(ctx context.Context, factsheetDir string) {
+	paths, err := outputPDFs(factsheetDir)
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		if err := rewritePDF(ctx, path, []string{"--linearize"}); err != nil {
+			logFrom(ctx).Warn().Err(err).Msgf("Failed to linearize %s", path)
+		}
+	}
+}
+
+// qpdfDocument is a PDF as qpdf describes it in its JSON output (version 2), to change objects of the
+// file with qpdf --update-from-json. This needs qpdf 11 or later.
+type qpdfDocument struct {
+	// pages are the references of the page objects, e.g. "3 0 R"
+	pages       []string
+	pdfVersion  string
+	maxObjectID int
+	// objects holds the dictionaries of the file by key, "obj:3 0 R" or "trailer"
+	objects map[string]map[string]any
+}
+
+func readQPDFDocument(ctx context.Context, pdfPath string) (*qpdfDocument, error) {
+	ctx, cancel := withConversionTimeout(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "qpdf", "--json=2", "--json-key=pages", "--json-key=qpdf", pdfPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, timeoutError(ctx, "qpdf", ctx.Err())
+		}
+		return nil, fmt.Errorf("qpdf --json failed: %v: %s", err, qpdfMessage(stderr.String(), pdfPath))
+	}
+
+	var raw struct {
+		Pages []struct {
+			Object string `json:"object"`
+		} `json:"pages"`
+		// QPDF holds a header with the highest object number and then every object of the file
+		QPDF []json.RawMessage `json:"qpdf"`
+	}
+	if err := json.Unmarshal(output, &raw); err != nil || len(raw.QPDF) != 2 {
+		return nil, fmt.Errorf("unexpected qpdf JSON: %v", err)
+	}
+	var header struct {
+		PDFVersion  string `json:"pdfversion"`
+		MaxObjectID int    `json:"maxobjectid"`
+	}
+	var objects map[string]struct {
+		Value any `json:"value"`
+	}
+	if err := json.Unmarshal(raw.QPDF[0], &header); err != nil {
+		return nil, fmt.Errorf("unexpected qpdf JSON: %w", err)
+	}
+	if err := json.Unmarshal(raw.QPDF[1], &objects); err != nil {
+		return nil, fmt.Errorf("unexpected qpdf JSON: %w", err)
+	}
+
+	doc := &qpdfDocument{pdfVersion: header.PDFVersion, maxObjectID: header.MaxObjectID, objects: map[string]map[string]any{}}
+	for _, page := range raw.Pages {
+		doc.pages = append(doc.pages, page.Object)
+	}
+	for key, object := range objects {
+		if dict, ok := object.Value.(map[string]any); ok {
+			doc.objects[key] = dict
+		}
+	}
+	return doc, nil
+}
+
+// object returns the dictionary of an object by reference
+func (d *qpdfDocument) object(ref string) (map[string]any, error) {
+	dict, ok := d.objects["obj:"+ref]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", ref)
+	}
+	return dict, nil
+}
+
+// root is the reference of the document catalog
+func (d *qpdfDocument) root() string {
+	ref, _ := d.objects["trailer"]["/Root"].(string)
+	return ref
+}
+
+// newObject numbers an object to add to the file after its highest object
+func (d *qpdfDocument) newObject() string {
+	d.maxObjectID++
+	return fmt.Sprintf("%d 0 R", d.maxObjectID)
+}
+
+// update replaces or adds the objects of the file, given by key like in the JSON output
+func (d *qpdfDocument) update(ctx context.Context, pdfPath string, objects map[string]any) error {
+	data, err := json.Marshal(map[string]any{
+		"qpdf": []any{
+			map[string]any{"jsonversion": 2, "pdfversion": d.pdfVersion, "maxobjectid": d.maxObjectID},
+			objects,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	updatePath := pdfPath + ".json"
+	if err := os.WriteFile(updatePath, data, 0600); err != nil {
+		return err
+	}
+	defer os.Remove(updatePath)
+
+	outputPath := pdfPath + ".updated"
+	if err := runQPDF(ctx, "", "--update-from-json="+updatePath, pdfPath, outputPath); err != nil {
+		os.Remove(outputPath)
+		return qpdfFailed(err, pdfPath)
+	}
+	return os.Rename(outputPath, pdfPath)
+}
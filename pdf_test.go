@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+)
+
+// writeTestPDF writes a PDF with the given number of pages to dir
+func writeTestPDF(t *testing.T, dir, name string, pages int) string {
+	t.Helper()
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetFont("Helvetica", "", 12)
+	for i := 0; i < pages; i++ {
+		pdf.AddPage()
+		pdf.Cell(40, 10, name)
+	}
+	path := filepath.Join(dir, name)
+	if err := pdf.OutputFileAndClose(path); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestMergePDFs(t *testing.T) {
+	dir := t.TempDir()
+	factsheet := writeTestPDF(t, dir, "factsheet.pdf", 1)
+	resume := writeTestPDF(t, dir, "resume.pdf", 2)
+	broken := filepath.Join(dir, "broken.pdf")
+	if err := os.WriteFile(broken, []byte("%PDF-1.4 not really"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		inputs []string
+		pages  int
+		fails  bool
+	}{
+		{"single", []string{factsheet}, 1, false},
+		{"factsheet and resume", []string{factsheet, resume}, 3, false},
+		{"resume first", []string{resume, factsheet, resume}, 5, false},
+		{"broken input", []string{factsheet, broken}, 0, true},
+		{"missing input", []string{factsheet, filepath.Join(dir, "missing.pdf")}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "merged.pdf")
+			err := mergePDFs(context.Background(), out, tt.inputs...)
+			if tt.fails {
+				if err == nil {
+					t.Fatal("mergePDFs succeeded, want an error")
+				}
+				if _, statErr := os.Stat(out); statErr == nil {
+					t.Error("a failed merge left its output behind")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergePDFs: %v", err)
+			}
+			pages, err := api.PageCountFile(out)
+			if err != nil {
+				t.Fatalf("count pages: %v", err)
+			}
+			if pages != tt.pages {
+				t.Errorf("merged %d pages, want %d", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestStampWatermark(t *testing.T) {
+	tests := []struct {
+		name      string
+		watermark string
+		direction string
+	}{
+		{"company", watermarkText("Confidential - {CompanyName}", "acme", "Acme Corp"), directionLTR},
+		{"long", strings.Repeat("Confidential ", 8), directionLTR},
+		{"right to left", "Confidential - Acme Corp", directionRTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestPDF(t, t.TempDir(), "packet.pdf", 2)
+			if err := stampWatermark(context.Background(), path, tt.watermark, tt.direction); err != nil {
+				t.Fatalf("stampWatermark: %v", err)
+			}
+			stamped, err := api.HasWatermarksFile(path, pdfcpuConfig())
+			if err != nil {
+				t.Fatalf("read stamped PDF: %v", err)
+			}
+			if !stamped {
+				t.Error("stamped PDF has no watermark")
+			}
+			if pages, err := api.PageCountFile(path); err != nil || pages != 2 {
+				t.Errorf("stamped PDF has %d pages (%v), want 2", pages, err)
+			}
+		})
+	}
+}
+
+func TestStampWatermarkBrokenPDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-1.4 not really"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := stampWatermark(context.Background(), path, "Confidential", directionLTR); err == nil {
+		t.Fatal("stampWatermark succeeded, want an error")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "%PDF-1.4 not really" {
+		t.Error("a failed stamp changed the PDF")
+	}
+}
+
+func TestStampWatermarkTrueType(t *testing.T) {
+	savedFonts, savedWatermarkFonts, savedDir := factsheetFonts, watermarkFonts, appConfig.Processing.ScratchDir
+	t.Cleanup(func() {
+		factsheetFonts, watermarkFonts, appConfig.Processing.ScratchDir = savedFonts, savedWatermarkFonts, savedDir
+	})
+	face, err := sfnt.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factsheetFonts = []*factsheetFont{{family: "Go", styles: map[string][]byte{"": goregular.TTF, "B": gobold.TTF}, face: face}}
+	watermarkFonts, appConfig.Processing.ScratchDir = map[string]string{}, t.TempDir()
+
+	setupWatermarkFonts()
+	if watermarkFonts["Go"] != "Go-Bold" {
+		t.Fatalf("watermark fonts %v, want Go installed as Go-Bold", watermarkFonts)
+	}
+	path := writeTestPDF(t, t.TempDir(), "packet.pdf", 1)
+	if err := stampWatermark(context.Background(), path, "Vertraulich – Müller & Søn", directionLTR); err != nil {
+		t.Fatalf("stampWatermark: %v", err)
+	}
+	if stamped, err := api.HasWatermarksFile(path, pdfcpuConfig()); err != nil || !stamped {
+		t.Errorf("HasWatermarksFile = %v, %v, want true", stamped, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// photoPixels is the longest side photos are scaled down to, plenty for the printed size
+	photoPixels = 400
+	// photoWidth and photoHeight are the size of the photo box on the factsheet, in mm
+	photoWidth  = 30.0
+	photoHeight = 36.0
+)
+
+// candidatePhoto is the photo of a candidate, ready to embed. A photo that could not be fetched has no
+// data and is drawn as a placeholder with the candidate's initials.
+type candidatePhoto struct {
+	data     []byte
+	initials string
+}
+
+// fetchPhoto downloads the candidate's photo and scales it down to a JPEG. It returns nil without a
+// photo_url; a missing or broken photo gives a placeholder rather than failing the candidate.
+func fetchPhoto(ctx context.Context, tenant string, cand Candidate, candTempDir string) *candidatePhoto {
+	if cand.PhotoURL == "" {
+		return nil
+	}
+	photo := &candidatePhoto{initials: initials(cand.Name)}
+
+	// The resume headers often authenticate against the ATS, they are only sent to the host of the resume
+	var headers ResumeHeaders
+	if sameHost(cand.PhotoURL, cand.ResumeURL) {
+		headers = cand.ResumeHeaders
+	}
+	photoPath := filepath.Join(candTempDir, "photo")
+	if _, err := downloadFile(ctx, tenants.downloadGuard(tenant), cand.PhotoURL, headers, photoPath); err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("Photo of %s not available, using a placeholder", cand.Email)
+		return photo
+	}
+	data, err := os.ReadFile(photoPath)
+	if err == nil {
+		data, err = scalePhoto(data)
+	}
+	if err != nil {
+		logFrom(ctx).Warn().Err(err).Msgf("Photo of %s not usable, using a placeholder", cand.Email)
+		return photo
+	}
+	photo.data = data
+	return photo
+}
+
+// scalePhoto turns a photo upright and scales it down to photoPixels, re-encoded as JPEG
+func scalePhoto(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	upright := orientImage(img, jpegOrientation(data))
+
+	bounds := upright.Bounds()
+	scale := min(1, float64(photoPixels)/float64(max(bounds.Dx(), bounds.Dy())))
+	scaled := image.NewRGBA(image.Rect(0, 0, max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale))))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), upright, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// draw puts the photo in a box of photoWidth by photoHeight at x, y, scaled to fit and centred
+func (p *candidatePhoto) draw(pdf *gofpdf.Fpdf, text *factsheetText, x, y float64) {
+	if len(p.data) == 0 {
+		pdf.SetFillColor(230, 230, 230)
+		pdf.SetDrawColor(180, 180, 180)
+		pdf.SetXY(x, y)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(photoWidth, photoHeight, text.font("B", 20, p.initials), "1", 0, "C", true, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetDrawColor(0, 0, 0)
+		return
+	}
+	opts := gofpdf.ImageOptions{ImageType: "JPG"}
+	info := pdf.RegisterImageOptionsReader("photo", opts, bytes.NewReader(p.data))
+	if info == nil {
+		return
+	}
+	scale := min(photoWidth/info.Width(), photoHeight/info.Height())
+	w, h := info.Width()*scale, info.Height()*scale
+	pdf.ImageOptions("photo", x+(photoWidth-w)/2, y+(photoHeight-h)/2, w, h, false, opts, 0, "")
+}
+
+// dataURI returns the photo as a data: URI for factsheet templates, empty for the placeholder
+func (p *candidatePhoto) dataURI() template.URL {
+	if p == nil || len(p.data) == 0 {
+		return ""
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(p.data))
+}
+
+// initials are the first letters of the first and last word of a name, e.g. "JD" for John Doe
+func initials(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) })
+	if len(words) == 0 {
+		return "?"
+	}
+	first := []rune(words[0])[:1]
+	if len(words) == 1 {
+		return strings.ToUpper(string(first))
+	}
+	last := []rune(words[len(words)-1])[:1]
+	return strings.ToUpper(string(first) + string(last))
+}
+
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil || b == "" {
+		return false
+	}
+	ub, err := url.Parse(b)
+	return err == nil && strings.EqualFold(ua.Host, ub.Host)
+}
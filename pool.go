@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+)
+
+var (
+	// candidateSlots bounds how many candidates are processed at once across all jobs
+	candidateSlots chan struct{}
+	// conversionSlots bounds how many LibreOffice conversions run at once
+	conversionSlots chan struct{}
+)
+
+// setupWorkerPool sizes the candidate and conversion limits
+func setupWorkerPool() {
+	workers := appConfig.Processing.WorkerConcurrency
+	conversions := appConfig.Processing.MaxConcurrentConversions
+
+	candidateSlots = make(chan struct{}, workers)
+	conversionSlots = make(chan struct{}, conversions)
+	logger.Info().Msgf("Worker pool initialized: %d concurrent candidates, %d concurrent conversions", workers, conversions)
+}
+
+// processCandidatesLocally runs the job's candidates on a bounded set of workers in this process.
+// concurrency further limits the job below the global pool size when it is greater than zero.
+func processCandidatesLocally(job *Job, req ProcessRequest, factsheetDir, tempDir string) {
+	workers := cap(candidateSlots)
+	if req.Concurrency > 0 && req.Concurrency < workers {
+		workers = req.Concurrency
+	}
+	if workers > len(req.Candidates) {
+		workers = len(req.Candidates)
+	}
+
+	indexes := make(chan int)
+	fileNames := packetFileNames(job.ID, req)
+	var wg sync.WaitGroup
+
+	ctx := job.context()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if ctx.Err() != nil || job.candidateStopped(index) {
+					job.cancelCandidate(index)
+					continue
+				}
+				// Wait for the tenant before taking a shared slot so a busy tenant cannot hold the pool
+				release, err := tenants.acquire(ctx, req.TenantName)
+				if err != nil {
+					job.cancelCandidate(index)
+					continue
+				}
+				select {
+				case candidateSlots <- struct{}{}:
+				case <-ctx.Done():
+					release()
+					job.cancelCandidate(index)
+					continue
+				}
+				// The candidate may have been cancelled while it waited
+				if job.candidateStopped(index) {
+					<-candidateSlots
+					release()
+					job.cancelCandidate(index)
+					continue
+				}
+
+				job.startCandidate(index)
+				candidateCtx, done := job.candidateContext(index)
+				result, err := processCandidate(candidateCtx, jobInfo{job.ID, req.TenantName, req.CompanyName}, req.JobOptions, req.Candidates[index], fileNames[index],
+					factsheetDir, tempDir)
+				done()
+				job.finishCandidate(index, result, err)
+				<-candidateSlots
+				release()
+			}
+		}()
+	}
+
+	for i := range req.Candidates {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PreviewRequest is the payload of the factsheet preview endpoint
+type PreviewRequest struct {
+	TenantName  string    `json:"tenant_name"`
+	CompanyName string    `json:"company_name"`
+	Candidate   Candidate `json:"candidate"`
+	// Branding is drawn instead of the tenant's, so changes can be seen before they are saved
+	Branding *Branding `json:"branding,omitempty"`
+	JobOptions
+}
+
+// previewFactsheet renders the factsheet of one candidate and sends it back inline, without the resume, a job
+// or an archive, so changes to templates, themes and branding can be looked at right away
+func previewFactsheet(c *gin.Context) {
+	var req PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		invalidBody("Invalid request format: "+err.Error(), err).abort(c)
+		return
+	}
+	var ok bool
+	if req.TenantName, ok = scopeTenant(c, req.TenantName); !ok {
+		return
+	}
+
+	errs := &requestErrors{}
+	if req.TenantName == "" {
+		errs.add("tenant_name", "is required")
+	}
+	errs.checkLength("company_name", req.CompanyName, appConfig.Limits.MaxFieldLength)
+	req.Candidate.validate("candidate", errs)
+	if req.Branding != nil {
+		if err := req.Branding.validate(); err != nil {
+			errs.add("branding", err.Error())
+		}
+	}
+	if e := errs.apiError(); e != nil {
+		e.abort(c)
+		return
+	}
+	if err := req.JobOptions.validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeValidationFailed, err.Error())
+		return
+	}
+
+	if req.Blind {
+		candidates := []Candidate{req.Candidate}
+		assignCandidateCodes(candidates)
+		req.Candidate = candidates[0]
+	}
+	branding := req.Branding
+	if branding == nil {
+		branding = tenants.branding(req.TenantName)
+	}
+	scratchDir := scratchRoot(req.TenantName)
+	os.MkdirAll(scratchDir, 0700)
+	tempDir, err := os.MkdirTemp(scratchDir, "preview-")
+	if err != nil {
+		requestLog(c).Error().Err(err).Msg("Failed to create preview directory")
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to render factsheet")
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	job := jobInfo{ID: "preview-" + uuid.New().String(), Tenant: req.TenantName, Company: req.CompanyName}
+	outputPath := filepath.Join(tempDir, "factsheet.pdf")
+	if err := renderFactsheet(c.Request.Context(), job, req.JobOptions, branding, req.shown(req.Candidate), outputPath, tempDir); err != nil {
+		requestLog(c).Error().Err(err).Msgf("Failed to render factsheet preview for tenant %s", req.TenantName)
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to render factsheet: "+err.Error())
+		return
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to render factsheet")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "failed to render factsheet")
+		return
+	}
+	name := "factsheet.pdf"
+	if base := sanitizeFilename(req.shown(req.Candidate).Name); base != "" {
+		name = base + "_factsheet.pdf"
+	}
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, info.Size(), "application/pdf", file, map[string]string{
+		"Content-Disposition": fmt.Sprintf("inline; filename=%q", name),
+	})
+}
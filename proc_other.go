@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroupOnCancel relies on exec.CommandContext killing the process itself on this platform
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.WaitDelay = processWaitDelay
+}
+
+// restrictFileModes leaves file permissions to the platform's defaults
+func restrictFileModes() {}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroupOnCancel starts the command in its own process group and kills the whole group when
+// the command's context is cancelled. LibreOffice forks soffice.bin, which would otherwise keep running.
+// A child that escaped the group may still hold the output pipes open; Wait gives up on them after
+// processWaitDelay instead of blocking.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = processWaitDelay
+}
+
+// restrictFileModes keeps the files and directories the service and the tools it runs create, resumes and
+// archives full of personal data, readable by the service's user only
+func restrictFileModes() {
+	syscall.Umask(0077)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedProxies are the networks of server.trusted_proxies, whose X-Forwarded-* headers are believed
+var trustedProxies []netip.Prefix
+
+// setupTrustedProxies makes the router take the client address from X-Forwarded-For and X-Real-IP only on
+// requests from a trusted proxy; from anyone else the headers could be forged. No proxy is trusted by default.
+func setupTrustedProxies(router *gin.Engine) error {
+	prefixes, err := parseTrustedProxies(appConfig.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies = prefixes
+	return router.SetTrustedProxies(appConfig.Server.TrustedProxies)
+}
+
+// parseTrustedProxies reads proxies given as addresses, e.g. 10.0.0.1, or networks, e.g. 10.0.0.0/8
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// fromTrustedProxy reports whether the request came straight from one of the trusted proxies
+func fromTrustedProxy(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeader is the last value of an X-Forwarded-* header on a request from a trusted proxy, the one
+// that proxy set. Proxies that append to the header leave the values the client sent in front of theirs.
+func forwardedHeader(c *gin.Context, name string) string {
+	if !fromTrustedProxy(c) {
+		return ""
+	}
+	values := c.Request.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	last := values[len(values)-1]
+	return strings.TrimSpace(last[strings.LastIndex(last, ",")+1:])
+}
+
+// requestScheme is the scheme the client used: https on TLS connections, otherwise the X-Forwarded-Proto
+// of a trusted proxy
+func requestScheme(c *gin.Context) string {
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	if proto := strings.ToLower(forwardedHeader(c, "X-Forwarded-Proto")); proto == "https" || proto == "http" {
+		return proto
+	}
+	return "http"
+}
+
+// requestBaseURL is where the client reached this service through a trusted proxy, e.g.
+// https://factsheets.example.com, with the scheme and host the proxy forwarded. It is empty for requests that
+// came straight to this service, whose Host header anyone could have set.
+func requestBaseURL(c *gin.Context) string {
+	if !fromTrustedProxy(c) {
+		return ""
+	}
+	host := forwardedHeader(c, "X-Forwarded-Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	if host == "" {
+		return ""
+	}
+	return requestScheme(c) + "://" + host
+}
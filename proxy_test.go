@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyTestRouter answers every request with what the service makes of its forwarding headers
+func proxyTestRouter(t *testing.T, proxies []string) *gin.Engine {
+	t.Helper()
+	saved, savedPrefixes := appConfig.Server.TrustedProxies, trustedProxies
+	t.Cleanup(func() { appConfig.Server.TrustedProxies, trustedProxies = saved, savedPrefixes })
+	appConfig.Server.TrustedProxies = proxies
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := setupTrustedProxies(router); err != nil {
+		t.Fatalf("setupTrustedProxies: %v", err)
+	}
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"client_ip": c.ClientIP(), "base_url": requestBaseURL(c), "scheme": requestScheme(c)})
+	})
+	return router
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	router := proxyTestRouter(t, []string{"10.0.0.0/8", "192.0.2.1"})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    http.Header
+		clientIP   string
+		baseURL    string
+		scheme     string
+	}{
+		{
+			name:       "spoofed headers from an untrusted peer",
+			remoteAddr: "203.0.113.5:4711",
+			headers: http.Header{
+				"X-Forwarded-For":   {"10.9.9.9"},
+				"X-Real-Ip":         {"10.9.9.9"},
+				"X-Forwarded-Host":  {"evil.example.com"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			clientIP: "203.0.113.5",
+			baseURL:  "",
+			scheme:   "http",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:4711",
+			headers: http.Header{
+				"X-Forwarded-For":   {"198.51.100.7"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			clientIP: "198.51.100.7",
+			baseURL:  "https://factsheets.example.com",
+			scheme:   "https",
+		},
+		{
+			name:       "trusted proxy given as an address",
+			remoteAddr: "192.0.2.1:4711",
+			headers:    http.Header{"X-Forwarded-For": {"198.51.100.7"}},
+			clientIP:   "198.51.100.7",
+			baseURL:    "http://factsheets.example.com",
+			scheme:     "http",
+		},
+		{
+			name:       "trusted proxy over IPv4-mapped IPv6",
+			remoteAddr: "[::ffff:10.1.2.3]:4711",
+			headers:    http.Header{"X-Forwarded-For": {"198.51.100.7"}, "X-Forwarded-Proto": {"https"}},
+			clientIP:   "198.51.100.7",
+			baseURL:    "https://factsheets.example.com",
+			scheme:     "https",
+		},
+		{
+			name:       "client values in front of the proxy's",
+			remoteAddr: "10.1.2.3:4711",
+			headers: http.Header{
+				"X-Forwarded-For":   {"6.6.6.6, 198.51.100.7"},
+				"X-Forwarded-Host":  {"evil.example.com, factsheets.example.org"},
+				"X-Forwarded-Proto": {"http, https"},
+			},
+			clientIP: "198.51.100.7",
+			baseURL:  "https://factsheets.example.org",
+			scheme:   "https",
+		},
+		{
+			name:       "client header lines in front of the proxy's",
+			remoteAddr: "10.1.2.3:4711",
+			headers: http.Header{
+				"X-Forwarded-Host":  {"evil.example.com", "factsheets.example.org"},
+				"X-Forwarded-Proto": {"https", "http"},
+			},
+			clientIP: "10.1.2.3",
+			baseURL:  "http://factsheets.example.org",
+			scheme:   "http",
+		},
+		{
+			name:       "unknown scheme",
+			remoteAddr: "10.1.2.3:4711",
+			headers:    http.Header{"X-Forwarded-Proto": {"gopher"}},
+			clientIP:   "10.1.2.3",
+			baseURL:    "http://factsheets.example.com",
+			scheme:     "http",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://factsheets.example.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				req.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var got struct {
+				ClientIP string `json:"client_ip"`
+				BaseURL  string `json:"base_url"`
+				Scheme   string `json:"scheme"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response %q: %v", w.Body.String(), err)
+			}
+			if got.ClientIP != tt.clientIP {
+				t.Errorf("client IP = %q, want %q", got.ClientIP, tt.clientIP)
+			}
+			if got.BaseURL != tt.baseURL {
+				t.Errorf("base URL = %q, want %q", got.BaseURL, tt.baseURL)
+			}
+			if got.Scheme != tt.scheme {
+				t.Errorf("scheme = %q, want %q", got.Scheme, tt.scheme)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		valid   bool
+	}{
+		{[]string{"10.0.0.1"}, true},
+		{[]string{"10.0.0.0/8", "2001:db8::/32"}, true},
+		{[]string{"::ffff:10.0.0.1"}, true},
+		{[]string{"proxy.example.com"}, false},
+		{[]string{"10.0.0.0/33"}, false},
+	}
+	for _, tt := range tests {
+		if _, err := parseTrustedProxies(tt.proxies); (err == nil) != tt.valid {
+			t.Errorf("parseTrustedProxies(%q) = %v, valid %v", tt.proxies, err, tt.valid)
+		}
+	}
+}
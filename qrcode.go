@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"image/png"
+	"net/url"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	// qrSize is the printed size of the profile QR code in mm, readable by phone cameras at arm's length
+	qrSize = 25.0
+	// maxProfileURLLength keeps QR codes coarse enough to scan from a printout
+	maxProfileURLLength = 500
+)
+
+// validateProfileURL checks the candidate's ATS profile link, which is printed as a QR code
+func (cand Candidate) validateProfileURL() error {
+	if cand.ProfileURL == "" {
+		return nil
+	}
+	if len(cand.ProfileURL) > maxProfileURLLength {
+		return errors.New("profile_url is too long for a QR code")
+	}
+	u, err := url.Parse(cand.ProfileURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("profile_url must be an http or https URL")
+	}
+	return nil
+}
+
+// profileQR encodes the profile URL, with medium error correction so creased printouts still scan
+func profileQR(profileURL string) (barcode.Barcode, error) {
+	return qr.Encode(profileURL, qr.M, qr.Auto)
+}
+
+// drawProfileQR draws the QR code of the profile URL as a square of qrSize at x, y, module by module so
+// it stays sharp at any zoom, captioned underneath. The code also links to the profile for readers of the
+// PDF itself.
+func drawProfileQR(pdf *gofpdf.Fpdf, text *factsheetText, profileURL string, x, y float64) {
+	code, err := profileQR(profileURL)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to encode profile URL as QR code")
+		return
+	}
+	bounds := code.Bounds()
+	module := qrSize / float64(bounds.Dx())
+	pdf.SetFillColor(0, 0, 0)
+	for row := bounds.Min.Y; row < bounds.Max.Y; row++ {
+		for col := bounds.Min.X; col < bounds.Max.X; col++ {
+			if r, _, _, _ := code.At(col, row).RGBA(); r < 0x8000 {
+				pdf.Rect(x+float64(col-bounds.Min.X)*module, y+float64(row-bounds.Min.Y)*module, module, module, "F")
+			}
+		}
+	}
+	pdf.LinkString(x, y, qrSize, qrSize, profileURL)
+
+	pdf.SetXY(x, y+qrSize+1)
+	pdf.SetTextColor(128, 128, 128)
+	pdf.CellFormat(qrSize, 4, text.font("", 8, "Live profile"), "", 0, "C", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+}
+
+// qrDataURI returns the QR code of the profile URL as a PNG data: URI for factsheet templates
+func qrDataURI(profileURL string) template.URL {
+	if profileURL == "" {
+		return ""
+	}
+	code, err := profileQR(profileURL)
+	if err == nil {
+		code, err = barcode.Scale(code, 300, 300)
+	}
+	var buf bytes.Buffer
+	if err == nil {
+		err = png.Encode(&buf, code)
+	}
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// candidateTask is a single candidate of a job waiting to be processed by any instance sharing the queue
+type candidateTask struct {
+	JobID        string     `json:"job_id"`
+	Owner        string     `json:"owner"`
+	Tenant       string     `json:"tenant"`
+	Company      string     `json:"company,omitempty"`
+	Index        int        `json:"index"`
+	Attempt      int        `json:"attempt"`
+	Candidate    Candidate  `json:"candidate"`
+	Options      JobOptions `json:"options"`
+	FactsheetDir string     `json:"factsheet_dir"`
+	TempDir      string     `json:"temp_dir"`
+	// FileName is the name of the candidate's packet, which depends on the rest of the job
+	FileName string `json:"file_name,omitempty"`
+	// RequestID is the id of the request that created the job, see Job
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// candidateEvent reports the progress of a task back to the instance that owns the job
+type candidateEvent struct {
+	JobID     string `json:"job_id"`
+	Index     int    `json:"index"`
+	Finished  bool   `json:"finished"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	// DownloadAttempts is the number of download attempts of the last processing attempt
+	DownloadAttempts int `json:"download_attempts,omitempty"`
+	// Format and Converter describe the resume, see candidateResult
+	Format    string `json:"format,omitempty"`
+	Converter string `json:"converter,omitempty"`
+	// File and Failure are the outcome of the candidate, see candidateResult
+	File    string            `json:"file,omitempty"`
+	Failure *CandidateFailure `json:"failure,omitempty"`
+}
+
+// redisTaskQueue distributes candidates over every instance connected to the same Redis.
+//
+// Tasks are moved atomically from the shared tasks list into a per-worker processing list while they
+// are being worked on. Workers refresh a heartbeat key, and the processing lists of workers whose
+// heartbeat expired are pushed back onto the tasks list, so candidates survive pod restarts.
+// Progress is sent to the owning instance through its events list; the owner applies it to the job
+// and packages the archive once every candidate is done.
+type redisTaskQueue struct {
+	client      *redis.Client
+	prefix      string
+	workers     int
+	maxAttempts int
+
+	mu      sync.Mutex
+	pending map[string]*queuedJob
+	// inflight holds the cancel functions of tasks this instance is processing, by job
+	inflight map[string]map[*candidateTask]context.CancelFunc
+
+	// stopping makes workers exit after their current task, interrupted marks tasks cancelled by shutdown
+	stopping    atomic.Bool
+	interrupted atomic.Bool
+	workersWG   sync.WaitGroup
+}
+
+// queuedJob is a job owned by this instance that is waiting for its candidates
+type queuedJob struct {
+	job       *Job
+	remaining int
+	done      chan struct{}
+}
+
+const workerHeartbeatTTL = 30 * time.Second
+
+// cancelledJobTTL is how long the cancellation marker of a job is kept for workers to skip its candidates
+const cancelledJobTTL = 24 * time.Hour
+
+// tenantRetryDelay keeps workers from spinning on tasks of tenants that are at their limit
+const tenantRetryDelay = 200 * time.Millisecond
+
+// taskQueue is nil unless REDIS_URL is configured, candidates are then processed in-process
+var taskQueue *redisTaskQueue
+
+func setupTaskQueue() []string {
+	cfg := appConfig.Queue
+	redisURL := cfg.RedisURL
+	if redisURL == "" {
+		return nil
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid REDIS_URL, processing candidates in-process")
+		return nil
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		logger.Warn().Err(err).Msg("Cannot connect to Redis, processing candidates in-process")
+		return nil
+	}
+
+	q := &redisTaskQueue{
+		client:      client,
+		prefix:      cfg.Prefix,
+		workers:     cfg.Workers,
+		maxAttempts: cfg.MaxAttempts,
+		pending:     make(map[string]*queuedJob),
+		inflight:    make(map[string]map[*candidateTask]context.CancelFunc),
+	}
+	if q.workers == 0 {
+		q.workers = cap(candidateSlots)
+	}
+	taskQueue = q
+
+	// Anything left in our processing list belongs to the previous run of this instance
+	if moved := q.requeue(instanceID); moved > 0 {
+		logger.Info().Msgf("Requeued %d candidates interrupted by the previous shutdown", moved)
+	}
+
+	q.heartbeat()
+	go q.keepAlive()
+	go q.reap()
+	go q.dispatchEvents()
+	go q.watchCancellations()
+	q.workersWG.Add(q.workers)
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+
+	logger.Info().Msgf("Redis task queue enabled (instance %s, %d workers, %d attempts)", instanceID, q.workers, q.maxAttempts)
+	return q.resumeOwnedJobs()
+}
+
+func (q *redisTaskQueue) key(parts ...string) string {
+	return q.prefix + ":" + strings.Join(parts, ":")
+}
+
+// run enqueues every candidate of the job and blocks until all of them have been processed
+func (q *redisTaskQueue) run(job *Job, req ProcessRequest, factsheetDir, tempDir string) error {
+	ctx := context.Background()
+	qj := q.track(job, len(req.Candidates))
+
+	// Remember the request so the job can be resumed if this instance restarts
+	data, err := json.Marshal(req)
+	if err != nil {
+		q.untrack(job.ID)
+		return err
+	}
+
+	pipe := q.client.TxPipeline()
+	pipe.Set(ctx, q.key("job", job.ID), data, 0)
+	pipe.SAdd(ctx, q.key("owned", instanceID), job.ID)
+	fileNames := packetFileNames(job.ID, req)
+	for i, cand := range req.Candidates {
+		payload, err := json.Marshal(candidateTask{
+			JobID:        job.ID,
+			Owner:        instanceID,
+			Tenant:       job.TenantName,
+			Company:      job.CompanyName,
+			Index:        i,
+			Candidate:    cand,
+			FileName:     fileNames[i],
+			Options:      req.JobOptions,
+			FactsheetDir: factsheetDir,
+			TempDir:      tempDir,
+			RequestID:    job.RequestID,
+		})
+		if err != nil {
+			q.untrack(job.ID)
+			return err
+		}
+		pipe.LPush(ctx, q.key("tasks"), payload)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		q.untrack(job.ID)
+		return err
+	}
+
+	job.log().Info().Msgf("Queued %d candidates of job %s", len(req.Candidates), job.ID)
+	<-qj.done
+	return nil
+}
+
+// release forgets a job once its archive has been packaged
+func (q *redisTaskQueue) release(jobID string) {
+	q.untrack(jobID)
+
+	ctx := context.Background()
+	pipe := q.client.TxPipeline()
+	pipe.Del(ctx, q.key("job", jobID))
+	pipe.SRem(ctx, q.key("owned", instanceID), jobID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error().Err(err).Msgf("Error releasing job %s from the queue", jobID)
+	}
+}
+
+func (q *redisTaskQueue) track(job *Job, remaining int) *queuedJob {
+	qj := &queuedJob{job: job, remaining: remaining, done: make(chan struct{})}
+	if remaining == 0 {
+		close(qj.done)
+	}
+	q.mu.Lock()
+	q.pending[job.ID] = qj
+	q.mu.Unlock()
+	return qj
+}
+
+func (q *redisTaskQueue) untrack(jobID string) {
+	q.mu.Lock()
+	delete(q.pending, jobID)
+	q.mu.Unlock()
+}
+
+// resumeOwnedJobs picks up jobs this instance owned before it restarted and returns their IDs
+func (q *redisTaskQueue) resumeOwnedJobs() []string {
+	ctx := context.Background()
+	ids, err := q.client.SMembers(ctx, q.key("owned", instanceID)).Result()
+	if err != nil {
+		logger.Error().Err(err).Msg("Error listing owned jobs")
+		return nil
+	}
+
+	resumed := []string{}
+	for _, id := range ids {
+		data, err := q.client.Get(ctx, q.key("job", id)).Bytes()
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Cannot resume job %s", id)
+			q.release(id)
+			continue
+		}
+		var req ProcessRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			logger.Warn().Err(err).Msgf("Cannot resume job %s: invalid request", id)
+			q.release(id)
+			continue
+		}
+
+		// Candidate progress lives in the job store, without it there is nothing to resume from
+		if jobDB == nil {
+			logger.Warn().Msgf("Cannot resume job %s: job store is disabled", id)
+			q.release(id)
+			continue
+		}
+		job, err := jobDB.loadJob(id)
+		if err != nil || len(job.Candidates) != len(req.Candidates) {
+			logger.Warn().Err(err).Msgf("Cannot resume job %s", id)
+			q.release(id)
+			continue
+		}
+		if job.Status != jobStatusQueued && job.Status != jobStatusProcessing {
+			q.release(id)
+			continue
+		}
+
+		remaining := 0
+		for _, cand := range job.Candidates {
+			if !isFinalCandidateStatus(cand.Status) {
+				remaining++
+			}
+		}
+
+		job.failFast = req.failFastPolicy()
+		job.initCancel()
+		jobs.track(job)
+		qj := q.track(job, remaining)
+		resumed = append(resumed, id)
+		logger.Info().Msgf("Resuming job %s with %d of %d candidates remaining", id, remaining, len(job.Candidates))
+
+		go func() {
+			baseDir, factsheetDir, _ := jobDirs(job.TenantName, job.ID)
+			defer q.release(job.ID)
+			defer cleanupJobDir(job.ID, baseDir)
+
+			<-qj.done
+			packageJob(job, req, factsheetDir)
+			auditFinishedJob(job, req)
+			notifyJobDone(job, req)
+		}()
+	}
+	return resumed
+}
+
+// work processes tasks from the shared queue until the process exits
+func (q *redisTaskQueue) work() {
+	defer q.workersWG.Done()
+	ctx := context.Background()
+	tasksKey := q.key("tasks")
+	processingKey := q.key("processing", instanceID)
+
+	for !q.stopping.Load() {
+		raw, err := q.client.BLMove(ctx, tasksKey, processingKey, "RIGHT", "LEFT", 5*time.Second).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Error reading from task queue")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var task candidateTask
+		if err := json.Unmarshal([]byte(raw), &task); err != nil {
+			logger.Warn().Err(err).Msg("Dropping invalid task")
+			q.client.LRem(ctx, processingKey, 1, raw)
+			continue
+		}
+
+		// Candidates of cancelled jobs, and cancelled candidates, are skipped without being processed
+		if q.isCancelled(ctx, task.JobID, task.Index) {
+			payload, _ := json.Marshal(candidateEvent{JobID: task.JobID, Index: task.Index, Finished: true, Cancelled: true})
+			pipe := q.client.TxPipeline()
+			pipe.RPush(ctx, q.key("events", task.Owner), payload)
+			pipe.LRem(ctx, processingKey, 1, raw)
+			if _, err := pipe.Exec(ctx); err != nil {
+				task.log().Error().Err(err).Msgf("Error skipping candidate %d of cancelled job %s", task.Index, task.JobID)
+			}
+			continue
+		}
+
+		// A tenant at its limit goes back to the end of the queue so other tenants' tasks are picked up first
+		release, ok := tenants.tryAcquire(task.Tenant)
+		if !ok {
+			pipe := q.client.TxPipeline()
+			pipe.LPush(ctx, tasksKey, raw)
+			pipe.LRem(ctx, processingKey, 1, raw)
+			if _, err := pipe.Exec(ctx); err != nil {
+				task.log().Error().Err(err).Msgf("Error deferring candidate %d of job %s", task.Index, task.JobID)
+			}
+			time.Sleep(tenantRetryDelay)
+			continue
+		}
+
+		q.report(ctx, task.Owner, candidateEvent{JobID: task.JobID, Index: task.Index})
+		taskCtx, done := q.startTask(&task)
+		// Tasks queued by older instances name packets the way they did
+		if task.FileName == "" {
+			task.FileName = factsheetFileName(task.Candidate)
+		}
+		result, err := processCandidate(taskCtx, jobInfo{task.JobID, task.Tenant, task.Company}, task.Options, task.Candidate, task.FileName,
+			task.FactsheetDir, task.TempDir)
+		cancelled := taskCtx.Err() != nil
+		done()
+		release()
+
+		// Leave tasks interrupted by shutdown unacknowledged, they are requeued for another worker
+		if cancelled && q.interrupted.Load() {
+			task.log().Warn().Msgf("Candidate %d of job %s interrupted by shutdown", task.Index, task.JobID)
+			continue
+		}
+
+		pipe := q.client.TxPipeline()
+		// A candidate that ran out of time would most likely run out of time again
+		if err != nil && !cancelled && errorCode(err) != errorCodeCandidateTimeout && task.Attempt+1 < q.maxAttempts {
+			task.Attempt++
+			task.log().Warn().Msgf("Retrying candidate %s of job %s (attempt %d of %d)", task.Candidate.Email, task.JobID, task.Attempt+1, q.maxAttempts)
+			payload, _ := json.Marshal(task)
+			pipe.LPush(ctx, tasksKey, payload)
+		} else {
+			event := candidateEvent{JobID: task.JobID, Index: task.Index, Finished: true, Cancelled: cancelled,
+				DownloadAttempts: result.DownloadAttempts, Format: result.Format, Converter: result.Converter, File: result.File}
+			if err != nil && !cancelled {
+				event.Error, event.ErrorCode, event.Failure = err.Error(), errorCode(err), result.Failure
+			}
+			payload, _ := json.Marshal(event)
+			pipe.RPush(ctx, q.key("events", task.Owner), payload)
+		}
+		// Acknowledge last, a crash before this point makes another worker retry the task
+		pipe.LRem(ctx, processingKey, 1, raw)
+		if _, err := pipe.Exec(ctx); err != nil {
+			task.log().Error().Err(err).Msgf("Error acknowledging candidate %d of job %s", task.Index, task.JobID)
+		}
+	}
+}
+
+func (q *redisTaskQueue) report(ctx context.Context, owner string, event candidateEvent) {
+	payload, _ := json.Marshal(event)
+	if err := q.client.RPush(ctx, q.key("events", owner), payload).Err(); err != nil {
+		logFrom(ctx).Error().Err(err).Msgf("Error reporting progress of job %s", event.JobID)
+	}
+}
+
+// dispatchEvents applies progress reported by workers to the jobs owned by this instance
+func (q *redisTaskQueue) dispatchEvents() {
+	ctx := context.Background()
+	eventsKey := q.key("events", instanceID)
+
+	for {
+		result, err := q.client.BLPop(ctx, 5*time.Second, eventsKey).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Error reading job events")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var event candidateEvent
+		if err := json.Unmarshal([]byte(result[1]), &event); err != nil {
+			logger.Warn().Err(err).Msg("Dropping invalid job event")
+			continue
+		}
+		q.apply(event)
+	}
+}
+
+func (q *redisTaskQueue) apply(event candidateEvent) {
+	q.mu.Lock()
+	qj, ok := q.pending[event.JobID]
+	q.mu.Unlock()
+	if !ok || event.Index < 0 || event.Index >= len(qj.job.Candidates) {
+		logger.Warn().Msgf("Ignoring event for unknown job %s", event.JobID)
+		return
+	}
+
+	// A worker that dies between reporting and acknowledging causes a duplicate, keep the first result
+	qj.job.mu.Lock()
+	status := qj.job.Candidates[event.Index].Status
+	qj.job.mu.Unlock()
+	if isFinalCandidateStatus(status) {
+		return
+	}
+
+	if !event.Finished {
+		qj.job.startCandidate(event.Index)
+		return
+	}
+
+	if event.Cancelled {
+		qj.job.cancelCandidate(event.Index)
+	} else {
+		var err error
+		if event.Error != "" {
+			err = errors.New(event.Error)
+			if event.ErrorCode != "" {
+				err = &codedError{event.ErrorCode, err}
+			}
+		}
+		qj.job.finishCandidate(event.Index, candidateResult{DownloadAttempts: event.DownloadAttempts,
+			Format: event.Format, Converter: event.Converter, File: event.File, Failure: event.Failure}, err)
+	}
+
+	q.mu.Lock()
+	qj.remaining--
+	if qj.remaining == 0 {
+		close(qj.done)
+	}
+	q.mu.Unlock()
+}
+
+// stop makes the workers exit once their current task is done
+func (q *redisTaskQueue) stop() {
+	q.stopping.Store(true)
+}
+
+// wait reports whether all workers exited before ctx was done
+func (q *redisTaskQueue) wait(ctx context.Context) bool {
+	return waitWithTimeout(&q.workersWG, ctx)
+}
+
+// interrupt cancels every task in flight on this instance
+func (q *redisTaskQueue) interrupt() {
+	q.interrupted.Store(true)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, tasks := range q.inflight {
+		for _, cancel := range tasks {
+			cancel()
+		}
+	}
+}
+
+// cancel marks the job as cancelled for every instance: queued candidates are skipped and in-flight
+// ones are interrupted
+func (q *redisTaskQueue) cancel(jobID string) error {
+	ctx := context.Background()
+	pipe := q.client.TxPipeline()
+	pipe.Set(ctx, q.key("cancelled", jobID), "1", cancelledJobTTL)
+	pipe.Publish(ctx, q.key("cancel"), jobID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// cancelCandidate cancels a single candidate of a job for every instance, like cancel does for the whole job
+func (q *redisTaskQueue) cancelCandidate(jobID string, index int) error {
+	ctx := context.Background()
+	pipe := q.client.TxPipeline()
+	pipe.Set(ctx, q.key("cancelled", jobID, strconv.Itoa(index)), "1", cancelledJobTTL)
+	pipe.Publish(ctx, q.key("cancel"), jobID+":"+strconv.Itoa(index))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// isCancelled reports whether the job or its candidate at index was cancelled
+func (q *redisTaskQueue) isCancelled(ctx context.Context, jobID string, index int) bool {
+	exists, err := q.client.Exists(ctx, q.key("cancelled", jobID), q.key("cancelled", jobID, strconv.Itoa(index))).Result()
+	if err != nil {
+		logFrom(ctx).Error().Err(err).Msgf("Error checking cancellation of job %s", jobID)
+		return false
+	}
+	return exists > 0
+}
+
+// log returns the logger of the task, carrying the ids of its job and the candidate's email
+func (t *candidateTask) log() *zerolog.Logger {
+	l := jobLogger(t.JobID, t.Tenant, t.RequestID).Str("candidate_email", t.Candidate.Email).Logger()
+	return &l
+}
+
+// startTask registers a task as in flight and returns its context and the function that unregisters it
+func (q *redisTaskQueue) startTask(task *candidateTask) (context.Context, func()) {
+	l := jobLogger(task.JobID, task.Tenant, task.RequestID).Logger()
+	ctx, cancel := context.WithCancel(l.WithContext(context.Background()))
+	q.mu.Lock()
+	if q.inflight[task.JobID] == nil {
+		q.inflight[task.JobID] = make(map[*candidateTask]context.CancelFunc)
+	}
+	q.inflight[task.JobID][task] = cancel
+	q.mu.Unlock()
+
+	return ctx, func() {
+		q.mu.Lock()
+		delete(q.inflight[task.JobID], task)
+		if len(q.inflight[task.JobID]) == 0 {
+			delete(q.inflight, task.JobID)
+		}
+		q.mu.Unlock()
+		cancel()
+	}
+}
+
+// watchCancellations interrupts tasks and owned jobs when any instance cancels a job. Cancelled candidates
+// are published as <job id>:<index> and only interrupt the candidate's task.
+func (q *redisTaskQueue) watchCancellations() {
+	sub := q.client.Subscribe(context.Background(), q.key("cancel"))
+	for msg := range sub.Channel() {
+		jobID, candidate, single := strings.Cut(msg.Payload, ":")
+		index, err := strconv.Atoi(candidate)
+		if single && err != nil {
+			logger.Warn().Msgf("Ignoring invalid cancellation %q", msg.Payload)
+			continue
+		}
+
+		q.mu.Lock()
+		for task, cancel := range q.inflight[jobID] {
+			if !single || task.Index == index {
+				cancel()
+			}
+		}
+		qj, owned := q.pending[jobID]
+		q.mu.Unlock()
+
+		if owned && !single {
+			qj.job.cancel()
+		}
+	}
+}
+
+func (q *redisTaskQueue) heartbeat() {
+	if err := q.client.Set(context.Background(), q.key("worker", instanceID), time.Now().Unix(), workerHeartbeatTTL).Err(); err != nil {
+		logger.Error().Err(err).Msg("Error refreshing worker heartbeat")
+	}
+}
+
+func (q *redisTaskQueue) keepAlive() {
+	for range time.Tick(workerHeartbeatTTL / 3) {
+		q.heartbeat()
+	}
+}
+
+// reap requeues tasks held by workers whose heartbeat has expired
+func (q *redisTaskQueue) reap() {
+	ctx := context.Background()
+	prefix := q.key("processing", "")
+
+	for range time.Tick(workerHeartbeatTTL) {
+		iter := q.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			worker := strings.TrimPrefix(iter.Val(), prefix)
+			if worker == instanceID {
+				continue
+			}
+			alive, err := q.client.Exists(ctx, q.key("worker", worker)).Result()
+			if err != nil || alive > 0 {
+				continue
+			}
+			if moved := q.requeue(worker); moved > 0 {
+				logger.Warn().Msgf("Requeued %d candidates from unresponsive worker %s", moved, worker)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			logger.Error().Err(err).Msg("Error scanning processing lists")
+		}
+	}
+}
+
+// requeue moves every task in a worker's processing list back to the front of the tasks list
+func (q *redisTaskQueue) requeue(worker string) int {
+	ctx := context.Background()
+	moved := 0
+	for {
+		err := q.client.LMove(ctx, q.key("processing", worker), q.key("tasks"), "RIGHT", "RIGHT").Err()
+		if errors.Is(err, redis.Nil) {
+			return moved
+		}
+		if err != nil {
+			logger.Error().Err(err).Msgf("Error requeueing tasks of worker %s", worker)
+			return moved
+		}
+		moved++
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiterIdle is how long the bucket of a client or caller is kept after its last request. Any sensible
+// limit has filled the bucket again by then, so forgetting it changes nothing.
+const keyedLimiterIdle = 10 * time.Minute
+
+// requestLimiter holds the token buckets API requests are taken from: one shared by everyone and one for
+// each client address, taken before authentication so floods of bad credentials are turned away too, and
+// one for each authenticated caller
+type requestLimiter struct {
+	mu      sync.Mutex
+	global  *rate.Limiter
+	clients *keyedLimiters
+	callers *keyedLimiters
+}
+
+// keyedLimiters are token buckets of the same size, one for each key. Callers must hold the mutex of the
+// requestLimiter they belong to.
+type keyedLimiters struct {
+	perMinute int
+	burst     int
+	buckets   map[string]*keyedLimiter
+	lastSweep time.Time
+}
+
+type keyedLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+var requestLimits *requestLimiter
+
+func newRequestLimiter(cfg RateLimitConfig) *requestLimiter {
+	l := &requestLimiter{
+		clients: newKeyedLimiters(cfg.ClientIPPerMinute, cfg.ClientIPBurst),
+		callers: newKeyedLimiters(cfg.CallerPerMinute, cfg.CallerBurst),
+	}
+	if cfg.GlobalPerMinute > 0 {
+		l.global = rate.NewLimiter(perMinute(cfg.GlobalPerMinute), cmp.Or(cfg.GlobalBurst, cfg.GlobalPerMinute))
+	}
+	return l
+}
+
+// newKeyedLimiters returns nil when perMinute is 0
+func newKeyedLimiters(perMinute, burst int) *keyedLimiters {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &keyedLimiters{perMinute: perMinute, burst: cmp.Or(burst, perMinute), buckets: map[string]*keyedLimiter{}, lastSweep: time.Now()}
+}
+
+// get returns the bucket of key, and forgets those of keys that have been idle for a while
+func (k *keyedLimiters) get(key string, now time.Time) *rate.Limiter {
+	if now.Sub(k.lastSweep) > time.Minute {
+		for idle, b := range k.buckets {
+			if now.Sub(b.lastSeen) > keyedLimiterIdle {
+				delete(k.buckets, idle)
+			}
+		}
+		k.lastSweep = now
+	}
+	b, ok := k.buckets[key]
+	if !ok {
+		b = &keyedLimiter{limiter: rate.NewLimiter(perMinute(k.perMinute), k.burst)}
+		k.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// allowClient takes a token for a request from the client's bucket and the global one
+func (l *requestLimiter) allowClient(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var buckets []*rate.Limiter
+	if l.clients != nil {
+		buckets = append(buckets, l.clients.get(ip, now))
+	}
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+	return take(now, buckets)
+}
+
+// allowCaller takes a token for a request from the caller's bucket
+func (l *requestLimiter) allowCaller(caller string) (bool, time.Duration) {
+	if l.callers == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	return take(now, []*rate.Limiter{l.callers.get(caller, now)})
+}
+
+// take takes a token from every bucket, or otherwise tells how long to wait. A request turned away by one
+// bucket takes no token from the others.
+func take(now time.Time, buckets []*rate.Limiter) (bool, time.Duration) {
+	var wait time.Duration
+	for _, bucket := range buckets {
+		if missing := 1 - bucket.TokensAt(now); missing > 0 {
+			wait = max(wait, time.Duration(missing/float64(bucket.Limit())*float64(time.Second)))
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, bucket := range buckets {
+		bucket.AllowN(now, 1)
+	}
+	return true, 0
+}
+
+// limitClients turns away API requests beyond the global and per-address rates with 429 and a Retry-After
+// header, so nobody can swamp the service, not even with requests that fail authentication. The address is
+// the client's as told by a trusted proxy.
+func limitClients(c *gin.Context) {
+	if requestLimits == nil {
+		c.Next()
+		return
+	}
+	if ok, wait := requestLimits.allowClient(c.ClientIP()); !ok {
+		rejectRateLimited(c, wait)
+		return
+	}
+	c.Next()
+}
+
+// limitRequests turns away API requests beyond the per-caller rate. It runs after authentication so callers
+// are told apart by their token subject or client certificate, and by their address otherwise.
+func limitRequests(c *gin.Context) {
+	if requestLimits == nil {
+		c.Next()
+		return
+	}
+	if ok, wait := requestLimits.allowCaller(requestedBy(c)); !ok {
+		rejectRateLimited(c, wait)
+		return
+	}
+	c.Next()
+}
+
+func rejectRateLimited(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", retryAfterSeconds(wait))
+	abortWithError(c, http.StatusTooManyRequests, errCodeRateLimited, "request rate limit exceeded, try again later")
+}
+
+// setupRateLimits enables the request rate limits when any is configured
+func setupRateLimits() {
+	if cfg := appConfig.Server.RateLimit; cfg.GlobalPerMinute > 0 || cfg.ClientIPPerMinute > 0 || cfg.CallerPerMinute > 0 {
+		requestLimits = newRequestLimiter(cfg)
+	}
+}
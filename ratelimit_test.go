@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitTestRouter limits requests as the API does, with a stand-in for authentication that accepts the
+// bearer token "good" only
+func rateLimitTestRouter(t *testing.T, cfg RateLimitConfig) *gin.Engine {
+	t.Helper()
+	saved := requestLimits
+	t.Cleanup(func() { requestLimits = saved })
+	requestLimits = newRequestLimiter(cfg)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authenticate := func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "Bearer good" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+	router.GET("/", limitClients, authenticate, limitRequests, func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func TestRequestRateLimits(t *testing.T) {
+	type request struct {
+		addr  string
+		token string
+		want  int
+	}
+	tests := []struct {
+		name     string
+		cfg      RateLimitConfig
+		requests []request
+	}{
+		{
+			name: "global bucket",
+			cfg:  RateLimitConfig{GlobalPerMinute: 1, GlobalBurst: 2},
+			requests: []request{
+				{"198.51.100.1:1000", "good", http.StatusOK},
+				{"198.51.100.2:1000", "good", http.StatusOK},
+				{"198.51.100.3:1000", "good", http.StatusTooManyRequests},
+			},
+		},
+		{
+			name: "bad credentials are limited by address",
+			cfg:  RateLimitConfig{ClientIPPerMinute: 1, ClientIPBurst: 2},
+			requests: []request{
+				{"198.51.100.1:1000", "bad", http.StatusUnauthorized},
+				{"198.51.100.1:1001", "", http.StatusUnauthorized},
+				{"198.51.100.1:1002", "good", http.StatusTooManyRequests},
+				{"198.51.100.2:1000", "bad", http.StatusUnauthorized},
+			},
+		},
+		{
+			name: "caller bucket",
+			cfg:  RateLimitConfig{ClientIPPerMinute: 1, ClientIPBurst: 5, CallerPerMinute: 1, CallerBurst: 1},
+			requests: []request{
+				{"198.51.100.1:1000", "good", http.StatusOK},
+				{"198.51.100.1:1000", "bad", http.StatusUnauthorized},
+				{"198.51.100.1:1000", "good", http.StatusTooManyRequests},
+				{"198.51.100.2:1000", "good", http.StatusOK},
+			},
+		},
+		{
+			name: "refused requests take no global token",
+			cfg:  RateLimitConfig{GlobalPerMinute: 1, GlobalBurst: 2, ClientIPPerMinute: 1, ClientIPBurst: 1},
+			requests: []request{
+				{"198.51.100.1:1000", "good", http.StatusOK},
+				{"198.51.100.1:1000", "good", http.StatusTooManyRequests},
+				{"198.51.100.1:1000", "good", http.StatusTooManyRequests},
+				{"198.51.100.2:1000", "good", http.StatusOK},
+				{"198.51.100.3:1000", "good", http.StatusTooManyRequests},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := rateLimitTestRouter(t, tt.cfg)
+			for i, r := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = r.addr
+				if r.token != "" {
+					req.Header.Set("Authorization", "Bearer "+r.token)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != r.want {
+					t.Fatalf("request %d from %s: status %d, want %d", i+1, r.addr, w.Code, r.want)
+				}
+				retryAfter := w.Header().Get("Retry-After")
+				if r.want != http.StatusTooManyRequests {
+					if retryAfter != "" {
+						t.Errorf("request %d: Retry-After %q on status %d", i+1, retryAfter, w.Code)
+					}
+					continue
+				}
+				if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 {
+					t.Errorf("request %d: Retry-After %q, want a positive number of seconds", i+1, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestSetupRateLimitsDisabled(t *testing.T) {
+	saved, savedLimits := appConfig.Server.RateLimit, requestLimits
+	t.Cleanup(func() { appConfig.Server.RateLimit, requestLimits = saved, savedLimits })
+	appConfig.Server.RateLimit, requestLimits = RateLimitConfig{GlobalBurst: 10, CallerBurst: 10}, nil
+
+	setupRateLimits()
+	if requestLimits != nil {
+		t.Fatal("setupRateLimits enabled limits without a per-minute rate")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// redactionDPI is the resolution resume pages are rendered at for redaction, sharp enough for Tesseract
+const redactionDPI = 150
+
+var (
+	redactEmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	redactPhonePattern = regexp.MustCompile(`\+?\(?\d[\d\s().-]{7,}\d`)
+	// dateRangePattern matches runs of digits that look like phone numbers but are years, e.g. 01.2015 - 12.2019
+	dateRangePattern = regexp.MustCompile(`^(\d{1,2}[.-])?(19|20)\d{2}\s*[-.]\s*(\d{1,2}[.-])?(19|20)\d{2}$`)
+)
+
+const (
+	// minPhoneRedactDigits keeps dates and amounts out of the phone numbers that are blacked out
+	minPhoneRedactDigits = 10
+	// redactPadding is how many pixels the boxes reach beyond the words they cover
+	redactPadding = 3
+)
+
+// ocrWord is a word Tesseract found on a page, with its box in pixels
+type ocrWord struct {
+	text string
+	box  image.Rectangle
+}
+
+// ocrPage is what Tesseract found on a page: its lines of words, and the regions without text that make out
+// pictures, such as a photo of the candidate
+type ocrPage struct {
+	lines    [][]ocrWord
+	pictures []image.Rectangle
+}
+
+// redactResume blacks out the email addresses, phone numbers and photos on the pages of a resume PDF, and
+// the candidate's name in blind jobs. Every page is rendered to an image with Ghostscript, Tesseract finds
+// the words and pictures on it, and the pages are put back together as images with black boxes over what
+// was found, so nothing redacted is left under the boxes. Pages lose their text layer, OCR adds it back.
+func redactResume(ctx context.Context, pdfPath string, cand Candidate, opts JobOptions) (string, error) {
+	dir := filepath.Join(filepath.Dir(pdfPath), "redaction")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// The page renders show everything the redaction hides
+	defer os.RemoveAll(dir)
+	pages, err := renderPages(ctx, pdfPath, dir)
+	if err != nil {
+		return "", err
+	}
+	patterns := []*regexp.Regexp{redactEmailPattern, redactPhonePattern}
+	if name := strings.Fields(cand.Name); opts.Blind && len(name) > 0 {
+		quoted := make([]string, len(name))
+		for i, part := range name {
+			quoted[i] = regexp.QuoteMeta(part)
+		}
+		patterns = append(patterns, regexp.MustCompile(`(?i)`+strings.Join(quoted, `\s+`)))
+	}
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	boxes := 0
+	for i, page := range pages {
+		found, err := recognizePage(ctx, page, opts.ocrLanguages())
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i+1, err)
+		}
+		covered := append(found.matches(patterns), found.pictures...)
+		boxes += len(covered)
+		data, size, err := maskPage(page, covered)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i+1, err)
+		}
+		width, height := float64(size.X)*72/redactionDPI, float64(size.Y)*72/redactionDPI
+		orientation := "P"
+		if width > height {
+			orientation = "L"
+		}
+		name := fmt.Sprintf("page-%d", i+1)
+		pdf.AddPageFormat(orientation, gofpdf.SizeType{Wd: width, Ht: height})
+		pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: "JPG"}, bytes.NewReader(data))
+		pdf.ImageOptions(name, 0, 0, width, height, false, gofpdf.ImageOptions{ImageType: "JPG"}, 0, "")
+	}
+	outputPath := filepath.Join(filepath.Dir(pdfPath), "resume-redacted.pdf")
+	if err := pdf.OutputFileAndClose(outputPath); err != nil {
+		return "", err
+	}
+	logFrom(ctx).Debug().Msgf("Redacted %d areas on %d pages of %s", boxes, len(pages), pdfPath)
+	return outputPath, nil
+}
+
+// renderPages renders every page of a PDF to a PNG image in dir and returns their paths in order
+func renderPages(ctx context.Context, pdfPath, dir string) ([]string, error) {
+	ctx, done, err := startConversion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	cmd := exec.CommandContext(ctx, "gs", "-q", "-dSAFER", "-dBATCH", "-dNOPAUSE", "-sDEVICE=png16m",
+		fmt.Sprintf("-r%d", redactionDPI), "-sOutputFile="+filepath.Join(dir, "page-%04d.png"), pdfPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, errors.New("ghostscript is not installed")
+		}
+		return nil, timeoutError(ctx, "ghostscript", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err == nil && len(pages) == 0 {
+		err = errors.New("ghostscript rendered no pages")
+	}
+	return pages, err
+}
+
+// recognizePage runs Tesseract on a page image and reads its words and picture regions from the TSV output
+func recognizePage(ctx context.Context, imagePath string, languages []string) (*ocrPage, error) {
+	ctx, done, err := startConversion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	cmd := exec.CommandContext(ctx, "tesseract", imagePath, "stdout", "-l", strings.Join(languages, "+"), "tsv")
+	killProcessGroupOnCancel(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, errors.New("tesseract is not installed")
+		}
+		return nil, timeoutError(ctx, "tesseract", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return parseTesseractTSV(&stdout), nil
+}
+
+// parseTesseractTSV reads Tesseract's TSV output: a row per block, paragraph, line and word, with its box.
+// Blocks without a word in them are pictures, or rules and boxes drawn on the page; only those the size of a
+// photo, half an inch to three inches each way, are kept.
+func parseTesseractTSV(tsv *bytes.Buffer) *ocrPage {
+	page := &ocrPage{}
+	lines := map[string]int{}
+	var blocks []string
+	blockBoxes, blockText := map[string]image.Rectangle{}, map[string]bool{}
+	scanner := bufio.NewScanner(tsv)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 {
+			continue
+		}
+		level, err := strconv.Atoi(fields[0])
+		if err != nil {
+			// The header row
+			continue
+		}
+		var box [4]int
+		for i := range box {
+			box[i], _ = strconv.Atoi(fields[6+i])
+		}
+		rect := image.Rect(box[0], box[1], box[0]+box[2], box[1]+box[3])
+		block := fields[1] + "." + fields[2]
+		switch level {
+		case 2:
+			blocks = append(blocks, block)
+			blockBoxes[block] = rect
+		case 5:
+			text := strings.TrimSpace(fields[11])
+			if text == "" {
+				continue
+			}
+			blockText[block] = true
+			line := strings.Join(fields[1:5], ".")
+			i, ok := lines[line]
+			if !ok {
+				i = len(page.lines)
+				lines[line] = i
+				page.lines = append(page.lines, nil)
+			}
+			page.lines[i] = append(page.lines[i], ocrWord{text, rect})
+		}
+	}
+	for _, block := range blocks {
+		box := blockBoxes[block]
+		if !blockText[block] && isPhotoSize(box.Dx()) && isPhotoSize(box.Dy()) {
+			page.pictures = append(page.pictures, box)
+		}
+	}
+	return page
+}
+
+func isPhotoSize(pixels int) bool {
+	return pixels >= redactionDPI/2 && pixels <= 3*redactionDPI
+}
+
+// matches are the boxes of the words the patterns match on the page, a line at a time so numbers written
+// with spaces are found whole
+func (p *ocrPage) matches(patterns []*regexp.Regexp) []image.Rectangle {
+	var boxes []image.Rectangle
+	for _, line := range p.lines {
+		var text strings.Builder
+		starts := make([]int, len(line))
+		for i, word := range line {
+			if i > 0 {
+				text.WriteByte(' ')
+			}
+			starts[i] = text.Len()
+			text.WriteString(word.text)
+		}
+		for _, pattern := range patterns {
+			for _, match := range pattern.FindAllStringIndex(text.String(), -1) {
+				found := text.String()[match[0]:match[1]]
+				if pattern == redactPhonePattern && !isPhoneNumber(found) {
+					continue
+				}
+				for i, word := range line {
+					if starts[i] < match[1] && starts[i]+len(word.text) > match[0] {
+						boxes = append(boxes, word.box)
+					}
+				}
+			}
+		}
+	}
+	return boxes
+}
+
+// isPhoneNumber tells the phone numbers among the runs of digits the phone pattern matches
+func isPhoneNumber(s string) bool {
+	digits := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= minPhoneRedactDigits && digits <= maxPhoneDigits && !dateRangePattern.MatchString(strings.TrimSpace(s))
+}
+
+// maskPage paints the boxes black on a page image and returns it as a JPEG, with its size in pixels
+func maskPage(imagePath string, boxes []image.Rectangle) ([]byte, image.Point, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	src, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	bounds := src.Bounds()
+	page := image.NewRGBA(bounds)
+	draw.Draw(page, bounds, src, bounds.Min, draw.Src)
+	black := image.NewUniform(color.Black)
+	for _, box := range boxes {
+		draw.Draw(page, box.Inset(-redactPadding).Intersect(bounds), black, image.Point{}, draw.Src)
+	}
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, page, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, image.Point{}, err
+	}
+	return out.Bytes(), bounds.Size(), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errShutdown is the cause of jobs aborted because the service stopped before they finished
+var errShutdown = errors.New("interrupted by service shutdown")
+
+var (
+	// shuttingDown is set once a termination signal was received, new jobs are then refused
+	shuttingDown atomic.Bool
+	// activeJobs counts the jobs running in this process
+	activeJobs sync.WaitGroup
+	// admission is held while a job is counted and while shuttingDown is set, so no job is counted once the
+	// shutdown waits for activeJobs
+	admission sync.Mutex
+)
+
+// admitJob counts a job as running unless the service is shutting down, the shutdown then waits for it.
+// Admitted jobs must call activeJobs.Done once they finish.
+func admitJob() bool {
+	admission.Lock()
+	defer admission.Unlock()
+	if shuttingDown.Load() {
+		return false
+	}
+	activeJobs.Add(1)
+	return true
+}
+
+// shutdown stops accepting requests and gives running jobs until the shutdown timeout to finish. Jobs still
+// running after that are aborted so their LibreOffice processes are killed and their state is persisted.
+func shutdown(srv *http.Server) {
+	timeout := appConfig.Server.ShutdownTimeout
+	logger.Info().Msgf("Shutting down, waiting up to %s for running jobs", timeout)
+	admission.Lock()
+	shuttingDown.Store(true)
+	admission.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Shutdown closes the listeners right away and then waits for in-flight requests, such as synchronous jobs
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error().Err(err).Msg("Error shutting down HTTP server")
+		}
+	}()
+	if taskQueue != nil {
+		taskQueue.stop()
+	}
+
+	if waitWithTimeout(&activeJobs, ctx) && (taskQueue == nil || taskQueue.wait(ctx)) {
+		logger.Info().Msg("All jobs finished")
+	} else {
+		abortRunningJobs()
+	}
+	<-serverDone
+
+	if unoserver != nil {
+		unoserver.close()
+	}
+	if jobDB != nil {
+		if err := jobDB.db.Close(); err != nil {
+			logger.Error().Err(err).Msg("Error closing job store")
+		}
+	}
+	logger.Info().Msg("Shutdown complete")
+}
+
+// abortRunningJobs stops whatever is still running once the shutdown deadline has passed
+func abortRunningJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+
+	// Interrupted tasks go back onto the queue for other instances, and jobs owned through the queue
+	// are resumed when this instance comes back, so only in-process jobs are failed
+	if taskQueue != nil {
+		taskQueue.interrupt()
+		taskQueue.wait(ctx)
+		if moved := taskQueue.requeue(instanceID); moved > 0 {
+			logger.Info().Msgf("Requeued %d interrupted candidates", moved)
+		}
+		return
+	}
+
+	jobs.mu.RLock()
+	for _, job := range jobs.jobs {
+		if job.abort(errShutdown) {
+			job.log().Warn().Msgf("Aborting job %s", job.ID)
+		}
+	}
+	jobs.mu.RUnlock()
+
+	// Give aborted jobs a moment to record their final state
+	if !waitWithTimeout(&activeJobs, ctx) {
+		logger.Warn().Msg("Some jobs did not stop in time")
+	}
+}
+
+// shutdownGracePeriod is how long aborted jobs get to persist their state
+const shutdownGracePeriod = 10 * time.Second
+
+// waitWithTimeout waits for wg and reports whether it finished before ctx was done
+func waitWithTimeout(wg *sync.WaitGroup, ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const driveScope = "https://www.googleapis.com/auth/drive.readonly"
+
+// driveFileIDPattern matches the file id in /file/d/<id>/ and /document/d/<id>/ style paths
+var driveFileIDPattern = regexp.MustCompile(`/d/([A-Za-z0-9_-]{10,})`)
+
+// driveSource fetches drive.google.com and docs.google.com links through the Drive API instead of
+// downloading the HTML viewer page. Native Google Docs, Sheets and Slides are exported to PDF.
+type driveSource struct {
+	client   *http.Client
+	apiKey   string
+	endpoint string
+}
+
+// resumeDrive is nil unless Google Drive links are enabled
+var resumeDrive *driveSource
+
+// setupDriveSource is called from setupResumeSources
+func setupDriveSource() {
+	cfg := appConfig.Download.GoogleDrive
+	if !cfg.Enabled {
+		return
+	}
+	source, err := newDriveSource(cfg)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to configure Google Drive resume downloads")
+		return
+	}
+	resumeDrive = source
+	logger.Info().Msg("Google Drive resume downloads enabled")
+}
+
+// newDriveSource authenticates with an API key for files shared by link, or otherwise with the
+// configured credentials file or Application Default Credentials
+func newDriveSource(c GoogleDriveConfig) (*driveSource, error) {
+	d := &driveSource{apiKey: c.APIKey, endpoint: strings.TrimSuffix(c.Endpoint, "/")}
+	if c.APIKey != "" {
+		d.client = &http.Client{Timeout: appConfig.Processing.DownloadTimeout}
+		return d, nil
+	}
+
+	ctx := context.Background()
+	var creds *google.Credentials
+	var err error
+	if c.CredentialsFile != "" {
+		data, readErr := os.ReadFile(c.CredentialsFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read Google credentials file: %w", readErr)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, driveScope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, driveScope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google credentials: %w", err)
+	}
+	d.client = oauth2.NewClient(ctx, creds.TokenSource)
+	d.client.Timeout = appConfig.Processing.DownloadTimeout
+	return d, nil
+}
+
+// isDriveURL reports whether the URL is a Google Drive or Google Docs link
+func isDriveURL(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	return host == "drive.google.com" || host == "docs.google.com"
+}
+
+// driveFileID extracts the file id from the link formats Drive hands out
+func driveFileID(u *url.URL) (string, bool) {
+	if match := driveFileIDPattern.FindStringSubmatch(u.Path); match != nil {
+		return match[1], true
+	}
+	if id := u.Query().Get("id"); id != "" {
+		return id, true
+	}
+	return "", false
+}
+
+// fetch downloads the file behind a Drive link. An Authorization header supplied with the candidate
+// is used instead of the service's own credentials, e.g. a recruiter's OAuth token.
+func (d *driveSource) fetch(ctx context.Context, u *url.URL, headers ResumeHeaders, outputPath string) (downloadInfo, error) {
+	fileID, ok := driveFileID(u)
+	if !ok {
+		return downloadInfo{}, errors.New("unsupported Google Drive link, expected a file or document link")
+	}
+
+	var file struct {
+		MimeType string `json:"mimeType"`
+		Name     string `json:"name"`
+	}
+	resp, err := d.get(ctx, "/files/"+url.PathEscape(fileID), url.Values{"fields": {"mimeType,name"}, "supportsAllDrives": {"true"}}, headers)
+	if err != nil {
+		return downloadInfo{}, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if err != nil {
+		return downloadInfo{}, fmt.Errorf("invalid Google Drive response: %w", err)
+	}
+	info := downloadInfo{ContentType: file.MimeType, Filename: file.Name}
+
+	// Native Google documents have no file content and must be exported
+	if strings.HasPrefix(file.MimeType, "application/vnd.google-apps.") {
+		if !driveExportable(file.MimeType) {
+			return downloadInfo{}, fmt.Errorf("google drive item %q (%s) cannot be exported to PDF", file.Name, file.MimeType)
+		}
+		info = downloadInfo{ContentType: "application/pdf", Filename: file.Name + ".pdf"}
+		resp, err = d.get(ctx, "/files/"+url.PathEscape(fileID)+"/export", url.Values{"mimeType": {"application/pdf"}}, headers)
+	} else {
+		resp, err = d.get(ctx, "/files/"+url.PathEscape(fileID), url.Values{"alt": {"media"}, "supportsAllDrives": {"true"}}, headers)
+	}
+	if err != nil {
+		return downloadInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	logFrom(ctx).Debug().Msgf("Fetching Google Drive file %s (%s)", fileID, file.MimeType)
+	return info, saveDownload(resp.Body, resp.ContentLength, outputPath)
+}
+
+// probe reads the metadata of the file behind a Drive link without downloading it. Native Google documents
+// are exported as PDFs of unknown size.
+func (d *driveSource) probe(ctx context.Context, u *url.URL, headers ResumeHeaders) (resourceProbe, error) {
+	fileID, ok := driveFileID(u)
+	if !ok {
+		return resourceProbe{}, errors.New("unsupported Google Drive link, expected a file or document link")
+	}
+	var file struct {
+		MimeType string `json:"mimeType"`
+		Name     string `json:"name"`
+		Size     int64  `json:"size,string"`
+	}
+	resp, err := d.get(ctx, "/files/"+url.PathEscape(fileID), url.Values{"fields": {"mimeType,name,size"}, "supportsAllDrives": {"true"}}, headers)
+	if err != nil {
+		return resourceProbe{}, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if err != nil {
+		return resourceProbe{}, fmt.Errorf("invalid Google Drive response: %w", err)
+	}
+	if strings.HasPrefix(file.MimeType, "application/vnd.google-apps.") {
+		if !driveExportable(file.MimeType) {
+			return resourceProbe{}, fmt.Errorf("google drive item %q (%s) cannot be exported to PDF", file.Name, file.MimeType)
+		}
+		return resourceProbe{ContentType: "application/pdf", Filename: file.Name + ".pdf"}, nil
+	}
+	return resourceProbe{ContentType: file.MimeType, Filename: file.Name, Size: file.Size}, nil
+}
+
+// driveExportable reports whether a native Google document can be exported to PDF
+func driveExportable(mimeType string) bool {
+	switch mimeType {
+	case "application/vnd.google-apps.document", "application/vnd.google-apps.spreadsheet",
+		"application/vnd.google-apps.presentation", "application/vnd.google-apps.drawing":
+		return true
+	}
+	return false
+}
+
+func (d *driveSource) get(ctx context.Context, path string, query url.Values, headers ResumeHeaders) (*http.Response, error) {
+	client := d.client
+	authorization := headers.get("Authorization")
+	if authorization != "" {
+		client = &http.Client{Timeout: appConfig.Processing.DownloadTimeout}
+	} else if d.apiKey != "" {
+		query.Set("key", d.apiKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return nil, &retryableError{err: err}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	// The Drive API explains failures in the error body, e.g. exportSizeLimitExceeded
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	json.Unmarshal(body, &apiErr)
+	err = fmt.Errorf("google drive request failed: HTTP %d %s", resp.StatusCode, apiErr.Error.Message)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.New("google drive file not found or not shared with the service account")
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return nil, err
+}
+
+// get returns the value of a header, matching the name case-insensitively
+func (h ResumeHeaders) get(name string) string {
+	for key, value := range h {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
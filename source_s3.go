@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Source fetches s3://bucket/key resume URLs with the service's own AWS credentials
+type s3Source struct {
+	client  *s3.Client
+	buckets []string
+}
+
+// resumeS3 is nil unless s3:// resume URLs are allowed
+var resumeS3 *s3Source
+
+// setupResumeSources prepares the resume sources that need credentials of their own
+func setupResumeSources() {
+	setupDriveSource()
+
+	cfg := appConfig.Download
+	if !containsString(cfg.AllowedSchemes, "s3") {
+		return
+	}
+
+	source, err := newS3Source(cfg.S3)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to configure s3:// resume downloads")
+		return
+	}
+	resumeS3 = source
+	logger.Info().Msgf("s3:// resume downloads enabled for buckets %s", strings.Join(cfg.S3.Buckets, ", "))
+}
+
+// newS3Source uses the default AWS credential chain (env, shared config, IAM role)
+func newS3Source(c S3SourceConfig) (*s3Source, error) {
+	opts := []func(*config.LoadOptions) error{}
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Source{client: client, buckets: normalizeHosts(c.Buckets)}, nil
+}
+
+// fetch downloads the object. Only buckets in the configured list can be read, since the service's
+// credentials usually reach far more than resumes.
+func (s *s3Source) fetch(ctx context.Context, u *url.URL, outputPath string) (downloadInfo, error) {
+	bucket, key, err := s.object(u)
+	if err != nil {
+		return downloadInfo{}, err
+	}
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		var noSuchBucket *types.NoSuchBucket
+		if errors.As(err, &noSuchKey) || errors.As(err, &noSuchBucket) {
+			return downloadInfo{}, fmt.Errorf("s3 object %s/%s not found", bucket, key)
+		}
+		return downloadInfo{}, fmt.Errorf("failed to get s3 object %s/%s: %w", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	info := downloadInfo{
+		ETag:        aws.ToString(out.ETag),
+		ContentType: aws.ToString(out.ContentType),
+		Filename:    dispositionFilename(aws.ToString(out.ContentDisposition)),
+	}
+	return info, saveDownload(out.Body, aws.ToInt64(out.ContentLength), outputPath)
+}
+
+// object returns the bucket and key of an s3:// URL, if the bucket may be read
+func (s *s3Source) object(u *url.URL) (string, string, error) {
+	bucket := strings.ToLower(u.Host)
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" {
+		return "", "", errors.New("s3 URL has no object key")
+	}
+	if !containsString(s.buckets, "*") && !matchesHost(s.buckets, bucket) {
+		return "", "", fmt.Errorf("%w: bucket %s is not allowed", errDownloadBlocked, bucket)
+	}
+	return bucket, key, nil
+}
+
+// probe looks the object up without downloading it
+func (s *s3Source) probe(ctx context.Context, u *url.URL) (resourceProbe, error) {
+	bucket, key, err := s.object(u)
+	if err != nil {
+		return resourceProbe{}, err
+	}
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return resourceProbe{}, fmt.Errorf("s3 object %s/%s not found", bucket, key)
+		}
+		return resourceProbe{}, fmt.Errorf("failed to look up s3 object %s/%s: %w", bucket, key, err)
+	}
+	return resourceProbe{
+		ContentType: aws.ToString(out.ContentType),
+		Filename:    dispositionFilename(aws.ToString(out.ContentDisposition)),
+		Size:        aws.ToInt64(out.ContentLength),
+	}, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxSplitArchiveMB is the largest part size a job can ask for
+const maxSplitArchiveMB = 4096
+
+// archiveEntryOverhead covers the headers an archive adds to every file, and then some
+const archiveEntryOverhead = 1024
+
+// ArchivePart is one of the numbered archives the files of a job are split into
+type ArchivePart struct {
+	Part     int    `json:"part"`
+	FileName string `json:"file_name"`
+	// Path is where the part is kept on this instance, empty once it is delivered to remote storage
+	Path     string          `json:"path,omitempty"`
+	Size     int64           `json:"size"`
+	Files    []string        `json:"files"`
+	Delivery *DeliveryResult `json:"delivery,omitempty"`
+}
+
+// leadFiles go in the first part, which so tells what the other parts hold
+var leadFiles = []string{manifestJSONName, manifestCSVName, summaryWorkbookName, indexPDFName}
+
+// archiveFile is a file going into the archive, by its path relative to the job's factsheet directory
+type archiveFile struct {
+	name string
+	size int64
+}
+
+// listArchiveFiles are the files of factsheetDir, the lead files first and the rest in the order of their
+// paths
+func listArchiveFiles(factsheetDir string) ([]archiveFile, error) {
+	var files []archiveFile
+	err := filepath.Walk(factsheetDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(factsheetDir, path)
+		files = append(files, archiveFile{filepath.ToSlash(rel), info.Size()})
+		return nil
+	})
+	lead := func(f archiveFile) int {
+		if i := slices.Index(leadFiles, f.name); i >= 0 {
+			return i
+		}
+		return len(leadFiles)
+	}
+	slices.SortStableFunc(files, func(a, b archiveFile) int { return lead(a) - lead(b) })
+	return files, err
+}
+
+// planArchiveParts groups the files in order into parts of at most limit bytes each. A file too large for
+// a part of its own still gets one. reserve is room kept in the first part for the manifest to grow.
+func planArchiveParts(files []archiveFile, limit, reserve int64) [][]archiveFile {
+	var parts [][]archiveFile
+	var size int64
+	for _, f := range files {
+		cost := f.size + f.size/1000 + archiveEntryOverhead
+		if len(parts) == 0 || (size+cost > limit && len(parts[len(parts)-1]) > 0) {
+			parts = append(parts, nil)
+			size = 0
+			if len(parts) == 1 {
+				size = reserve
+			}
+		}
+		parts[len(parts)-1] = append(parts[len(parts)-1], f)
+		size += cost
+	}
+	return parts
+}
+
+// splitArchive packs the files of the job into numbered archives of at most split_archive_mb each, named
+// like the archive with .part1, .part2 and so on before the extension. It returns nil when everything
+// fits in one archive, which is then made as usual.
+func splitArchive(job *Job, req ProcessRequest, factsheetDir, baseName string) ([]ArchivePart, error) {
+	files, err := listArchiveFiles(factsheetDir)
+	if err != nil {
+		return nil, err
+	}
+	limit := int64(req.SplitArchiveMB) << 20
+	// Every candidate's entry in the manifest gains its part, and the manifest a list of the parts
+	reserve := int64(32*len(req.Candidates) + 256*len(files))
+	plan := planArchiveParts(files, limit, reserve)
+	if len(plan) <= 1 {
+		return nil, nil
+	}
+
+	parts := make([]ArchivePart, len(plan))
+	for i, planned := range plan {
+		parts[i] = ArchivePart{
+			Part:     i + 1,
+			FileName: fmt.Sprintf("%s.part%d%s", baseName, i+1, archiveExtension(req.ArchiveFormat)),
+		}
+		for _, f := range planned {
+			parts[i].Files = append(parts[i].Files, f.name)
+			if f.size+archiveEntryOverhead > limit {
+				job.warn(fmt.Sprintf("%s is larger than split_archive_mb, part %d holds only it", f.name, i+1))
+			}
+		}
+	}
+	if err := addManifestParts(factsheetDir, parts); err != nil {
+		return nil, fmt.Errorf("failed to add the parts to the manifest: %w", err)
+	}
+
+	for i := range parts {
+		parts[i].Path = filepath.Join(appConfig.Storage.ArchiveDir, parts[i].FileName)
+		files := map[string]bool{}
+		for _, name := range parts[i].Files {
+			files[name] = true
+		}
+		if err := archiveFolder(req.JobOptions, factsheetDir, parts[i].Path, files); err != nil {
+			for _, part := range parts[:i+1] {
+				os.Remove(part.Path)
+			}
+			return nil, fmt.Errorf("part %d: %w", i+1, err)
+		}
+		if size, err := atRestSize(parts[i].Path); err == nil {
+			parts[i].Size = size
+		}
+	}
+	job.log().Info().Msgf("Split archive of job %s into %d parts", job.ID, len(parts))
+	return parts, nil
+}
+
+// addManifestParts records in manifest.json, and manifest.csv if the job has one, which part holds each
+// candidate's packet, and the parts
+func addManifestParts(factsheetDir string, parts []ArchivePart) error {
+	path := filepath.Join(factsheetDir, manifestJSONName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var manifest jobManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		manifest.Parts = append(manifest.Parts, part.FileName)
+		for i := range manifest.Candidates {
+			if file := manifest.Candidates[i].File; file != "" && slices.Contains(part.Files, file) {
+				manifest.Candidates[i].Part = part.Part
+			}
+		}
+	}
+	if data, err = json.MarshalIndent(manifest, "", "  "); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	csvPath := filepath.Join(factsheetDir, manifestCSVName)
+	if _, err := os.Stat(csvPath); err == nil {
+		return writeManifestCSV(manifest.Candidates, csvPath)
+	}
+	return nil
+}
+
+// deliverArchiveParts uploads every part of the archive with the backend. The local copies are only
+// removed once all parts are delivered, so a failed job can still be downloaded from this instance.
+func deliverArchiveParts(backend string, parts []ArchivePart) error {
+	for i := range parts {
+		delivery, err := deliverArchive(backend, parts[i].Path, parts[i].FileName)
+		if err != nil {
+			return fmt.Errorf("part %d: %w", parts[i].Part, err)
+		}
+		parts[i].Delivery = delivery
+	}
+	for i := range parts {
+		if err := os.Remove(parts[i].Path); err != nil {
+			logger.Error().Err(err).Msgf("Error removing delivered archive part %s", parts[i].Path)
+		}
+		parts[i].Path = ""
+	}
+	return nil
+}
+
+// archivePart finds the part of the job's split archive numbered part, as given in a download request
+func (j *Job) archivePart(part string) (ArchivePart, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, p := range j.Parts {
+		if fmt.Sprint(p.Part) == strings.TrimSpace(part) {
+			return p, true
+		}
+	}
+	return ArchivePart{}, false
+}
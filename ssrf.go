@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DownloadPolicy restricts where resumes may be downloaded from. Private, loopback and link-local
+// addresses are blocked unless AllowPrivateNetworks is set or the address is in AllowedNetworks.
+type DownloadPolicy struct {
+	AllowedSchemes []string `yaml:"allowed_schemes" json:"allowed_schemes,omitempty" env:"DOWNLOAD_ALLOWED_SCHEMES"`
+	// Host patterns match the host exactly or, written as "*.example.com", any of its subdomains.
+	// An empty allowlist allows every host that is not denied.
+	AllowedHosts []string `yaml:"allowed_hosts" json:"allowed_hosts,omitempty" env:"DOWNLOAD_ALLOWED_HOSTS"`
+	DeniedHosts  []string `yaml:"denied_hosts" json:"denied_hosts,omitempty" env:"DOWNLOAD_DENIED_HOSTS"`
+	// Networks are CIDR ranges checked against every address a download connects to
+	AllowedNetworks      []string `yaml:"allowed_networks" json:"allowed_networks,omitempty" env:"DOWNLOAD_ALLOWED_NETWORKS"`
+	DeniedNetworks       []string `yaml:"denied_networks" json:"denied_networks,omitempty" env:"DOWNLOAD_DENIED_NETWORKS"`
+	AllowPrivateNetworks bool     `yaml:"allow_private_networks" json:"allow_private_networks,omitempty" env:"DOWNLOAD_ALLOW_PRIVATE_NETWORKS"`
+}
+
+// errDownloadBlocked is returned for resume URLs the download policy does not allow
+var errDownloadBlocked = errors.New("resume URL is not allowed")
+
+// reservedNetworks are not publicly routable but are missed by the netip classification helpers
+var reservedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// merge applies a tenant override. Allowed schemes and hosts replace the defaults so a tenant can be
+// restricted further; denied hosts and networks and allowed networks are added to the defaults.
+func (p DownloadPolicy) merge(override *DownloadPolicy) DownloadPolicy {
+	if override == nil {
+		return p
+	}
+	merged := p
+	if len(override.AllowedSchemes) > 0 {
+		merged.AllowedSchemes = override.AllowedSchemes
+	}
+	if len(override.AllowedHosts) > 0 {
+		merged.AllowedHosts = override.AllowedHosts
+	}
+	merged.DeniedHosts = slices.Concat(p.DeniedHosts, override.DeniedHosts)
+	merged.AllowedNetworks = slices.Concat(p.AllowedNetworks, override.AllowedNetworks)
+	merged.DeniedNetworks = slices.Concat(p.DeniedNetworks, override.DeniedNetworks)
+	merged.AllowPrivateNetworks = p.AllowPrivateNetworks || override.AllowPrivateNetworks
+	return merged
+}
+
+// downloadGuard is a compiled DownloadPolicy
+type downloadGuard struct {
+	schemes         []string
+	allowedHosts    []string
+	deniedHosts     []string
+	allowedNetworks []netip.Prefix
+	deniedNetworks  []netip.Prefix
+	allowPrivate    bool
+}
+
+func (p DownloadPolicy) compile() (*downloadGuard, error) {
+	g := &downloadGuard{
+		allowedHosts: normalizeHosts(p.AllowedHosts),
+		deniedHosts:  normalizeHosts(p.DeniedHosts),
+		allowPrivate: p.AllowPrivateNetworks,
+	}
+	for _, scheme := range p.AllowedSchemes {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "http" && scheme != "https" && scheme != "s3" {
+			return nil, fmt.Errorf("unsupported download scheme %q", scheme)
+		}
+		g.schemes = append(g.schemes, scheme)
+	}
+	var err error
+	if g.allowedNetworks, err = parsePrefixes(p.AllowedNetworks); err != nil {
+		return nil, err
+	}
+	if g.deniedNetworks, err = parsePrefixes(p.DeniedNetworks); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func normalizeHosts(hosts []string) []string {
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			normalized = append(normalized, strings.TrimSuffix(host, "."))
+		}
+	}
+	return normalized
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// checkURL validates the scheme and host of a URL before any connection is made
+func (g *downloadGuard) checkURL(u *url.URL) error {
+	if !slices.Contains(g.schemes, strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("%w: scheme %q is not allowed", errDownloadBlocked, u.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("%w: missing host", errDownloadBlocked)
+	}
+	if matchesHost(g.deniedHosts, host) {
+		return fmt.Errorf("%w: host %s is denied", errDownloadBlocked, host)
+	}
+	if len(g.allowedHosts) > 0 && !matchesHost(g.allowedHosts, host) {
+		return fmt.Errorf("%w: host %s is not in the allowlist", errDownloadBlocked, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && u.Scheme != "s3" {
+		return g.checkAddr(addr)
+	}
+	return nil
+}
+
+func matchesHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddr validates an address a download is about to connect to
+func (g *downloadGuard) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range g.deniedNetworks {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: address %s is in a denied network", errDownloadBlocked, addr)
+		}
+	}
+	for _, prefix := range g.allowedNetworks {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if !g.allowPrivate && isNonPublicAddr(addr) {
+		return fmt.Errorf("%w: address %s is not public", errDownloadBlocked, addr)
+	}
+	return nil
+}
+
+func isNonPublicAddr(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range reservedNetworks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns an HTTP client that applies the policy to every redirect and to the address of every
+// connection, so a host that resolves to an internal address is caught after DNS resolution. Proxies
+// from the environment are not used, they would hide the real destination.
+func (g *downloadGuard) client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: unexpected address %s", errDownloadBlocked, address)
+			}
+			return g.checkAddr(addrPort.Addr())
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return g.checkURL(req.URL)
+		},
+	}
+}
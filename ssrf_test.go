@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func mustGuard(t *testing.T, policy DownloadPolicy) *downloadGuard {
+	t.Helper()
+	if policy.AllowedSchemes == nil {
+		policy.AllowedSchemes = []string{"http", "https"}
+	}
+	guard, err := policy.compile()
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	return guard
+}
+
+func TestDownloadGuardCheckURL(t *testing.T) {
+	guard := mustGuard(t, DownloadPolicy{DeniedHosts: []string{"*.internal.example.com"}})
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://93.184.216.34/cv.pdf", false},
+		{"https://cdn.example.com/cv.pdf", false},
+		{"http://127.0.0.1/cv.pdf", true},
+		{"http://10.1.2.3/cv.pdf", true},
+		{"http://172.16.0.1/cv.pdf", true},
+		{"http://192.168.1.1/cv.pdf", true},
+		{"http://100.64.0.1/cv.pdf", true},
+		{"http://0.0.0.0/cv.pdf", true},
+		// Cloud metadata endpoints are link-local
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://[fe80::1]/cv.pdf", true},
+		{"http://[::1]/cv.pdf", true},
+		{"http://[fd00::1]/cv.pdf", true},
+		// IPv4-mapped IPv6 addresses are judged by the IPv4 address they carry
+		{"http://[::ffff:127.0.0.1]/cv.pdf", true},
+		{"http://[::ffff:169.254.169.254]/cv.pdf", true},
+		{"http://[::ffff:10.0.0.1]/cv.pdf", true},
+		{"http://[::ffff:93.184.216.34]/cv.pdf", false},
+		// NAT64 addresses reach IPv4 through a translator
+		{"http://[64:ff9b::7f00:1]/cv.pdf", true},
+		{"ftp://cdn.example.com/cv.pdf", true},
+		{"file:///etc/passwd", true},
+		{"https://files.internal.example.com/cv.pdf", true},
+		{"https:///cv.pdf", true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tt.url, err)
+		}
+		err = guard.checkURL(u)
+		if blocked := errors.Is(err, errDownloadBlocked); blocked != tt.blocked {
+			t.Errorf("checkURL(%s) = %v, blocked %v", tt.url, err, tt.blocked)
+		}
+	}
+}
+
+func TestDownloadGuardCheckAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  DownloadPolicy
+		addr    string
+		blocked bool
+	}{
+		{"public", DownloadPolicy{}, "8.8.8.8", false},
+		{"private", DownloadPolicy{}, "10.0.0.1", true},
+		{"mapped private", DownloadPolicy{}, "::ffff:192.168.0.1", true},
+		{"mapped link-local", DownloadPolicy{}, "::ffff:169.254.169.254", true},
+		{"multicast", DownloadPolicy{}, "224.0.0.1", true},
+		{"allowed network", DownloadPolicy{AllowedNetworks: []string{"10.20.0.0/16"}}, "10.20.1.1", false},
+		{"allowed network mapped", DownloadPolicy{AllowedNetworks: []string{"10.20.0.0/16"}}, "::ffff:10.20.1.1", false},
+		{"outside allowed network", DownloadPolicy{AllowedNetworks: []string{"10.20.0.0/16"}}, "10.21.0.1", true},
+		{"private allowed", DownloadPolicy{AllowPrivateNetworks: true}, "10.0.0.1", false},
+		{"denied beats allowed", DownloadPolicy{AllowPrivateNetworks: true, DeniedNetworks: []string{"10.0.0.0/8"}}, "10.0.0.1", true},
+		{"denied public", DownloadPolicy{DeniedNetworks: []string{"8.8.8.8"}}, "8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mustGuard(t, tt.policy).checkAddr(netip.MustParseAddr(tt.addr))
+			if blocked := errors.Is(err, errDownloadBlocked); blocked != tt.blocked {
+				t.Errorf("checkAddr(%s) = %v, blocked %v", tt.addr, err, tt.blocked)
+			}
+		})
+	}
+}
+
+// A public host name can resolve to an internal address, the dialer catches it after resolution
+func TestDownloadGuardClientBlocksLoopbackConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	resp, err := mustGuard(t, DownloadPolicy{}).client(5 * time.Second).Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, errDownloadBlocked) {
+		t.Fatalf("Get(%s) = %v, want a blocked download", srv.URL, err)
+	}
+
+	resp, err = mustGuard(t, DownloadPolicy{AllowPrivateNetworks: true}).client(5 * time.Second).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get(%s) with private networks allowed: %v", srv.URL, err)
+	}
+	resp.Body.Close()
+}
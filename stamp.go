@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	pdfcpufont "github.com/pdfcpu/pdfcpu/pkg/font"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/font/sfnt"
+)
+
+// maxWatermarkLength keeps watermarks to a line that fits across the page
+const maxWatermarkLength = 100
+
+// watermarkText fills in the placeholders of a watermark known when the job is submitted, {CandidateName}
+// is left for each candidate
+func watermarkText(watermark, tenant, company string) string {
+	return strings.NewReplacer("{CompanyName}", company, "{TenantName}", tenant).Replace(watermark)
+}
+
+// watermarkCoreFont draws watermarks when there are no TrueType fonts. pdfcpu writes its text in the
+// Latin-1 characters of the font, anything else comes out as a space.
+const watermarkCoreFont = "Helvetica-Bold"
+
+// watermarkFonts are the names pdfcpu knows the bold style of each factsheet font by, by family
+var watermarkFonts = map[string]string{}
+
+// setupWatermarkFonts installs the bold style of the factsheet fonts as pdfcpu fonts, so watermarks are
+// drawn in the fonts of the factsheets. pdfcpu only draws in core fonts and those of its font directory,
+// which lives in the scratch directory.
+func setupWatermarkFonts() {
+	if len(factsheetFonts) == 0 {
+		return
+	}
+	dir := filepath.Join(appConfig.Processing.ScratchDir, "pdfcpu-fonts")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Warn().Err(err).Msgf("Watermarks use %s", watermarkCoreFont)
+		return
+	}
+	pdfcpufont.UserFontDir = dir
+	names := map[string]string{}
+	for _, font := range factsheetFonts {
+		name, err := installWatermarkFont(dir, font)
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Watermarks skip font %s", font.family)
+			continue
+		}
+		names[font.family] = name
+	}
+	if err := runPDFCPU(pdfcpufont.LoadUserFonts); err != nil {
+		logger.Warn().Err(err).Msgf("Watermarks use %s", watermarkCoreFont)
+		return
+	}
+	for family, name := range names {
+		if pdfcpufont.IsUserFont(name) {
+			watermarkFonts[family] = name
+		}
+	}
+}
+
+// installWatermarkFont installs the bold style of font in dir and returns the name pdfcpu knows it by,
+// its PostScript name
+func installWatermarkFont(dir string, font *factsheetFont) (string, error) {
+	bold := font.styles["B"]
+	face, err := sfnt.Parse(bold)
+	if err != nil {
+		return "", fmt.Errorf("not a TrueType font: %w", err)
+	}
+	name, err := face.Name(nil, sfnt.NameIDPostScript)
+	if err != nil {
+		return "", fmt.Errorf("no PostScript name: %w", err)
+	}
+	err = runPDFCPU(func() error { return pdfcpufont.InstallFontFromBytes(dir, font.family, bold) })
+	return name, err
+}
+
+// stampWatermark stamps the watermark diagonally across every page of a PDF, the factsheet and the
+// resume pages alike. pdfcpu draws it in process, light gray and translucent so the page underneath
+// stays readable, and over the content so no scanned page hides it.
+func stampWatermark(ctx context.Context, pdfPath, watermark, direction string) error {
+	conf := pdfcpuConfig()
+	wm, err := watermarkStamp(watermark, direction)
+	if err != nil {
+		return fmt.Errorf("failed to render watermark: %w", err)
+	}
+	return writePDFWithPDFCPU(ctx, "watermark", pdfPath, func(tmp string) error {
+		return api.AddWatermarksFile(pdfPath, tmp, nil, wm, conf)
+	})
+}
+
+// watermarkStamp describes the watermark for pdfcpu: from the bottom left to the top right corner, up to
+// 96pt and scaled to about two thirds of the diagonal of an A4 page. Like the factsheet text, Arabic is
+// shaped and right-to-left text put in visual order, in the first factsheet font that has its characters.
+func watermarkStamp(watermark, direction string) (*model.Watermark, error) {
+	text, fontName := watermark, watermarkCoreFont
+	if len(factsheetFonts) > 0 {
+		shaped := shapeArabic(watermark)
+		if name, ok := watermarkFonts[pickFont(shaped).family]; ok {
+			text, fontName = visualOrder(shaped, direction), name
+		}
+	}
+
+	scale := 1.0
+	a4Diagonal := math.Hypot(595.28, 841.89)
+	if width := pdfcpufont.TextWidth(text, fontName, 96); width > a4Diagonal*2/3 {
+		scale = a4Diagonal * 2 / 3 / width
+	}
+	desc := fmt.Sprintf("fontname:%s, points:96, scalefactor:%.3f abs, diagonal:1, opacity:0.15, fillcolor:#808080",
+		fontName, scale)
+	return api.TextWatermark(text, desc, true, false, types.POINTS)
+}
+
+// stampPageNumbers writes the candidate's name and "Page X of Y" at the foot of every page of a packet,
+// so a printed stack of factsheets can be put back in order
+func stampPageNumbers(ctx context.Context, pdfPath, name, direction, candTempDir string) error {
+	pages, err := pdfPageCount(ctx, pdfPath, "")
+	if err != nil {
+		return err
+	}
+	stampPath := filepath.Join(candTempDir, "page-numbers.pdf")
+	if err := renderPageNumbers(name, pages, direction, stampPath); err != nil {
+		return fmt.Errorf("failed to render page numbers: %w", err)
+	}
+	return overlayPDF(ctx, pdfPath, stampPath, false)
+}
+
+// renderPageNumbers draws one footer page for every page of the packet: the name at the start of the
+// line and the page number at its end
+func renderPageNumbers(name string, pages int, direction, outputPath string) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	text := newFactsheetText(pdf, direction)
+	pdf.SetAutoPageBreak(false, 0)
+	nameAlign, numberAlign := "L", "R"
+	if direction == directionRTL {
+		nameAlign, numberAlign = "R", "L"
+	}
+	for page := 1; page <= pages; page++ {
+		pdf.AddPage()
+		pageWidth, pageHeight := pdf.GetPageSize()
+		left, _, right, _ := pdf.GetMargins()
+		pdf.SetTextColor(128, 128, 128)
+		pdf.SetXY(left, pageHeight-8)
+		pdf.CellFormat(pageWidth-left-right, 4, text.font("", 8, name), "", 0, nameAlign, false, 0, "")
+		number := fmt.Sprintf("Page %d of %d", page, pages)
+		pdf.SetXY(left, pageHeight-8)
+		pdf.CellFormat(pageWidth-left-right, 4, text.font("", 8, number), "", 0, numberAlign, false, 0, "")
+	}
+	return pdf.OutputFileAndClose(outputPath)
+}
+
+// pdfPageCount asks qpdf for the number of pages of a PDF, opening encrypted PDFs with password
+func pdfPageCount(ctx context.Context, pdfPath, password string) (int, error) {
+	ctx, cancel := withConversionTimeout(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "qpdf", "--show-npages", pdfPath)
+	if password != "" {
+		cmd = exec.CommandContext(ctx, "qpdf", "--password-file=-", "--show-npages", pdfPath)
+		cmd.Stdin = strings.NewReader(password + "\n")
+	}
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		switch {
+		case errors.Is(err, exec.ErrNotFound):
+			return 0, errors.New("qpdf is not installed, it is needed to stamp pages")
+		case ctx.Err() != nil:
+			return 0, timeoutError(ctx, "qpdf", ctx.Err())
+		}
+		return 0, fmt.Errorf("failed to count pages: %s", qpdfMessage(stderr.String(), pdfPath))
+	}
+	pages, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil || pages < 1 {
+		return 0, fmt.Errorf("failed to count pages: unexpected qpdf output %q", strings.TrimSpace(string(output)))
+	}
+	return pages, nil
+}
+
+// overlayPDF puts the pages of a stamp PDF over the pages of a PDF with qpdf, scaled to fit each page.
+// With repeat the first stamp page goes on every page, otherwise stamp page n goes on page n.
+func overlayPDF(ctx context.Context, pdfPath, stampPath string, repeat bool) error {
+	outputPath := pdfPath + ".stamped"
+	args := []string{pdfPath, "--overlay", stampPath}
+	if repeat {
+		args = append(args, "--repeat=1")
+	}
+	args = append(args, "--", outputPath)
+	if err := runQPDF(ctx, "", args...); err != nil {
+		os.Remove(outputPath)
+		var failure *qpdfFailure
+		switch {
+		case errors.Is(err, exec.ErrNotFound):
+			return errors.New("qpdf is not installed, it is needed to stamp pages")
+		case errors.As(err, &failure):
+			return fmt.Errorf("failed to stamp pages: %s", failure.message(pdfPath))
+		}
+		return err
+	}
+	return os.Rename(outputPath, pdfPath)
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// errJobNotFound is returned when a job does not exist in the store
+var errJobNotFound = errors.New("job not found")
+
+// sqlJobStore persists jobs and candidate statuses so they survive restarts.
+// Queries use $N placeholders and ON CONFLICT upserts, which both SQLite and Postgres understand.
+type sqlJobStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// jobDB is nil when persistence is disabled
+var jobDB *sqlJobStore
+
+const jobStoreSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id                     VARCHAR(64) PRIMARY KEY,
+	tenant_name            TEXT NOT NULL,
+	company_name           TEXT NOT NULL,
+	status                 VARCHAR(50) NOT NULL,
+	total_candidates       INTEGER NOT NULL,
+	processed_successfully INTEGER NOT NULL,
+	errors                 TEXT NOT NULL,
+	zip_file_path          TEXT NOT NULL,
+	zip_file_name          TEXT NOT NULL,
+	delivery               TEXT NOT NULL,
+	owner                  VARCHAR(255) NOT NULL DEFAULT '',
+	idempotency_key        VARCHAR(255) NOT NULL DEFAULT '',
+	request_hash           VARCHAR(64) NOT NULL DEFAULT '',
+	created_at             TIMESTAMP NOT NULL,
+	started_at             TIMESTAMP NULL,
+	completed_at           TIMESTAMP NULL,
+	archive_expires_at     TIMESTAMP NULL,
+	archive_deleted_at     TIMESTAMP NULL,
+	warnings               TEXT NOT NULL DEFAULT '',
+	archive_parts          TEXT NOT NULL DEFAULT '',
+	archive_password       TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS job_candidates (
+	job_id       VARCHAR(64) NOT NULL,
+	idx          INTEGER NOT NULL,
+	name         TEXT NOT NULL,
+	email        TEXT NOT NULL,
+	status       VARCHAR(50) NOT NULL,
+	error        TEXT NOT NULL,
+	started_at   TIMESTAMP NULL,
+	completed_at TIMESTAMP NULL,
+	download_attempts INTEGER NOT NULL DEFAULT 0,
+	error_code   VARCHAR(64) NOT NULL DEFAULT '',
+	format       VARCHAR(255) NOT NULL DEFAULT '',
+	converter    VARCHAR(64) NOT NULL DEFAULT '',
+	candidate_id VARCHAR(64) NOT NULL DEFAULT '',
+	file         TEXT NOT NULL DEFAULT '',
+	failure      TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (job_id, idx)
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs (created_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_tenant_created_at ON jobs (tenant_name, created_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_completed_at ON jobs (completed_at);
+CREATE TABLE IF NOT EXISTS tenant_settings (
+	tenant_name               VARCHAR(255) PRIMARY KEY,
+	max_concurrent_candidates INTEGER NOT NULL,
+	candidates_per_minute     INTEGER NOT NULL,
+	jobs_per_minute           INTEGER NOT NULL,
+	archive_retention         VARCHAR(32) NOT NULL DEFAULT '',
+	job_retention             VARCHAR(32) NOT NULL DEFAULT '',
+	scratch_dir               TEXT NOT NULL DEFAULT '',
+	download_policy           TEXT NOT NULL DEFAULT '',
+	branding                  TEXT NOT NULL DEFAULT '',
+	theme                     VARCHAR(32) NOT NULL DEFAULT '',
+	email                     TEXT NOT NULL DEFAULT '',
+	notifications             TEXT NOT NULL DEFAULT '',
+	updated_at                TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS audit_log (
+	id          VARCHAR(64) PRIMARY KEY,
+	created_at  TIMESTAMP NOT NULL,
+	tenant_name VARCHAR(255) NOT NULL,
+	action      VARCHAR(64) NOT NULL,
+	job_id      VARCHAR(64) NOT NULL,
+	actor       TEXT NOT NULL,
+	client_cn   TEXT NOT NULL,
+	client_ip   VARCHAR(64) NOT NULL,
+	request_id  VARCHAR(128) NOT NULL,
+	details     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_tenant_created_at ON audit_log (tenant_name, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_job_id ON audit_log (job_id);
+`
+
+// jobStoreMigrations upgrade databases created by earlier versions. Errors for changes that were
+// already applied are ignored.
+var jobStoreMigrations = []string{
+	`ALTER TABLE jobs ADD COLUMN owner VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE jobs ADD COLUMN idempotency_key VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE jobs ADD COLUMN request_hash VARCHAR(64) NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_idempotency_key ON jobs (tenant_name, idempotency_key) WHERE idempotency_key <> ''`,
+	`ALTER TABLE jobs ADD COLUMN archive_expires_at TIMESTAMP NULL`,
+	`ALTER TABLE jobs ADD COLUMN archive_deleted_at TIMESTAMP NULL`,
+	`ALTER TABLE tenant_settings ADD COLUMN archive_retention VARCHAR(32) NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN download_policy TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE job_candidates ADD COLUMN download_attempts INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE job_candidates ADD COLUMN error_code VARCHAR(64) NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN branding TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE job_candidates ADD COLUMN format VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE job_candidates ADD COLUMN converter VARCHAR(64) NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN theme VARCHAR(32) NOT NULL DEFAULT ''`,
+	`ALTER TABLE job_candidates ADD COLUMN candidate_id VARCHAR(64) NOT NULL DEFAULT ''`,
+	`ALTER TABLE jobs ADD COLUMN warnings TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE jobs ADD COLUMN archive_parts TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN email TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN notifications TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE job_candidates ADD COLUMN file TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE job_candidates ADD COLUMN failure TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN job_retention VARCHAR(32) NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenant_settings ADD COLUMN scratch_dir TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE jobs ADD COLUMN archive_password TEXT NOT NULL DEFAULT ''`,
+}
+
+// setupJobStore opens the job database. The DSN is a postgres:// URL or a SQLite file path,
+// "none" disables persistence.
+func setupJobStore() {
+	dsn := appConfig.Storage.JobStoreDSN
+	if dsn == "none" {
+		logger.Warn().Msg("Job persistence disabled, job state is kept in memory only")
+		return
+	}
+
+	store, err := openJobStore(dsn)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to open job store, job state is kept in memory only")
+		return
+	}
+	jobDB = store
+	logger.Info().Msgf("Job store initialized (%s)", store.driver)
+}
+
+// failInterruptedJobs marks jobs this instance left running before a restart as failed,
+// except those that were resumed from the task queue
+func failInterruptedJobs(resumed []string) {
+	if jobDB == nil {
+		return
+	}
+	if count, err := jobDB.markInterrupted(instanceID, resumed); err != nil {
+		logger.Error().Err(err).Msg("Error marking interrupted jobs")
+	} else if count > 0 {
+		logger.Warn().Msgf("Marked %d jobs interrupted by the previous shutdown as failed", count)
+	}
+}
+
+func openJobStore(dsn string) (*sqlJobStore, error) {
+	driver := "sqlite3"
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		driver = "postgres"
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dsn), 0700); err != nil {
+			return nil, err
+		}
+		// WAL and a busy timeout keep concurrent candidate updates from failing with "database is locked"
+		dsn = "file:" + dsn + "?_journal_mode=WAL&_busy_timeout=5000"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Run statements one at a time so both drivers behave the same
+	for _, stmt := range strings.Split(jobStoreSchema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	for _, stmt := range jobStoreMigrations {
+		if _, err := db.Exec(stmt); err != nil && !isAlreadyApplied(err) {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate schema: %w", err)
+		}
+	}
+
+	return &sqlJobStore{db: db, driver: driver}, nil
+}
+
+func isAlreadyApplied(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate column") || strings.Contains(msg, "already exists")
+}
+
+func isUniqueViolation(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unique constraint") || strings.Contains(msg, "duplicate key")
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// createJob inserts a new job together with all of its candidates
+func (s *sqlJobStore) createJob(job *Job) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.saveJob(tx, job); err != nil {
+		return err
+	}
+
+	job.mu.Lock()
+	candidates := append([]CandidateProgress(nil), job.Candidates...)
+	job.mu.Unlock()
+	for i, cand := range candidates {
+		if err := s.saveCandidate(tx, job.ID, i, cand); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// saveJob upserts the job row without touching candidate rows
+func (s *sqlJobStore) saveJob(db execer, job *Job) error {
+	job.mu.Lock()
+	errorsJSON, _ := json.Marshal(job.Errors)
+	warningsJSON := []byte("")
+	if len(job.Warnings) > 0 {
+		warningsJSON, _ = json.Marshal(job.Warnings)
+	}
+	deliveryJSON := []byte("")
+	if job.Delivery != nil {
+		deliveryJSON, _ = json.Marshal(job.Delivery)
+	}
+	partsJSON := []byte("")
+	if len(job.Parts) > 0 {
+		partsJSON, _ = json.Marshal(job.Parts)
+	}
+	// The generated archive password never changes, it is only written with the new row
+	var archivePassword string
+	if job.ArchivePassword != "" && atRest != nil {
+		var err error
+		if archivePassword, err = atRest.sealValue(job.ArchivePassword); err != nil {
+			job.mu.Unlock()
+			return fmt.Errorf("failed to encrypt archive password: %w", err)
+		}
+	}
+	args := []any{
+		job.ID, job.TenantName, job.CompanyName, job.Status, len(job.Candidates), job.SuccessCount,
+		string(errorsJSON), job.ZipPath, job.ZipFileName, string(deliveryJSON), instanceID,
+		job.IdempotencyKey, job.RequestHash, job.CreatedAt.UTC(), nullTime(job.StartedAt), nullTime(job.CompletedAt),
+		nullTime(job.ArchiveExpiresAt), nullTime(job.ArchiveDeletedAt), string(warningsJSON),
+		string(partsJSON), archivePassword,
+	}
+	job.mu.Unlock()
+
+	_, err := db.Exec(`
+		INSERT INTO jobs (id, tenant_name, company_name, status, total_candidates, processed_successfully,
+			errors, zip_file_path, zip_file_name, delivery, owner, idempotency_key, request_hash,
+			created_at, started_at, completed_at, archive_expires_at, archive_deleted_at, warnings,
+			archive_parts, archive_password)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		ON CONFLICT (id) DO UPDATE SET
+			status = excluded.status,
+			processed_successfully = excluded.processed_successfully,
+			errors = excluded.errors,
+			zip_file_path = excluded.zip_file_path,
+			zip_file_name = excluded.zip_file_name,
+			delivery = excluded.delivery,
+			started_at = excluded.started_at,
+			completed_at = excluded.completed_at,
+			archive_expires_at = excluded.archive_expires_at,
+			archive_deleted_at = excluded.archive_deleted_at,
+			warnings = excluded.warnings,
+			archive_parts = excluded.archive_parts`, args...)
+	return err
+}
+
+// saveCandidate upserts the state of a single candidate
+func (s *sqlJobStore) saveCandidate(db execer, jobID string, index int, cand CandidateProgress) error {
+	var failureJSON string
+	if cand.Failure != nil {
+		data, _ := json.Marshal(cand.Failure)
+		failureJSON = string(data)
+	}
+	_, err := db.Exec(`
+		INSERT INTO job_candidates (job_id, idx, name, email, status, error, started_at, completed_at, download_attempts, error_code,
+			format, converter, candidate_id, file, failure)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (job_id, idx) DO UPDATE SET
+			status = excluded.status,
+			error = excluded.error,
+			started_at = excluded.started_at,
+			completed_at = excluded.completed_at,
+			download_attempts = excluded.download_attempts,
+			error_code = excluded.error_code,
+			format = excluded.format,
+			converter = excluded.converter,
+			file = excluded.file,
+			failure = excluded.failure`,
+		jobID, index, cand.Name, cand.Email, cand.Status, cand.Error, nullTimePtr(cand.StartedAt), nullTimePtr(cand.CompletedAt),
+		cand.DownloadAttempts, cand.ErrorCode, cand.Format, cand.Converter, cand.CandidateID, cand.File, failureJSON)
+	return err
+}
+
+// jobColumns are the columns read by scanJob
+const jobColumns = `id, tenant_name, company_name, status, total_candidates, processed_successfully, errors,
+	zip_file_path, zip_file_name, delivery, idempotency_key, request_hash, created_at, started_at, completed_at,
+	archive_expires_at, archive_deleted_at, warnings, archive_parts, archive_password`
+
+// scanJob reads a job row selected with jobColumns, without its candidates
+func scanJob(row interface{ Scan(...any) error }) (*Job, int, error) {
+	job := &Job{}
+	var total int
+	var errorsJSON, deliveryJSON, warningsJSON, partsJSON, archivePassword string
+	var startedAt, completedAt, archiveExpiresAt, archiveDeletedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.TenantName, &job.CompanyName, &job.Status, &total, &job.SuccessCount,
+		&errorsJSON, &job.ZipPath, &job.ZipFileName, &deliveryJSON, &job.IdempotencyKey, &job.RequestHash,
+		&job.CreatedAt, &startedAt, &completedAt, &archiveExpiresAt, &archiveDeletedAt, &warningsJSON,
+		&partsJSON, &archivePassword)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	job.StartedAt = startedAt.Time
+	job.CompletedAt = completedAt.Time
+	job.ArchiveExpiresAt = archiveExpiresAt.Time
+	job.ArchiveDeletedAt = archiveDeletedAt.Time
+	if err := json.Unmarshal([]byte(errorsJSON), &job.Errors); err != nil {
+		job.Errors = []string{}
+	}
+	if warningsJSON != "" {
+		json.Unmarshal([]byte(warningsJSON), &job.Warnings)
+	}
+	if partsJSON != "" {
+		json.Unmarshal([]byte(partsJSON), &job.Parts)
+	}
+	if archivePassword != "" && atRest != nil {
+		if job.ArchivePassword, err = atRest.openValue(archivePassword); err != nil {
+			job.log().Warn().Err(err).Msgf("Cannot read the archive password of job %s", job.ID)
+		}
+	}
+	if deliveryJSON != "" {
+		var delivery DeliveryResult
+		if err := json.Unmarshal([]byte(deliveryJSON), &delivery); err == nil {
+			job.Delivery = &delivery
+		}
+	}
+	return job, total, nil
+}
+
+// loadJob reads a job and its candidates back from the database
+func (s *sqlJobStore) loadJob(id string) (*Job, error) {
+	job, _, err := scanJob(s.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT name, email, status, error, started_at, completed_at, download_attempts, error_code, format, converter,
+			candidate_id, file, failure
+		FROM job_candidates WHERE job_id = $1 ORDER BY idx`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cand CandidateProgress
+		var candStarted, candCompleted sql.NullTime
+		var failureJSON string
+		if err := rows.Scan(&cand.Name, &cand.Email, &cand.Status, &cand.Error, &candStarted, &candCompleted,
+			&cand.DownloadAttempts, &cand.ErrorCode, &cand.Format, &cand.Converter, &cand.CandidateID, &cand.File,
+			&failureJSON); err != nil {
+			return nil, err
+		}
+		if failureJSON != "" {
+			json.Unmarshal([]byte(failureJSON), &cand.Failure)
+		}
+		cand.StartedAt = timePtr(candStarted)
+		cand.CompletedAt = timePtr(candCompleted)
+		job.Candidates = append(job.Candidates, cand)
+	}
+	return job, rows.Err()
+}
+
+// listJobs returns one page of the jobs matching the filter, newest first, and the total number of matches
+func (s *sqlJobStore) listJobs(filter JobFilter) ([]JobListItem, int, error) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Tenant != "" {
+		add("tenant_name = $%d", filter.Tenant)
+	}
+	if len(filter.Statuses) > 0 {
+		placeholders := []string{}
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		conditions = append(conditions, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To.UTC())
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM jobs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT %s FROM jobs%s ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d`,
+		jobColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []JobListItem{}
+	for rows.Next() {
+		job, totalCandidates, err := scanJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, newJobListItem(job, totalCandidates))
+	}
+	return items, total, rows.Err()
+}
+
+// findJobByIdempotencyKey returns the id of the job the tenant created with the idempotency key
+func (s *sqlJobStore) findJobByIdempotencyKey(tenant, key string) (string, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT id FROM jobs WHERE tenant_name = $1 AND idempotency_key = $2`, tenant, key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errJobNotFound
+	}
+	return id, err
+}
+
+// markInterrupted fails jobs the owner left queued or processing in a previous run, skipping excluded jobs
+func (s *sqlJobStore) markInterrupted(owner string, exclude []string) (int, error) {
+	rows, err := s.db.Query(`SELECT id FROM jobs WHERE owner = $1 AND status IN ($2, $3)`, owner, jobStatusQueued, jobStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !slices.Contains(exclude, id) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := tx.Exec(`
+			UPDATE job_candidates SET status = $1, error = $2, completed_at = $3
+			WHERE job_id = $4 AND status IN ($5, $6)`,
+			candidateStatusFailed, "interrupted by service restart", now, id, candidateStatusPending, candidateStatusProcessing); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`UPDATE jobs SET status = $1, completed_at = $2 WHERE id = $3`, jobStatusFailed, now, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// finishedJob is a job the janitor deletes once the retention of its tenant has passed
+type finishedJob struct {
+	id          string
+	tenant      string
+	completedAt time.Time
+}
+
+// expiredJobs returns up to limit jobs that finished before the time given, oldest first. They are the jobs
+// of tenant, or with an empty tenant those of every tenant but the excluded ones.
+func (s *sqlJobStore) expiredJobs(tenant string, exclude []string, before time.Time, limit int) ([]finishedJob, error) {
+	args := []any{before.UTC(), jobStatusQueued, jobStatusProcessing}
+	where := `completed_at IS NOT NULL AND completed_at < $1 AND status NOT IN ($2, $3)`
+	if tenant != "" {
+		args = append(args, tenant)
+		where += fmt.Sprintf(" AND tenant_name = $%d", len(args))
+	} else if len(exclude) > 0 {
+		placeholders := []string{}
+		for _, excluded := range exclude {
+			args = append(args, excluded)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		where += " AND tenant_name NOT IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	args = append(args, limit)
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT id, tenant_name, completed_at FROM jobs WHERE %s ORDER BY completed_at LIMIT $%d`,
+		where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []finishedJob{}
+	for rows.Next() {
+		var job finishedJob
+		if err := rows.Scan(&job.id, &job.tenant, &job.completedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	return list, rows.Err()
+}
+
+// deleteJob removes a job and its candidates
+func (s *sqlJobStore) deleteJob(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM job_candidates WHERE job_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM jobs WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadTenantSettings returns the settings of every tenant with overrides
+func (s *sqlJobStore) loadTenantSettings() ([]TenantSettings, error) {
+	rows, err := s.db.Query(`
+		SELECT tenant_name, max_concurrent_candidates, candidates_per_minute, jobs_per_minute, archive_retention,
+			job_retention, scratch_dir, download_policy, branding, theme, email, notifications, updated_at
+		FROM tenant_settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []TenantSettings{}
+	for rows.Next() {
+		var settings TenantSettings
+		var policyJSON, brandingJSON, emailJSON, notificationsJSON string
+		if err := rows.Scan(&settings.Tenant, &settings.MaxConcurrentCandidates, &settings.CandidatesPerMinute,
+			&settings.JobsPerMinute, &settings.ArchiveRetention, &settings.JobRetention, &settings.ScratchDir, &policyJSON, &brandingJSON, &settings.Theme, &emailJSON,
+			&notificationsJSON, &settings.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if policyJSON != "" {
+			var policy DownloadPolicy
+			if err := json.Unmarshal([]byte(policyJSON), &policy); err == nil {
+				settings.DownloadPolicy = &policy
+			}
+		}
+		if brandingJSON != "" {
+			var branding Branding
+			if err := json.Unmarshal([]byte(brandingJSON), &branding); err == nil {
+				settings.Branding = &branding
+			}
+		}
+		if emailJSON != "" {
+			var email EmailSettings
+			if err := json.Unmarshal([]byte(emailJSON), &email); err == nil {
+				settings.Email = &email
+			}
+		}
+		if notificationsJSON != "" {
+			var notifications NotificationSettings
+			if err := json.Unmarshal([]byte(notificationsJSON), &notifications); err == nil {
+				settings.Notifications = &notifications
+			}
+		}
+		list = append(list, settings)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlJobStore) saveTenantSettings(settings TenantSettings) error {
+	policyJSON := []byte{}
+	if settings.DownloadPolicy != nil {
+		policyJSON, _ = json.Marshal(settings.DownloadPolicy)
+	}
+	brandingJSON := []byte{}
+	if settings.Branding != nil {
+		brandingJSON, _ = json.Marshal(settings.Branding)
+	}
+	emailJSON := []byte{}
+	if settings.Email != nil {
+		emailJSON, _ = json.Marshal(settings.Email)
+	}
+	notificationsJSON := []byte{}
+	if settings.Notifications != nil {
+		notificationsJSON, _ = json.Marshal(settings.Notifications)
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO tenant_settings (tenant_name, max_concurrent_candidates, candidates_per_minute, jobs_per_minute,
+			archive_retention, job_retention, scratch_dir, download_policy, branding, theme, email, notifications, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (tenant_name) DO UPDATE SET
+			max_concurrent_candidates = excluded.max_concurrent_candidates,
+			candidates_per_minute = excluded.candidates_per_minute,
+			jobs_per_minute = excluded.jobs_per_minute,
+			archive_retention = excluded.archive_retention,
+			job_retention = excluded.job_retention,
+			scratch_dir = excluded.scratch_dir,
+			download_policy = excluded.download_policy,
+			branding = excluded.branding,
+			theme = excluded.theme,
+			email = excluded.email,
+			notifications = excluded.notifications,
+			updated_at = excluded.updated_at`,
+		settings.Tenant, settings.MaxConcurrentCandidates, settings.CandidatesPerMinute, settings.JobsPerMinute,
+		settings.ArchiveRetention, settings.JobRetention, settings.ScratchDir, string(policyJSON), string(brandingJSON), settings.Theme, string(emailJSON),
+		string(notificationsJSON), settings.UpdatedAt)
+	return err
+}
+
+func (s *sqlJobStore) deleteTenantSettings(tenant string) error {
+	_, err := s.db.Exec(`DELETE FROM tenant_settings WHERE tenant_name = $1`, tenant)
+	return err
+}
+
+// saveAuditEntry appends an entry to the audit log, entries are never changed or deleted
+func (s *sqlJobStore) saveAuditEntry(entry AuditEntry) error {
+	_, err := s.db.Exec(`INSERT INTO audit_log (id, created_at, tenant_name, action, job_id, actor, client_cn, client_ip,
+		request_id, details) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		entry.ID, entry.Time.UTC(), entry.Tenant, entry.Action, entry.JobID, entry.Actor, entry.ClientCN, entry.ClientIP,
+		entry.RequestID, string(entry.Details))
+	return err
+}
+
+// listAuditEntries returns one page of the audit entries matching the filter, newest first, and the total
+// number of matches
+func (s *sqlJobStore) listAuditEntries(filter AuditFilter) ([]AuditEntry, int, error) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Tenant != "" {
+		add("tenant_name = $%d", filter.Tenant)
+	}
+	if len(filter.Actions) > 0 {
+		placeholders := []string{}
+		for _, action := range filter.Actions {
+			args = append(args, action)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		conditions = append(conditions, "action IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.JobID != "" {
+		add("job_id = $%d", filter.JobID)
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To.UTC())
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT id, created_at, tenant_name, action, job_id, actor, client_cn, client_ip,
+		request_id, details FROM audit_log%s ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d`,
+		where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var details string
+		if err := rows.Scan(&entry.ID, &entry.Time, &entry.Tenant, &entry.Action, &entry.JobID, &entry.Actor,
+			&entry.ClientCN, &entry.ClientIP, &entry.RequestID, &details); err != nil {
+			return nil, 0, err
+		}
+		entry.Time = entry.Time.UTC()
+		if details != "" {
+			entry.Details = json.RawMessage(details)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func nullTimePtr(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return nullTime(*t)
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
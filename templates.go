@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// factsheetTemplates are the HTML factsheet layouts requests can select with template_id, by name
+var factsheetTemplates = map[string]*template.Template{}
+
+// factsheetFuncs are available to factsheet templates on top of the html/template built-ins
+var factsheetFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// factsheetData is what factsheet templates are executed with. The candidate's fields are available
+// directly, e.g. {{.Name}} and {{join .Skills ", "}}. Direction is ltr or rtl, for the dir attribute, and
+// Branding the tenant's logo, colors and letterhead texts, empty when it has none. Photo is the
+// candidate's photo as a data: URI, empty without one or when it could not be fetched, and Initials
+// can stand in for it. ProfileQR is the QR code of the profile URL as a data: URI. GeneratedAt is in the
+// job's timezone and Generated the same time in its date format. Blind is set for blind jobs, whose
+// candidates come with their code for a name and without contact details.
+type factsheetData struct {
+	Candidate
+	Blind       bool
+	Tenant      string
+	Direction   string
+	Branding    Branding
+	Photo       template.URL
+	Initials    string
+	ProfileQR   template.URL
+	GeneratedAt time.Time
+	Generated   string
+}
+
+// setupTemplates loads the factsheet templates of the template directory. Templates that do not parse
+// are skipped, so one broken file does not take the others down.
+func setupTemplates() {
+	dir := appConfig.Factsheet.TemplateDir
+	paths, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list factsheet templates")
+		return
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".html")
+		tmpl, err := template.New(filepath.Base(path)).Funcs(factsheetFuncs).ParseFiles(path)
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Skipping factsheet template %s", name)
+			continue
+		}
+		factsheetTemplates[name] = tmpl
+	}
+	if len(factsheetTemplates) > 0 {
+		names := make([]string, 0, len(factsheetTemplates))
+		for name := range factsheetTemplates {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		logger.Info().Msgf("Loaded factsheet templates from %s: %s", dir, strings.Join(names, ", "))
+	}
+}
+
+// renderFactsheet writes the factsheet of a candidate to outputPath, with the job's template or the
+// built-in table layout, in the branding given, nil for the plain look
+func renderFactsheet(ctx context.Context, job jobInfo, opts JobOptions, branding *Branding, cand Candidate, outputPath, candTempDir string) error {
+	photo := fetchPhoto(ctx, job.Tenant, cand, candTempDir)
+	cand.WorkHistory = sortedWorkHistory(cand.WorkHistory)
+	if opts.TemplateID == "" {
+		opts.Theme = opts.theme(job.Tenant)
+		return generateFactsheetPDF(cand, opts, candidateInfo(job, cand), branding, photo, outputPath)
+	}
+	tmpl, ok := factsheetTemplates[opts.TemplateID]
+	if !ok {
+		// Queued tasks can land on an instance with a different template directory
+		return fmt.Errorf("unknown template_id %q", opts.TemplateID)
+	}
+
+	htmlPath := filepath.Join(candTempDir, "factsheet.html")
+	page, err := os.Create(htmlPath)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	data := factsheetData{
+		Candidate:   cand,
+		Blind:       opts.Blind,
+		Tenant:      job.Tenant,
+		Direction:   opts.direction(),
+		Photo:       photo.dataURI(),
+		Initials:    initials(cand.Name),
+		ProfileQR:   qrDataURI(cand.ProfileURL),
+		GeneratedAt: opts.localTime(now),
+		Generated:   opts.formatTime(now),
+	}
+	if branding != nil {
+		data.Branding = *branding
+	}
+	err = tmpl.Execute(page, data)
+	if closeErr := page.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("template %s: %w", opts.TemplateID, err)
+	}
+
+	pdfPath, err := htmlToPDF(ctx, htmlPath, candTempDir)
+	if err != nil {
+		return err
+	}
+	return os.Rename(pdfPath, outputPath)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// TenantSettings are the scheduling limits of a tenant, zero means unlimited
+type TenantSettings struct {
+	Tenant                  string `json:"tenant,omitempty"`
+	MaxConcurrentCandidates int    `json:"max_concurrent_candidates"`
+	CandidatesPerMinute     int    `json:"candidates_per_minute"`
+	JobsPerMinute           int    `json:"jobs_per_minute"`
+	// ArchiveRetention overrides ARCHIVE_RETENTION for the tenant, e.g. "168h"; "0" keeps archives forever
+	ArchiveRetention string `json:"archive_retention,omitempty"`
+	// JobRetention overrides JOB_RETENTION for the tenant, how long job records are kept once finished
+	JobRetention string `json:"job_retention,omitempty"`
+	// ScratchDir is where the tenant's jobs work, relative to the scratch directory, e.g. a volume mounted
+	// for the tenant alone
+	ScratchDir string `json:"scratch_dir,omitempty"`
+	// DownloadPolicy adjusts the default download policy for the tenant's resume URLs
+	DownloadPolicy *DownloadPolicy `json:"download_policy,omitempty"`
+	// Branding gives the tenant's factsheets its logo, colors and letterhead
+	Branding *Branding `json:"branding,omitempty"`
+	// Theme is the built-in factsheet layout of jobs that do not choose one
+	Theme string `json:"theme,omitempty"`
+	// Email has the recipients and message of the tenant's archives delivered by email
+	Email *EmailSettings `json:"email,omitempty"`
+	// Notifications are the chat webhooks told when the tenant's jobs finish, instead of the configured ones
+	Notifications *NotificationSettings `json:"notifications,omitempty"`
+	UpdatedAt     time.Time             `json:"updated_at,omitzero"`
+}
+
+// tenantState holds the limiters built from a tenant's settings. Slots taken from an old state are
+// returned to it, so settings can be replaced while jobs are running.
+type tenantState struct {
+	settings   TenantSettings
+	custom     bool
+	slots      chan struct{}
+	candidates *rate.Limiter
+	jobs       *rate.Limiter
+	downloads  *downloadGuard
+}
+
+// tenantScheduler keeps one tenant from starving the others by capping its in-flight candidates
+// and the rate at which it can submit jobs and start candidates
+type tenantScheduler struct {
+	mu       sync.Mutex
+	defaults TenantSettings
+	tenants  map[string]*tenantState
+}
+
+var tenants = &tenantScheduler{tenants: map[string]*tenantState{}}
+
+// setupTenants reads the default limits and loads tenant overrides from the job store
+func setupTenants() {
+	cfg := appConfig.Tenants
+	tenants.defaults = TenantSettings{
+		MaxConcurrentCandidates: cfg.MaxConcurrentCandidates,
+		CandidatesPerMinute:     cfg.CandidatesPerMinute,
+		JobsPerMinute:           cfg.JobsPerMinute,
+	}
+	if jobDB == nil {
+		return
+	}
+
+	tenants.reload()
+	// Other instances may change settings through their own API, pick those changes up periodically
+	if interval := cfg.SettingsRefresh; interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				tenants.reload()
+			}
+		}()
+	}
+}
+
+func newTenantState(settings TenantSettings, custom bool) *tenantState {
+	state := &tenantState{settings: settings, custom: custom}
+	if settings.MaxConcurrentCandidates > 0 {
+		state.slots = make(chan struct{}, settings.MaxConcurrentCandidates)
+	}
+	if settings.CandidatesPerMinute > 0 {
+		state.candidates = rate.NewLimiter(perMinute(settings.CandidatesPerMinute), settings.CandidatesPerMinute)
+	}
+	if settings.JobsPerMinute > 0 {
+		state.jobs = rate.NewLimiter(perMinute(settings.JobsPerMinute), settings.JobsPerMinute)
+	}
+	guard, err := appConfig.Download.merge(settings.DownloadPolicy).compile()
+	if err != nil {
+		// Stored policies are validated when saved, fall back to the defaults if one still breaks
+		logger.Warn().Err(err).Msgf("Invalid download policy for tenant %s, using the defaults", settings.Tenant)
+		guard, _ = appConfig.Download.compile()
+	}
+	state.downloads = guard
+	return state
+}
+
+func perMinute(n int) rate.Limit {
+	return rate.Limit(float64(n) / 60)
+}
+
+// reload replaces the settings of every tenant with the ones stored in the job store
+func (s *tenantScheduler) reload() {
+	stored, err := jobDB.loadTenantSettings()
+	if err != nil {
+		logger.Error().Err(err).Msg("Error loading tenant settings")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byTenant := map[string]TenantSettings{}
+	for _, settings := range stored {
+		byTenant[settings.Tenant] = settings
+	}
+	for name, state := range s.tenants {
+		settings, ok := byTenant[name]
+		if !ok && state.custom {
+			delete(s.tenants, name)
+		} else if ok && !settings.UpdatedAt.Equal(state.settings.UpdatedAt) {
+			s.tenants[name] = newTenantState(settings, true)
+		}
+	}
+	for name, settings := range byTenant {
+		if _, ok := s.tenants[name]; !ok {
+			s.tenants[name] = newTenantState(settings, true)
+		}
+	}
+}
+
+func (s *tenantScheduler) state(tenant string) *tenantState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.tenants[tenant]
+	if !ok {
+		settings := s.defaults
+		settings.Tenant = tenant
+		state = newTenantState(settings, false)
+		s.tenants[tenant] = state
+	}
+	return state
+}
+
+// list returns the settings of every tenant that has overrides
+func (s *tenantScheduler) list() []TenantSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []TenantSettings{}
+	for _, state := range s.tenants {
+		if state.custom {
+			list = append(list, state.settings)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tenant < list[j].Tenant })
+	return list
+}
+
+func (s *tenantScheduler) set(settings TenantSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants[settings.Tenant] = newTenantState(settings, true)
+}
+
+func (s *tenantScheduler) reset(tenant string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenants, tenant)
+}
+
+// archiveRetention returns the tenant's archive retention override, if it has one
+func (s *tenantScheduler) archiveRetention(tenant string) (time.Duration, bool) {
+	return parseRetention(s.state(tenant).settings.ArchiveRetention)
+}
+
+// jobRetention returns the tenant's job retention override, if it has one
+func (s *tenantScheduler) jobRetention(tenant string) (time.Duration, bool) {
+	return parseRetention(s.state(tenant).settings.JobRetention)
+}
+
+// jobRetentions returns the job retention overrides of the tenants with settings of their own
+func (s *tenantScheduler) jobRetentions() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	retentions := map[string]time.Duration{}
+	for tenant, state := range s.tenants {
+		if retention, ok := parseRetention(state.settings.JobRetention); ok && state.custom {
+			retentions[tenant] = retention
+		}
+	}
+	return retentions
+}
+
+func parseRetention(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return retention, true
+}
+
+// downloadGuard returns the download policy that applies to the tenant's resume URLs
+func (s *tenantScheduler) downloadGuard(tenant string) *downloadGuard {
+	return s.state(tenant).downloads
+}
+
+// branding returns the tenant's factsheet branding, nil when it has none
+func (s *tenantScheduler) branding(tenant string) *Branding {
+	return s.state(tenant).settings.Branding
+}
+
+// scratchDir returns the tenant's scratch subpath, empty when it has none
+func (s *tenantScheduler) scratchDir(tenant string) string {
+	return s.state(tenant).settings.ScratchDir
+}
+
+// theme returns the tenant's default factsheet layout, empty when it has none
+func (s *tenantScheduler) theme(tenant string) string {
+	return s.state(tenant).settings.Theme
+}
+
+// email returns the tenant's email delivery settings, nil when it has none
+func (s *tenantScheduler) email(tenant string) *EmailSettings {
+	return s.state(tenant).settings.Email
+}
+
+// notifications returns the chat webhooks of the tenant's jobs and whether they are the tenant's own
+func (s *tenantScheduler) notifications(tenant string) (NotificationSettings, bool) {
+	if settings := s.state(tenant).settings.Notifications; settings != nil {
+		return *settings, true
+	}
+	return appConfig.Notifications.NotificationSettings, false
+}
+
+// allowJob reports whether the tenant may submit another job now, and otherwise how long to wait
+func (s *tenantScheduler) allowJob(tenant string) (bool, time.Duration) {
+	state := s.state(tenant)
+	if state.jobs == nil {
+		return true, 0
+	}
+	reservation := state.jobs.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// acquire blocks until the tenant may start another candidate and returns the function that frees its slot
+func (s *tenantScheduler) acquire(ctx context.Context, tenant string) (func(), error) {
+	state := s.state(tenant)
+	if state.slots != nil {
+		select {
+		case state.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if state.candidates != nil {
+		if err := state.candidates.Wait(ctx); err != nil {
+			state.release()
+			return nil, err
+		}
+	}
+	return state.release, nil
+}
+
+// tryAcquire is the non-blocking variant of acquire used by queue workers, which must not sit on a
+// task another tenant could be processing
+func (s *tenantScheduler) tryAcquire(tenant string) (func(), bool) {
+	state := s.state(tenant)
+	if state.slots != nil {
+		select {
+		case state.slots <- struct{}{}:
+		default:
+			return nil, false
+		}
+	}
+	if state.candidates != nil && !state.candidates.Allow() {
+		state.release()
+		return nil, false
+	}
+	return state.release, true
+}
+
+func (t *tenantState) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// retryAfterSeconds rounds a delay up to whole seconds for the Retry-After header
+func retryAfterSeconds(delay time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(delay.Seconds())))
+}
+
+func listTenantSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"defaults": tenants.defaults,
+		"tenants":  tenants.list(),
+	})
+}
+
+func getTenantSettings(c *gin.Context) {
+	if !canAccessTenant(c, c.Param("tenant")) {
+		abortWithError(c, http.StatusForbidden, errCodeForbidden, "token is not allowed to act for tenant "+c.Param("tenant"))
+		return
+	}
+	state := tenants.state(c.Param("tenant"))
+	c.JSON(http.StatusOK, gin.H{
+		"settings": state.settings,
+		"custom":   state.custom,
+	})
+}
+
+func updateTenantSettings(c *gin.Context) {
+	var settings TenantSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		invalidBody("Invalid request format: "+err.Error(), err).abort(c)
+		return
+	}
+	if settings.MaxConcurrentCandidates < 0 || settings.CandidatesPerMinute < 0 || settings.JobsPerMinute < 0 {
+		e := apiError(http.StatusBadRequest, errCodeValidationFailed, "Limits cannot be negative, use 0 for unlimited")
+		if settings.MaxConcurrentCandidates < 0 {
+			e.field("max_concurrent_candidates", "cannot be negative")
+		}
+		if settings.CandidatesPerMinute < 0 {
+			e.field("candidates_per_minute", "cannot be negative")
+		}
+		if settings.JobsPerMinute < 0 {
+			e.field("jobs_per_minute", "cannot be negative")
+		}
+		e.abort(c)
+		return
+	}
+	for _, retention := range []struct{ name, value string }{
+		{"archive_retention", settings.ArchiveRetention},
+		{"job_retention", settings.JobRetention},
+	} {
+		if retention.value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(retention.value); err != nil || duration < 0 {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, retention.name+" must be a non-negative duration such as \"72h\"").
+				field(retention.name, "must be a non-negative duration").abort(c)
+			return
+		}
+	}
+	if settings.ScratchDir != "" && !filepath.IsLocal(settings.ScratchDir) {
+		message := "must be a path within the scratch directory, such as \"acme\""
+		apiError(http.StatusBadRequest, errCodeValidationFailed, "scratch_dir "+message).field("scratch_dir", message).abort(c)
+		return
+	}
+	if settings.DownloadPolicy != nil {
+		if _, err := appConfig.Download.merge(settings.DownloadPolicy).compile(); err != nil {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid download_policy: "+err.Error()).field("download_policy", err.Error()).abort(c)
+			return
+		}
+	}
+	if settings.Branding != nil {
+		if err := settings.Branding.validate(); err != nil {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid branding: "+err.Error()).field("branding", err.Error()).abort(c)
+			return
+		}
+	}
+	if err := validateTheme(settings.Theme); err != nil {
+		apiError(http.StatusBadRequest, errCodeValidationFailed, err.Error()).field("theme", err.Error()).abort(c)
+		return
+	}
+	if settings.Email != nil {
+		if err := settings.Email.validate(); err != nil {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid email: "+err.Error()).field("email", err.Error()).abort(c)
+			return
+		}
+	}
+	if settings.Notifications != nil {
+		if err := settings.Notifications.validate(); err != nil {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, "invalid notifications: "+err.Error()).field("notifications", err.Error()).abort(c)
+			return
+		}
+	}
+	settings.Tenant = c.Param("tenant")
+	// Postgres keeps microseconds, truncate so reloads see the same timestamp
+	settings.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	if jobDB != nil {
+		if err := jobDB.saveTenantSettings(settings); err != nil {
+			requestLog(c).Error().Err(err).Msgf("Error saving settings of tenant %s", settings.Tenant)
+			abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to save tenant settings")
+			return
+		}
+	}
+	tenants.set(settings)
+	// The webhook URLs are secrets of their own, anyone who has one can post to the channel
+	logged := settings
+	if settings.Notifications != nil {
+		logged.Notifications = settings.Notifications.redacted()
+	}
+	requestLog(c).Info().Msgf("Updated settings of tenant %s by %s: %+v", settings.Tenant, requestedBy(c), logged)
+	audit.recordRequest(c, auditTenantSettingsUpdated, settings.Tenant, "", logged)
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings, "custom": true})
+}
+
+func deleteTenantSettings(c *gin.Context) {
+	tenant := c.Param("tenant")
+	if jobDB != nil {
+		if err := jobDB.deleteTenantSettings(tenant); err != nil {
+			requestLog(c).Error().Err(err).Msgf("Error deleting settings of tenant %s", tenant)
+			abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to delete tenant settings")
+			return
+		}
+	}
+	tenants.reset(tenant)
+	requestLog(c).Info().Msgf("Reset settings of tenant %s to the defaults by %s", tenant, requestedBy(c))
+	audit.recordRequest(c, auditTenantSettingsDeleted, tenant, "", nil)
+
+	c.Status(http.StatusNoContent)
+}
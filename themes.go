@@ -0,0 +1,526 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Built-in layouts of factsheets drawn without a template
+const (
+	// themeClassic is a table of the candidate's fields under a title bar
+	themeClassic = "classic"
+	// themeModern puts the photo, contact details and skills in a sidebar beside the other fields
+	themeModern = "modern"
+	// themeCompact fits the candidate on one page in small type
+	themeCompact = "compact"
+)
+
+const (
+	// sidebarWidth is the width of the modern theme's sidebar and sidebarGap its distance to the fields, in mm
+	sidebarWidth = 62.0
+	sidebarGap   = 8.0
+	// compactLines is how many lines a value may take in the compact theme before it is cut short
+	compactLines = 3
+)
+
+// factsheetPage is a factsheet being drawn: the page below the letterhead and what goes on it
+type factsheetPage struct {
+	pdf      *gofpdf.Fpdf
+	text     *factsheetText
+	cand     Candidate
+	opts     JobOptions
+	branding *Branding
+	photo    *candidatePhoto
+}
+
+// factsheetThemes draw a factsheet in each built-in layout, by the name requests select it with
+var factsheetThemes = map[string]func(factsheetPage){
+	themeClassic: drawClassicFactsheet,
+	themeModern:  drawModernFactsheet,
+	themeCompact: drawCompactFactsheet,
+}
+
+// validateTheme checks the name of a built-in layout, empty for the default
+func validateTheme(theme string) error {
+	if _, ok := factsheetThemes[theme]; theme != "" && !ok {
+		return fmt.Errorf("theme must be %s, %s or %s", themeClassic, themeModern, themeCompact)
+	}
+	return nil
+}
+
+// theme is the layout of the job's factsheets: its own, the tenant's default or the classic table
+func (o JobOptions) theme(tenant string) string {
+	if o.Theme != "" {
+		return o.Theme
+	}
+	if theme := tenants.theme(tenant); theme != "" {
+		return theme
+	}
+	return themeClassic
+}
+
+// generatedLine is the note at the end of a factsheet saying when it was generated
+func (p factsheetPage) generatedLine() string {
+	return fmt.Sprintf("Generated on: %s", p.opts.formatTime(time.Now()))
+}
+
+// frame is the content area of the page: its left edge, width and the bottom content may reach. place
+// turns a position across it into a page position, mirrored for right-to-left layouts.
+func (p factsheetPage) frame() (left, width, limit float64, place func(x, w float64) float64) {
+	left, _, right, _ := p.pdf.GetMargins()
+	pageWidth, pageHeight := p.pdf.GetPageSize()
+	_, bottom := p.pdf.GetAutoPageBreak()
+	width = pageWidth - left - right
+	rtl := p.opts.direction() == directionRTL
+	place = func(x, w float64) float64 {
+		if rtl {
+			return left + width - x - w
+		}
+		return left + x
+	}
+	return left, width, pageHeight - bottom, place
+}
+
+// writeLines draws lines one under the other from y in the current font and returns the y below them
+func (p factsheetPage) writeLines(x, y, width, lineHeight float64, lines []string, align string) float64 {
+	for _, line := range lines {
+		p.pdf.SetXY(x, y)
+		p.pdf.CellFormat(width, lineHeight, line, "", 0, align, false, 0, "")
+		y += lineHeight
+	}
+	return y
+}
+
+// factsheetTable is a table of a factsheet section. Widths are the shares of the table each column takes.
+// Cells of more than clip lines are cut short. Tables with a bottom stop there, the others continue on
+// new pages. Flagged rows are drawn in red, e.g. expired certifications.
+type factsheetTable struct {
+	titles  []string
+	widths  []float64
+	rows    [][]string
+	flagged []bool
+	size    float64
+	clip    int
+	bottom  float64
+}
+
+// drawTable draws a table across width from y under a header row on the tenant's secondary color, mirrored
+// for right-to-left layouts, repeating the header on every new page. It returns the y below the table and
+// how many rows were drawn.
+func (p factsheetPage) drawTable(x, y, width float64, table factsheetTable) (float64, int) {
+	pdf, text := p.pdf, p.text
+	_, _, limit, _ := p.frame()
+	if table.bottom > 0 {
+		limit = table.bottom
+	}
+	lineHeight := table.size * 0.45
+	order := make([]int, len(table.titles))
+	for i := range order {
+		order[i] = i
+	}
+	if p.opts.direction() == directionRTL {
+		slices.Reverse(order)
+	}
+
+	// drawRow draws the cells of a row side by side in the colors set by paint, or reports that it does not
+	// fit above limit
+	drawRow := func(cells []string, style string, paint func()) bool {
+		height := lineHeight
+		lines := make([][]string, len(cells))
+		for i, cell := range cells {
+			lines[i] = text.lines(style, table.size, cell, width*table.widths[i]-2)
+			if table.clip > 0 {
+				lines[i] = clipLines(lines[i], table.clip)
+			}
+			height = max(height, float64(len(lines[i]))*lineHeight)
+		}
+		height += 2
+		if y+height > limit {
+			return false
+		}
+		cellX := x
+		for _, i := range order {
+			cellWidth := width * table.widths[i]
+			paint()
+			pdf.SetXY(cellX, y)
+			pdf.CellFormat(cellWidth, height, "", "1", 0, "", true, 0, "")
+			// Every cell is written in a font that has its script
+			text.font(style, table.size, cells[i])
+			p.writeLines(cellX+1, y+1, cellWidth-2, lineHeight, lines[i], text.align(cells[i]))
+			cellX += cellWidth
+		}
+		pdf.SetTextColor(0, 0, 0)
+		y += height
+		return true
+	}
+	header := func() {
+		fillColor(pdf, p.branding.SecondaryColor, 220)
+	}
+
+	if table.bottom == 0 {
+		y = p.ensureRoom(y, 3*lineHeight+4)
+	}
+	if !drawRow(table.titles, "B", header) {
+		return y, 0
+	}
+	for n, row := range table.rows {
+		gray := 250 - n%2*10
+		body := func() { pdf.SetFillColor(gray, gray, gray) }
+		if n < len(table.flagged) && table.flagged[n] {
+			body = func() {
+				pdf.SetFillColor(253, 228, 228)
+				pdf.SetTextColor(176, 0, 0)
+			}
+		}
+		if drawRow(row, "", body) {
+			continue
+		}
+		if table.bottom > 0 {
+			return y, n
+		}
+		pdf.AddPage()
+		y = pdf.GetY()
+		drawRow(table.titles, "B", header)
+		drawRow(row, "", body)
+	}
+	return y, len(table.rows)
+}
+
+// clipLines keeps the first n lines, marking the last one kept when there were more
+func clipLines(lines []string, n int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	lines = lines[:n]
+	lines[n-1] += "..."
+	return lines
+}
+
+// drawModernFactsheet draws a sidebar on the tenant's primary color holding the photo, name, contact
+// details, skills and profile QR code, and the remaining fields as headed sections beside it
+func drawModernFactsheet(p factsheetPage) {
+	pdf, text, cand := p.pdf, p.text, p.cand
+	_, width, limit, place := p.frame()
+	top := pdf.GetY()
+
+	fillColor(pdf, p.branding.PrimaryColor, 60)
+	pdf.Rect(place(0, sidebarWidth), top, sidebarWidth, limit-top, "F")
+	inner := sidebarWidth - 10
+	x, y := place(5, inner), top+6
+	if p.photo != nil {
+		p.photo.draw(pdf, text, place((sidebarWidth-photoWidth)/2, photoWidth), y)
+		y += photoHeight + 6
+	}
+	// sidebarText writes wrapped white text into the sidebar below the last
+	sidebarText := func(style string, size, lineHeight float64, s string) {
+		pdf.SetTextColor(255, 255, 255)
+		y = p.writeLines(x, y, inner, lineHeight, text.lines(style, size, s, inner), text.align(s))
+	}
+	// sidebarHeading starts a group of the sidebar, underlined
+	sidebarHeading := func(heading string) {
+		y += 5
+		sidebarText("B", 9, 5, heading)
+		pdf.SetDrawColor(255, 255, 255)
+		pdf.Line(x, y, x+inner, y)
+		pdf.SetDrawColor(0, 0, 0)
+		y += 2
+	}
+	sidebarText("B", 15, 7, cand.Name)
+
+	if cand.Email != "" || cand.MobileNo != "" {
+		sidebarHeading("CONTACT")
+		for _, contact := range []string{cand.Email, cand.MobileNo} {
+			if contact != "" {
+				sidebarText("", 9, 5, contact)
+			}
+		}
+	}
+
+	if links := cand.sortedLinks(); len(links) > 0 {
+		sidebarHeading("LINKS")
+		for _, link := range links {
+			p.drawLink(x, y, inner, 5, 9, link.label, link.url, [3]int{255, 255, 255})
+			y += 5
+		}
+	}
+
+	// Skills go one a line for as long as there is room above the QR code
+	bottom := limit - 5
+	if cand.ProfileURL != "" {
+		bottom -= qrSize + 10
+	}
+	if len(cand.Skills) > 0 {
+		sidebarHeading("SKILLS")
+		for i, skill := range cand.Skills {
+			if y+10 > bottom && i < len(cand.Skills)-1 {
+				sidebarText("I", 9, 5, fmt.Sprintf("+%d more", len(cand.Skills)-i))
+				break
+			}
+			sidebarText("", 9, 5, skill)
+		}
+	}
+
+	if cand.ProfileURL != "" {
+		// On a white card, the code would not scan off the dark sidebar
+		qrX, qrY := place((sidebarWidth-qrSize)/2, qrSize), limit-qrSize-10
+		pdf.SetFillColor(255, 255, 255)
+		pdf.Rect(qrX-2, qrY-2, qrSize+4, qrSize+9, "F")
+		drawProfileQR(pdf, text, cand.ProfileURL, qrX, qrY)
+	}
+	pdf.SetTextColor(0, 0, 0)
+
+	// The other fields beside the sidebar, under headings in the tenant's color. Only the first page
+	// has the sidebar, the fields keep to their column on the following ones.
+	mainWidth := width - sidebarWidth - sidebarGap
+	x, y = place(sidebarWidth+sidebarGap, mainWidth), top+4
+	title := p.opts.factsheetTitle()
+	textColor(pdf, p.branding.PrimaryColor, 60)
+	y = p.writeLines(x, y, mainWidth, 9, []string{text.font("B", 18, title)}, text.align(title))
+	if r, g, b, err := parseColor(p.branding.PrimaryColor); err == nil {
+		pdf.SetDrawColor(r, g, b)
+	}
+	pdf.SetLineWidth(0.5)
+	pdf.Line(x, y+1, x+mainWidth, y+1)
+	pdf.SetLineWidth(0.2)
+	pdf.SetDrawColor(0, 0, 0)
+	y += 6
+
+	// newLine moves down by height, to the top of the next page when it does not fit
+	newLine := func(height float64) {
+		y = p.ensureRoom(y, height)
+	}
+	// drawSection writes a field under a heading in the tenant's color, leaving out empty ones
+	drawSection := func(label, value string) {
+		if value == "" {
+			return
+		}
+		newLine(11)
+		textColor(pdf, p.branding.PrimaryColor, 60)
+		y = p.writeLines(x, y, mainWidth, 6, text.lines("B", 11, label, mainWidth), text.align(label))
+		pdf.SetTextColor(0, 0, 0)
+		for _, line := range text.lines("", 11, value, mainWidth) {
+			// A new page draws its letterhead in another font
+			newLine(5.5)
+			text.font("", 11, value)
+			y = p.writeLines(x, y, mainWidth, 5.5, []string{line}, text.align(value))
+		}
+		y += 4
+	}
+	drawSection("Qualification", cand.Qualification)
+	drawSection("Experience", cand.Experience)
+	y = p.drawWorkHistory(x, y, mainWidth)
+	if len(cand.Education) > 0 {
+		y = p.sectionHeading(x, y, mainWidth, "EDUCATION")
+		y, _ = p.drawEducation(x, y, mainWidth, 9, 0)
+		y += 6
+	}
+	if len(cand.Certifications) > 0 {
+		y = p.sectionHeading(x, y, mainWidth, "CERTIFICATIONS")
+		y, _ = p.drawCertifications(x, y, mainWidth, 9, 0)
+		y += 6
+	}
+	for _, field := range cand.CustomFields {
+		drawSection(field.Label, field.Value)
+	}
+
+	generated := p.generatedLine()
+	newLine(5)
+	pdf.SetTextColor(128, 128, 128)
+	p.writeLines(x, y+2, mainWidth, 5, []string{text.font("I", 9, generated)}, text.align(generated))
+	pdf.SetTextColor(0, 0, 0)
+}
+
+// drawCompactFactsheet fits the candidate on one page: a name bar, the key facts beside the photo and
+// profile QR code, then the skills and custom fields in two columns. Values are cut short after a few
+// lines, and custom fields that no longer fit on the page are left out with a note saying how many.
+func drawCompactFactsheet(p factsheetPage) {
+	pdf, text, cand := p.pdf, p.text, p.cand
+	left, width, limit, place := p.frame()
+	top := pdf.GetY()
+
+	// Name bar, white on the tenant's primary color, with the document title at its far end
+	if fillColor(pdf, p.branding.PrimaryColor, 230) {
+		pdf.SetTextColor(255, 255, 255)
+	}
+	pdf.SetXY(left, top)
+	pdf.CellFormat(width, 10, text.font("B", 14, " "+cand.Name+" "), "", 0, text.align(cand.Name), true, 0, "")
+	trailing := "R"
+	if text.align(cand.Name) == "R" {
+		trailing = "L"
+	}
+	pdf.SetXY(left, top)
+	pdf.CellFormat(width, 10, text.font("B", 8, " "+p.opts.factsheetTitle()+" "), "", 0, trailing, false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	y := top + 14
+
+	// Key facts, with the photo and the QR code to their side
+	aside, asideBottom := 0.0, y
+	if p.photo != nil {
+		p.photo.draw(pdf, text, place(width-photoWidth, photoWidth), y)
+		aside, asideBottom = aside+photoWidth+4, max(asideBottom, y+photoHeight)
+	}
+	if cand.ProfileURL != "" {
+		drawProfileQR(pdf, text, cand.ProfileURL, place(width-aside-qrSize, qrSize), y)
+		aside, asideBottom = aside+qrSize+4, max(asideBottom, y+qrSize+5)
+	}
+	factsWidth, labelWidth := width-aside, 28.0
+	facts := [][]string{
+		{"Email", cand.Email},
+		{"Mobile Number", cand.MobileNo},
+		{"Qualification", cand.Qualification},
+		{"Experience", cand.Experience},
+	}
+	if p.opts.Blind {
+		facts = facts[2:]
+	}
+	for i, fact := range facts {
+		lines := clipLines(text.lines("", 9, fact[1], factsWidth-labelWidth-4), 2)
+		height := max(7, float64(len(lines))*4.5+2)
+		gray := 250 - i%2*10
+		fillColor(pdf, p.branding.SecondaryColor, gray)
+		pdf.SetXY(place(0, labelWidth), y)
+		pdf.CellFormat(labelWidth, height, text.font("B", 9, fact[0]), "", 0, text.align(fact[0]), true, 0, "")
+		pdf.SetFillColor(gray, gray, gray)
+		pdf.Rect(place(labelWidth, factsWidth-labelWidth), y, factsWidth-labelWidth, height, "F")
+		text.font("", 9, fact[1])
+		p.writeLines(place(labelWidth+2, factsWidth-labelWidth-4), y+1, factsWidth-labelWidth-4, 4.5, lines, text.align(fact[1]))
+		y += height
+	}
+	// Links by their labels, side by side on a row of their own
+	if links := cand.sortedLinks(); len(links) > 0 {
+		valueWidth := factsWidth - labelWidth - 4
+		var rows [][]candidateLink
+		used := valueWidth
+		for _, link := range links {
+			width := min(pdf.GetStringWidth(text.font("", 9, link.label))+6, valueWidth)
+			if used+width > valueWidth {
+				rows, used = append(rows, nil), 0
+			}
+			rows[len(rows)-1] = append(rows[len(rows)-1], link)
+			used += width
+		}
+		height := float64(len(rows))*4.5 + 2
+		gray := 250 - len(facts)%2*10
+		fillColor(pdf, p.branding.SecondaryColor, gray)
+		pdf.SetXY(place(0, labelWidth), y)
+		pdf.CellFormat(labelWidth, height, text.font("B", 9, "Links"), "", 0, text.align("Links"), true, 0, "")
+		pdf.SetFillColor(gray, gray, gray)
+		pdf.Rect(place(labelWidth, factsWidth-labelWidth), y, factsWidth-labelWidth, height, "F")
+		for n, row := range rows {
+			offset := labelWidth + 1
+			for _, link := range row {
+				width := min(pdf.GetStringWidth(text.font("", 9, link.label))+6, valueWidth)
+				p.drawLink(place(offset, width), y+1+float64(n)*4.5, width, 4.5, 9, link.label, link.url, linkBlue)
+				offset += width
+			}
+		}
+		y += height
+	}
+	y = max(y, asideBottom) + 5
+
+	// heading writes the label of a value in the tenant's color
+	heading := func(x, y, width float64, label string) float64 {
+		textColor(pdf, p.branding.PrimaryColor, 60)
+		y = p.writeLines(x, y, width, 4.5, []string{text.font("B", 9, label)}, text.align(label))
+		pdf.SetTextColor(0, 0, 0)
+		return y
+	}
+	footer := limit - 10
+	if len(cand.Skills) > 0 {
+		skills := strings.Join(cand.Skills, ", ")
+		y = heading(place(0, width), y, width, "Skills")
+		lines := clipLines(text.lines("", 9, skills, width), compactLines)
+		y = p.writeLines(place(0, width), y, width, 4.5, lines, text.align(skills)) + 3
+	}
+
+	// Positions a line each with the period at the far end, descriptions cut to two lines
+	var omitted []string
+	if len(cand.WorkHistory) > 0 {
+		y = heading(place(0, width), y, width, "Work Experience")
+		for i, position := range cand.WorkHistory {
+			description := clipLines(text.lines("", 8, position.Description, width), 2)
+			if position.Description == "" {
+				description = nil
+			}
+			height := 4.5 + float64(len(description))*4
+			if y+height > footer {
+				omitted = append(omitted, fmt.Sprintf("%d more positions", len(cand.WorkHistory)-i))
+				break
+			}
+			title, period := positionHeading(position), position.Period()
+			align, periodAlign, titleX := text.align(title), "R", left
+			if align == "R" {
+				periodAlign, titleX = "L", left+40
+			}
+			pdf.SetTextColor(100, 100, 100)
+			p.writeLines(left, y, width, 4.5, []string{text.font("I", 8, period)}, periodAlign)
+			pdf.SetTextColor(0, 0, 0)
+			p.writeLines(titleX, y, width-40, 4.5, clipLines(text.lines("B", 9, title, width-40), 1), align)
+			text.font("", 8, position.Description)
+			y = p.writeLines(place(0, width), y+4.5, width, 4, description, text.align(position.Description)) + 1
+		}
+		y += 2
+	}
+
+	// Qualifications in a table of a line per row, under a heading when there is room for a row
+	if len(cand.Education) > 0 {
+		shown := 0
+		if y+13 <= footer {
+			y = heading(place(0, width), y, width, "Education")
+			y, shown = p.drawEducation(left, y, width, 8, footer)
+			y += 3
+		}
+		if shown < len(cand.Education) {
+			omitted = append(omitted, fmt.Sprintf("%d more qualifications", len(cand.Education)-shown))
+		}
+	}
+	if len(cand.Certifications) > 0 {
+		shown := 0
+		if y+13 <= footer {
+			y = heading(place(0, width), y, width, "Certifications")
+			y, shown = p.drawCertifications(left, y, width, 8, footer)
+			y += 3
+		}
+		if shown < len(cand.Certifications) {
+			omitted = append(omitted, fmt.Sprintf("%d more certifications", len(cand.Certifications)-shown))
+		}
+	}
+
+	// Custom fields two to a row, each column a label over its value
+	columnWidth := (width - 6) / 2
+	for i := 0; i < len(cand.CustomFields); i += 2 {
+		pair := cand.CustomFields[i:min(i+2, len(cand.CustomFields))]
+		values := make([][]string, len(pair))
+		rowLines := 0
+		for n, field := range pair {
+			values[n] = clipLines(text.lines("", 9, field.Value, columnWidth), compactLines)
+			rowLines = max(rowLines, len(values[n]))
+		}
+		height := 4.5 + float64(rowLines)*4.5 + 3
+		if y+height > footer {
+			omitted = append(omitted, fmt.Sprintf("%d more fields", len(cand.CustomFields)-i))
+			break
+		}
+		for n, field := range pair {
+			x := place(float64(n)*(columnWidth+6), columnWidth)
+			below := heading(x, y, columnWidth, field.Label)
+			text.font("", 9, field.Value)
+			p.writeLines(x, below, columnWidth, 4.5, values[n], text.align(field.Value))
+		}
+		y += height
+	}
+
+	notes := []string{p.generatedLine()}
+	if len(omitted) > 0 {
+		notes = append([]string{strings.Join(omitted, " and ") + " do not fit on the page"}, notes...)
+	}
+	pdf.SetTextColor(128, 128, 128)
+	for _, note := range notes {
+		y = p.writeLines(place(0, width), min(y+1, footer), width, 4.5, []string{text.font("I", 8, note)}, text.align(note))
+	}
+	pdf.SetTextColor(0, 0, 0)
+}
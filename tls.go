@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const clientCNKey = "client_cn"
+
+// newServer builds the HTTP server. It serves HTTPS when a certificate is configured and HTTP/2 on both
+// TLS and, when enabled, cleartext (h2c) connections.
+func newServer(handler http.Handler) (*http.Server, error) {
+	cfg := appConfig.Server
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: handler}
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!cfg.DisableHTTP2)
+	protocols.SetUnencryptedHTTP2(cfg.H2C && !cfg.DisableHTTP2)
+	srv.Protocols = &protocols
+
+	if cfg.TLSCertFile == "" {
+		return srv, nil
+	}
+
+	reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLSReloadInterval > 0 {
+		go reloader.watch(cfg.TLSReloadInterval)
+	}
+	srv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if cfg.TLSClientCAFile != "" {
+		pool, err := loadCertPool(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.TLSClientAuth == "optional" {
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		logger.Info().Msgf("Mutual TLS enabled (%s) with client CAs from %s", cfg.TLSClientAuth, cfg.TLSClientCAFile)
+	}
+	return srv, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("client CA file contains no PEM certificates")
+	}
+	return pool, nil
+}
+
+// recordClientCertificate makes the common name of a verified client certificate available to handlers
+func recordClientCertificate(c *gin.Context) {
+	if state := c.Request.TLS; state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		c.Set(clientCNKey, state.VerifiedChains[0][0].Subject.CommonName)
+	}
+	c.Next()
+}
+
+// clientCN returns the common name of the verified client certificate, empty without mutual TLS
+func clientCN(c *gin.Context) string {
+	return c.GetString(clientCNKey)
+}
+
+// serve runs the server until it is shut down
+func serve(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		logger.Info().Msgf("Server started at %s (HTTPS)", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	logger.Info().Msgf("Server started at %s", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+// certReloader serves the certificate from disk and picks up rotated files without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the key pair again when either file changed since the last load
+func (r *certReloader) reload() error {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && !modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// watch polls the certificate files. A failed reload keeps serving the previous certificate, which
+// covers the window where a rotation has written the certificate but not yet the key.
+func (r *certReloader) watch(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		r.mu.RLock()
+		previous := r.modTime
+		r.mu.RUnlock()
+
+		if err := r.reload(); err != nil {
+			logger.Warn().Err(err).Msg("TLS certificate reload failed, keeping the current one")
+			continue
+		}
+
+		r.mu.RLock()
+		reloaded := r.modTime.After(previous)
+		r.mu.RUnlock()
+		if reloaded {
+			logger.Info().Msgf("TLS certificate reloaded from %s", r.certFile)
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// unoserverStartTimeout is how long LibreOffice may take to start listening
+	unoserverStartTimeout = time.Minute
+	// unoserverMaxRestartDelay caps the back-off between restarts of a process that keeps crashing
+	unoserverMaxRestartDelay = 30 * time.Second
+)
+
+// unoserverPool keeps LibreOffice running as unoserver processes, so conversions skip the start-up of
+// LibreOffice, which takes longer than converting a typical resume. Each process converts one document
+// at a time; the free channel hands them out and bounds the conversions running at once. Processes that
+// exit, stop answering health checks or hang in a conversion are restarted.
+type unoserverPool struct {
+	processes      []*unoserverProcess
+	free           chan *unoserverProcess
+	healthInterval time.Duration
+	maxConversions int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// unoserverProcess is one unoserver and its LibreOffice, listening on port for conversions
+type unoserverProcess struct {
+	port       int
+	unoPort    int
+	profileDir string
+
+	mu sync.Mutex
+	// ready is closed once the current run accepts connections
+	ready chan struct{}
+	// stop kills the current run, the supervisor then starts a new one
+	stop        context.CancelFunc
+	busy        bool
+	conversions int
+}
+
+// unoserver is set when conversion.backend is unoserver
+var unoserver *unoserverPool
+
+func newUnoserverPool(cfg UnoserverConfig, size int) *unoserverPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &unoserverPool{
+		free:           make(chan *unoserverProcess, size),
+		healthInterval: cfg.HealthInterval,
+		maxConversions: cfg.MaxConversions,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	for i := 0; i < size; i++ {
+		proc := &unoserverProcess{
+			port:    cfg.Port + 2*i,
+			unoPort: cfg.Port + 2*i + 1,
+			// Every LibreOffice needs a profile of its own, they lock it
+			profileDir: filepath.Join(appConfig.Processing.ScratchDir, "unoserver", strconv.Itoa(i)),
+			ready:      make(chan struct{}),
+		}
+		p.processes = append(p.processes, proc)
+		p.free <- proc
+		p.wg.Add(1)
+		go p.supervise(proc)
+	}
+	if p.healthInterval > 0 {
+		p.wg.Add(1)
+		go p.checkHealth()
+	}
+	logger.Info().Msgf("Started %d unoserver processes on ports %d-%d", size, cfg.Port, cfg.Port+2*size-1)
+	return p
+}
+
+// Convert converts a document with the next free unoserver
+func (p *unoserverPool) Convert(ctx context.Context, inputPath, outputDir string) (string, error) {
+	var proc *unoserverProcess
+	select {
+	case proc = <-p.free:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	proc.setBusy(true)
+	defer func() {
+		proc.setBusy(false)
+		p.free <- proc
+	}()
+
+	if err := proc.waitReady(ctx); err != nil {
+		return "", fmt.Errorf("unoserver on port %d is not ready: %w", proc.port, err)
+	}
+
+	outputPath := pdfOutputPath(inputPath, outputDir)
+	cmd := exec.CommandContext(ctx, "unoconvert", "--host", "127.0.0.1", "--port", strconv.Itoa(proc.port),
+		"--convert-to", "pdf", inputPath, outputPath)
+	killProcessGroupOnCancel(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stderr
+	err := cmd.Run()
+
+	proc.mu.Lock()
+	proc.conversions++
+	recycle := p.maxConversions > 0 && proc.conversions >= p.maxConversions
+	proc.mu.Unlock()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// LibreOffice may still be busy with the document, start over with a fresh one
+		logFrom(ctx).Warn().Msgf("Restarting unoserver on port %d after an interrupted conversion", proc.port)
+		proc.restart()
+	case err != nil && !proc.alive():
+		logFrom(ctx).Warn().Msgf("Restarting unoserver on port %d, it stopped responding", proc.port)
+		proc.restart()
+	case recycle:
+		logFrom(ctx).Warn().Msgf("Restarting unoserver on port %d after %d conversions", proc.port, p.maxConversions)
+		proc.restart()
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return outputPath, nil
+}
+
+// supervise runs a process until the pool is closed, restarting it whenever it exits. A process that
+// keeps crashing right after starting is restarted with an increasing delay.
+func (p *unoserverPool) supervise(proc *unoserverProcess) {
+	defer p.wg.Done()
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := proc.run(p.ctx)
+		if p.ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			delay = time.Second
+		}
+		logger.Warn().Err(err).Msgf("unoserver on port %d exited, restarting in %s", proc.port, delay)
+		select {
+		case <-time.After(delay):
+		case <-p.ctx.Done():
+			return
+		}
+		delay = min(2*delay, unoserverMaxRestartDelay)
+	}
+}
+
+// checkHealth restarts idle processes that no longer accept connections
+func (p *unoserverPool) checkHealth() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+		for _, proc := range p.processes {
+			proc.mu.Lock()
+			idle, ready := !proc.busy, isClosed(proc.ready)
+			proc.mu.Unlock()
+			if idle && ready && !proc.alive() {
+				logger.Warn().Msgf("unoserver on port %d failed its health check, restarting", proc.port)
+				proc.restart()
+			}
+		}
+	}
+}
+
+// close stops every process
+func (p *unoserverPool) close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// run starts unoserver and waits for it to exit
+func (proc *unoserverProcess) run(poolCtx context.Context) error {
+	ctx, stop := context.WithCancel(poolCtx)
+	defer stop()
+	proc.mu.Lock()
+	// Conversions waiting since the last run ended wait on the open channel
+	if isClosed(proc.ready) {
+		proc.ready = make(chan struct{})
+	}
+	ready := proc.ready
+	proc.stop, proc.conversions = stop, 0
+	proc.mu.Unlock()
+
+	if err := os.MkdirAll(proc.profileDir, 0700); err != nil {
+		return err
+	}
+	profile := url.URL{Scheme: "file", Path: filepath.ToSlash(proc.profileDir)}
+	cmd := exec.CommandContext(ctx, "unoserver", "--interface", "127.0.0.1",
+		"--port", strconv.Itoa(proc.port), "--uno-port", strconv.Itoa(proc.unoPort),
+		"--user-installation", profile.String())
+	killProcessGroupOnCancel(cmd)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(unoserverStartTimeout)
+	for !proc.alive() {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("%v: %s", err, lastLines(output.String()))
+		case <-deadline:
+			stop()
+			<-exited
+			return errors.New("did not start listening in time")
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	close(ready)
+	logger.Debug().Msgf("unoserver listening on port %d", proc.port)
+
+	err := <-exited
+	proc.mu.Lock()
+	if proc.ready == ready {
+		proc.ready = make(chan struct{})
+	}
+	proc.mu.Unlock()
+	if err == nil {
+		err = errors.New("exited")
+	}
+	return err
+}
+
+// waitReady waits until the current run accepts conversions
+func (proc *unoserverProcess) waitReady(ctx context.Context) error {
+	proc.mu.Lock()
+	ready := proc.ready
+	proc.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// alive reports whether the process accepts connections
+func (proc *unoserverProcess) alive() bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(proc.port)), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// restart kills the current run, conversions wait for the supervisor to start the next one. A process
+// that is not up, because it is starting or has exited already, is left to the supervisor.
+func (proc *unoserverProcess) restart() {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if !isClosed(proc.ready) {
+		return
+	}
+	proc.ready = make(chan struct{})
+	proc.stop()
+}
+
+func (proc *unoserverProcess) setBusy(busy bool) {
+	proc.mu.Lock()
+	proc.busy = busy
+	proc.mu.Unlock()
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// lastLines keeps the end of a process's output for error messages
+func lastLines(output string) string {
+	const limit = 500
+	if len(output) > limit {
+		return "..." + output[len(output)-limit:]
+	}
+	return output
+}
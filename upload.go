@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadMemory is how much of a multipart upload is kept in memory, larger files are buffered on disk
+const uploadMemory = 32 << 20
+
+// processCandidatesUpload accepts the job as multipart/form-data: a "candidates" part with the same JSON
+// as POST /api/v1/process-candidates and a "resume_<index>" file part for every candidate without a resume_url
+func processCandidatesUpload(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(uploadMemory); err != nil {
+		requestLog(c).Warn().Err(err).Msg("Error parsing multipart upload")
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "invalid multipart form")
+		return
+	}
+	form := c.Request.MultipartForm
+
+	var req ProcessRequest
+	data, err := formPart(form, "candidates")
+	if err != nil {
+		apiError(http.StatusBadRequest, errCodeInvalidRequest, err.Error()).field("candidates", err.Error()).abort(c)
+		return
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		requestLog(c).Warn().Err(err).Msg("Error binding JSON")
+		e := invalidBody("invalid candidates JSON", err)
+		if len(e.FieldErrors) == 0 {
+			e.field("candidates", "invalid JSON")
+		}
+		e.abort(c)
+		return
+	}
+
+	for name, files := range form.File {
+		if name == "candidates" {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(name, "resume_"))
+		if !strings.HasPrefix(name, "resume_") || err != nil || index < 0 || index >= len(req.Candidates) {
+			message := fmt.Sprintf("unexpected file part %q, expected resume_0 to resume_%d", name, len(req.Candidates)-1)
+			apiError(http.StatusBadRequest, errCodeValidationFailed, message).field(name, "unexpected file part").abort(c)
+			return
+		}
+		cand := &req.Candidates[index]
+		if cand.ResumeURL != "" || len(cand.ResumeContent) > 0 {
+			apiError(http.StatusBadRequest, errCodeValidationFailed, fmt.Sprintf("candidate %d has a resume_url or resume_content and a file part", index)).
+				field(name, "candidate already has a resume_url or resume_content").abort(c)
+			return
+		}
+		var size int64
+		for _, file := range files {
+			size += file.Size
+		}
+		if maxSize := int64(appConfig.Download.MaxSize); maxSize > 0 && size > maxSize {
+			limit := fmt.Sprintf("%s exceeds the limit of %s", formatBytes(size), formatBytes(maxSize))
+			apiError(http.StatusBadRequest, errCodeValidationFailed, fmt.Sprintf("candidate %d: %v: %s", index, errResumeTooLarge, limit)).
+				field(name, limit).abort(c)
+			return
+		}
+
+		// Several images, e.g. photos of each page, are packed into an archive that becomes one page per image
+		if len(files) > 1 {
+			cand.ResumeContent, err = zipImages(files)
+			cand.ResumeFilename = name + ".zip"
+		} else {
+			cand.ResumeContent, err = readFormFile(files[0])
+			cand.ResumeFilename = files[0].Filename
+		}
+		if err != nil {
+			requestLog(c).Error().Err(err).Msgf("Error reading upload %s", name)
+			apiError(http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("file part %s: %v", name, err)).field(name, err.Error()).abort(c)
+			return
+		}
+	}
+
+	submitJob(c, req)
+}
+
+// formPart returns a part sent either as a plain form value or as a file, as curl -F name=@file does
+func formPart(form *multipart.Form, name string) ([]byte, error) {
+	if values := form.Value[name]; len(values) > 0 {
+		return []byte(values[0]), nil
+	}
+	if files := form.File[name]; len(files) > 0 {
+		return readFormFile(files[0])
+	}
+	return nil, fmt.Errorf("missing %s part", name)
+}
+
+// zipImages packs the files of a part into an image archive, keeping their order as the page order
+func zipImages(files []*multipart.FileHeader) ([]byte, error) {
+	if len(files) > maxImagePages {
+		return nil, fmt.Errorf("at most %d images are supported", maxImagePages)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, file := range files {
+		data, err := readFormFile(file)
+		if err != nil {
+			return nil, errors.New("failed to read file")
+		}
+		format, ok := sniffImage(data)
+		if !ok {
+			return nil, errors.New("several files are only supported for images")
+		}
+		// Stored, images are compressed already
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("page%03d%s", i+1, format.Ext), Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
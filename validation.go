@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxFieldErrors bounds the field errors of a response, a request with thousands of bad candidates gets the first
+const maxFieldErrors = 100
+
+// requestErrors collects the field errors of a request, so callers can fix every problem of a request at once
+type requestErrors struct {
+	fields []FieldError
+	// total counts the errors beyond maxFieldErrors too
+	total int
+}
+
+func (v *requestErrors) add(field, message string) {
+	v.total++
+	if len(v.fields) < maxFieldErrors {
+		v.fields = append(v.fields, FieldError{Field: field, Message: message})
+	}
+}
+
+// apiError is the validation error of the request, nil when every field is valid
+func (v *requestErrors) apiError() *APIError {
+	if v.total == 0 {
+		return nil
+	}
+	first := v.fields[0]
+	message := first.Field + ": " + first.Message
+	switch {
+	case v.total == 2:
+		message += " (and 1 more error)"
+	case v.total > 2:
+		message += fmt.Sprintf(" (and %d more errors)", v.total-1)
+	}
+	e := apiError(http.StatusBadRequest, errCodeValidationFailed, message)
+	e.FieldErrors = v.fields
+	if v.total > len(v.fields) {
+		e.detail("omitted_field_errors", v.total-len(v.fields))
+	}
+	return e
+}
+
+// validateRequest checks the fields of a job request and its candidates against the schema and the configured
+// limits, and the formats of their contact details in the request's validation mode, normalizing them if asked
+// to. It returns the format problems the job goes ahead with. Options that depend on each other are checked by
+// JobOptions.validate.
+func validateRequest(req *ProcessRequest) ([]string, *APIError) {
+	limits := appConfig.Limits
+	errs := &requestErrors{}
+	if req.TenantName == "" {
+		errs.add("tenant_name", "is required")
+	}
+	if req.CompanyName == "" {
+		errs.add("company_name", "is required")
+	}
+	errs.checkLength("company_name", req.CompanyName, limits.MaxFieldLength)
+	if req.Concurrency < 0 {
+		errs.add("concurrency", "cannot be negative")
+	}
+	if err := req.ResumeHeaders.validate(); err != nil {
+		errs.add("resume_headers", err.Error())
+	}
+	if req.FailFast != nil {
+		if err := req.FailFast.validate(); err != nil {
+			errs.add("fail_fast", err.Error())
+		}
+	}
+
+	switch {
+	case len(req.Candidates) == 0:
+		errs.add("candidates", "cannot be empty")
+	case limits.MaxCandidates > 0 && len(req.Candidates) > limits.MaxCandidates:
+		// The candidates themselves are not checked, the request has to be split anyway
+		errs.add("candidates", fmt.Sprintf("at most %d candidates are allowed per request, got %d", limits.MaxCandidates, len(req.Candidates)))
+		return nil, errs.apiError()
+	}
+
+	warnings := checkFormats(req, errs)
+	for i, cand := range req.Candidates {
+		cand.validate(fmt.Sprintf("candidates[%d]", i), errs)
+	}
+	checkDuplicateIDs(req.Candidates, errs)
+	return warnings, errs.apiError()
+}
+
+// validate adds the errors of a candidate of the request, prefix is its path, e.g. candidates[2]
+func (cand Candidate) validate(prefix string, errs *requestErrors) {
+	limits := appConfig.Limits
+	if strings.TrimSpace(cand.Name) == "" {
+		errs.add(prefix+".name", "is required")
+	}
+	// Packets are named after the email of candidates without a candidate_id
+	if cand.Email == "" && cand.CandidateID == "" {
+		errs.add(prefix+".email", "is required without a candidate_id")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"name", cand.Name},
+		{"candidate_code", cand.CandidateCode},
+		{"email", cand.Email},
+		{"mobile_no", cand.MobileNo},
+		{"experience", cand.Experience},
+		{"qualification", cand.Qualification},
+		{"resume_filename", cand.ResumeFilename},
+	} {
+		errs.checkLength(prefix+"."+field.name, field.value, limits.MaxFieldLength)
+	}
+
+	if limits.MaxSkills > 0 && len(cand.Skills) > limits.MaxSkills {
+		errs.add(prefix+".skills", fmt.Sprintf("at most %d skills are allowed, got %d", limits.MaxSkills, len(cand.Skills)))
+	}
+	for i, skill := range cand.Skills {
+		errs.checkLength(fmt.Sprintf("%s.skills[%d]", prefix, i), skill, limits.MaxFieldLength)
+	}
+	for i, field := range cand.CustomFields {
+		errs.checkLength(fmt.Sprintf("%s.custom_fields[%d].label", prefix, i), field.Label, limits.MaxFieldLength)
+		errs.checkLength(fmt.Sprintf("%s.custom_fields[%d].value", prefix, i), field.Value, limits.MaxFieldLength)
+	}
+
+	if err := cand.ResumeHeaders.validate(); err != nil {
+		errs.add(prefix+".resume_headers", err.Error())
+	}
+	checks := []struct {
+		field    string
+		validate func() error
+	}{
+		{"resume_content", cand.validateResumeContent},
+		{"candidate_id", cand.validateCandidateID},
+		{"group", cand.validateGroup},
+		{"custom_fields", cand.validateCustomFields},
+		{"profile_url", cand.validateProfileURL},
+		{"attachments", cand.validateAttachments},
+		{"links", cand.validateLinks},
+		{"work_history", cand.validateWorkHistory},
+		{"education", cand.validateEducation},
+		{"certifications", cand.validateCertifications},
+	}
+	for _, check := range checks {
+		if err := check.validate(); err != nil {
+			errs.add(prefix+"."+check.field, err.Error())
+		}
+	}
+}
+
+// checkLength adds an error when a field is longer than limit characters, 0 allows any length
+func (v *requestErrors) checkLength(field, value string, limit int) {
+	if limit > 0 && utf8.RuneCountInString(value) > limit {
+		v.add(field, fmt.Sprintf("cannot be longer than %d characters", limit))
+	}
+}
+
+// invalidBody is the error of a request body that cannot be decoded, pointing at the field of a type mismatch
+// or the position of a syntax error
+func invalidBody(message string, err error) *APIError {
+	e := apiError(http.StatusBadRequest, errCodeInvalidRequest, message)
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		field := jsonPath(typeErr.Field)
+		e.Message = fmt.Sprintf("%s: %s must be %s, got %s", message, field, typeErr.Type.String(), typeErr.Value)
+		e.field(field, fmt.Sprintf("must be %s, got %s", typeErr.Type.String(), typeErr.Value))
+	case errors.As(err, &syntaxErr):
+		e.Message = fmt.Sprintf("%s: %v at byte %d", message, syntaxErr, syntaxErr.Offset)
+		e.detail("offset", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		e.Message = message + ": the body ends before the JSON is complete"
+	case errors.Is(err, io.EOF):
+		e.Message = message + ": empty body"
+	}
+	return e
+}
+
+// jsonPath turns the dotted path of encoding/json, e.g. candidates.2.skills.0, into candidates[2].skills[0]
+func jsonPath(dotted string) string {
+	var path strings.Builder
+	for i, part := range strings.Split(dotted, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			path.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			path.WriteByte('.')
+		}
+		path.WriteString(part)
+	}
+	return path.String()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxMonitorMessageSize bounds the commands clients send, they are a few bytes of JSON
+	maxMonitorMessageSize = 4096
+	monitorWriteTimeout   = 10 * time.Second
+	// monitorPongTimeout closes connections whose client stopped answering pings, which are sent while idle
+	monitorPongTimeout = 2 * jobEventsKeepAlive
+)
+
+// The upgrader's default origin check keeps other sites from opening a monitor in the browsers of logged-in users
+var monitorUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// monitorMessage is what travels over the monitor connection: events from the server, the same as those of
+// GET /api/v1/jobs/:id/events, and commands from the client
+type monitorMessage struct {
+	// Event names messages from the server: candidate, progress, done, cancelling or error
+	Event string `json:"event,omitempty"`
+	Data  any    `json:"data,omitempty"`
+	// Action names commands from the client, cancel_candidate is the only one
+	Action string `json:"action,omitempty"`
+	Index  *int   `json:"index,omitempty"`
+}
+
+// monitorJob serves a WebSocket that streams the progress of a job like streamJobEvents and takes commands
+// to cancel single candidates while the job runs. The connection is closed once the job has finished.
+func monitorJob(c *gin.Context) {
+	job, ok := jobs.get(c.Param("id"))
+	if !ok || !canAccessTenant(c, job.TenantName) {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "job not found")
+		return
+	}
+
+	conn, err := monitorUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has answered the request
+		requestLog(c).Error().Err(err).Msgf("Error opening monitor of job %s", job.ID)
+		return
+	}
+	defer conn.Close()
+	caller := requestedBy(c)
+
+	// Events and replies to commands are sent from two goroutines, control messages are safe to send from any
+	var writeMu sync.Mutex
+	send := func(event string, data any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+		return conn.WriteJSON(monitorMessage{Event: event, Data: data})
+	}
+
+	// Commands are read while events are sent, the monitor ends when the client goes away
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	conn.SetReadLimit(maxMonitorMessageSize)
+	conn.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+	})
+	go func() {
+		defer cancel()
+		for {
+			var command monitorMessage
+			if err := conn.ReadJSON(&command); err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+			if command.Action != "cancel_candidate" || command.Index == nil {
+				send("error", apiError(http.StatusBadRequest, errCodeInvalidRequest, `expected {"action": "cancel_candidate", "index": <candidate index>}`))
+				continue
+			}
+			if e := stopJobCandidate(job, *command.Index); e != nil {
+				send("error", e.detail("index", *command.Index))
+				continue
+			}
+			requestLog(c).Info().Msgf("Cancelling candidate %d of job %s at the request of %s", *command.Index, job.ID, caller)
+			audit.recordRequest(c, auditCandidateCancelled, job.TenantName, job.ID, gin.H{"index": *command.Index})
+			send("cancelling", gin.H{"index": *command.Index})
+		}
+	}()
+
+	followJob(ctx, job, send, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(monitorWriteTimeout))
+	})
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(monitorWriteTimeout))
+}
+
+// stopJobCandidate cancels a single candidate of a running job, wherever it runs. Candidates that already
+// finished are left alone.
+func stopJobCandidate(job *Job, index int) *APIError {
+	job.mu.Lock()
+	if index < 0 || index >= len(job.Candidates) {
+		job.mu.Unlock()
+		return apiError(http.StatusBadRequest, errCodeValidationFailed, "no such candidate").field("index", "out of range")
+	}
+	status := job.Candidates[index].Status
+	job.mu.Unlock()
+	if isFinalCandidateStatus(status) {
+		return apiError(http.StatusConflict, errCodeConflict, "candidate has already finished").detail("status", status)
+	}
+
+	if taskQueue != nil {
+		// Whichever instance picks up or is processing the candidate's task skips or interrupts it
+		if err := taskQueue.cancelCandidate(job.ID, index); err != nil {
+			job.log().Error().Err(err).Msgf("Error broadcasting cancellation of candidate %d of job %s", index, job.ID)
+			return apiError(http.StatusInternalServerError, errCodeInternal, "failed to cancel candidate")
+		}
+		return nil
+	}
+	if !job.stopCandidate(index) {
+		return apiError(http.StatusConflict, errCodeJobOnAnotherInstance, "job is running on another instance")
+	}
+	return nil
+}
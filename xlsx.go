@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// summaryWorkbookName is the spreadsheet at the root of the archive summarizing the candidates
+const summaryWorkbookName = "summary.xlsx"
+
+// summaryColumns are the fixed columns of the summary, the labels of custom fields follow them
+var summaryColumns = []string{"#", "Name", "Email", "Mobile Number", "Qualification", "Experience", "Skills", "Status", "Error", "File"}
+
+// buildSummaryWorkbook writes summary.xlsx to factsheetDir: a row per candidate in submission order, with
+// a column for every custom field used in the job, and filters on the header row so recruiters can sort
+// and narrow down the batch in Excel
+func buildSummaryWorkbook(job *Job, req ProcessRequest, factsheetDir string) error {
+	job.mu.Lock()
+	progress := append([]CandidateProgress(nil), job.Candidates...)
+	job.mu.Unlock()
+
+	// Custom fields become columns in the order they first appear
+	var customLabels []string
+	seen := map[string]bool{}
+	for _, cand := range req.Candidates {
+		for _, field := range cand.CustomFields {
+			if !seen[field.Label] {
+				seen[field.Label] = true
+				customLabels = append(customLabels, field.Label)
+			}
+		}
+	}
+
+	var header []any
+	for _, column := range append(append([]string(nil), summaryColumns...), customLabels...) {
+		header = append(header, column)
+	}
+	rows := [][]any{header}
+	fileNames := packetFileNames(job.ID, req)
+	for i, cand := range req.shownCandidates() {
+		status, errorText, file := candidateStatusPending, "", ""
+		if i < len(progress) {
+			status, errorText = progress[i].Status, progress[i].Error
+		}
+		if _, err := os.Stat(filepath.Join(factsheetDir, fileNames[i])); err == nil {
+			file = fileNames[i]
+		}
+		row := []any{i + 1, cand.Name, cand.Email, cand.MobileNo, cand.Qualification, cand.Experience,
+			strings.Join(cand.Skills, ", "), status, errorText, file}
+		for _, label := range customLabels {
+			value := ""
+			for _, field := range cand.CustomFields {
+				if field.Label == label {
+					value = field.Value
+					break
+				}
+			}
+			row = append(row, value)
+		}
+		rows = append(rows, row)
+	}
+
+	data, err := writeXLSX("Candidates", rows)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(factsheetDir, summaryWorkbookName), data, 0600)
+}
+
+// writeXLSX builds a workbook of one sheet holding rows of text and integers, the first row a bold header
+// that stays in view while scrolling and carries filters. Only the parts of the Office Open XML format
+// that Excel, LibreOffice and Google Sheets need are written.
+func writeXLSX(sheetName string, rows [][]any) ([]byte, error) {
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	var sheet bytes.Buffer
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	if columns > 0 {
+		// Widths from the longest value of each column, within reason
+		sheet.WriteString(`<cols>`)
+		for col := 0; col < columns; col++ {
+			width := 4
+			for _, row := range rows {
+				if col < len(row) {
+					width = max(width, min(len([]rune(fmt.Sprint(row[col])))+2, 60))
+				}
+			}
+			fmt.Fprintf(&sheet, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, col+1, col+1, width)
+		}
+		sheet.WriteString(`</cols>`)
+	}
+	sheet.WriteString(`<sheetData>`)
+	for r, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, r+1)
+		style := ""
+		if r == 0 {
+			style = ` s="1"`
+		}
+		for c, value := range row {
+			switch value := value.(type) {
+			case int:
+				fmt.Fprintf(&sheet, `<c r="%s%d"%s><v>%d</v></c>`, xlsxColumn(c), r+1, style, value)
+			case string:
+				if value == "" {
+					continue
+				}
+				fmt.Fprintf(&sheet, `<c r="%s%d" t="inlineStr"%s><is><t xml:space="preserve">`, xlsxColumn(c), r+1, style)
+				if err := xml.EscapeText(&sheet, []byte(value)); err != nil {
+					return nil, err
+				}
+				sheet.WriteString(`</t></is></c>`)
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData>`)
+	if len(rows) > 0 && columns > 0 {
+		fmt.Fprintf(&sheet, `<autoFilter ref="A1:%s%d"/>`, xlsxColumn(columns-1), len(rows))
+	}
+	sheet.WriteString(`</worksheet>`)
+
+	var name bytes.Buffer
+	if err := xml.EscapeText(&name, []byte(sheetName)); err != nil {
+		return nil, err
+	}
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets>` +
+			// Excel only shows the filter buttons with the filter database name defined
+			`<definedNames><definedName name="_xlnm._FilterDatabase" localSheetId="0" hidden="1">'` + strings.ReplaceAll(name.String(), "'", "''") +
+			fmt.Sprintf(`'!$A$1:$%s$%d</definedName></definedNames>`, xlsxColumn(max(columns, 1)-1), max(len(rows), 1)) +
+			`</workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`},
+		// Style 1 is the bold header
+		{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+			`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, part := range parts {
+		w, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xlsxColumn is the letter name of a column counted from 0: A, B, ... Z, AA, AB, ...
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}